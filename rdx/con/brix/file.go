@@ -0,0 +1,220 @@
+package brix
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Ext is the extension of brik files, the file name is its hash in hex
+const Ext = ".brik"
+
+// The file is a sequence of TLV records:
+//
+//	H    header with a `P` record holding the 32-byte hash of every parent file
+//	K... one record per key in ascending order: 16-byte id followed by the value
+//	G    optional signature: 32-byte ed25519 public key and the signature
+//	     of the SHA-256 of everything before the `G` record
+//
+// The hash of a file is the SHA-256 of all its bytes including the signature.
+const (
+	litHeader    byte = 'H'
+	litParent    byte = 'P'
+	litKey       byte = 'K'
+	litSignature byte = 'G'
+)
+
+var (
+	ErrBadFile      = errors.New("bad brik file")
+	ErrBadSignature = errors.New("bad brik signature")
+)
+
+// Hash is the SHA-256 of a brik file
+type Hash [sha256.Size]byte
+
+func (h Hash) String() string {
+	return hex.EncodeToString(h[:])
+}
+
+// ParseHash parses the hex form of a hash
+func ParseHash(s string) (Hash, error) {
+	var h Hash
+	data, err := hex.DecodeString(s)
+	if err != nil || len(data) != len(h) {
+		return h, fmt.Errorf("%w: bad hash %q", ErrBadFile, s)
+	}
+	copy(h[:], data)
+	return h, nil
+}
+
+// Record is a key-value pair of a file
+type Record struct {
+	ID    ID
+	Value Value
+}
+
+// File is a parsed brik file
+type File struct {
+	Hash    Hash
+	Parents []Hash
+	// Signer is the key that signed the file, nil for unsigned files
+	Signer  ed25519.PublicKey
+	Records []Record
+}
+
+// Build serializes records into a brik file linked to the parents.
+// The file is signed if key is not nil.
+func Build(parents []Hash, records []Record, key ed25519.PrivateKey) ([]byte, error) {
+	sorted := make([]Record, len(records))
+	copy(sorted, records)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ID.Compare(sorted[j].ID) < 0
+	})
+
+	var header []byte
+	for _, parent := range parents {
+		header = appendRecord(header, litParent, parent[:])
+	}
+	data := appendRecord(nil, litHeader, header)
+
+	for i, record := range sorted {
+		if i > 0 && sorted[i-1].ID == record.ID {
+			return nil, fmt.Errorf("%w: duplicate key %s", ErrBadFile, record.ID)
+		}
+		if _, rest, err := takeValue(record.Value); err != nil || len(rest) != 0 {
+			return nil, fmt.Errorf("%w: key %s", ErrBadValue, record.ID)
+		}
+		body := append(record.ID.Bytes(), record.Value...)
+		data = appendRecord(data, litKey, body)
+	}
+
+	if key != nil {
+		digest := sha256.Sum256(data)
+		body := append([]byte(key.Public().(ed25519.PublicKey)), ed25519.Sign(key, digest[:])...)
+		data = appendRecord(data, litSignature, body)
+	}
+
+	return data, nil
+}
+
+// Parse reads a brik file, checking the key order and the signature
+func Parse(data []byte) (*File, error) {
+	file := &File{Hash: sha256.Sum256(data)}
+
+	lit, header, rest, err := takeRecord(data)
+	if err != nil || lit != litHeader {
+		return nil, fmt.Errorf("%w: no header", ErrBadFile)
+	}
+	for len(header) > 0 {
+		var parent []byte
+		lit, parent, header, err = takeRecord(header)
+		if err != nil || lit != litParent || len(parent) != len(Hash{}) {
+			return nil, fmt.Errorf("%w: bad parent", ErrBadFile)
+		}
+		file.Parents = append(file.Parents, Hash(parent))
+	}
+
+	for len(rest) > 0 {
+		signed := data[:len(data)-len(rest)]
+		var body []byte
+		lit, body, rest, err = takeRecord(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadFile, err)
+		}
+
+		switch lit {
+		case litKey:
+			if file.Signer != nil {
+				return nil, fmt.Errorf("%w: record after the signature", ErrBadFile)
+			}
+			if len(body) < IDLen {
+				return nil, fmt.Errorf("%w: short record", ErrBadFile)
+			}
+			id, _ := IDFromBytes(body[:IDLen])
+			value, tail, err := takeValue(body[IDLen:])
+			if err != nil || len(tail) != 0 {
+				return nil, fmt.Errorf("%w: key %s", ErrBadValue, id)
+			}
+			if n := len(file.Records); n > 0 && file.Records[n-1].ID.Compare(id) >= 0 {
+				return nil, fmt.Errorf("%w: key %s is out of order", ErrBadFile, id)
+			}
+			file.Records = append(file.Records, Record{ID: id, Value: value})
+		case litSignature:
+			if file.Signer != nil || len(rest) != 0 {
+				return nil, fmt.Errorf("%w: the signature must be the last record", ErrBadFile)
+			}
+			if len(body) != ed25519.PublicKeySize+ed25519.SignatureSize {
+				return nil, ErrBadSignature
+			}
+			signer := ed25519.PublicKey(body[:ed25519.PublicKeySize])
+			digest := sha256.Sum256(signed)
+			if !ed25519.Verify(signer, digest[:], body[ed25519.PublicKeySize:]) {
+				return nil, ErrBadSignature
+			}
+			file.Signer = signer
+		default:
+			return nil, fmt.Errorf("%w: unexpected record %c", ErrBadFile, lit)
+		}
+	}
+
+	return file, nil
+}
+
+// ReadFile reads a brik file and checks that it is named by its hash
+func ReadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if name := strings.TrimSuffix(filepath.Base(path), Ext); name != file.Hash.String() {
+		return nil, fmt.Errorf("%w: %s does not match its hash %s", ErrBadFile, path, file.Hash)
+	}
+	return file, nil
+}
+
+// WriteFile writes a file built by Build into dir under its hash
+func WriteFile(dir string, data []byte) (Hash, error) {
+	hash := Hash(sha256.Sum256(data))
+	path := filepath.Join(dir, hash.String()+Ext)
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return hash, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return hash, err
+	}
+	return hash, nil
+}
+
+// Get looks a key up
+func (f *File) Get(id ID) (Value, bool) {
+	i := sort.Search(len(f.Records), func(i int) bool {
+		return f.Records[i].ID.Compare(id) >= 0
+	})
+	if i < len(f.Records) && f.Records[i].ID == id {
+		return f.Records[i].Value, true
+	}
+	return nil, false
+}
+
+// HasParent reports whether the file is linked to the given one
+func (f *File) HasParent(hash Hash) bool {
+	for _, parent := range f.Parents {
+		if parent == hash {
+			return true
+		}
+	}
+	return false
+}
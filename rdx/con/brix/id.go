@@ -0,0 +1,85 @@
+package brix
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// IDLen is the size of a serialized ID
+const IDLen = 16
+
+var ErrBadID = errors.New("bad RDX id")
+
+// ID is a 128-bit RDX id: the source (replica) part and the sequence part.
+// It is written as `src-seq` in hex, so the ConCoin objects are `cc-1`, `cc-3` etc.
+type ID struct {
+	Src uint64
+	Seq uint64
+}
+
+// ParseID parses the `src-seq` hex notation
+func ParseID(s string) (ID, error) {
+	src, seq, ok := strings.Cut(s, "-")
+	if !ok {
+		return ID{}, fmt.Errorf("%w: %q", ErrBadID, s)
+	}
+	srcNum, err := strconv.ParseUint(src, 16, 64)
+	if err != nil {
+		return ID{}, fmt.Errorf("%w: %q", ErrBadID, s)
+	}
+	seqNum, err := strconv.ParseUint(seq, 16, 64)
+	if err != nil {
+		return ID{}, fmt.Errorf("%w: %q", ErrBadID, s)
+	}
+	return ID{Src: srcNum, Seq: seqNum}, nil
+}
+
+// MustParseID is ParseID for constants
+func MustParseID(s string) ID {
+	id, err := ParseID(s)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+func (id ID) String() string {
+	return strconv.FormatUint(id.Src, 16) + "-" + strconv.FormatUint(id.Seq, 16)
+}
+
+// Compare orders ids by source, then by sequence number
+func (id ID) Compare(other ID) int {
+	switch {
+	case id.Src < other.Src:
+		return -1
+	case id.Src > other.Src:
+		return 1
+	case id.Seq < other.Seq:
+		return -1
+	case id.Seq > other.Seq:
+		return 1
+	}
+	return 0
+}
+
+// Bytes returns the big-endian form, which sorts the same way as Compare
+func (id ID) Bytes() []byte {
+	var buf [IDLen]byte
+	binary.BigEndian.PutUint64(buf[:8], id.Src)
+	binary.BigEndian.PutUint64(buf[8:], id.Seq)
+	return buf[:]
+}
+
+// IDFromBytes is the inverse of ID.Bytes
+func IDFromBytes(data []byte) (ID, error) {
+	if len(data) != IDLen {
+		return ID{}, fmt.Errorf("%w: %d bytes", ErrBadID, len(data))
+	}
+	return ID{
+		Src: binary.BigEndian.Uint64(data[:8]),
+		Seq: binary.BigEndian.Uint64(data[8:]),
+	}, nil
+}
@@ -0,0 +1,264 @@
+package brix
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// HeadFile names the newest file of a stack
+const HeadFile = "HEAD"
+
+var ErrAmbiguousHead = errors.New("brik stack has several heads")
+
+// Stack is an LSM stack of brik files in one directory. Each file is linked
+// to the previous one by its first parent. Reading a key merges its values
+// from the oldest file to the newest one, so a file only needs to hold the
+// changed keys and map entries.
+type Stack struct {
+	dir   string
+	key   ed25519.PrivateKey
+	files []*File // the oldest file goes first
+	mutex sync.RWMutex
+}
+
+// OpenStack loads the stack ending at the file named in HEAD. Without HEAD the
+// newest file is the only one that no other file refers to as a parent.
+func OpenStack(dir string) (*Stack, error) {
+	s := &Stack{dir: dir}
+
+	head, err := s.findHead()
+	if err != nil {
+		return nil, err
+	}
+
+	for hash := head; hash != nil; {
+		file, err := ReadFile(s.path(*hash))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read brik stack: %w", err)
+		}
+		s.files = append(s.files, file)
+
+		hash = nil
+		if len(file.Parents) > 0 {
+			hash = &file.Parents[0]
+		}
+	}
+
+	for i, j := 0, len(s.files)-1; i < j; i, j = i+1, j-1 {
+		s.files[i], s.files[j] = s.files[j], s.files[i]
+	}
+
+	return s, nil
+}
+
+// SetKey makes the stack sign the files it writes from now on
+func (s *Stack) SetKey(key ed25519.PrivateKey) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.key = key
+}
+
+// Head returns the hash of the newest file, nil for an empty stack
+func (s *Stack) Head() *Hash {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if len(s.files) == 0 {
+		return nil
+	}
+	hash := s.files[len(s.files)-1].Hash
+	return &hash
+}
+
+// Depth returns the number of files in the stack
+func (s *Stack) Depth() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.files)
+}
+
+// Files returns the files of the stack, the oldest first
+func (s *Stack) Files() []*File {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	files := make([]*File, len(s.files))
+	copy(files, s.files)
+	return files
+}
+
+// Get returns the merged value of a key
+func (s *Stack) Get(id ID) (Value, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.get(id)
+}
+
+// Scan returns the merged values of all keys in key order
+func (s *Stack) Scan() []Record {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.scan()
+}
+
+// Append writes a new file with the records on top of the stack
+func (s *Stack) Append(records []Record) (Hash, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var parents []Hash
+	if len(s.files) > 0 {
+		parents = []Hash{s.files[len(s.files)-1].Hash}
+	}
+
+	file, err := s.write(parents, records)
+	if err != nil {
+		return Hash{}, err
+	}
+	s.files = append(s.files, file)
+	return file.Hash, nil
+}
+
+// Compact merges the whole stack into a single file and removes the old ones
+func (s *Stack) Compact() (Hash, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	file, err := s.write(nil, s.scan())
+	if err != nil {
+		return Hash{}, err
+	}
+	s.files = []*File{file}
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return file.Hash, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if filepath.Ext(name) == Ext && name != file.Hash.String()+Ext {
+			if err := os.Remove(filepath.Join(s.dir, name)); err != nil {
+				return file.Hash, err
+			}
+		}
+	}
+	return file.Hash, nil
+}
+
+// write saves a file and moves HEAD to it
+func (s *Stack) write(parents []Hash, records []Record) (*File, error) {
+	data, err := Build(parents, records, s.key)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := WriteFile(s.dir, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write brik file: %w", err)
+	}
+
+	headPath := filepath.Join(s.dir, HeadFile)
+	if err := os.WriteFile(headPath+".tmp", []byte(hash.String()+"\n"), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write HEAD: %w", err)
+	}
+	if err := os.Rename(headPath+".tmp", headPath); err != nil {
+		return nil, fmt.Errorf("failed to write HEAD: %w", err)
+	}
+
+	return Parse(data)
+}
+
+func (s *Stack) get(id ID) (Value, bool) {
+	var merged Value
+	for _, file := range s.files {
+		if value, ok := file.Get(id); ok {
+			if merged == nil {
+				merged = value
+			} else {
+				merged = Merge(merged, value)
+			}
+		}
+	}
+	if merged == nil || merged.Type() == TypeTomb {
+		return nil, false
+	}
+	return dropTombs(merged), true
+}
+
+func (s *Stack) scan() []Record {
+	seen := make(map[ID]bool)
+	var ids []ID
+	for _, file := range s.files {
+		for _, record := range file.Records {
+			if !seen[record.ID] {
+				seen[record.ID] = true
+				ids = append(ids, record.ID)
+			}
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].Compare(ids[j]) < 0
+	})
+
+	records := make([]Record, 0, len(ids))
+	for _, id := range ids {
+		if value, ok := s.get(id); ok {
+			records = append(records, Record{ID: id, Value: value})
+		}
+	}
+	return records
+}
+
+// findHead reads HEAD or, if there is none, finds the file nobody refers to
+func (s *Stack) findHead() (*Hash, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, HeadFile))
+	if err == nil {
+		hash, err := ParseHash(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, err
+		}
+		return &hash, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	heads := make(map[Hash]bool)
+	var parents []Hash
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != Ext {
+			continue
+		}
+		file, err := ReadFile(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		heads[file.Hash] = true
+		parents = append(parents, file.Parents...)
+	}
+	for _, parent := range parents {
+		delete(heads, parent)
+	}
+
+	if len(heads) > 1 {
+		return nil, fmt.Errorf("%w: %s", ErrAmbiguousHead, s.dir)
+	}
+	for hash := range heads {
+		return &hash, nil
+	}
+	return nil, nil
+}
+
+func (s *Stack) path(hash Hash) string {
+	return filepath.Join(s.dir, hash.String()+Ext)
+}
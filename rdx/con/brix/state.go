@@ -0,0 +1,130 @@
+package brix
+
+import (
+	"fmt"
+)
+
+// ConCoin objects of the state
+var (
+	BalancesID      = MustParseID("cc-1")
+	BlockNonceID    = MustParseID("cc-2")
+	PublicKeysID    = MustParseID("cc-3")
+	NoncesID        = MustParseID("cc-4")
+	LastBlockHashID = MustParseID("cc-5")
+)
+
+// State is the ConCoin state as kept in a stack. A file on top of the stack
+// may hold only the changed entries, a nil map or an empty string means
+// "no change".
+type State struct {
+	Balances      map[string]int64
+	PublicKeys    map[string]string
+	Nonces        map[string]uint64
+	BlockNonce    string
+	LastBlockHash string
+}
+
+// ReadState reads the merged state of a stack
+func ReadState(stack *Stack) (*State, error) {
+	state := &State{
+		Balances:   make(map[string]int64),
+		PublicKeys: make(map[string]string),
+		Nonces:     make(map[string]uint64),
+	}
+
+	if err := readMap(stack, BalancesID, func(user string, value Value) error {
+		balance, err := value.Int()
+		state.Balances[user] = balance
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	if err := readMap(stack, PublicKeysID, func(user string, value Value) error {
+		pubKey, err := value.Str()
+		state.PublicKeys[user] = pubKey
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	if err := readMap(stack, NoncesID, func(user string, value Value) error {
+		nonce, err := value.Int()
+		state.Nonces[user] = uint64(nonce)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if state.BlockNonce, err = readString(stack, BlockNonceID); err != nil {
+		return nil, err
+	}
+	if state.LastBlockHash, err = readString(stack, LastBlockHashID); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// Records converts the state into records to be appended to a stack
+func (s *State) Records() []Record {
+	var records []Record
+
+	if s.Balances != nil {
+		entries := make(map[string]Value, len(s.Balances))
+		for user, balance := range s.Balances {
+			entries[user] = Int(balance)
+		}
+		records = append(records, Record{ID: BalancesID, Value: Map(entries)})
+	}
+	if s.PublicKeys != nil {
+		entries := make(map[string]Value, len(s.PublicKeys))
+		for user, pubKey := range s.PublicKeys {
+			entries[user] = String(pubKey)
+		}
+		records = append(records, Record{ID: PublicKeysID, Value: Map(entries)})
+	}
+	if s.Nonces != nil {
+		entries := make(map[string]Value, len(s.Nonces))
+		for user, nonce := range s.Nonces {
+			entries[user] = Int(int64(nonce))
+		}
+		records = append(records, Record{ID: NoncesID, Value: Map(entries)})
+	}
+	if s.BlockNonce != "" {
+		records = append(records, Record{ID: BlockNonceID, Value: String(s.BlockNonce)})
+	}
+	if s.LastBlockHash != "" {
+		records = append(records, Record{ID: LastBlockHashID, Value: String(s.LastBlockHash)})
+	}
+
+	return records
+}
+
+func readMap(stack *Stack, id ID, read func(key string, value Value) error) error {
+	value, ok := stack.Get(id)
+	if !ok {
+		return nil
+	}
+	entries, err := value.Map()
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", id, err)
+	}
+	for key, entry := range entries {
+		if err := read(key, entry); err != nil {
+			return fmt.Errorf("failed to read %s of %s: %w", key, id, err)
+		}
+	}
+	return nil
+}
+
+func readString(stack *Stack, id ID) (string, error) {
+	value, ok := stack.Get(id)
+	if !ok {
+		return "", nil
+	}
+	s, err := value.Str()
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", id, err)
+	}
+	return s, nil
+}
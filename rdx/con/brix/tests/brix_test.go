@@ -0,0 +1,256 @@
+package tests
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"concoin/brix"
+)
+
+var (
+	balances   = brix.MustParseID("cc-1")
+	blockNonce = brix.MustParseID("cc-2")
+)
+
+func TestID(t *testing.T) {
+	id, err := brix.ParseID("cc-4")
+	if err != nil {
+		t.Fatalf("Failed to parse id: %v", err)
+	}
+	if id.Src != 0xcc || id.Seq != 4 || id.String() != "cc-4" {
+		t.Errorf("Unexpected id %+v", id)
+	}
+
+	parsed, err := brix.IDFromBytes(id.Bytes())
+	if err != nil || parsed != id {
+		t.Errorf("Bytes round trip failed: %v %v", parsed, err)
+	}
+
+	if _, err := brix.ParseID("cc"); !errors.Is(err, brix.ErrBadID) {
+		t.Errorf("Expected ErrBadID, got %v", err)
+	}
+}
+
+func TestValues(t *testing.T) {
+	for _, n := range []int64{0, 1, -1, 300, -70000, 1 << 40, -1 << 63} {
+		got, err := brix.Int(n).Int()
+		if err != nil || got != n {
+			t.Errorf("Int round trip of %d: got %d, %v", n, got, err)
+		}
+	}
+
+	s, err := brix.String("Alice").Str()
+	if err != nil || s != "Alice" {
+		t.Errorf("String round trip: got %q, %v", s, err)
+	}
+
+	if _, err := brix.String("Alice").Int(); !errors.Is(err, brix.ErrBadValue) {
+		t.Errorf("Expected ErrBadValue, got %v", err)
+	}
+
+	merged := brix.Merge(
+		brix.Map(map[string]brix.Value{"Alice": brix.Int(50), "Bob": brix.Int(10)}),
+		brix.Map(map[string]brix.Value{"Alice": brix.Int(40), "Bob": brix.Tomb(), "Carol": brix.Int(1)}),
+	)
+	entries, err := merged.Map()
+	if err != nil {
+		t.Fatalf("Failed to decode map: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	if alice, _ := entries["Alice"].Int(); alice != 40 {
+		t.Errorf("Expected Alice 40, got %d", alice)
+	}
+	if carol, _ := entries["Carol"].Int(); carol != 1 {
+		t.Errorf("Expected Carol 1, got %d", carol)
+	}
+}
+
+func TestFile(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	parent := brix.Hash{1, 2, 3}
+	data, err := brix.Build([]brix.Hash{parent}, []brix.Record{
+		{ID: blockNonce, Value: brix.String("lmns54nguoq2dfg")},
+		{ID: balances, Value: brix.Map(map[string]brix.Value{"Scrooge": brix.Int(1000)})},
+	}, key)
+	if err != nil {
+		t.Fatalf("Failed to build file: %v", err)
+	}
+
+	file, err := brix.Parse(data)
+	if err != nil {
+		t.Fatalf("Failed to parse file: %v", err)
+	}
+	if !file.HasParent(parent) || len(file.Parents) != 1 {
+		t.Errorf("Unexpected parents %v", file.Parents)
+	}
+	if !file.Signer.Equal(key.Public()) {
+		t.Errorf("Unexpected signer")
+	}
+	if len(file.Records) != 2 || file.Records[0].ID != balances {
+		t.Errorf("Records are not sorted: %v", file.Records)
+	}
+	nonce, ok := file.Get(blockNonce)
+	if s, _ := nonce.Str(); !ok || s != "lmns54nguoq2dfg" {
+		t.Errorf("Unexpected nonce %q", s)
+	}
+
+	t.Run("Tampered", func(t *testing.T) {
+		tampered := append([]byte(nil), data...)
+		tampered[len(tampered)-100] ^= 1
+		if _, err := brix.Parse(tampered); err == nil {
+			t.Errorf("Expected tampered file to be rejected")
+		}
+	})
+
+	t.Run("Renamed", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, brix.Hash{}.String()+brix.Ext)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		if _, err := brix.ReadFile(path); !errors.Is(err, brix.ErrBadFile) {
+			t.Errorf("Expected ErrBadFile, got %v", err)
+		}
+	})
+}
+
+func TestStack(t *testing.T) {
+	dir := t.TempDir()
+
+	stack, err := brix.OpenStack(dir)
+	if err != nil {
+		t.Fatalf("Failed to open empty stack: %v", err)
+	}
+	if stack.Head() != nil {
+		t.Fatalf("Expected empty stack")
+	}
+
+	first, err := stack.Append([]brix.Record{
+		{ID: balances, Value: brix.Map(map[string]brix.Value{"Alice": brix.Int(50), "Bob": brix.Int(10)})},
+		{ID: blockNonce, Value: brix.String("first")},
+	})
+	if err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+	second, err := stack.Append([]brix.Record{
+		{ID: balances, Value: brix.Map(map[string]brix.Value{"Alice": brix.Int(45), "Bob": brix.Tomb()})},
+		{ID: blockNonce, Value: brix.Tomb()},
+	})
+	if err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+
+	check := func(t *testing.T, stack *brix.Stack) {
+		value, ok := stack.Get(balances)
+		if !ok {
+			t.Fatalf("Balances not found")
+		}
+		entries, err := value.Map()
+		if err != nil {
+			t.Fatalf("Failed to decode balances: %v", err)
+		}
+		if len(entries) != 1 {
+			t.Fatalf("Expected only Alice, got %d entries", len(entries))
+		}
+		if alice, _ := entries["Alice"].Int(); alice != 45 {
+			t.Errorf("Expected Alice 45, got %d", alice)
+		}
+		if _, ok := stack.Get(blockNonce); ok {
+			t.Errorf("Expected deleted key to be missing")
+		}
+	}
+
+	t.Run("Reopen", func(t *testing.T) {
+		reopened, err := brix.OpenStack(dir)
+		if err != nil {
+			t.Fatalf("Failed to reopen stack: %v", err)
+		}
+		if reopened.Depth() != 2 || *reopened.Head() != second {
+			t.Fatalf("Unexpected stack head")
+		}
+		if files := reopened.Files(); !files[1].HasParent(first) {
+			t.Errorf("Second file is not linked to the first one")
+		}
+		check(t, reopened)
+	})
+
+	t.Run("WithoutHead", func(t *testing.T) {
+		if err := os.Remove(filepath.Join(dir, brix.HeadFile)); err != nil {
+			t.Fatalf("Failed to remove HEAD: %v", err)
+		}
+		reopened, err := brix.OpenStack(dir)
+		if err != nil {
+			t.Fatalf("Failed to reopen stack: %v", err)
+		}
+		if *reopened.Head() != second {
+			t.Fatalf("Expected the unreferenced file to be the head")
+		}
+	})
+
+	t.Run("Compact", func(t *testing.T) {
+		head, err := stack.Compact()
+		if err != nil {
+			t.Fatalf("Failed to compact: %v", err)
+		}
+		if stack.Depth() != 1 || len(stack.Files()[0].Parents) != 0 {
+			t.Fatalf("Expected a single file without parents")
+		}
+		check(t, stack)
+
+		matches, _ := filepath.Glob(filepath.Join(dir, "*"+brix.Ext))
+		if len(matches) != 1 || filepath.Base(matches[0]) != head.String()+brix.Ext {
+			t.Errorf("Expected old files to be removed, got %v", matches)
+		}
+	})
+}
+
+func TestState(t *testing.T) {
+	stack, err := brix.OpenStack(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open stack: %v", err)
+	}
+
+	genesis := &brix.State{
+		Balances:      map[string]int64{"Alice": 50},
+		PublicKeys:    map[string]string{"Alice": "04aa"},
+		LastBlockHash: "block1",
+	}
+	if _, err := stack.Append(genesis.Records()); err != nil {
+		t.Fatalf("Failed to append genesis: %v", err)
+	}
+
+	// The next block only carries the changes
+	block := &brix.State{
+		Balances:      map[string]int64{"Alice": 20, "Bob": 30},
+		PublicKeys:    map[string]string{"Bob": "04bb"},
+		Nonces:        map[string]uint64{"Alice": 1},
+		BlockNonce:    "42",
+		LastBlockHash: "block2",
+	}
+	if _, err := stack.Append(block.Records()); err != nil {
+		t.Fatalf("Failed to append block: %v", err)
+	}
+
+	state, err := brix.ReadState(stack)
+	if err != nil {
+		t.Fatalf("Failed to read state: %v", err)
+	}
+	if state.Balances["Alice"] != 20 || state.Balances["Bob"] != 30 {
+		t.Errorf("Unexpected balances %v", state.Balances)
+	}
+	if len(state.PublicKeys) != 2 || state.PublicKeys["Alice"] != "04aa" {
+		t.Errorf("Unexpected public keys %v", state.PublicKeys)
+	}
+	if state.Nonces["Alice"] != 1 || state.BlockNonce != "42" || state.LastBlockHash != "block2" {
+		t.Errorf("Unexpected state %+v", state)
+	}
+}
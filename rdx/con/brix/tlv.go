@@ -0,0 +1,61 @@
+package brix
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// The records are ToyTLV: an uppercase letter with a 4-byte little-endian length,
+// a lowercase letter with a 1-byte length for short bodies, or a tiny record
+// `0`..`9` whose digit is the length of the body.
+
+var ErrBadRecord = errors.New("bad TLV record")
+
+// appendRecord appends a record of type lit (an uppercase letter)
+func appendRecord(dst []byte, lit byte, body []byte) []byte {
+	if len(body) <= 0xff {
+		dst = append(dst, lit|0x20, byte(len(body)))
+	} else {
+		var length [4]byte
+		binary.LittleEndian.PutUint32(length[:], uint32(len(body)))
+		dst = append(dst, lit)
+		dst = append(dst, length[:]...)
+	}
+	return append(dst, body...)
+}
+
+// appendTiny appends a tiny record, the body must be at most 9 bytes long
+func appendTiny(dst []byte, body []byte) []byte {
+	dst = append(dst, '0'+byte(len(body)))
+	return append(dst, body...)
+}
+
+// takeRecord reads the next record. The type of a tiny record is '0'.
+func takeRecord(data []byte) (lit byte, body []byte, rest []byte, err error) {
+	if len(data) == 0 {
+		return 0, nil, nil, ErrBadRecord
+	}
+
+	var length, header int
+	switch c := data[0]; {
+	case c >= '0' && c <= '9':
+		lit, length, header = '0', int(c-'0'), 1
+	case c >= 'a' && c <= 'z':
+		if len(data) < 2 {
+			return 0, nil, nil, ErrBadRecord
+		}
+		lit, length, header = c&^0x20, int(data[1]), 2
+	case c >= 'A' && c <= 'Z':
+		if len(data) < 5 {
+			return 0, nil, nil, ErrBadRecord
+		}
+		lit, length, header = c, int(binary.LittleEndian.Uint32(data[1:5])), 5
+	default:
+		return 0, nil, nil, ErrBadRecord
+	}
+
+	if length < 0 || len(data)-header < length {
+		return 0, nil, nil, ErrBadRecord
+	}
+	return lit, data[header : header+length], data[header+length:], nil
+}
@@ -0,0 +1,209 @@
+package brix
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// RDX element types used by ConCoin
+const (
+	TypeInt    byte = 'I'
+	TypeString byte = 'S'
+	TypeMap    byte = 'M'
+	TypeTomb   byte = 'T'
+)
+
+var ErrBadValue = errors.New("bad RDX value")
+
+// Value is an RDX element in its binary TLV form. The body of an element starts
+// with its revision stamp; ConCoin state is decided by consensus rather than
+// merged concurrently, so the stamp is always empty.
+type Value []byte
+
+// Int makes an `I` element
+func Int(n int64) Value {
+	zigzag := uint64(n<<1) ^ uint64(n>>63)
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], zigzag)
+	return element(TypeInt, buf[:zipLen(zigzag)])
+}
+
+// String makes an `S` element
+func String(s string) Value {
+	return element(TypeString, []byte(s))
+}
+
+// Tomb makes a `T` element, which deletes the key or the map entry it is written to
+func Tomb() Value {
+	return element(TypeTomb, nil)
+}
+
+// Map makes an `M` element with string keys, ordered by key
+func Map(entries map[string]Value) Value {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var body []byte
+	for _, key := range keys {
+		body = append(body, String(key)...)
+		body = append(body, entries[key]...)
+	}
+	return element(TypeMap, body)
+}
+
+// Type returns the element type letter
+func (v Value) Type() byte {
+	lit, _, _, err := takeRecord(v)
+	if err != nil {
+		return 0
+	}
+	return lit
+}
+
+// Int decodes an `I` element
+func (v Value) Int() (int64, error) {
+	payload, err := v.payload(TypeInt)
+	if err != nil {
+		return 0, err
+	}
+	if len(payload) > 8 {
+		return 0, fmt.Errorf("%w: int of %d bytes", ErrBadValue, len(payload))
+	}
+	var buf [8]byte
+	copy(buf[:], payload)
+	zigzag := binary.LittleEndian.Uint64(buf[:])
+	return int64(zigzag>>1) ^ -int64(zigzag&1), nil
+}
+
+// Str decodes an `S` element
+func (v Value) Str() (string, error) {
+	payload, err := v.payload(TypeString)
+	if err != nil {
+		return "", err
+	}
+	return string(payload), nil
+}
+
+// Map decodes an `M` element with string keys. Deleted entries are skipped.
+func (v Value) Map() (map[string]Value, error) {
+	entries, err := v.entries()
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range entries {
+		if value.Type() == TypeTomb {
+			delete(entries, key)
+		}
+	}
+	return entries, nil
+}
+
+// Merge applies a newer version of a value on top of an older one.
+// Maps are merged entry by entry, any other newer value replaces the older one.
+func Merge(older, newer Value) Value {
+	if older.Type() != TypeMap || newer.Type() != TypeMap {
+		return newer
+	}
+	merged, err := older.entries()
+	if err != nil {
+		return newer
+	}
+	update, err := newer.entries()
+	if err != nil {
+		return newer
+	}
+	for key, value := range update {
+		merged[key] = value
+	}
+	return Map(merged)
+}
+
+// dropTombs removes deletion marks once there is nothing older left to delete
+func dropTombs(v Value) Value {
+	if v.Type() != TypeMap {
+		return v
+	}
+	entries, err := v.Map()
+	if err != nil {
+		return v
+	}
+	return Map(entries)
+}
+
+// entries decodes map entries including the deletion marks
+func (v Value) entries() (map[string]Value, error) {
+	payload, err := v.payload(TypeMap)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]Value)
+	for len(payload) > 0 {
+		var key, value Value
+		if key, payload, err = takeValue(payload); err != nil {
+			return nil, err
+		}
+		if len(payload) == 0 {
+			return nil, fmt.Errorf("%w: map key without a value", ErrBadValue)
+		}
+		if value, payload, err = takeValue(payload); err != nil {
+			return nil, err
+		}
+		name, err := key.Str()
+		if err != nil {
+			return nil, fmt.Errorf("%w: map key is not a string", ErrBadValue)
+		}
+		entries[name] = value
+	}
+	return entries, nil
+}
+
+// payload checks the element type and strips the revision stamp
+func (v Value) payload(lit byte) ([]byte, error) {
+	recordLit, body, rest, err := takeRecord(v)
+	if err != nil || len(rest) != 0 {
+		return nil, ErrBadValue
+	}
+	if recordLit != lit {
+		return nil, fmt.Errorf("%w: expected %c, got %c", ErrBadValue, lit, recordLit)
+	}
+	stampLit, _, payload, err := takeRecord(body)
+	if err != nil || stampLit != '0' {
+		return nil, fmt.Errorf("%w: no revision stamp", ErrBadValue)
+	}
+	return payload, nil
+}
+
+// takeValue reads one element off the data
+func takeValue(data []byte) (Value, []byte, error) {
+	lit, _, rest, err := takeRecord(data)
+	if err != nil || lit == '0' {
+		return nil, nil, ErrBadValue
+	}
+	return Value(data[:len(data)-len(rest)]), rest, nil
+}
+
+func element(lit byte, payload []byte) Value {
+	body := appendTiny(nil, nil)
+	body = append(body, payload...)
+	return Value(appendRecord(nil, lit, body))
+}
+
+// zipLen is the number of bytes a zipped int takes: 0, 1, 2, 4 or 8
+func zipLen(n uint64) int {
+	switch {
+	case n == 0:
+		return 0
+	case n <= 0xff:
+		return 1
+	case n <= 0xffff:
+		return 2
+	case n <= 0xffffffff:
+		return 4
+	}
+	return 8
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"concoin/conrun/pkg/storage"
+
+	"github.com/spf13/cobra"
+)
+
+var root string
+
+func main() {
+	rootCmd := &cobra.Command{
+		Use:   "migrate [data-dir...]",
+		Short: "Import json node storage into the kv storage",
+		Long: "Copies messages, peers, sync cursors and bans of the json storage " +
+			"(data-dir/messages, data-dir/peers) into the kv storage (data-dir/kv). " +
+			"Without arguments all node data directories under --root are imported. " +
+			"Messages already in the kv storage are skipped, so the import can be repeated. " +
+			"The node must be stopped; start it with --storage=kv afterwards.",
+		RunE: run,
+	}
+	rootCmd.Flags().StringVar(&root, "root", ".nodedata", "Directory with node data directories port*")
+
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func run(cmd *cobra.Command, args []string) error {
+	dataDirs := args
+	if len(dataDirs) == 0 {
+		matches, err := filepath.Glob(filepath.Join(root, "port*"))
+		if err != nil {
+			return err
+		}
+		dataDirs = matches
+	}
+	if len(dataDirs) == 0 {
+		return fmt.Errorf("no node data directories in %s", root)
+	}
+
+	for _, dataDir := range dataDirs {
+		if err := migrate(dataDir); err != nil {
+			return fmt.Errorf("%s: %w", dataDir, err)
+		}
+	}
+	return nil
+}
+
+// migrate переносит данные одного узла
+func migrate(dataDir string) error {
+	if _, err := os.Stat(filepath.Join(dataDir, "messages")); err != nil {
+		return fmt.Errorf("no json storage: %w", err)
+	}
+
+	to, err := storage.NewKVStorage(dataDir)
+	if err != nil {
+		return err
+	}
+	stats, err := storage.Migrate(storage.NewStorage(dataDir), to)
+	if closeErr := to.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: imported %d messages (%d already present), %d peers, %d sync cursors, %d bans\n",
+		dataDir, stats.Messages, stats.Skipped, stats.Peers, stats.Cursors, stats.Bans)
+	return nil
+}
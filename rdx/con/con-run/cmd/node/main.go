@@ -1,39 +1,79 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 
 	"concoin/conrun/pkg/api"
 	"concoin/conrun/pkg/config"
-	"concoin/conrun/pkg/gossip"
-	"concoin/conrun/pkg/hooks"
-	"concoin/conrun/pkg/models"
-	"concoin/conrun/pkg/pex"
-	"concoin/conrun/pkg/storage"
+	"concoin/conrun/pkg/node"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 var (
-	port      int
-	seedPort  int
-	cleanFlag bool
+	configPath string
+	port       int
+	seeds      []string
+	listenAddr string
+	advertise  string
+	cleanFlag  bool
+	minerID    string
+	threads    int
+	backend    string
+	netType    string
 )
 
+// aliasedSettings настройки, для которых есть короткие флаги выше. ID узла
+// выводится из ключа, поэтому флага для него нет.
+var aliasedSettings = map[string]bool{
+	"node_id":         true,
+	"port":            true,
+	"seed_nodes":      true,
+	"listen_addr":     true,
+	"advertise_addr":  true,
+	"storage_backend": true,
+	"transport.type":  true,
+	"miner.miner_id":  true,
+	"miner.threads":   true,
+}
+
 func main() {
 	rootCmd := &cobra.Command{
 		Use:   "node",
 		Short: "Network node",
-		Long:  "Simple network node for peer exchange and message passing",
-		Run:   run,
+		Long: "Simple network node for peer exchange and message passing.\n\n" +
+			"Settings are taken from defaults, then --config file, then " + config.EnvPrefix + "* environment variables, then flags. " +
+			"SIGHUP reloads the config file and environment and applies log level and limits without restart.",
+		Run: run,
 	}
 
+	rootCmd.Flags().StringVar(&configPath, "config", "", "JSON config file (see data_dir/config/config.json for all settings)")
 	rootCmd.Flags().IntVar(&port, "port", 3000, "Port to listen on")
-	rootCmd.Flags().IntVar(&seedPort, "seed", 0, "Seed node port")
+	rootCmd.Flags().StringSliceVar(&seeds, "seed", nil, "Seed node as host:port, or a port of a node on this machine (repeatable)")
+	rootCmd.Flags().StringVar(&listenAddr, "listen", "", "Interface to listen on (default: all interfaces)")
+	rootCmd.Flags().StringVar(&advertise, "advertise", "", "Address announced to peers as host[:port] (default: the IP peers see)")
 	rootCmd.Flags().BoolVar(&cleanFlag, "clean", false, "Clean start (remove all data)")
+	rootCmd.Flags().StringVar(&minerID, "miner-id", "", "Mine blocks with rewards to this user id (mining is disabled if empty)")
+	rootCmd.Flags().IntVar(&threads, "mine-threads", 0, "Number of mining threads (default: number of CPUs)")
+	rootCmd.Flags().StringVar(&backend, "storage", "", "Storage backend: json, brix (RDX SST files) or kv (embedded key-value store)")
+	rootCmd.Flags().StringVar(&netType, "transport", "", "Peer transport: http or tcp (persistent connections)")
+
+	// Флаги для остальных настроек конфигурации
+	defaults := config.DefaultConfig(3000, 0)
+	defaults.DataDir = ""
+	for _, field := range config.Fields(defaults) {
+		if !aliasedSettings[field.Key] {
+			rootCmd.Flags().String(field.Flag, field.Value, fmt.Sprintf("Setting %s (env %s)", field.Key, field.Env))
+		}
+	}
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Printf("Error: %v\n", err)
@@ -41,16 +81,59 @@ func main() {
 	}
 }
 
+// flagOverrides собирает настройки из флагов, заданных в командной строке
+func flagOverrides(cmd *cobra.Command) map[string]string {
+	overrides := make(map[string]string)
+	flags := cmd.Flags()
+	for _, field := range config.Fields(config.DefaultConfig(3000, 0)) {
+		if flag := flags.Lookup(field.Flag); flag != nil && flag.Changed && !aliasedSettings[field.Key] {
+			overrides[field.Key] = flag.Value.String()
+		}
+	}
+
+	if flags.Changed("port") {
+		overrides["port"] = strconv.Itoa(port)
+	}
+	if flags.Changed("seed") {
+		overrides["seed_nodes"] = strings.Join(seeds, ",")
+	}
+	if flags.Changed("listen") {
+		overrides["listen_addr"] = listenAddr
+	}
+	if flags.Changed("advertise") {
+		overrides["advertise_addr"] = advertise
+	}
+	if minerID != "" {
+		overrides["miner.enabled"] = "true"
+		overrides["miner.miner_id"] = minerID
+	}
+	if threads > 0 {
+		overrides["miner.threads"] = strconv.Itoa(threads)
+	}
+	if backend != "" {
+		overrides["storage_backend"] = backend
+	}
+	if netType != "" {
+		overrides["transport.type"] = netType
+	}
+	return overrides
+}
+
 func run(cmd *cobra.Command, args []string) {
 	// Инициализируем логгер
 	logger := logrus.New()
-	logger.SetLevel(logrus.InfoLevel)
 	logger.SetFormatter(&logrus.TextFormatter{
 		FullTimestamp: true,
 	})
 
-	// Создаем конфигурацию
-	cfg := config.DefaultConfig(port, seedPort)
+	// Собираем конфигурацию из файла, окружения и флагов
+	overrides := flagOverrides(cmd)
+	cfg, err := config.Load(configPath, os.Environ(), overrides)
+	if err != nil {
+		logger.Fatalf("Failed to load config: %v", err)
+	}
+	level, _ := logrus.ParseLevel(cfg.LogLevel)
+	logger.SetLevel(level)
 
 	// Очищаем данные, если указан флаг clean
 	if cleanFlag {
@@ -60,19 +143,6 @@ func run(cmd *cobra.Command, args []string) {
 		logger.Info("Cleaned all data directories")
 	}
 
-	// Создаем директории для хранения данных
-	if err := cfg.CreateDataDirs(); err != nil {
-		logger.Fatalf("Failed to create data directories: %v", err)
-	}
-
-	// Сохраняем конфигурацию
-	if err := cfg.SaveConfig(); err != nil {
-		logger.Fatalf("Failed to save config: %v", err)
-	}
-
-	// Создаем хранилище
-	store := storage.NewStorage(cfg.DataDir)
-
 	// Получаем абсолютный путь к корневой директории проекта
 	projectRoot, err := os.Getwd()
 	if err != nil {
@@ -80,34 +150,23 @@ func run(cmd *cobra.Command, args []string) {
 	}
 	logger.Infof("Project root directory: %s", projectRoot)
 
-	// Создаем менеджер хуков
-	hookManager := hooks.NewHookManager(cfg.DataDir, logger)
-	hookManager.AddHook(hooks.NewDebugHook(logger))
-	hookManager.AddHook(hooks.NewBlockchainHook(cfg.DataDir, logger))
-
-	// Создаем Gossip протокол
-	gossipProtocol := gossip.NewGossipProtocol(cfg, logger, store, hookManager)
-
-	// Создаем PEX протокол
-	pexProtocol := pex.NewPexProtocol(cfg, store, logger, hookManager)
-
-	// Создаем API
-	nodeAPI := api.NewAPI(cfg, gossipProtocol, pexProtocol, logger, store, hookManager)
+	// Создаем узел
+	n, err := node.New(cfg, logger)
+	if err != nil {
+		logger.Fatalf("Failed to create node: %v", err)
+	}
 
 	// Устанавливаем хук для логгера
-	logger.AddHook(&LogHook{nodeAPI})
+	logger.AddHook(&LogHook{n.API})
 
-	// Настраиваем взаимодействие компонентов
-	pexProtocol.SetOnPeersListHandler(func(peers []models.Peer) {
-		gossipProtocol.UpdatePeers(peers)
-	})
+	// Останавливаем узел по SIGINT и SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Запускаем компоненты
-	pexProtocol.Start()
-	gossipProtocol.Start()
-	nodeAPI.Start()
-
-	logger.Infof("Node started on port %d with seed port %d", cfg.Port, seedPort)
+	if err := n.Start(ctx); err != nil {
+		logger.Fatalf("Failed to start node: %v", err)
+	}
 
 	// Запускаем API получения транзакций
 	http.HandleFunc("/transactions", nil)
@@ -118,8 +177,27 @@ func run(cmd *cobra.Command, args []string) {
 		}
 	}()
 
-	// Ждем бесконечно
-	select {}
+	// По SIGHUP перечитываем конфигурацию
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
+	// Ждем сигнала остановки; повторный сигнал завершает процесс сразу
+	for {
+		select {
+		case <-reload:
+			next, err := config.Load(configPath, os.Environ(), overrides)
+			if err != nil {
+				logger.Errorf("Failed to reload config, keeping the current one: %v", err)
+				continue
+			}
+			n.Reload(next)
+		case <-ctx.Done():
+			stop()
+			logger.Info("Shutting down")
+			n.Stop()
+			return
+		}
+	}
 }
 
 // LogHook перенаправляет логи в API
@@ -1,8 +1,9 @@
 module concoin/conrun
 
-go 1.21
+go 1.22.4
 
 require (
+	concoin v0.0.0
 	github.com/gorilla/mux v1.8.1
 	github.com/sirupsen/logrus v1.9.3
 	github.com/spf13/cobra v1.8.0
@@ -18,3 +19,5 @@ require (
 	golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace concoin => ../
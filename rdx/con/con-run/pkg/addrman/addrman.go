@@ -0,0 +1,387 @@
+package addrman
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	mathrand "math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"concoin/conrun/pkg/config"
+	"concoin/conrun/pkg/models"
+)
+
+const (
+	bucketSize = 16 // адресов в одной корзине
+
+	// Доля корзин таблицы проверенных адресов, доступная одной группе сетей
+	triedGroupDivisor = 8
+
+	// newPerTried во столько раз новых корзин больше, чем проверенных
+	newPerTried = 4
+
+	// maxAttempts неудачных попыток подряд, после которых адрес считается плохим
+	maxAttempts = 3
+
+	// horizon адреса, не появлявшиеся дольше, уступают место в корзине
+	horizon = 30 * time.Minute
+)
+
+// AddrMan хранит известные адреса пиров в двух таблицах по образцу addrman
+// из Bitcoin. Новые адреса, полученные через PEX, лежат в таблице new, адреса,
+// с которыми состоялся обмен, - в таблице tried. Обе таблицы разбиты на корзины:
+//   - корзина нового адреса выбирается по группе сети источника, так что один
+//     источник может заполнить только NewPeerShare процентов корзин new;
+//   - корзина проверенного адреса выбирается по группе сети самого адреса,
+//     так что узлы из одной сети занимают лишь часть таблицы tried.
+//
+// Номера корзин зависят от случайного ключа узла, поэтому атакующий не может
+// заранее подобрать адреса под нужные корзины. AddrMan не потокобезопасен.
+type AddrMan struct {
+	key       [32]byte
+	entries   map[string]*entry // nodeID -> адрес
+	newTable  [][]string        // корзина -> nodeID
+	tried     [][]string
+	triedSize int
+
+	maxTried  int // не больше MaxPeers проверенных адресов
+	perSource int // корзин new на одну группу источников
+	perGroup  int // корзин tried на одну группу адресов
+	score     func(nodeID string) float64
+}
+
+type entry struct {
+	peer     models.Peer
+	source   models.Peer // пир, сообщивший адрес; пустой - сам узел
+	tried    bool
+	bucket   int
+	attempts int // неудачных попыток подряд
+}
+
+// New создает пустые таблицы. Число корзин выводится из MaxPeers, доля корзин
+// new на один источник - из NewPeerShare. score оценивает пира при вытеснении
+// из заполненной таблицы tried.
+func New(cfg config.PexConfig, score func(nodeID string) float64) *AddrMan {
+	triedCount := max(1, (cfg.MaxPeers+bucketSize-1)/bucketSize)
+	newCount := triedCount * newPerTried
+
+	a := &AddrMan{
+		entries:   make(map[string]*entry),
+		newTable:  make([][]string, newCount),
+		tried:     make([][]string, triedCount),
+		maxTried:  max(1, cfg.MaxPeers),
+		perSource: max(1, (newCount*cfg.NewPeerShare+99)/100),
+		perGroup:  max(1, triedCount/triedGroupDivisor),
+		score:     score,
+	}
+	if _, err := rand.Read(a.key[:]); err != nil {
+		panic(fmt.Sprintf("addrman: failed to generate bucket key: %v", err))
+	}
+	return a
+}
+
+// Group возвращает группу сети адреса: /16 для IPv4, /32 для IPv6, домен
+// второго уровня для имен. Адреса loopback различаются целиком, чтобы узлы
+// тестовой сети на одной машине не делили одну группу.
+func Group(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil && host == "":
+		return "unroutable"
+	case ip == nil:
+		labels := strings.Split(strings.ToLower(strings.TrimSuffix(host, ".")), ".")
+		if len(labels) > 2 {
+			labels = labels[len(labels)-2:]
+		}
+		return "name:" + strings.Join(labels, ".")
+	case ip.IsLoopback():
+		return "local:" + address
+	case ip.IsUnspecified():
+		return "unroutable"
+	case ip.To4() != nil:
+		ip4 := ip.To4()
+		return fmt.Sprintf("ipv4:%d.%d", ip4[0], ip4[1])
+	}
+	return fmt.Sprintf("ipv6:%x", []byte(ip.To16()[:4]))
+}
+
+// Add добавляет адрес, полученный от пира source, в таблицу new. Известному адресу
+// обновляется время; адрес проверенного пира так не меняется. Возвращает false,
+// если адрес уже известен или его корзина заполнена хорошими адресами.
+func (a *AddrMan) Add(peer models.Peer, source models.Peer) bool {
+	if e, exists := a.entries[peer.NodeID]; exists {
+		if peer.LastSeen.After(e.peer.LastSeen) && (!e.tried || peer.Address == e.peer.Address) {
+			if !e.tried && peer.Address != e.peer.Address {
+				a.unlink(e)
+				e.peer.Address = peer.Address
+				e.source = source
+				if !a.placeNew(e) {
+					delete(a.entries, peer.NodeID)
+				}
+			}
+			e.peer.LastSeen = peer.LastSeen
+		}
+		return false
+	}
+
+	e := &entry{peer: peer, source: source}
+	if !a.placeNew(e) {
+		return false
+	}
+	a.entries[peer.NodeID] = e
+	return true
+}
+
+// placeNew кладет адрес в его корзину new, вытесняя из нее плохой адрес
+func (a *AddrMan) placeNew(e *entry) bool {
+	bucket := a.newBucket(e.peer.Address, e.source.Address)
+	if len(a.newTable[bucket]) >= bucketSize {
+		victim := -1
+		for i, nodeID := range a.newTable[bucket] {
+			if a.terrible(a.entries[nodeID]) {
+				victim = i
+				break
+			}
+		}
+		if victim < 0 {
+			return false
+		}
+		delete(a.entries, a.newTable[bucket][victim])
+		a.newTable[bucket] = append(a.newTable[bucket][:victim], a.newTable[bucket][victim+1:]...)
+	}
+
+	e.tried = false
+	e.bucket = bucket
+	a.newTable[bucket] = append(a.newTable[bucket], e.peer.NodeID)
+	return true
+}
+
+// Good отмечает успешный обмен с пиром и переносит его в таблицу tried.
+// Если корзина или таблица заполнена, худший по оценке пир возвращается
+// в new; если худший - сам кандидат, он остается в new. Возвращает true,
+// если пир оказался в tried впервые.
+func (a *AddrMan) Good(nodeID string) bool {
+	e, exists := a.entries[nodeID]
+	if !exists {
+		return false
+	}
+	e.attempts = 0
+	if e.tried {
+		return false
+	}
+
+	bucket := a.triedBucket(e.peer.Address)
+	var victim *entry
+	switch {
+	case len(a.tried[bucket]) >= bucketSize:
+		victim = a.worst(a.tried[bucket])
+	case a.triedSize >= a.maxTried:
+		for _, ids := range a.tried {
+			if candidate := a.worst(ids); candidate != nil && (victim == nil || a.worse(candidate, victim)) {
+				victim = candidate
+			}
+		}
+	}
+	if victim != nil {
+		if !a.worse(victim, e) {
+			return false
+		}
+		a.unlink(victim)
+		if !a.placeNew(victim) {
+			delete(a.entries, victim.peer.NodeID)
+		}
+	}
+
+	a.unlink(e)
+	e.tried = true
+	e.bucket = bucket
+	a.tried[bucket] = append(a.tried[bucket], nodeID)
+	a.triedSize++
+	return true
+}
+
+// Attempt отмечает неудачную попытку обмена с пиром. Для непроверенного
+// адреса при первой неудаче возвращает ID сообщившего его пира.
+func (a *AddrMan) Attempt(nodeID string) string {
+	e, exists := a.entries[nodeID]
+	if !exists {
+		return ""
+	}
+	e.attempts++
+	if e.tried || e.attempts > 1 {
+		return ""
+	}
+	return e.source.NodeID
+}
+
+// Remove удаляет адрес пира из таблиц
+func (a *AddrMan) Remove(nodeID string) bool {
+	e, exists := a.entries[nodeID]
+	if !exists {
+		return false
+	}
+	a.unlink(e)
+	delete(a.entries, nodeID)
+	return true
+}
+
+// Expire удаляет адреса, не появлявшиеся дольше ttl
+func (a *AddrMan) Expire(ttl time.Duration) int {
+	removed := 0
+	for nodeID, e := range a.entries {
+		if time.Since(e.peer.LastSeen) > ttl {
+			a.Remove(nodeID)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Lookup возвращает адрес пира и признак того, что он проверен
+func (a *AddrMan) Lookup(nodeID string) (models.Peer, bool, bool) {
+	e, exists := a.entries[nodeID]
+	if !exists {
+		return models.Peer{}, false, false
+	}
+	return e.peer, e.tried, true
+}
+
+// Tried возвращает проверенные адреса
+func (a *AddrMan) Tried() []models.Peer {
+	return a.collect(a.tried)
+}
+
+// NewAddresses возвращает непроверенные адреса
+func (a *AddrMan) NewAddresses() []models.Peer {
+	return a.collect(a.newTable)
+}
+
+// Size возвращает число проверенных и новых адресов
+func (a *AddrMan) Size() (int, int) {
+	return a.triedSize, len(a.entries) - a.triedSize
+}
+
+// Select выбирает адрес для следующего обмена: с равной вероятностью из
+// tried или new, внутри таблицы - случайную непустую корзину и случайный
+// адрес в ней, чтобы группа с множеством адресов не получала преимущества.
+func (a *AddrMan) Select() (models.Peer, bool) {
+	triedCount, newCount := a.Size()
+	table := a.tried
+	if triedCount == 0 || newCount > 0 && mathrand.Intn(2) == 0 {
+		table = a.newTable
+	}
+	if triedCount+newCount == 0 {
+		return models.Peer{}, false
+	}
+
+	var buckets []int
+	for i, ids := range table {
+		if len(ids) > 0 {
+			buckets = append(buckets, i)
+		}
+	}
+	ids := table[buckets[mathrand.Intn(len(buckets))]]
+	return a.entries[ids[mathrand.Intn(len(ids))]].peer, true
+}
+
+// Random возвращает до count случайных адресов из обеих таблиц, не считая
+// плохих и давно не появлявшихся
+func (a *AddrMan) Random(count int) []models.Peer {
+	var candidates []models.Peer
+	for _, e := range a.entries {
+		if !a.terrible(e) && time.Since(e.peer.LastSeen) < horizon {
+			candidates = append(candidates, e.peer)
+		}
+	}
+	mathrand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	if len(candidates) > count {
+		candidates = candidates[:count]
+	}
+	return candidates
+}
+
+// unlink убирает адрес из его корзины
+func (a *AddrMan) unlink(e *entry) {
+	table := a.newTable
+	if e.tried {
+		table = a.tried
+		a.triedSize--
+	}
+	ids := table[e.bucket]
+	for i, nodeID := range ids {
+		if nodeID == e.peer.NodeID {
+			table[e.bucket] = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+}
+
+// worst возвращает худший адрес из списка
+func (a *AddrMan) worst(ids []string) *entry {
+	var worst *entry
+	for _, nodeID := range ids {
+		if e := a.entries[nodeID]; worst == nil || a.worse(e, worst) {
+			worst = e
+		}
+	}
+	return worst
+}
+
+// worse сравнивает адреса по оценке пира, при равных оценках хуже более старый
+func (a *AddrMan) worse(x, y *entry) bool {
+	sx, sy := a.score(x.peer.NodeID), a.score(y.peer.NodeID)
+	if sx != sy {
+		return sx < sy
+	}
+	return x.peer.LastSeen.Before(y.peer.LastSeen)
+}
+
+// terrible сообщает, что адрес можно вытеснить из корзины new
+func (a *AddrMan) terrible(e *entry) bool {
+	return e.attempts >= maxAttempts || time.Since(e.peer.LastSeen) > horizon
+}
+
+func (a *AddrMan) collect(table [][]string) []models.Peer {
+	var peers []models.Peer
+	for _, ids := range table {
+		for _, nodeID := range ids {
+			peers = append(peers, a.entries[nodeID].peer)
+		}
+	}
+	return peers
+}
+
+// newBucket выбирает корзину new: источник попадает в perSource корзин,
+// адрес - в одну из них
+func (a *AddrMan) newBucket(address, source string) int {
+	sourceGroup := Group(source)
+	slot := a.hash(Group(address), sourceGroup) % uint64(a.perSource)
+	return int(a.hash(sourceGroup, fmt.Sprint(slot)) % uint64(len(a.newTable)))
+}
+
+// triedBucket выбирает корзину tried: группа адреса попадает в perGroup
+// корзин, адрес - в одну из них
+func (a *AddrMan) triedBucket(address string) int {
+	slot := a.hash(address) % uint64(a.perGroup)
+	return int(a.hash(Group(address), fmt.Sprint(slot)) % uint64(len(a.tried)))
+}
+
+func (a *AddrMan) hash(parts ...string) uint64 {
+	h := sha256.New()
+	h.Write(a.key[:])
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return binary.BigEndian.Uint64(h.Sum(nil)[:8])
+}
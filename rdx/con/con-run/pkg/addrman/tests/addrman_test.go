@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"concoin/conrun/pkg/addrman"
+	"concoin/conrun/pkg/config"
+	"concoin/conrun/pkg/models"
+)
+
+func newAddrMan(scores map[string]float64) (*addrman.AddrMan, config.PexConfig) {
+	cfg := config.DefaultConfig(3000, 0).PexConfig
+	return addrman.New(cfg, func(nodeID string) float64 { return scores[nodeID] }), cfg
+}
+
+func peer(i int, address string) models.Peer {
+	return models.Peer{NodeID: fmt.Sprintf("peer-%d", i), Address: address, LastSeen: time.Now()}
+}
+
+func TestGroup(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"203.0.113.5:3000", "203.0.7.9:4000", true},
+		{"203.0.113.5:3000", "203.1.113.5:3000", false},
+		{"[2001:db8:1::1]:3000", "[2001:db8:2::1]:3000", true},
+		{"[2001:db8::1]:3000", "[2001:db9::1]:3000", false},
+		{"a.node.example.org:3000", "b.example.org:3000", true},
+		{"example.org:3000", "example.com:3000", false},
+		{"127.0.0.1:3000", "127.0.0.1:3001", false},
+	}
+	for _, tt := range tests {
+		if same := addrman.Group(tt.a) == addrman.Group(tt.b); same != tt.same {
+			t.Errorf("Group(%s) == Group(%s) is %v, expected %v", tt.a, tt.b, same, tt.same)
+		}
+	}
+}
+
+func TestAddrMan_SourceLimit(t *testing.T) {
+	a, cfg := newAddrMan(nil)
+
+	// A single source can fill only its share of the new table
+	attacker := models.Peer{NodeID: "attacker", Address: "198.51.100.7:3000"}
+	for i := 0; i < 1000; i++ {
+		a.Add(peer(i, fmt.Sprintf("10.%d.%d.1:3000", i/250, i%250)), attacker)
+	}
+	_, fromAttacker := a.Size()
+	newBuckets := (cfg.MaxPeers + 15) / 16 * 4
+	limit := (newBuckets*cfg.NewPeerShare + 99) / 100 * 16
+	if fromAttacker == 0 || fromAttacker > limit {
+		t.Fatalf("Expected at most %d addresses from one source, got %d", limit, fromAttacker)
+	}
+
+	// Other sources still get their addresses in
+	for i := 0; i < 50; i++ {
+		source := models.Peer{NodeID: fmt.Sprintf("source-%d", i), Address: fmt.Sprintf("%d.1.1.1:3000", 20+i)}
+		a.Add(peer(1000+i, fmt.Sprintf("172.%d.1.1:3000", i)), source)
+	}
+	if _, total := a.Size(); total-fromAttacker < 30 {
+		t.Errorf("Expected addresses from other sources to be accepted, got %d", total-fromAttacker)
+	}
+
+	// Known addresses are not added twice
+	if a.Add(peer(0, "10.0.0.1:3000"), attacker) {
+		t.Errorf("Expected a known address not to be added again")
+	}
+}
+
+func TestAddrMan_Tried(t *testing.T) {
+	scores := map[string]float64{}
+	a, cfg := newAddrMan(scores)
+
+	// Verified addresses from one network take only a part of the tried table
+	for i := 0; i < cfg.MaxPeers; i++ {
+		source := models.Peer{NodeID: "source", Address: fmt.Sprintf("%d.2.3.4:3000", 20+i)}
+		p := peer(i, fmt.Sprintf("10.0.%d.1:3000", i))
+		a.Add(p, source)
+		a.Good(p.NodeID)
+	}
+	tried, _ := a.Size()
+	if tried == 0 || tried > 16 {
+		t.Fatalf("Expected one network to occupy at most one tried bucket, got %d addresses", tried)
+	}
+
+	// A well scored peer replaces the worst one in a full bucket
+	for _, p := range a.Tried() {
+		scores[p.NodeID] = -50
+	}
+	candidate := peer(cfg.MaxPeers, "10.0.250.1:3000")
+	a.Add(candidate, models.Peer{})
+	if !a.Good(candidate.NodeID) {
+		t.Fatalf("Expected a well scored peer to replace a poorly scored one")
+	}
+	if after, _ := a.Size(); after != tried {
+		t.Errorf("Expected the tried table to keep %d addresses, got %d", tried, after)
+	}
+
+	// A poorly scored peer does not push out better ones
+	scores["peer-loser"] = -100
+	loser := models.Peer{NodeID: "peer-loser", Address: "10.0.251.1:3000", LastSeen: time.Now()}
+	a.Add(loser, models.Peer{})
+	if a.Good(loser.NodeID) {
+		t.Errorf("Expected a poorly scored peer to stay new")
+	}
+	if _, isTried, ok := a.Lookup(loser.NodeID); !ok || isTried {
+		t.Errorf("Expected the poorly scored peer to be kept as new")
+	}
+}
+
+func TestAddrMan_Select(t *testing.T) {
+	a, _ := newAddrMan(nil)
+	if _, ok := a.Select(); ok {
+		t.Fatalf("Expected nothing to select from empty tables")
+	}
+
+	a.Add(peer(1, "10.1.1.1:3000"), models.Peer{})
+	a.Add(peer(2, "10.2.1.1:3000"), models.Peer{})
+	a.Good("peer-1")
+
+	// Both tables are used, so new addresses get verified
+	counts := map[string]int{}
+	for i := 0; i < 200; i++ {
+		selected, _ := a.Select()
+		counts[selected.NodeID]++
+	}
+	if counts["peer-1"] == 0 || counts["peer-2"] == 0 {
+		t.Errorf("Expected both tried and new addresses to be selected, got %v", counts)
+	}
+
+	// Failed attempts blame the source of an unverified address once
+	source := models.Peer{NodeID: "source", Address: "198.51.100.7:3000"}
+	a.Add(peer(3, "10.3.1.1:3000"), source)
+	if blamed := a.Attempt("peer-3"); blamed != "source" {
+		t.Errorf("Expected the source to be blamed, got %q", blamed)
+	}
+	if blamed := a.Attempt("peer-3"); blamed != "" {
+		t.Errorf("Expected the source to be blamed only once, got %q", blamed)
+	}
+	if blamed := a.Attempt("peer-1"); blamed != "" {
+		t.Errorf("Expected no blame for a verified address, got %q", blamed)
+	}
+}
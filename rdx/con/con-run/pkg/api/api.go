@@ -1,21 +1,37 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"sync"
 	"time"
 
 	"concoin/conrun/pkg/config"
+	"concoin/conrun/pkg/identity"
 	"concoin/conrun/pkg/interfaces"
+	"concoin/conrun/pkg/limits"
 	"concoin/conrun/pkg/models"
+	"concoin/conrun/pkg/reputation"
+	"concoin/conrun/pkg/retention"
+	"concoin/conrun/pkg/transport"
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 )
 
+// maxSyncBatch наибольшее число сообщений в одной порции синхронизации
+const maxSyncBatch = 1000
+
+// shutdownTimeout время на завершение начатых запросов при остановке сервера
+const shutdownTimeout = 5 * time.Second
+
 // API представляет собой HTTP API узла
 type API struct {
 	config      *config.Config
@@ -26,6 +42,16 @@ type API struct {
 	Router      *mux.Router
 	storage     interfaces.StorageInterface
 	hookManager interfaces.HookManagerInterface
+	chain       interfaces.ChainInterface
+	mempool     interfaces.MempoolInterface
+	identity    *identity.Identity
+	reputation  interfaces.ReputationInterface
+	limiter     *limits.Limiter
+	retention   *retention.Manager
+	server      *http.Server
+	streams     *transport.Server // соединения транспорта tcp, их не закрывает http.Server
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
 }
 
 // LogEntry представляет собой запись лога
@@ -68,6 +94,7 @@ func NewAPI(config *config.Config, gossip interfaces.GossipProtocolInterface, pe
 		Router:      mux.NewRouter(),
 		storage:     storage,
 		hookManager: hookManager,
+		limiter:     limits.NewLimiter(config.LimitsConfig),
 	}
 
 	// Настраиваем маршруты
@@ -79,8 +106,14 @@ func NewAPI(config *config.Config, gossip interfaces.GossipProtocolInterface, pe
 // setupRoutes настраивает маршруты API
 func (a *API) setupRoutes() {
 	// Gossip и PEX обработчики
-	a.Router.HandleFunc("/gossip", a.handleGossipMessage).Methods("POST")
-	a.Router.HandleFunc("/pex", a.handlePexMessage).Methods("POST")
+	a.Router.Handle("/gossip", a.limited(a.handleGossipMessage)).Methods("POST")
+	a.Router.Handle("/gossip/sync", a.limited(a.handleGossipSync)).Methods("POST")
+	a.Router.Handle("/pex", a.limited(a.handlePexMessage)).Methods("POST")
+
+	// Долгоживущие соединения транспорта tcp: запросы из них идут в те же маршруты
+	a.streams = transport.NewServer(a.Router, a.config.TransportConfig, a.logger)
+	a.streams.SetBodyLimit(a.limiter.MaxBodySize)
+	a.Router.Handle(transport.Path, a.streams).Methods("GET")
 
 	// Проверка доступности
 	a.Router.HandleFunc("/ping", a.handlePing).Methods("GET")
@@ -88,24 +121,216 @@ func (a *API) setupRoutes() {
 	// Отладочный API
 	a.Router.HandleFunc("/debug", a.handleDebug).Methods("GET")
 	a.Router.HandleFunc("/network", a.handleNetwork).Methods("GET")
+	a.Router.HandleFunc("/reputation", a.handleReputation).Methods("GET")
+	a.Router.HandleFunc("/retention", a.handleRetention).Methods("GET")
+	a.Router.HandleFunc("/message_types", a.handleMessageTypes).Methods("GET")
+	a.Router.HandleFunc("/hooks", a.handleHooks).Methods("GET")
 
 	// API для работы с сообщениями
 	a.Router.HandleFunc("/messages", a.handleGetMessages).Methods("GET")
 	a.Router.HandleFunc("/messages/{id}", a.handleGetMessage).Methods("GET")
-	a.Router.HandleFunc("/message", a.handleMessage).Methods("POST")
-	a.Router.HandleFunc("/add_message", a.handleAddMessage).Methods("POST")
+	a.Router.Handle("/sync/messages", a.limited(a.handleSyncMessages)).Methods("GET")
+	a.Router.Handle("/message", a.limited(a.handleMessage)).Methods("POST")
+	a.Router.Handle("/add_message", a.limited(a.handleAddMessage)).Methods("POST")
+
+	// API состояния блокчейна
+	a.Router.HandleFunc("/chain/state", a.handleChainState).Methods("GET")
+	a.Router.HandleFunc("/chain/tip", a.handleChainTip).Methods("GET")
+	a.Router.HandleFunc("/chain/balance/{user}", a.handleChainBalance).Methods("GET")
+	a.Router.HandleFunc("/chain/blocks/{hash}", a.handleChainBlock).Methods("GET")
+
+	// API мемпула
+	a.Router.HandleFunc("/mempool", a.handleMempool).Methods("GET")
+	a.Router.HandleFunc("/mempool/pick", a.handleMempoolPick).Methods("GET")
+}
+
+// SetChain подключает состояние блокчейна к API
+func (a *API) SetChain(chain interfaces.ChainInterface) {
+	a.chain = chain
+}
+
+// SetIdentity задает ключ, которым узел подписывает свои сообщения
+func (a *API) SetIdentity(identity *identity.Identity) {
+	a.identity = identity
+}
+
+// SetReputation подключает оценки пиров к API
+func (a *API) SetReputation(reputation interfaces.ReputationInterface) {
+	a.reputation = reputation
 }
 
-// Start запускает HTTP сервер
-func (a *API) Start() {
-	// Запускаем сервер на указанном порту
-	addr := fmt.Sprintf(":%d", a.config.Port)
+// SetRetention подключает очистку хранилища к API
+func (a *API) SetRetention(retention *retention.Manager) {
+	a.retention = retention
+}
+
+// SetLimits заменяет лимиты входящих запросов на ходу
+func (a *API) SetLimits(cfg config.LimitsConfig) {
+	a.limiter.SetConfig(cfg)
+}
+
+// SetMempool подключает мемпул к API
+func (a *API) SetMempool(mempool interfaces.MempoolInterface) {
+	a.mempool = mempool
+}
+
+// Start запускает HTTP сервер. Ошибка открытия порта возвращается сразу,
+// сервер останавливается при отмене ctx или вызове Stop.
+func (a *API) Start(ctx context.Context) error {
+	addr := a.config.ListenAddress()
+	a.logger.Infof("Starting API server on %s", addr)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to start API server: %w", err)
+	}
+
+	a.server = &http.Server{Handler: a.Router}
+	ctx, a.cancel = context.WithCancel(ctx)
+	a.wg.Add(2)
 	go func() {
-		a.logger.Infof("Starting API server on %s", addr)
-		if err := http.ListenAndServe(addr, a.Router); err != nil {
-			a.logger.Fatalf("Failed to start API server: %v", err)
+		defer a.wg.Done()
+		if err := a.server.Serve(a.limiter.Listener(listener)); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			a.logger.Errorf("API server failed: %v", err)
 		}
 	}()
+	go func() {
+		defer a.wg.Done()
+		<-ctx.Done()
+		a.shutdown()
+	}()
+	return nil
+}
+
+// Stop останавливает HTTP сервер, дожидаясь завершения начатых запросов,
+// и закрывает соединения транспорта
+func (a *API) Stop() {
+	if a.cancel != nil {
+		a.cancel()
+	}
+	a.wg.Wait()
+}
+
+// shutdown перестает принимать соединения и закрывает открытые
+func (a *API) shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := a.server.Shutdown(ctx); err != nil {
+		a.logger.Warnf("Failed to stop API server gracefully: %v", err)
+		a.server.Close()
+	}
+	a.streams.Close()
+	a.logger.Info("API server stopped")
+}
+
+// limited ограничивает частоту, число одновременных запросов и размер тела
+// запроса для обработчика входящих сообщений
+func (a *API) limited(handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := a.limitKey(r)
+		if !a.limiter.Acquire() {
+			a.limiter.Report(key, limits.ReasonConcurrency)
+			http.Error(w, "Too many concurrent requests", http.StatusServiceUnavailable)
+			return
+		}
+		defer a.limiter.Release()
+
+		if reason, err := a.limiter.Allow(key); err != nil {
+			// Общий лимит исчерпан не по вине этого пира
+			if reason == limits.ReasonPeerRate {
+				a.violation(r, reason)
+			} else {
+				a.limiter.Report(key, reason)
+			}
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		if size := a.limiter.MaxBodySize(); size > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, size)
+		}
+		handler(w, r)
+	})
+}
+
+// violation учитывает превышение лимита в статистике и в оценке пира
+func (a *API) violation(r *http.Request, reason string) {
+	a.limiter.Report(a.limitKey(r), reason)
+	if sender := a.verifiedSender(r); sender != "" && a.reputation != nil {
+		a.reputation.RecordLimited(sender, reason)
+	}
+}
+
+// rejectLarge отвечает 413, если тело запроса превысило лимит при чтении
+func (a *API) rejectLarge(w http.ResponseWriter, r *http.Request, err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	if !errors.As(err, &maxBytesErr) {
+		return false
+	}
+	a.violation(r, limits.ReasonBodySize)
+	http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+	return true
+}
+
+// checkPayload отвечает 413, если payload сообщения больше лимита его типа,
+// и 400, если payload не соответствует типу сообщения. Разобранный payload
+// остается в сообщении, хуки получают его без повторного разбора.
+func (a *API) checkPayload(w http.ResponseWriter, r *http.Request, message *models.GossipMessage) bool {
+	err := a.limiter.CheckPayload(message)
+	if err == nil {
+		err = models.DecodeMessage(message)
+	}
+	if err == nil {
+		return true
+	}
+
+	a.logger.Warnf("Rejected message %s: %v", message.MessageID, err)
+	if errors.Is(err, limits.ErrPayloadTooLarge) || errors.Is(err, models.ErrPayloadTooLarge) {
+		a.violation(r, limits.ReasonPayloadSize)
+		http.Error(w, "Message payload too large", http.StatusRequestEntityTooLarge)
+		return false
+	}
+	if sender := a.verifiedSender(r); sender != "" && a.reputation != nil {
+		a.reputation.RecordRejected(sender, err.Error())
+	}
+	http.Error(w, fmt.Sprintf("Invalid message payload: %v", err), http.StatusBadRequest)
+	return false
+}
+
+// limitKey возвращает ключ пира для лимитов - IP соединения. Узлы на этой
+// же машине различаются по подтвержденному ID (см. verifiedSender), иначе они
+// делили бы один лимит. Заявленный, но не подтвержденный ID не учитывается:
+// иначе каждый новый sender получал бы свое ведро.
+func (a *API) limitKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		if sender := a.verifiedSender(r); sender != "" {
+			return sender
+		}
+	}
+	return host
+}
+
+// verifiedSender возвращает ID из параметра sender, если запрос пришел
+// с хоста, по которому этот пир известен
+func (a *API) verifiedSender(r *http.Request) string {
+	sender := r.URL.Query().Get("sender")
+	remoteHost, _, err := net.SplitHostPort(r.RemoteAddr)
+	if sender == "" || err != nil {
+		return ""
+	}
+	for _, peer := range a.pex.GetPeers() {
+		if peer.NodeID != sender {
+			continue
+		}
+		if host, _, err := net.SplitHostPort(peer.Address); err == nil && host == remoteHost {
+			return sender
+		}
+	}
+	return ""
 }
 
 // LogHook представляет собой хук для logrus
@@ -130,13 +355,23 @@ func (a *API) handleGossipMessage(w http.ResponseWriter, r *http.Request) {
 	var message models.GossipMessage
 
 	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+		if a.rejectLarge(w, r, err) {
+			return
+		}
 		a.logger.Warnf("Failed to decode Gossip message: %v", err)
 		http.Error(w, "Invalid message format", http.StatusBadRequest)
 		return
 	}
+	if !a.checkPayload(w, r, &message) {
+		return
+	}
 
 	// Обрабатываем сообщение
-	if err := a.gossip.HandleMessage(&message); err != nil {
+	if err := a.gossip.HandleMessage(&message, remotePeer(r)); err != nil {
+		if errors.Is(err, reputation.ErrBanned) {
+			http.Error(w, "Peer is banned", http.StatusForbidden)
+			return
+		}
 		a.logger.Warnf("Failed to handle Gossip message: %v", err)
 		http.Error(w, "Failed to process message", http.StatusInternalServerError)
 		return
@@ -145,11 +380,49 @@ func (a *API) handleGossipMessage(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// handleGossipSync обрабатывает anti-entropy обмен сводками сообщений
+func (a *API) handleGossipSync(w http.ResponseWriter, r *http.Request) {
+	var request models.GossipSync
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		if a.rejectLarge(w, r, err) {
+			return
+		}
+		a.logger.Warnf("Failed to decode Gossip sync request: %v", err)
+		http.Error(w, "Invalid sync request format", http.StatusBadRequest)
+		return
+	}
+	for i := range request.Messages {
+		if !a.checkPayload(w, r, &request.Messages[i]) {
+			return
+		}
+	}
+
+	response, err := a.gossip.HandleSync(&request, remotePeer(r))
+	if errors.Is(err, reputation.ErrBanned) {
+		http.Error(w, "Peer is banned", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		a.logger.Warnf("Failed to handle Gossip sync request: %v", err)
+		http.Error(w, "Failed to process sync request", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		a.logger.Warnf("Failed to encode Gossip sync response: %v", err)
+	}
+}
+
 // handlePexMessage обрабатывает входящее PEX сообщение
 func (a *API) handlePexMessage(w http.ResponseWriter, r *http.Request) {
 	var request models.PexMessage
 
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		if a.rejectLarge(w, r, err) {
+			return
+		}
 		a.logger.Warnf("Failed to decode PEX message: %v", err)
 		http.Error(w, "Invalid message format", http.StatusBadRequest)
 		return
@@ -162,7 +435,7 @@ func (a *API) handlePexMessage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Обрабатываем запрос и формируем ответ
-	response := a.pex.HandlePexRequest(request)
+	response := a.pex.HandlePexRequest(request, r.RemoteAddr)
 
 	// Отправляем ответ
 	w.Header().Set("Content-Type", "application/json")
@@ -184,17 +457,23 @@ func (a *API) handleMessage(w http.ResponseWriter, r *http.Request) {
 	var message models.GossipMessage
 
 	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+		if a.rejectLarge(w, r, err) {
+			return
+		}
 		a.logger.Warnf("Failed to decode message: %v", err)
 		http.Error(w, "Invalid message format", http.StatusBadRequest)
 		return
 	}
+	if !a.checkPayload(w, r, &message) {
+		return
+	}
 
 	// Обрабатываем сообщение через хуки
 	isValid := a.hookManager.ProcessMessage(&message, interfaces.MessageTypePush)
 
 	if !isValid {
 		a.logger.Warnf("Message validation failed: %s", message.MessageID)
-		http.Error(w, "Message validation failed", http.StatusBadRequest)
+		a.writeRejection(w, message.MessageID)
 		return
 	}
 
@@ -206,7 +485,7 @@ func (a *API) handleMessage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Отправляем сообщение через gossip
-	if err := a.gossip.HandleMessage(&message); err != nil {
+	if err := a.gossip.HandleMessage(&message, nil); err != nil {
 		a.logger.Warnf("Failed to propagate message via gossip: %v", err)
 		// Не возвращаем ошибку, т.к. сообщение уже сохранено
 	}
@@ -214,6 +493,23 @@ func (a *API) handleMessage(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// writeRejection отвечает клиенту ошибкой валидации вместе с причинами отказа от хуков
+func (a *API) writeRejection(w http.ResponseWriter, messageID string) {
+	var rejections []models.Rejection
+	if reporter, ok := a.hookManager.(interfaces.RejectionReporter); ok {
+		rejections = reporter.GetRejections(messageID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":     "rejected",
+		"error":      "Message validation failed",
+		"message_id": messageID,
+		"rejections": rejections,
+	})
+}
+
 // handleDebug обрабатывает запрос отладочной информации
 func (a *API) handleDebug(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
@@ -286,6 +582,89 @@ func (a *API) handleDebug(w http.ResponseWriter, r *http.Request) {
         </table>
     </div>
     
+    <h2>Limits</h2>
+    <div class="stats">
+        <p>Open connections: {{.Limits.Connections}}, requests in progress: {{.Limits.Inflight}}</p>
+        <table>
+            <tr>
+                <th>Peer</th>
+                <th>Limit</th>
+                <th>Count</th>
+                <th>Last</th>
+            </tr>
+            {{range .Limits.Violations}}
+            <tr>
+                <td>{{.Peer}}</td>
+                <td>{{.Reason}}</td>
+                <td>{{.Count}}</td>
+                <td>{{.Last.Format "15:04:05"}}</td>
+            </tr>
+            {{end}}
+        </table>
+    </div>
+
+    {{with .Hooks}}
+    <h2>Hooks</h2>
+    <div class="stats">
+        <table>
+            <tr>
+                <th>Hook</th>
+                <th>Priority</th>
+                <th>Validated</th>
+                <th>Rejected</th>
+                <th>Handled</th>
+                <th>Failed</th>
+                <th>Workers</th>
+                <th>Queue</th>
+                <th>Overflows</th>
+                <th>Handle time</th>
+            </tr>
+            {{range .}}
+            <tr>
+                <td>{{.Hook}}</td>
+                <td>{{.Priority}}</td>
+                <td>{{.Validated}}</td>
+                <td>{{.Rejected}}</td>
+                <td>{{.Handled}}</td>
+                <td>{{.Failed}}</td>
+                <td>{{.Workers}}</td>
+                <td>{{if .Workers}}{{.Queued}}/{{.QueueSize}}{{end}}</td>
+                <td>{{.Overflows}}</td>
+                <td>{{.HandleTime}}</td>
+            </tr>
+            {{end}}
+        </table>
+    </div>
+    {{end}}
+
+    {{with .Retention}}
+    <h2>Retention</h2>
+    <div class="stats">
+        <p>Interval: {{.Interval}}{{if not .Enabled}} (disabled){{end}}, deleted since start: {{.TotalDeleted}}</p>
+        {{if not .LastRun.IsZero}}
+        <p>Last run: {{.LastRun.Format "15:04:05"}} in {{.Duration}}, scanned {{.Scanned}} messages, forgot {{.Expired}} deleted IDs{{if .Compacted}}, storage compacted{{end}}{{if .Error}}, error: {{.Error}}{{end}}</p>
+        {{end}}
+        <table>
+            <tr>
+                <th>Policy</th>
+                <th>Rule</th>
+                <th>Deleted last run</th>
+            </tr>
+            {{$deleted := .Deleted}}
+            {{range $key, $rule := .Policies}}
+            <tr>
+                <td>{{$key}}</td>
+                <td>{{$rule}}</td>
+                <td>{{index $deleted $key}}</td>
+            </tr>
+            {{end}}
+        </table>
+        {{with .Storage}}
+        <p>Storage: {{.Segments}} segments, {{.SegmentBytes}} bytes on disk, {{.MemtableKeys}} keys in memory, log {{.WALBytes}} bytes</p>
+        {{end}}
+    </div>
+    {{end}}
+
     <h2>Node Logs</h2>
     <div class="logs">
         <table>
@@ -352,20 +731,31 @@ func (a *API) handleDebug(w http.ResponseWriter, r *http.Request) {
 
 	// Создаем данные для шаблона
 	data := struct {
-		NodeID   string
-		Address  string
-		Peers    int
-		Uptime   string
-		Logs     []LogEntry
-		Messages []models.GossipMessage
+		NodeID    string
+		Address   string
+		Peers     int
+		Uptime    string
+		Limits    limits.Stats
+		Retention *retention.Stats
+		Hooks     []models.HookStats
+		Logs      []LogEntry
+		Messages  []models.GossipMessage
 	}{
 		NodeID:   stats.NodeID,
 		Address:  stats.Address,
 		Peers:    stats.Peers,
 		Uptime:   stats.Uptime,
+		Limits:   a.limiter.Stats(),
 		Logs:     a.logBuffer,
 		Messages: messages,
 	}
+	if a.retention != nil {
+		retentionStats := a.retention.Stats()
+		data.Retention = &retentionStats
+	}
+	if reporter, ok := a.hookManager.(interfaces.HookStatsReporter); ok {
+		data.Hooks = reporter.HookStats()
+	}
 
 	// Парсим и выполняем шаблон
 	t, err := template.New("debug").Parse(tmpl)
@@ -521,7 +911,7 @@ func (a *API) getNodeStats() NodeStats {
 
 	return NodeStats{
 		NodeID:  a.config.NodeID,
-		Address: fmt.Sprintf("127.0.0.1:%d", a.config.Port),
+		Address: a.pex.AdvertisedAddress(),
 		Peers:   len(peers),
 		Uptime:  "N/A", // В MVP не отслеживаем время работы
 	}
@@ -545,6 +935,12 @@ func (a *API) checkPeerStatus(address string) bool {
 
 // handleGetMessages обрабатывает запрос списка всех сообщений
 func (a *API) handleGetMessages(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Has("type") || query.Has("since") || query.Has("until") || query.Has("limit") {
+		a.handleQueryMessages(w, r)
+		return
+	}
+
 	messages, err := a.storage.GetMessageList()
 	if err != nil {
 		a.logger.Warnf("Failed to get message list: %v", err)
@@ -556,6 +952,65 @@ func (a *API) handleGetMessages(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(messages)
 }
 
+// handleQueryMessages отдает сообщения по индексам хранилища: параметр type
+// задает тип, since и until (RFC 3339) - интервал времени [since, until),
+// limit - наибольшее число сообщений
+func (a *API) handleQueryMessages(w http.ResponseWriter, r *http.Request) {
+	index, ok := a.storage.(interfaces.MessageIndex)
+	if !ok {
+		http.Error(w, "Message queries need the kv storage backend", http.StatusNotImplemented)
+		return
+	}
+
+	query := r.URL.Query()
+	var since, until time.Time
+	for _, param := range []struct {
+		name  string
+		value *time.Time
+	}{{"since", &since}, {"until", &until}} {
+		if query.Get(param.name) == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339Nano, query.Get(param.name))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid %s parameter", param.name), http.StatusBadRequest)
+			return
+		}
+		*param.value = parsed
+	}
+
+	limit := maxSyncBatch
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		if parsed < limit {
+			limit = parsed
+		}
+	}
+
+	var messages []*models.GossipMessage
+	var err error
+	if messageType := query.Get("type"); messageType != "" {
+		messages, err = index.MessagesByType(messageType, since, until, limit)
+	} else {
+		messages, err = index.MessagesBetween(since, until, limit)
+	}
+	if err != nil {
+		a.logger.Warnf("Failed to query messages: %v", err)
+		http.Error(w, "Failed to get messages", http.StatusInternalServerError)
+		return
+	}
+	if messages == nil {
+		messages = []*models.GossipMessage{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
+
 // handleGetMessage обрабатывает запрос конкретного сообщения
 func (a *API) handleGetMessage(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -576,6 +1031,121 @@ func (a *API) handleGetMessage(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(message)
 }
 
+// remotePeer возвращает пира, приславшего запрос: ID из параметра sender
+// и адрес соединения
+func remotePeer(r *http.Request) *models.Peer {
+	return &models.Peer{
+		NodeID:  r.URL.Query().Get("sender"),
+		Address: r.RemoteAddr,
+	}
+}
+
+// handleReputation возвращает оценки пиров и действующие баны
+func (a *API) handleReputation(w http.ResponseWriter, r *http.Request) {
+	if a.reputation == nil {
+		http.Error(w, "Reputation is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"peers": a.reputation.Scores(),
+		"bans":  a.reputation.Bans(),
+	})
+}
+
+// handleRetention возвращает правила хранения сообщений и итоги последней очистки
+func (a *API) handleRetention(w http.ResponseWriter, r *http.Request) {
+	if a.retention == nil {
+		http.Error(w, "Retention is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.retention.Stats())
+}
+
+// handleHooks возвращает счетчики хуков в порядке их вызова
+func (a *API) handleHooks(w http.ResponseWriter, r *http.Request) {
+	reporter, ok := a.hookManager.(interfaces.HookStatsReporter)
+	if !ok {
+		http.Error(w, "Hook stats are not available", http.StatusNotImplemented)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reporter.HookStats())
+}
+
+// handleMessageTypes возвращает зарегистрированные типы сообщений с их схемами и лимитами
+func (a *API) handleMessageTypes(w http.ResponseWriter, r *http.Request) {
+	type messageType struct {
+		Type     string         `json:"type"`
+		MaxSize  int            `json:"max_size,omitempty"`
+		Schema   *models.Schema `json:"schema,omitempty"`
+		Validate bool           `json:"validate"` // есть ли проверка кодом кроме схемы
+	}
+
+	var types []messageType
+	for _, spec := range models.MessageTypes() {
+		types = append(types, messageType{
+			Type:     spec.Type,
+			MaxSize:  spec.MaxSize,
+			Schema:   spec.Schema,
+			Validate: spec.Validate != nil,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(types)
+}
+
+// handleSyncMessages отдает порцию сообщений, сохраненных после номера after
+func (a *API) handleSyncMessages(w http.ResponseWriter, r *http.Request) {
+	var after uint64
+	if value := r.URL.Query().Get("after"); value != "" {
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid after parameter", http.StatusBadRequest)
+			return
+		}
+		after = parsed
+	}
+
+	limit := a.config.GossipConfig.SyncBatchSize
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+	if limit <= 0 || limit > maxSyncBatch {
+		limit = maxSyncBatch
+	}
+
+	messages, cursor, head, err := a.storage.GetMessagesSince(after, limit)
+	if err != nil {
+		a.logger.Warnf("Failed to get messages since %d: %v", after, err)
+		http.Error(w, "Failed to get messages", http.StatusInternalServerError)
+		return
+	}
+
+	batch := models.MessageBatch{
+		Messages: make([]models.GossipMessage, 0, len(messages)),
+		Cursor:   cursor,
+		Head:     head,
+		More:     cursor < head,
+	}
+	for _, message := range messages {
+		batch.Messages = append(batch.Messages, *message)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(batch)
+}
+
 // handleAddMessage обрабатывает запрос на добавление нового сообщения
 func (a *API) handleAddMessage(w http.ResponseWriter, r *http.Request) {
 	// Читаем тело запроса
@@ -584,33 +1154,42 @@ func (a *API) handleAddMessage(w http.ResponseWriter, r *http.Request) {
 		Payload interface{} `json:"payload"`
 	}
 
-	projectRoot, err := os.Getwd()
-	if err != nil {
-	}
-	a.logger.Infof("handleAddMessage - Project root directory: %s", projectRoot)
-
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		if a.rejectLarge(w, r, err) {
+			return
+		}
 		a.logger.Warnf("Failed to decode message payload: %v", err)
 		http.Error(w, "Invalid message format", http.StatusBadRequest)
 		return
 	}
 
-	// Создаем Gossip сообщение
+	if a.identity == nil {
+		http.Error(w, "Node identity is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Создаем и подписываем Gossip сообщение
 	message := &models.GossipMessage{
-		MessageID:   fmt.Sprintf("msg-%d", time.Now().UnixNano()),
-		OriginID:    a.config.NodeID,
 		Timestamp:   time.Now().UTC(),
 		TTL:         a.config.GossipConfig.MessageTTL,
 		MessageType: request.Type,
 		Payload:     request.Payload,
 	}
+	if !a.checkPayload(w, r, message) {
+		return
+	}
+	if err := a.identity.Seal(message); err != nil {
+		a.logger.Warnf("Failed to sign message: %v", err)
+		http.Error(w, "Invalid message payload", http.StatusBadRequest)
+		return
+	}
 
 	// Обрабатываем сообщение через хуки
 	isValid := a.hookManager.ProcessMessage(message, interfaces.MessageTypePush)
 
 	if !isValid {
 		a.logger.Warnf("Message validation failed: %s", message.MessageID)
-		http.Error(w, "Message validation failed", http.StatusBadRequest)
+		a.writeRejection(w, message.MessageID)
 		return
 	}
 
@@ -622,7 +1201,7 @@ func (a *API) handleAddMessage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Отправляем сообщение через gossip
-	if err := a.gossip.HandleMessage(message); err != nil {
+	if err := a.gossip.HandleMessage(message, nil); err != nil {
 		a.logger.Warnf("Failed to propagate message via gossip: %v", err)
 		// Не возвращаем ошибку, т.к. сообщение уже сохранено
 	}
@@ -634,3 +1213,106 @@ func (a *API) handleAddMessage(w http.ResponseWriter, r *http.Request) {
 		"message_id": message.MessageID,
 	})
 }
+
+// handleChainState возвращает текущее состояние блокчейна в формате actual_state.json
+func (a *API) handleChainState(w http.ResponseWriter, r *http.Request) {
+	if a.chain == nil {
+		http.Error(w, "Chain state is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.chain.Snapshot())
+}
+
+// handleChainTip возвращает хеш и высоту последнего принятого блока
+func (a *API) handleChainTip(w http.ResponseWriter, r *http.Request) {
+	if a.chain == nil {
+		http.Error(w, "Chain state is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"hash":   a.chain.LastBlockHash(),
+		"height": a.chain.Height(),
+	})
+}
+
+// handleChainBalance возвращает баланс и публичный ключ пользователя
+func (a *API) handleChainBalance(w http.ResponseWriter, r *http.Request) {
+	if a.chain == nil {
+		http.Error(w, "Chain state is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	username := mux.Vars(r)["user"]
+	user, err := a.chain.FetchUser(username)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user":    user.Username,
+		"balance": user.Balance,
+		"pub_key": user.PubKey,
+	})
+}
+
+// handleChainBlock возвращает принятый блок по хешу
+func (a *API) handleChainBlock(w http.ResponseWriter, r *http.Request) {
+	if a.chain == nil {
+		http.Error(w, "Chain state is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	hash := mux.Vars(r)["hash"]
+	block, err := a.chain.FetchBlock(hash)
+	if err != nil {
+		http.Error(w, "Block not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(block)
+}
+
+// handleMempool возвращает транзакции мемпула в порядке приоритета
+func (a *API) handleMempool(w http.ResponseWriter, r *http.Request) {
+	if a.mempool == nil {
+		http.Error(w, "Mempool is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"size":         a.mempool.Size(),
+		"transactions": a.mempool.Entries(),
+	})
+}
+
+// handleMempoolPick возвращает транзакции для нового блока.
+// Параметр limit ограничивает их количество (по умолчанию BlockchainConfig.MaxTransactions).
+func (a *API) handleMempoolPick(w http.ResponseWriter, r *http.Request) {
+	if a.mempool == nil {
+		http.Error(w, "Mempool is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	limit := a.config.BlockchainConfig.MaxTransactions
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		if parsed < limit {
+			limit = parsed
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.mempool.Pick(limit))
+}
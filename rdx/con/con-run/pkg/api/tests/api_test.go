@@ -2,16 +2,26 @@ package tests
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"concoin/conrun/pkg/api"
+	"concoin/conrun/pkg/blockchain"
 	"concoin/conrun/pkg/config"
+	"concoin/conrun/pkg/hooks"
+	"concoin/conrun/pkg/identity"
 	"concoin/conrun/pkg/interfaces"
 	"concoin/conrun/pkg/models"
+	"concoin/conrun/pkg/reputation"
+	"concoin/conrun/pkg/retention"
+	"concoin/conrun/pkg/storage"
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
@@ -23,7 +33,12 @@ type MockGossipProtocol struct {
 	mock.Mock
 }
 
-func (m *MockGossipProtocol) Start() {
+func (m *MockGossipProtocol) Start(ctx context.Context) error {
+	args := m.Called(ctx)
+	return args.Error(0)
+}
+
+func (m *MockGossipProtocol) Stop() {
 	m.Called()
 }
 
@@ -31,16 +46,29 @@ func (m *MockGossipProtocol) UpdatePeers(peers []models.Peer) {
 	m.Called(peers)
 }
 
-func (m *MockGossipProtocol) HandleMessage(message *models.GossipMessage) error {
-	args := m.Called(message)
+func (m *MockGossipProtocol) HandleMessage(message *models.GossipMessage, from *models.Peer) error {
+	args := m.Called(message, from)
 	return args.Error(0)
 }
 
+func (m *MockGossipProtocol) HandleSync(request *models.GossipSync, from *models.Peer) (*models.GossipSync, error) {
+	args := m.Called(request, from)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.GossipSync), args.Error(1)
+}
+
 type MockPexProtocol struct {
 	mock.Mock
 }
 
-func (m *MockPexProtocol) Start() {
+func (m *MockPexProtocol) Start(ctx context.Context) error {
+	args := m.Called(ctx)
+	return args.Error(0)
+}
+
+func (m *MockPexProtocol) Stop() {
 	m.Called()
 }
 
@@ -58,8 +86,13 @@ func (m *MockPexProtocol) GetPeers() []models.Peer {
 	return args.Get(0).([]models.Peer)
 }
 
-func (m *MockPexProtocol) HandlePexRequest(request models.PexMessage) models.PexMessage {
-	args := m.Called(request)
+func (m *MockPexProtocol) AdvertisedAddress() string {
+	args := m.Called()
+	return args.String(0)
+}
+
+func (m *MockPexProtocol) HandlePexRequest(request models.PexMessage, remoteAddr string) models.PexMessage {
+	args := m.Called(request, remoteAddr)
 	return args.Get(0).(models.PexMessage)
 }
 
@@ -118,6 +151,35 @@ func (m *MockStorage) HasMessage(messageID string) bool {
 	return args.Bool(0)
 }
 
+func (m *MockStorage) GetMessagesSince(seq uint64, limit int) ([]*models.GossipMessage, uint64, uint64, error) {
+	args := m.Called(seq, limit)
+	return args.Get(0).([]*models.GossipMessage), args.Get(1).(uint64), args.Get(2).(uint64), args.Error(3)
+}
+
+func (m *MockStorage) GetSyncCursor(nodeID string) (uint64, error) {
+	args := m.Called(nodeID)
+	return args.Get(0).(uint64), args.Error(1)
+}
+
+func (m *MockStorage) SaveSyncCursor(nodeID string, cursor uint64) error {
+	args := m.Called(nodeID, cursor)
+	return args.Error(0)
+}
+
+func (m *MockStorage) SaveBan(ban *models.Ban) error {
+	args := m.Called(ban)
+	return args.Error(0)
+}
+
+func (m *MockStorage) Close() error {
+	return nil
+}
+
+func (m *MockStorage) GetBans() ([]*models.Ban, error) {
+	args := m.Called()
+	return args.Get(0).([]*models.Ban), args.Error(1)
+}
+
 func TestAPI_handlePing(t *testing.T) {
 	// Create test dependencies
 	logger := logrus.New()
@@ -176,7 +238,7 @@ func TestAPI_handleGossipMessage(t *testing.T) {
 		Payload:     map[string]interface{}{"content": "Hello, world!"},
 	}
 
-	mockGossip.On("HandleMessage", mock.AnythingOfType("*models.GossipMessage")).Return(nil)
+	mockGossip.On("HandleMessage", mock.AnythingOfType("*models.GossipMessage"), mock.Anything).Return(nil)
 
 	// Create API
 	nodeAPI := api.NewAPI(cfg, mockGossip, mockPex, logger, mockStorage, mockHookManager)
@@ -207,6 +269,144 @@ func TestAPI_handleGossipMessage(t *testing.T) {
 	mockGossip.AssertExpectations(t)
 }
 
+func TestAPI_Limits(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	cfg := config.DefaultConfig(3000, 0)
+	cfg.LimitsConfig.PeerRate = 0.001
+	cfg.LimitsConfig.PeerBurst = 2
+	cfg.LimitsConfig.MaxBodySize = 1024
+	cfg.LimitsConfig.PayloadSizes = map[string]int{"user_message": 64}
+
+	mockGossip := new(MockGossipProtocol)
+	mockPex := new(MockPexProtocol)
+	mockStorage := new(MockStorage)
+	mockGossip.On("HandleMessage", mock.AnythingOfType("*models.GossipMessage"), mock.Anything).Return(nil)
+	mockPex.On("GetPeers").Return([]models.Peer{
+		{NodeID: "peer-a", Address: "10.0.0.1:3000"},
+		{NodeID: "peer-b", Address: "127.0.0.1:3001"},
+	})
+	mockPex.On("AdvertisedAddress").Return("")
+	mockStorage.On("GetMessageList").Return([]string{}, nil)
+
+	nodeAPI := api.NewAPI(cfg, mockGossip, mockPex, logger, mockStorage, new(MockHookManager))
+	manager := reputation.NewManager(cfg.ReputationConfig, nil, logger)
+	nodeAPI.SetReputation(manager)
+
+	sendAs := func(sender, remoteAddr string, payload interface{}) int {
+		data, _ := json.Marshal(models.GossipMessage{MessageID: "id", MessageType: "user_message", Payload: payload})
+		req := httptest.NewRequest("POST", "/gossip?sender="+sender, bytes.NewReader(data))
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		nodeAPI.Router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	send := func(remoteAddr string, payload interface{}) int {
+		return sendAs("peer-a", remoteAddr, payload)
+	}
+
+	// The burst is allowed, then the peer is throttled and loses points
+	for i := 0; i < 2; i++ {
+		if code := send("10.0.0.1:5000", "hi"); code != http.StatusOK {
+			t.Fatalf("Expected request %d to pass, got %d", i, code)
+		}
+	}
+	if code := send("10.0.0.1:5000", "hi"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, code)
+	}
+	scores := manager.Scores()
+	if len(scores) != 1 || scores[0].NodeID != "peer-a" || scores[0].Limited != 1 {
+		t.Errorf("Expected the violation to be recorded for peer-a, got %+v", scores)
+	}
+
+	// Other peers have their own limits, but not for oversized messages
+	if code := send("10.0.0.2:5000", strings.Repeat("x", 100)); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected oversized payload to get %d, got %d", http.StatusRequestEntityTooLarge, code)
+	}
+	if code := send("10.0.0.3:5000", strings.Repeat("x", 2000)); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected oversized body to get %d, got %d", http.StatusRequestEntityTooLarge, code)
+	}
+
+	// Loopback callers share the host limit unless the sender is a known
+	// peer on this host, so made-up sender IDs get no extra requests
+	for i, sender := range []string{"fake-1", "fake-2", "fake-3"} {
+		expected := http.StatusOK
+		if i == 2 {
+			expected = http.StatusTooManyRequests
+		}
+		if code := sendAs(sender, "127.0.0.1:5000", "hi"); code != expected {
+			t.Errorf("Expected request from %s to get %d, got %d", sender, expected, code)
+		}
+	}
+	if code := sendAs("peer-b", "127.0.0.1:5001", "hi"); code != http.StatusOK {
+		t.Errorf("Expected known loopback peer to have its own limit, got %d", code)
+	}
+
+	// Violations are shown on the debug page
+	rr := httptest.NewRecorder()
+	nodeAPI.Router.ServeHTTP(rr, httptest.NewRequest("GET", "/debug", nil))
+	for _, expected := range []string{"peer rate", "10.0.0.2", "payload size", "10.0.0.3", "body size"} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("Expected debug page to contain %q", expected)
+		}
+	}
+}
+
+func TestAPI_MessageTypes(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cfg := config.DefaultConfig(3000, 0)
+
+	mockGossip := new(MockGossipProtocol)
+	mockGossip.On("HandleMessage", mock.AnythingOfType("*models.GossipMessage"), mock.Anything).Return(nil)
+	mockPex := new(MockPexProtocol)
+	mockPex.On("GetPeers").Return([]models.Peer{{NodeID: "peer-a", Address: "192.0.2.1:3000"}})
+	nodeAPI := api.NewAPI(cfg, mockGossip, mockPex, logger, new(MockStorage), new(MockHookManager))
+	manager := reputation.NewManager(cfg.ReputationConfig, nil, logger)
+	nodeAPI.SetReputation(manager)
+
+	send := func(payload interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(models.GossipMessage{MessageID: "id", MessageType: blockchain.MessageType, Payload: payload})
+		rr := httptest.NewRecorder()
+		nodeAPI.Router.ServeHTTP(rr, httptest.NewRequest("POST", "/gossip?sender=peer-a", bytes.NewReader(data)))
+		return rr
+	}
+
+	// The payload is checked against the schema of its type before the gossip sees it
+	rr := send(map[string]interface{}{"from": "Alice", "to": "Bob", "amount": "5"})
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "payload.amount") {
+		t.Errorf("Expected the invalid amount to be rejected, got %d %s", rr.Code, rr.Body.String())
+	}
+	if scores := manager.Scores(); len(scores) != 1 || scores[0].Rejected != 1 {
+		t.Errorf("Expected the rejection to be recorded, got %+v", scores)
+	}
+	mockGossip.AssertNotCalled(t, "HandleMessage", mock.Anything, mock.Anything)
+
+	// A valid payload reaches the gossip already decoded
+	if rr := send(map[string]interface{}{"from": "Alice", "to": "Bob", "amount": 5}); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	message := mockGossip.Calls[0].Arguments.Get(0).(*models.GossipMessage)
+	if payload, ok := message.Decoded.(*blockchain.Payload); !ok || payload.Transaction == nil || payload.Transaction.Amount != 5 {
+		t.Errorf("Expected a decoded transaction, got %#v", message.Decoded)
+	}
+
+	rr = httptest.NewRecorder()
+	nodeAPI.Router.ServeHTTP(rr, httptest.NewRequest("GET", "/message_types", nil))
+	var types []struct {
+		Type    string         `json:"type"`
+		MaxSize int            `json:"max_size"`
+		Schema  *models.Schema `json:"schema"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&types); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(types) != 2 || types[0].Type != blockchain.MessageType || types[0].Schema == nil || types[1].Type != models.UserMessageType {
+		t.Errorf("Unexpected message types %+v", types)
+	}
+}
+
 func TestAPI_handlePexMessage(t *testing.T) {
 	// Create test dependencies
 	logger := logrus.New()
@@ -240,7 +440,7 @@ func TestAPI_handlePexMessage(t *testing.T) {
 		Peers:     []models.Peer{testPeer},
 	}
 
-	mockPex.On("HandlePexRequest", mock.AnythingOfType("models.PexMessage")).Return(testResponse)
+	mockPex.On("HandlePexRequest", mock.AnythingOfType("models.PexMessage"), mock.AnythingOfType("string")).Return(testResponse)
 
 	// Create API
 	nodeAPI := api.NewAPI(cfg, mockGossip, mockPex, logger, mockStorage, mockHookManager)
@@ -296,10 +496,15 @@ func TestAPI_handleAddMessage(t *testing.T) {
 	// Set up expectations
 	mockHookManager.On("ProcessMessage", mock.AnythingOfType("*models.GossipMessage"), interfaces.MessageTypePush).Return(true)
 	mockStorage.On("SaveMessage", mock.AnythingOfType("*models.GossipMessage")).Return(nil)
-	mockGossip.On("HandleMessage", mock.AnythingOfType("*models.GossipMessage")).Return(nil)
+	mockGossip.On("HandleMessage", mock.AnythingOfType("*models.GossipMessage"), mock.Anything).Return(nil)
 
 	// Create API
 	nodeAPI := api.NewAPI(cfg, mockGossip, mockPex, logger, mockStorage, mockHookManager)
+	nodeIdentity, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+	nodeAPI.SetIdentity(nodeIdentity)
 
 	// Create test request
 	requestData := []byte(`{
@@ -340,6 +545,15 @@ func TestAPI_handleAddMessage(t *testing.T) {
 		t.Errorf("Expected non-empty message_id in response")
 	}
 
+	// The message is signed by the node
+	message := mockStorage.Calls[0].Arguments.Get(0).(*models.GossipMessage)
+	if message.MessageID != response["message_id"] || message.OriginID != nodeIdentity.NodeID {
+		t.Errorf("Unexpected message envelope %+v", message)
+	}
+	if err := identity.Verify(message); err != nil {
+		t.Errorf("Message signature is not valid: %v", err)
+	}
+
 	mockHookManager.AssertExpectations(t)
 	mockStorage.AssertExpectations(t)
 	mockGossip.AssertExpectations(t)
@@ -397,6 +611,185 @@ func TestAPI_handleGetMessages(t *testing.T) {
 	mockStorage.AssertExpectations(t)
 }
 
+func TestAPI_handleQueryMessages(t *testing.T) {
+	logger := logrus.New()
+	cfg := config.DefaultConfig(3000, 0)
+
+	store, err := storage.NewKVStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, messageType := range []string{"user_message", "block", "user_message", "user_message"} {
+		store.SaveMessage(&models.GossipMessage{
+			MessageID:   fmt.Sprintf("msg-%d", i),
+			Timestamp:   base.Add(time.Duration(i) * time.Minute),
+			MessageType: messageType,
+		})
+	}
+
+	nodeAPI := api.NewAPI(cfg, new(MockGossipProtocol), new(MockPexProtocol), logger, store, new(MockHookManager))
+	query := func(url string) ([]models.GossipMessage, int) {
+		rr := httptest.NewRecorder()
+		nodeAPI.Router.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+		var messages []models.GossipMessage
+		if rr.Code == http.StatusOK {
+			if err := json.NewDecoder(rr.Body).Decode(&messages); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+		}
+		return messages, rr.Code
+	}
+
+	messages, code := query("/messages?type=user_message&since=2024-01-01T00:01:00Z&limit=1")
+	if code != http.StatusOK || len(messages) != 1 || messages[0].MessageID != "msg-2" {
+		t.Errorf("Unexpected response %d %v", code, messages)
+	}
+	messages, code = query("/messages?until=2024-01-01T00:02:00Z")
+	if code != http.StatusOK || len(messages) != 2 || messages[1].MessageID != "msg-1" {
+		t.Errorf("Unexpected response %d %v", code, messages)
+	}
+	if _, code := query("/messages?since=yesterday"); code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for a bad time, got %d", http.StatusBadRequest, code)
+	}
+
+	// Storages without indexes cannot answer queries
+	plainAPI := api.NewAPI(cfg, new(MockGossipProtocol), new(MockPexProtocol), logger, new(MockStorage), new(MockHookManager))
+	rr := httptest.NewRecorder()
+	plainAPI.Router.ServeHTTP(rr, httptest.NewRequest("GET", "/messages?type=block", nil))
+	if rr.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status %d, got %d", http.StatusNotImplemented, rr.Code)
+	}
+}
+
+func TestAPI_handleRetention(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cfg := config.DefaultConfig(3000, 0)
+
+	store := storage.NewStorage(t.TempDir())
+	store.SaveMessage(&models.GossipMessage{
+		MessageID:   "old",
+		Timestamp:   time.Now().Add(-2 * time.Hour),
+		MessageType: "user_message",
+	})
+
+	nodeAPI := api.NewAPI(cfg, new(MockGossipProtocol), new(MockPexProtocol), logger, store, new(MockHookManager))
+	rr := httptest.NewRecorder()
+	nodeAPI.Router.ServeHTTP(rr, httptest.NewRequest("GET", "/retention", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d without retention, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+
+	cfg.RetentionConfig.Policies = map[string]string{"user_message": "1h"}
+	manager := retention.NewManager(cfg.RetentionConfig, store, logger)
+	if _, err := manager.Run(context.Background()); err != nil {
+		t.Fatalf("Failed to run retention: %v", err)
+	}
+	nodeAPI.SetRetention(manager)
+
+	rr = httptest.NewRecorder()
+	nodeAPI.Router.ServeHTTP(rr, httptest.NewRequest("GET", "/retention", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	var stats retention.Stats
+	if err := json.NewDecoder(rr.Body).Decode(&stats); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if stats.Scanned != 1 || stats.Deleted["user_message"] != 1 || stats.Policies["user_message"] != "1h0m0s" {
+		t.Errorf("Unexpected retention stats %+v", stats)
+	}
+}
+
+func TestAPI_handleHooks(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cfg := config.DefaultConfig(3000, 0)
+
+	// Mock hook managers have no stats
+	nodeAPI := api.NewAPI(cfg, new(MockGossipProtocol), new(MockPexProtocol), logger, new(MockStorage), new(MockHookManager))
+	rr := httptest.NewRecorder()
+	nodeAPI.Router.ServeHTTP(rr, httptest.NewRequest("GET", "/hooks", nil))
+	if rr.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status %d, got %d", http.StatusNotImplemented, rr.Code)
+	}
+
+	hookManager := hooks.NewHookManager(t.TempDir(), logger)
+	hookManager.AddHook(hooks.NewDebugHook(logger))
+	hookManager.ProcessMessage(&models.GossipMessage{MessageID: "a", MessageType: "user_message"}, interfaces.MessageTypePush)
+	nodeAPI = api.NewAPI(cfg, new(MockGossipProtocol), new(MockPexProtocol), logger, new(MockStorage), hookManager)
+
+	rr = httptest.NewRecorder()
+	nodeAPI.Router.ServeHTTP(rr, httptest.NewRequest("GET", "/hooks", nil))
+	var stats []models.HookStats
+	if err := json.NewDecoder(rr.Body).Decode(&stats); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(stats) != 1 || stats[0].Hook != "DebugHook" || stats[0].Validated != 1 || stats[0].Handled != 1 {
+		t.Errorf("Unexpected hook stats %+v", stats)
+	}
+}
+
+func TestAPI_handleSyncMessages(t *testing.T) {
+	// Create test dependencies
+	logger := logrus.New()
+	logger.SetOutput(logrus.StandardLogger().Out)
+
+	cfg := config.DefaultConfig(3000, 0)
+
+	mockGossip := new(MockGossipProtocol)
+	mockPex := new(MockPexProtocol)
+	mockHookManager := new(MockHookManager)
+	mockStorage := new(MockStorage)
+
+	// Set up expectations
+	mockStorage.On("GetMessagesSince", uint64(3), 2).Return([]*models.GossipMessage{
+		{MessageID: "msg4"},
+		{MessageID: "msg5"},
+	}, uint64(5), uint64(8), nil)
+
+	// Create API
+	nodeAPI := api.NewAPI(cfg, mockGossip, mockPex, logger, mockStorage, mockHookManager)
+
+	req, err := http.NewRequest("GET", "/sync/messages?after=3&limit=2", nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	nodeAPI.Router.ServeHTTP(rr, req)
+
+	// Check response
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, status)
+	}
+
+	var batch models.MessageBatch
+	if err := json.NewDecoder(rr.Body).Decode(&batch); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if len(batch.Messages) != 2 || batch.Messages[0].MessageID != "msg4" {
+		t.Errorf("Unexpected messages %v", batch.Messages)
+	}
+	if batch.Cursor != 5 || batch.Head != 8 || !batch.More {
+		t.Errorf("Unexpected batch position %+v", batch)
+	}
+
+	// Invalid cursor
+	req, _ = http.NewRequest("GET", "/sync/messages?after=abc", nil)
+	rr = httptest.NewRecorder()
+	nodeAPI.Router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, status)
+	}
+
+	mockStorage.AssertExpectations(t)
+}
+
 func TestAPI_handleGetMessage(t *testing.T) {
 	// Create test dependencies
 	logger := logrus.New()
@@ -475,7 +868,13 @@ func TestAPI_Start(t *testing.T) {
 	logger := logrus.New()
 	logger.SetOutput(logrus.StandardLogger().Out)
 
-	cfg := config.DefaultConfig(3000, 0)
+	// Take a free port
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	cfg := config.DefaultConfig(listener.Addr().(*net.TCPAddr).Port, 0)
+	cfg.ListenAddr = "127.0.0.1"
 
 	mockGossip := new(MockGossipProtocol)
 	mockPex := new(MockPexProtocol)
@@ -485,14 +884,27 @@ func TestAPI_Start(t *testing.T) {
 	// Create API
 	nodeAPI := api.NewAPI(cfg, mockGossip, mockPex, logger, mockStorage, mockHookManager)
 
-	// Start API in a goroutine with a random port (to avoid conflicts with real services)
-	// This is just a basic test to ensure Start method doesn't crash
-	go func() {
-		nodeAPI.Start()
-	}()
+	// A busy port is reported by Start
+	if err := nodeAPI.Start(context.Background()); err == nil {
+		t.Fatalf("Expected an error for a busy port")
+	}
+	listener.Close()
 
-	// Wait a short time to make sure it has a chance to start
-	time.Sleep(100 * time.Millisecond)
+	if err := nodeAPI.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start API: %v", err)
+	}
+	url := fmt.Sprintf("http://%s/ping", cfg.ListenAddress())
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Expected the API to serve requests: %v", err)
+	}
+	resp.Body.Close()
+
+	// After Stop the port is closed
+	nodeAPI.Stop()
+	if _, err := http.Get(url); err == nil {
+		t.Errorf("Expected the API to be stopped")
+	}
 }
 
 func TestAPI_LogHook(t *testing.T) {
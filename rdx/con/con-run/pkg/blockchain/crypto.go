@@ -0,0 +1,51 @@
+package blockchain
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+)
+
+// verifySignature проверяет подпись транзакции публичным ключом отправителя
+func verifySignature(tx Transaction, pubKey PubKey) error {
+	data, err := transactionSigningData(tx)
+	if err != nil {
+		return fmt.Errorf("failed to marshal transaction data: %w", err)
+	}
+	digest := sha256.Sum256(data)
+
+	key, err := parsePublicKey(pubKey)
+	if err != nil {
+		return err
+	}
+
+	if !ecdsa.VerifyASN1(key, digest[:], tx.Signature) {
+		return fmt.Errorf("signature does not match public key")
+	}
+	return nil
+}
+
+// parsePublicKey разбирает hex-представление несжатого публичного ключа P-256
+func parsePublicKey(pubKey PubKey) (*ecdsa.PublicKey, error) {
+	raw, err := hex.DecodeString(pubKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode public key: %w", err)
+	}
+
+	// ecdh проверяет, что точка лежит на кривой
+	key, err := ecdh.P256().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	point := key.Bytes()
+	return &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(point[1:33]),
+		Y:     new(big.Int).SetBytes(point[33:]),
+	}, nil
+}
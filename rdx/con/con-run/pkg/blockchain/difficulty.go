@@ -0,0 +1,127 @@
+package blockchain
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// targetDigits длина цели сложности в hex-символах (256 бит, как у SHA-256)
+const targetDigits = 64
+
+// MaxTarget самая легкая допустимая цель, она же цель первого блока
+var MaxTarget = mustParseTarget(DifficultyTarget)
+
+// DifficultyParams параметры пересчета сложности.
+// Должны совпадать у всех узлов сети.
+type DifficultyParams struct {
+	RetargetInterval int           // число блоков между пересчетами цели
+	BlockTime        time.Duration // желаемое время между блоками
+}
+
+// ParseTarget разбирает цель сложности - 256-битное число в hex.
+// Недостающие младшие разряды считаются равными f, поэтому префикс "0000"
+// означает цель 0000ffff...ff: хеш не больше ее ровно тогда, когда начинается с "0000".
+func ParseTarget(s string) (*big.Int, error) {
+	if s == "" || len(s) > targetDigits {
+		return nil, fmt.Errorf("difficulty target must have 1 to %d hex digits, got %d", targetDigits, len(s))
+	}
+
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return nil, fmt.Errorf("difficulty target %q is not a lowercase hex number", s)
+		}
+	}
+
+	target, _ := new(big.Int).SetString(s+strings.Repeat("f", targetDigits-len(s)), 16)
+	return target, nil
+}
+
+// FormatTarget записывает цель в кратчайшей форме, отбрасывая младшие разряды f
+func FormatTarget(target *big.Int) string {
+	s := fmt.Sprintf("%0*x", targetDigits, target)
+	if trimmed := strings.TrimRight(s, "f"); trimmed != "" {
+		return trimmed
+	}
+	return "f"
+}
+
+// MeetsTarget проверяет, что хеш блока как число не больше цели
+func MeetsTarget(hash Hash, target *big.Int) bool {
+	value, ok := new(big.Int).SetString(hash, 16)
+	return ok && value.Cmp(target) <= 0
+}
+
+// BlockWork возвращает ожидаемое количество хешей, необходимое для нахождения блока:
+// 2^256 / (target + 1). Для цели в виде префикса из n нулей это 16^n.
+func BlockWork(block Block) *big.Int {
+	target, err := ParseTarget(block.DifficultyTarget)
+	if err != nil {
+		return new(big.Int)
+	}
+
+	work := new(big.Int).Lsh(big.NewInt(1), 4*targetDigits)
+	return work.Div(work, target.Add(target, big.NewInt(1)))
+}
+
+// NextTarget вычисляет цель для блока на высоте height (первый блок имеет высоту 1).
+// ancestor возвращает блок той же ветки на меньшей высоте.
+//
+// Цель пересчитывается каждые RetargetInterval блоков, как в Bitcoin: время между
+// первым и последним блоком прошедшего окна сравнивается с желаемым, изменение
+// ограничено четырьмя разами в каждую сторону, цель не бывает легче MaxTarget.
+func NextTarget(height int, ancestor func(height int) (*Block, error), params DifficultyParams) (*big.Int, error) {
+	if height <= 1 {
+		return new(big.Int).Set(MaxTarget), nil
+	}
+
+	prev, err := ancestor(height - 1)
+	if err != nil {
+		return nil, err
+	}
+	target, err := ParseTarget(prev.DifficultyTarget)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := params.RetargetInterval
+	if interval < 2 || (height-1)%interval != 0 {
+		return target, nil
+	}
+
+	first, err := ancestor(height - interval)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := int64(interval-1) * int64(params.BlockTime/time.Second)
+	if expected <= 0 {
+		return target, nil
+	}
+	actual := prev.Time - first.Time
+	if actual < expected/4 {
+		actual = expected / 4
+	}
+	if actual > expected*4 {
+		actual = expected * 4
+	}
+
+	target.Mul(target, big.NewInt(actual))
+	target.Div(target, big.NewInt(expected))
+	if target.Cmp(MaxTarget) > 0 {
+		target.Set(MaxTarget)
+	}
+	if target.Sign() == 0 {
+		target.SetInt64(1)
+	}
+	return target, nil
+}
+
+func mustParseTarget(s string) *big.Int {
+	target, err := ParseTarget(s)
+	if err != nil {
+		panic(err)
+	}
+	return target
+}
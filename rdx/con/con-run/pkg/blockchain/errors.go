@@ -0,0 +1,55 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUserNotFound  = errors.New("user not found")
+	ErrBlockNotFound = errors.New("block not found")
+)
+
+// RejectCode машиночитаемый код причины отклонения транзакции или блока
+type RejectCode string
+
+const (
+	RejectMalformed          RejectCode = "malformed"
+	RejectUnknownSender      RejectCode = "unknown_sender"
+	RejectBadSignature       RejectCode = "bad_signature"
+	RejectNegativeAmount     RejectCode = "negative_amount"
+	RejectNegativeFee        RejectCode = "negative_fee"
+	RejectInsufficientFunds  RejectCode = "insufficient_funds"
+	RejectNonceReused        RejectCode = "nonce_reused"
+	RejectNonceOutOfOrder    RejectCode = "nonce_out_of_order"
+	RejectBadPubKey          RejectCode = "bad_public_key"
+	RejectAccountExists      RejectCode = "account_exists"
+	RejectBadDifficulty      RejectCode = "bad_difficulty_target"
+	RejectHashMismatch       RejectCode = "hash_mismatch"
+	RejectInsufficientWork   RejectCode = "insufficient_work"
+	RejectBadPrevBlock       RejectCode = "bad_prev_block"
+	RejectBadTime            RejectCode = "bad_time"
+	RejectBadReward          RejectCode = "bad_reward"
+	RejectEmptyBlock         RejectCode = "empty_block"
+	RejectInvalidTransaction RejectCode = "invalid_transaction"
+	RejectBalancesMismatch   RejectCode = "balances_delta_mismatch"
+	RejectDoubleSpend        RejectCode = "double_spend"
+	RejectMempoolFull        RejectCode = "mempool_full"
+)
+
+// ValidationError описывает, почему транзакция или блок не прошли проверку
+type ValidationError struct {
+	Code   RejectCode
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Reason)
+}
+
+func reject(code RejectCode, format string, args ...interface{}) *ValidationError {
+	return &ValidationError{
+		Code:   code,
+		Reason: fmt.Sprintf(format, args...),
+	}
+}
@@ -0,0 +1,73 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// blockForHashing содержит поля блока, участвующие в вычислении хеша.
+// Порядок полей совпадает с con-valid, иначе хеши не сойдутся.
+type blockForHashing struct {
+	BalancesDelta    map[string]Amount `json:"balancesDelta"`
+	DifficultyTarget string            `json:"difficultyTarget"`
+	Miner            Username          `json:"miner"`
+	Nonce            string            `json:"nonce"`
+	Reward           Amount            `json:"reward"`
+	Time             int64             `json:"time"`
+	Txs              []Transaction     `json:"txs"`
+	PrevBlockHash    *Hash             `json:"prevBlock,omitempty"`
+}
+
+// CalculateBlockHash вычисляет хеш блока так же, как это делает con-valid
+func CalculateBlockHash(block Block) (Hash, error) {
+	data, err := json.Marshal(blockForHashing{
+		BalancesDelta:    block.BalancesDelta,
+		DifficultyTarget: block.DifficultyTarget,
+		Miner:            block.Miner,
+		Nonce:            block.Nonce,
+		Reward:           block.Reward,
+		Time:             block.Time,
+		Txs:              block.Txs,
+		PrevBlockHash:    block.PrevBlockHash,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal block for hashing: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// transactionSigningData возвращает байты, которые подписывает отправитель транзакции
+func transactionSigningData(tx Transaction) ([]byte, error) {
+	type txData struct {
+		From   Username `json:"from"`
+		To     Username `json:"to"`
+		Amount Amount   `json:"amount"`
+		Fee    Amount   `json:"fee,omitempty"`
+		Nonce  Nonce    `json:"nonce"`
+		PubKey PubKey   `json:"pubKey,omitempty"`
+	}
+
+	return json.Marshal(txData{
+		From:   tx.From,
+		To:     tx.To,
+		Amount: tx.Amount,
+		Fee:    tx.Fee,
+		Nonce:  tx.Nonce,
+		PubKey: tx.PubKey,
+	})
+}
+
+// TransactionHash вычисляет идентификатор транзакции как SHA-256 от ее JSON-представления
+func TransactionHash(tx Transaction) (Hash, error) {
+	data, err := json.Marshal(tx)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal transaction for hashing: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,120 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Ledger предоставляет состояние цепочки, против которого проверяются транзакции и блоки
+type Ledger interface {
+	FetchUser(username Username) (*User, error)
+	HasAccount(username Username) bool
+	FetchBlock(hash Hash) (*Block, error)
+	LastBlockHash() *Hash
+}
+
+// State хранит состояние цепочки в памяти.
+// Формат JSON совпадает с actual_state.json, который читает con-valid.
+type State struct {
+	Balances   map[Username]Amount `json:"cc-1"`
+	PublicKeys map[Username]PubKey `json:"cc-3"`
+	Nonces     map[Username]Nonce  `json:"cc-4"`
+	Tip        *Hash               `json:"last_block_hash"`
+	Height     int                 `json:"height"`
+	blocks     map[Hash]*Block
+	mutex      sync.RWMutex
+}
+
+// NewState создает пустое состояние
+func NewState() *State {
+	return &State{
+		Balances:   make(map[Username]Amount),
+		PublicKeys: make(map[Username]PubKey),
+		Nonces:     make(map[Username]Nonce),
+		blocks:     make(map[Hash]*Block),
+	}
+}
+
+// LoadState загружает состояние из файла в формате actual_state.json
+func LoadState(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	state := NewState()
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %w", err)
+	}
+	if state.Balances == nil {
+		state.Balances = make(map[Username]Amount)
+	}
+	if state.PublicKeys == nil {
+		state.PublicKeys = make(map[Username]PubKey)
+	}
+	if state.Nonces == nil {
+		state.Nonces = make(map[Username]Nonce)
+	}
+
+	return state, nil
+}
+
+// AddBlock добавляет принятый блок, на который могут ссылаться следующие блоки
+func (s *State) AddBlock(block *Block) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.blocks[block.Hash] = block
+}
+
+// FetchUser возвращает баланс, публичный ключ и номер последней транзакции пользователя
+func (s *State) FetchUser(username Username) (*User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	balance, ok := s.Balances[username]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	pubKey, ok := s.PublicKeys[username]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+
+	return &User{
+		Username: username,
+		PubKey:   pubKey,
+		Balance:  balance,
+		Nonce:    s.Nonces[username],
+	}, nil
+}
+
+// HasAccount сообщает, есть ли у пользователя баланс или публичный ключ
+func (s *State) HasAccount(username Username) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	_, hasBalance := s.Balances[username]
+	_, hasPubKey := s.PublicKeys[username]
+	return hasBalance || hasPubKey
+}
+
+// FetchBlock возвращает принятый блок по хешу
+func (s *State) FetchBlock(hash Hash) (*Block, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	block, ok := s.blocks[hash]
+	if !ok {
+		return nil, ErrBlockNotFound
+	}
+	return block, nil
+}
+
+// LastBlockHash возвращает хеш последнего принятого блока
+func (s *State) LastBlockHash() *Hash {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.Tip
+}
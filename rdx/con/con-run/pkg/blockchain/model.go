@@ -0,0 +1,112 @@
+package blockchain
+
+// MessageType тип gossip-сообщения, в котором передаются транзакции и блоки ConCoin
+const MessageType = "blockchain_concoin"
+
+// MinerUsername идентификатор, переводы на который считаются комиссией майнеру
+const MinerUsername = "cc"
+
+// Hash hex-строка SHA-256 хеша
+type Hash = string
+
+// Username идентификатор пользователя
+type Username = string
+
+// PubKey hex-строка публичного ключа пользователя (несжатая точка P-256)
+type PubKey = string
+
+// Amount количество монет
+type Amount = int
+
+// Nonce порядковый номер транзакции отправителя
+type Nonce = uint64
+
+// TxSignature подпись транзакции в формате ASN.1
+type TxSignature = []byte
+
+// Transaction представляет собой перевод монет между пользователями.
+// Nonce подписывается вместе с переводом: у каждой следующей транзакции
+// отправителя он на единицу больше, что защищает от повторной отправки.
+// Непустой PubKey делает транзакцию созданием аккаунта: вместе с первым
+// переводом получателю регистрируется его публичный ключ.
+// Fee списывается с отправителя сверх Amount и достается майнеру блока.
+type Transaction struct {
+	Amount    Amount      `json:"amount"`
+	Fee       Amount      `json:"fee,omitempty"`
+	From      Username    `json:"from"`
+	Nonce     Nonce       `json:"nonce"`
+	PubKey    PubKey      `json:"pubKey,omitempty"`
+	Signature TxSignature `json:"signature"`
+	To        Username    `json:"to"`
+}
+
+// Block представляет собой блок ConCoin
+type Block struct {
+	Hash             Hash              `json:"hash"`
+	DifficultyTarget string            `json:"difficultyTarget"`
+	BalancesDelta    map[string]Amount `json:"balancesDelta"`
+	Txs              []Transaction     `json:"txs"`
+	Nonce            string            `json:"nonce"`
+	Miner            Username          `json:"miner"`
+	Reward           Amount            `json:"reward"`
+	Time             int64             `json:"time"`
+	PrevBlockHash    *Hash             `json:"prevBlock"`
+}
+
+// User представляет собой пользователя с его балансом, публичным ключом
+// и номером последней принятой транзакции
+type User struct {
+	Username Username
+	PubKey   PubKey
+	Balance  Amount
+	Nonce    Nonce
+}
+
+// CreatesAccount сообщает, регистрирует ли транзакция аккаунт получателя
+func CreatesAccount(tx Transaction) bool {
+	return tx.PubKey != ""
+}
+
+// Commission возвращает комиссию, которую транзакция обещает майнеру:
+// поле Fee и перевод пользователю cc
+func Commission(tx Transaction) Amount {
+	commission := Amount(0)
+	if tx.Fee > 0 {
+		commission += tx.Fee
+	}
+	if tx.To == MinerUsername && tx.Amount > 0 {
+		commission += tx.Amount
+	}
+	return commission
+}
+
+// Cost возвращает, сколько транзакция списывает с отправителя
+func Cost(tx Transaction) Amount {
+	return tx.Amount + tx.Fee
+}
+
+// Affordable проверяет, что отправителю с балансом balance, уже потратившему
+// spent, хватает монет на amount и fee транзакции с неотрицательными суммами.
+// Суммы сравниваются вычитанием: amount + fee может переполниться и стать
+// отрицательной.
+func Affordable(tx Transaction, balance, spent Amount) bool {
+	available := balance - spent
+	return tx.Fee <= available && tx.Amount <= available-tx.Fee
+}
+
+// BalancesDelta вычисляет изменения балансов блока майнера miner с транзакциями txs:
+// комиссии и переводы пользователю cc зачисляются майнеру вместе с наградой
+func BalancesDelta(txs []Transaction, miner Username) map[string]Amount {
+	deltas := make(map[string]Amount)
+	for _, tx := range txs {
+		deltas[tx.From] -= Cost(tx)
+		to := tx.To
+		if to == MinerUsername {
+			to = miner
+		}
+		deltas[to] += tx.Amount
+		deltas[miner] += tx.Fee
+	}
+	deltas[miner] += BlockReward
+	return deltas
+}
@@ -0,0 +1,144 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"concoin/conrun/pkg/models"
+)
+
+// Payload содержимое сообщения blockchain_concoin: транзакция либо блок
+type Payload struct {
+	Transaction *Transaction
+	Block       *Block
+}
+
+// maxPayloadSize наибольший размер содержимого blockchain_concoin в байтах JSON
+const maxPayloadSize = 1 << 20
+
+// transactionSchema и payloadSchema проверяют форму содержимого до декодирования.
+// Правила ConCoin (подписи, балансы, nonce) проверяет BlockchainHook.
+var (
+	transactionSchema = models.MustParseSchema(`{
+		"type": "object",
+		"required": ["from", "to", "amount"],
+		"properties": {
+			"type": {"enum": ["transaction"]},
+			"amount": {"type": "integer"},
+			"fee": {"type": "integer"},
+			"from": {"type": "string"},
+			"to": {"type": "string"},
+			"nonce": {"type": "integer", "minimum": 0},
+			"pubKey": {"type": "string"},
+			"signature": {"type": ["string", "null"]}
+		}
+	}`)
+	blockSchema = models.MustParseSchema(`{
+		"type": "object",
+		"required": ["type", "hash", "txs"],
+		"properties": {
+			"type": {"enum": ["block"]},
+			"hash": {"type": "string"},
+			"difficultyTarget": {"type": "string"},
+			"balancesDelta": {"type": ["object", "null"]},
+			"txs": {"type": ["array", "null"]},
+			"nonce": {"type": "string"},
+			"miner": {"type": "string"},
+			"reward": {"type": "integer"},
+			"time": {"type": "integer"},
+			"prevBlock": {"type": ["string", "null"]}
+		}
+	}`)
+	payloadSchema = &models.Schema{OneOf: []*models.Schema{blockSchema, transactionSchema}}
+)
+
+func init() {
+	blockSchema.Properties["txs"].Items = transactionSchema
+	models.RegisterMessageType(models.MessageSpec{
+		Type:    MessageType,
+		New:     func() interface{} { return new(Payload) },
+		Schema:  payloadSchema,
+		MaxSize: maxPayloadSize,
+	})
+}
+
+// UnmarshalJSON разбирает содержимое gossip-сообщения.
+// Блоки помечены полем "type": "block" (как их публикует con-mine),
+// все остальное считается транзакцией в формате con-valid.
+func (p *Payload) UnmarshalJSON(data []byte) error {
+	var header struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return fmt.Errorf("payload is not an object: %w", err)
+	}
+
+	switch header.Type {
+	case "block":
+		var block Block
+		if err := json.Unmarshal(data, &block); err != nil {
+			return fmt.Errorf("failed to decode block: %w", err)
+		}
+		*p = Payload{Block: &block}
+	case "", "transaction":
+		var tx Transaction
+		if err := json.Unmarshal(data, &tx); err != nil {
+			return fmt.Errorf("failed to decode transaction: %w", err)
+		}
+		*p = Payload{Transaction: &tx}
+	default:
+		return fmt.Errorf("unknown payload type: %s", header.Type)
+	}
+	return nil
+}
+
+// MarshalJSON кодирует содержимое в том же виде, в каком его разбирает UnmarshalJSON
+func (p Payload) MarshalJSON() ([]byte, error) {
+	if p.Block != nil {
+		return json.Marshal(BlockPayload(p.Block))
+	}
+	return json.Marshal(p.Transaction)
+}
+
+// DecodePayload разбирает содержимое gossip-сообщения, не проверяя схему
+func DecodePayload(payload interface{}) (*Payload, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	var decoded Payload
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+	return &decoded, nil
+}
+
+// PayloadOf возвращает содержимое сообщения, разобранное по реестру типов
+// сообщений. Если сообщение уже разобрано при получении, повторного
+// разбора нет.
+func PayloadOf(message *models.GossipMessage) (*Payload, error) {
+	if message.MessageType != MessageType {
+		return nil, fmt.Errorf("message type %q is not %s", message.MessageType, MessageType)
+	}
+	decoded, err := message.DecodedPayload()
+	if err != nil {
+		return nil, err
+	}
+	payload, ok := decoded.(*Payload)
+	if !ok {
+		return nil, fmt.Errorf("unexpected payload type %T", decoded)
+	}
+	return payload, nil
+}
+
+// BlockPayload возвращает содержимое gossip-сообщения с блоком в формате con-mine
+func BlockPayload(block *Block) interface{} {
+	return struct {
+		Type string `json:"type"`
+		*Block
+	}{
+		Type:  "block",
+		Block: block,
+	}
+}
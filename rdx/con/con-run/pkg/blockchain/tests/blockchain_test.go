@@ -0,0 +1,390 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"concoin/conrun/pkg/blockchain"
+	"concoin/conrun/pkg/models"
+)
+
+// newKey создает ключ пользователя и возвращает его вместе с hex-представлением публичного ключа
+func newKey(t *testing.T) (*ecdsa.PrivateKey, blockchain.PubKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	pubKey := elliptic.Marshal(elliptic.P256(), key.PublicKey.X, key.PublicKey.Y)
+	return key, hex.EncodeToString(pubKey)
+}
+
+// signTx подписывает транзакцию так же, как это делает con-send
+func signTx(t *testing.T, tx blockchain.Transaction, key *ecdsa.PrivateKey) blockchain.Transaction {
+	data, err := json.Marshal(struct {
+		From   string `json:"from"`
+		To     string `json:"to"`
+		Amount int    `json:"amount"`
+		Fee    int    `json:"fee,omitempty"`
+		Nonce  uint64 `json:"nonce"`
+		PubKey string `json:"pubKey,omitempty"`
+	}{tx.From, tx.To, tx.Amount, tx.Fee, tx.Nonce, tx.PubKey})
+	if err != nil {
+		t.Fatalf("Failed to marshal transaction: %v", err)
+	}
+	digest := sha256.Sum256(data)
+	tx.Signature, err = ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	return tx
+}
+
+// mineBlock подбирает nonce для блока
+func mineBlock(t *testing.T, block blockchain.Block) blockchain.Block {
+	for nonce := 0; ; nonce++ {
+		block.Nonce = strconv.Itoa(nonce)
+		hash, err := blockchain.CalculateBlockHash(block)
+		if err != nil {
+			t.Fatalf("Failed to hash block: %v", err)
+		}
+		if strings.HasPrefix(hash, blockchain.DifficultyTarget) {
+			block.Hash = hash
+			return block
+		}
+	}
+}
+
+func newTestState(t *testing.T) (*blockchain.State, *ecdsa.PrivateKey) {
+	key, pubKey := newKey(t)
+	state := blockchain.NewState()
+	state.Balances["Alice"] = 50
+	state.PublicKeys["Alice"] = pubKey
+	return state, key
+}
+
+func requireRejectCode(t *testing.T, err error, code blockchain.RejectCode) {
+	t.Helper()
+	var validationErr *blockchain.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected validation error with code %s, got %v", code, err)
+	}
+	if validationErr.Code != code {
+		t.Errorf("Expected reject code %s, got %s (%s)", code, validationErr.Code, validationErr.Reason)
+	}
+}
+
+func TestValidateTransaction(t *testing.T) {
+	state, key := newTestState(t)
+
+	t.Run("Happy_Path", func(t *testing.T) {
+		tx := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 50, Nonce: 1}, key)
+		if err := blockchain.ValidateTransaction(tx, state); err != nil {
+			t.Errorf("Expected valid transaction, got %v", err)
+		}
+	})
+
+	t.Run("Amount_Is_More_Than_Balance", func(t *testing.T) {
+		tx := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 51, Nonce: 1}, key)
+		requireRejectCode(t, blockchain.ValidateTransaction(tx, state), blockchain.RejectInsufficientFunds)
+	})
+
+	t.Run("Negative_Amount", func(t *testing.T) {
+		tx := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: -1, Nonce: 1}, key)
+		requireRejectCode(t, blockchain.ValidateTransaction(tx, state), blockchain.RejectNegativeAmount)
+	})
+
+	t.Run("Negative_Fee", func(t *testing.T) {
+		tx := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 10, Fee: -1, Nonce: 1}, key)
+		requireRejectCode(t, blockchain.ValidateTransaction(tx, state), blockchain.RejectNegativeFee)
+	})
+
+	t.Run("Fee_Is_More_Than_Balance", func(t *testing.T) {
+		tx := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 50, Fee: 1, Nonce: 1}, key)
+		requireRejectCode(t, blockchain.ValidateTransaction(tx, state), blockchain.RejectInsufficientFunds)
+	})
+
+	t.Run("Amount_With_Fee_Overflows", func(t *testing.T) {
+		tx := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: math.MaxInt64, Fee: 1, Nonce: 1}, key)
+		requireRejectCode(t, blockchain.ValidateTransaction(tx, state), blockchain.RejectInsufficientFunds)
+
+		tx = signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 1, Fee: math.MaxInt64, Nonce: 1}, key)
+		requireRejectCode(t, blockchain.ValidateTransaction(tx, state), blockchain.RejectInsufficientFunds)
+	})
+
+	t.Run("Pending_Spends_With_Overflowing_Amount", func(t *testing.T) {
+		tx := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: math.MaxInt64 - 9, Fee: 1, Nonce: 2}, key)
+		requireRejectCode(t, blockchain.ValidatePendingTransaction(tx, state, 10, 1), blockchain.RejectInsufficientFunds)
+	})
+
+	t.Run("User_Not_Found", func(t *testing.T) {
+		tx := signTx(t, blockchain.Transaction{From: "Carol", To: "Bob", Amount: 1, Nonce: 1}, key)
+		requireRejectCode(t, blockchain.ValidateTransaction(tx, state), blockchain.RejectUnknownSender)
+	})
+
+	t.Run("Signature_Is_Bad", func(t *testing.T) {
+		tx := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 10, Nonce: 1}, key)
+		tx.Amount = 20
+		requireRejectCode(t, blockchain.ValidateTransaction(tx, state), blockchain.RejectBadSignature)
+	})
+
+	t.Run("Nonce_Is_Reused", func(t *testing.T) {
+		state, key := newTestState(t)
+		state.Nonces["Alice"] = 1
+		tx := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 10, Nonce: 1}, key)
+		requireRejectCode(t, blockchain.ValidateTransaction(tx, state), blockchain.RejectNonceReused)
+	})
+
+	t.Run("Creates_Account", func(t *testing.T) {
+		_, bobPubKey := newKey(t)
+		tx := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 10, Nonce: 1, PubKey: bobPubKey}, key)
+		if err := blockchain.ValidateTransaction(tx, state); err != nil {
+			t.Errorf("Expected valid account creation, got %v", err)
+		}
+	})
+
+	t.Run("Account_Already_Exists", func(t *testing.T) {
+		_, alicePubKey := newKey(t)
+		tx := signTx(t, blockchain.Transaction{From: "Alice", To: "Alice", Amount: 10, Nonce: 1, PubKey: alicePubKey}, key)
+		requireRejectCode(t, blockchain.ValidateTransaction(tx, state), blockchain.RejectAccountExists)
+	})
+
+	t.Run("Bad_Public_Key", func(t *testing.T) {
+		tx := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 10, Nonce: 1, PubKey: "04deadbeef"}, key)
+		requireRejectCode(t, blockchain.ValidateTransaction(tx, state), blockchain.RejectBadPubKey)
+	})
+
+	t.Run("Nonce_Is_Out_Of_Order", func(t *testing.T) {
+		tx := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 10, Nonce: 2}, key)
+		requireRejectCode(t, blockchain.ValidateTransaction(tx, state), blockchain.RejectNonceOutOfOrder)
+	})
+}
+
+func TestValidateBlock(t *testing.T) {
+	state, key := newTestState(t)
+
+	newBlock := func(txs ...blockchain.Transaction) blockchain.Block {
+		deltas := map[string]blockchain.Amount{"Scrooge": blockchain.BlockReward}
+		for _, tx := range txs {
+			deltas[tx.From] -= tx.Amount
+			deltas[tx.To] += tx.Amount
+		}
+		return blockchain.Block{
+			DifficultyTarget: blockchain.DifficultyTarget,
+			BalancesDelta:    deltas,
+			Txs:              txs,
+			Miner:            "Scrooge",
+			Reward:           blockchain.BlockReward,
+			Time:             time.Now().UTC().Unix(),
+		}
+	}
+
+	t.Run("Happy_Path", func(t *testing.T) {
+		tx := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 50, Nonce: 1}, key)
+		block := mineBlock(t, newBlock(tx))
+		if err := blockchain.ValidateBlock(block, state, blockchain.MaxTarget); err != nil {
+			t.Errorf("Expected valid block, got %v", err)
+		}
+	})
+
+	t.Run("Hash_Mismatch", func(t *testing.T) {
+		tx := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 50, Nonce: 1}, key)
+		block := mineBlock(t, newBlock(tx))
+		block.Reward = 2
+		requireRejectCode(t, blockchain.ValidateBlock(block, state, blockchain.MaxTarget), blockchain.RejectHashMismatch)
+	})
+
+	t.Run("Double_Spend_Inside_Block", func(t *testing.T) {
+		tx1 := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 30, Nonce: 1}, key)
+		tx2 := signTx(t, blockchain.Transaction{From: "Alice", To: "Carol", Amount: 30, Nonce: 2}, key)
+		block := mineBlock(t, newBlock(tx1, tx2))
+		requireRejectCode(t, blockchain.ValidateBlock(block, state, blockchain.MaxTarget), blockchain.RejectInvalidTransaction)
+	})
+
+	t.Run("Replayed_Transaction", func(t *testing.T) {
+		tx := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 10, Nonce: 1}, key)
+		block := mineBlock(t, newBlock(tx, tx))
+		requireRejectCode(t, blockchain.ValidateBlock(block, state, blockchain.MaxTarget), blockchain.RejectInvalidTransaction)
+	})
+
+	t.Run("Commission_Goes_To_Miner", func(t *testing.T) {
+		tx1 := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 10, Fee: 2, Nonce: 1}, key)
+		tx2 := signTx(t, blockchain.Transaction{From: "Alice", To: blockchain.MinerUsername, Amount: 3, Nonce: 2}, key)
+		block := newBlock(tx1, tx2)
+		block.BalancesDelta = map[string]blockchain.Amount{"Alice": -15, "Bob": 10, "Scrooge": 6}
+		block = mineBlock(t, block)
+		if err := blockchain.ValidateBlock(block, state, blockchain.MaxTarget); err != nil {
+			t.Errorf("Expected valid block, got %v", err)
+		}
+
+		// Перевод пользователю cc не может остаться у cc
+		block.BalancesDelta = map[string]blockchain.Amount{"Alice": -15, "Bob": 10, blockchain.MinerUsername: 3, "Scrooge": 3}
+		block = mineBlock(t, block)
+		requireRejectCode(t, blockchain.ValidateBlock(block, state, blockchain.MaxTarget), blockchain.RejectBalancesMismatch)
+	})
+
+	t.Run("Account_Created_After_Transfer", func(t *testing.T) {
+		_, bobPubKey := newKey(t)
+		tx1 := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 10, Nonce: 1}, key)
+		tx2 := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 10, Nonce: 2, PubKey: bobPubKey}, key)
+		block := mineBlock(t, newBlock(tx1, tx2))
+		requireRejectCode(t, blockchain.ValidateBlock(block, state, blockchain.MaxTarget), blockchain.RejectInvalidTransaction)
+	})
+
+	t.Run("Unknown_Previous_Block", func(t *testing.T) {
+		tx := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 50, Nonce: 1}, key)
+		block := newBlock(tx)
+		prev := "0000deadbeef"
+		block.PrevBlockHash = &prev
+		block = mineBlock(t, block)
+		requireRejectCode(t, blockchain.ValidateBlock(block, state, blockchain.MaxTarget), blockchain.RejectBadPrevBlock)
+	})
+
+	t.Run("Wrong_Difficulty_Target", func(t *testing.T) {
+		tx := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 50, Nonce: 1}, key)
+		block := mineBlock(t, newBlock(tx))
+		harder := new(big.Int).Rsh(blockchain.MaxTarget, 1)
+		requireRejectCode(t, blockchain.ValidateBlock(block, state, harder), blockchain.RejectBadDifficulty)
+	})
+}
+
+func TestDifficulty(t *testing.T) {
+	t.Run("Prefix_Target", func(t *testing.T) {
+		target, err := blockchain.ParseTarget("0000")
+		if err != nil {
+			t.Fatalf("Failed to parse target: %v", err)
+		}
+		if blockchain.FormatTarget(target) != "0000" {
+			t.Errorf("Expected target to format as 0000, got %s", blockchain.FormatTarget(target))
+		}
+		if !blockchain.MeetsTarget("0000ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", target) {
+			t.Errorf("Hash with prefix 0000 should meet target 0000")
+		}
+		if blockchain.MeetsTarget("0001000000000000000000000000000000000000000000000000000000000000", target) {
+			t.Errorf("Hash with prefix 0001 should not meet target 0000")
+		}
+		if work := blockchain.BlockWork(blockchain.Block{DifficultyTarget: "0000"}); work.Cmp(big.NewInt(1<<16)) != 0 {
+			t.Errorf("Expected work 65536 for target 0000, got %s", work)
+		}
+	})
+
+	t.Run("Invalid_Target", func(t *testing.T) {
+		for _, target := range []string{"", "-0000", "00zz", strings.Repeat("0", 65)} {
+			if _, err := blockchain.ParseTarget(target); err == nil {
+				t.Errorf("Expected target %q to be rejected", target)
+			}
+		}
+	})
+
+	params := blockchain.DifficultyParams{RetargetInterval: 3, BlockTime: 10 * time.Second}
+	chainWithTimes := func(times ...int64) func(height int) (*blockchain.Block, error) {
+		return func(height int) (*blockchain.Block, error) {
+			return &blockchain.Block{DifficultyTarget: "00008", Time: times[height-1]}, nil
+		}
+	}
+	current, _ := blockchain.ParseTarget("00008")
+
+	t.Run("Keeps_Target_Inside_Window", func(t *testing.T) {
+		target, err := blockchain.NextTarget(3, chainWithTimes(0, 1), params)
+		if err != nil || target.Cmp(current) != 0 {
+			t.Errorf("Expected unchanged target, got %v (%v)", target, err)
+		}
+	})
+
+	t.Run("Retarget", func(t *testing.T) {
+		// Окно из 3 блоков: 2 интервала по 10 секунд ожидается, 10 секунд получено
+		target, err := blockchain.NextTarget(4, chainWithTimes(100, 105, 110), params)
+		expected := new(big.Int).Div(current, big.NewInt(2))
+		if err != nil || target.Cmp(expected) != 0 {
+			t.Errorf("Expected target %s, got %v (%v)", blockchain.FormatTarget(expected), target, err)
+		}
+
+		// Слишком медленные блоки: цель растет не более чем в 4 раза и не выше MaxTarget
+		target, err = blockchain.NextTarget(4, chainWithTimes(100, 1000, 10000), params)
+		if err != nil || target.Cmp(blockchain.MaxTarget) != 0 {
+			t.Errorf("Expected target capped at MaxTarget, got %v (%v)", target, err)
+		}
+	})
+}
+
+func TestDecodePayload(t *testing.T) {
+	payload, err := blockchain.DecodePayload(map[string]interface{}{
+		"type": "block",
+		"hash": "abc",
+		"txs":  []interface{}{},
+	})
+	if err != nil {
+		t.Fatalf("Failed to decode block payload: %v", err)
+	}
+	if payload.Block == nil || payload.Block.Hash != "abc" {
+		t.Errorf("Expected block with hash abc, got %+v", payload)
+	}
+
+	payload, err = blockchain.DecodePayload(map[string]interface{}{
+		"from":   "Alice",
+		"to":     "Bob",
+		"amount": 5,
+	})
+	if err != nil {
+		t.Fatalf("Failed to decode transaction payload: %v", err)
+	}
+	if payload.Transaction == nil || payload.Transaction.Amount != 5 {
+		t.Errorf("Expected transaction with amount 5, got %+v", payload)
+	}
+
+	if _, err := blockchain.DecodePayload(map[string]interface{}{"type": "unknown"}); err == nil {
+		t.Error("Expected error for unknown payload type")
+	}
+}
+
+func TestPayloadOf(t *testing.T) {
+	tx := blockchain.Transaction{From: "Alice", To: "Bob", Amount: 5, Nonce: 1}
+	block := &blockchain.Block{Hash: "abc", Txs: []blockchain.Transaction{tx}}
+
+	// Typed payloads survive the trip through the wire format
+	for _, payload := range []interface{}{tx, blockchain.BlockPayload(block)} {
+		data, _ := json.Marshal(payload)
+		var raw interface{}
+		json.Unmarshal(data, &raw)
+
+		message := &models.GossipMessage{MessageType: blockchain.MessageType, Payload: raw}
+		if err := models.DecodeMessage(message); err != nil {
+			t.Fatalf("Failed to decode %s: %v", data, err)
+		}
+		decoded, err := blockchain.PayloadOf(message)
+		if err != nil || decoded != message.Decoded {
+			t.Fatalf("Expected the decoded payload to be reused, got %v", err)
+		}
+		again, _ := json.Marshal(decoded)
+		if string(again) != string(data) {
+			t.Errorf("Expected %s after a round trip, got %s", data, again)
+		}
+	}
+
+	invalid := []map[string]interface{}{
+		{"from": "Alice", "to": "Bob", "amount": "5"},
+		{"from": "Alice", "to": "Bob", "amount": 5, "nonce": -1},
+		{"type": "block", "hash": "abc", "txs": []interface{}{map[string]interface{}{"from": "Alice"}}},
+		{"type": "unknown"},
+	}
+	for _, payload := range invalid {
+		message := &models.GossipMessage{MessageType: blockchain.MessageType, Payload: payload}
+		if err := models.DecodeMessage(message); !errors.Is(err, models.ErrInvalidPayload) {
+			t.Errorf("Expected %v to be rejected, got %v", payload, err)
+		}
+	}
+
+	if _, err := blockchain.PayloadOf(&models.GossipMessage{MessageType: "user_message"}); err == nil {
+		t.Error("Expected error for a message of another type")
+	}
+}
@@ -0,0 +1,210 @@
+package blockchain
+
+import (
+	"errors"
+	"math/big"
+	"time"
+)
+
+const (
+	// DifficultyTarget цель первого блока и самая легкая допустимая цель
+	DifficultyTarget = "0000"
+	// BlockReward награда майнеру за блок
+	BlockReward Amount = 1
+)
+
+// ValidateTransaction проверяет транзакцию против состояния цепочки
+func ValidateTransaction(tx Transaction, ledger Ledger) error {
+	return ValidatePendingTransaction(tx, ledger, 0, 0)
+}
+
+// ValidatePendingTransaction проверяет транзакцию, перед которой у отправителя есть
+// pending еще не вошедших в цепочку транзакций, потративших spent монет.
+// Nonce транзакции должен быть на единицу больше nonce последней из них.
+func ValidatePendingTransaction(tx Transaction, ledger Ledger, spent Amount, pending int) error {
+	sender, err := ledger.FetchUser(tx.From)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return reject(RejectUnknownSender, "sender %q not found", tx.From)
+		}
+		return err
+	}
+
+	if err := verifySignature(tx, sender.PubKey); err != nil {
+		return reject(RejectBadSignature, "%v", err)
+	}
+
+	if tx.Amount < 0 {
+		return reject(RejectNegativeAmount, "amount %d is negative", tx.Amount)
+	}
+	if tx.Fee < 0 {
+		return reject(RejectNegativeFee, "fee %d is negative", tx.Fee)
+	}
+
+	expectedNonce := sender.Nonce + Nonce(pending) + 1
+	if tx.Nonce < expectedNonce {
+		return reject(RejectNonceReused, "nonce %d of %q is already used, expected %d",
+			tx.Nonce, tx.From, expectedNonce)
+	}
+	if tx.Nonce > expectedNonce {
+		return reject(RejectNonceOutOfOrder, "nonce %d of %q is out of order, expected %d",
+			tx.Nonce, tx.From, expectedNonce)
+	}
+
+	if !Affordable(tx, sender.Balance, spent) {
+		return reject(RejectInsufficientFunds, "amount %d with fee %d exceeds balance %d of %q",
+			tx.Amount, tx.Fee, sender.Balance-spent, tx.From)
+	}
+
+	if CreatesAccount(tx) {
+		return validateAccountCreation(tx, ledger)
+	}
+
+	return nil
+}
+
+// validateAccountCreation проверяет, что транзакция регистрирует корректный ключ
+// для еще не занятого идентификатора
+func validateAccountCreation(tx Transaction, ledger Ledger) error {
+	if _, err := parsePublicKey(tx.PubKey); err != nil {
+		return reject(RejectBadPubKey, "%v", err)
+	}
+	if tx.To == MinerUsername {
+		return reject(RejectAccountExists, "account %q is reserved for the miner", tx.To)
+	}
+	if ledger.HasAccount(tx.To) {
+		return reject(RejectAccountExists, "account %q already exists", tx.To)
+	}
+	return nil
+}
+
+// ValidateBlock проверяет блок против состояния цепочки.
+// target - ожидаемая цель сложности блока (см. NextTarget).
+func ValidateBlock(block Block, ledger Ledger, target *big.Int) error {
+	blockTarget, err := ParseTarget(block.DifficultyTarget)
+	if err != nil {
+		return reject(RejectBadDifficulty, "%v", err)
+	}
+	if blockTarget.Cmp(target) != 0 {
+		return reject(RejectBadDifficulty, "difficulty target %q, expected %q",
+			block.DifficultyTarget, FormatTarget(target))
+	}
+
+	hash, err := CalculateBlockHash(block)
+	if err != nil {
+		return reject(RejectMalformed, "%v", err)
+	}
+	if hash != block.Hash {
+		return reject(RejectHashMismatch, "block hash %s, calculated %s", block.Hash, hash)
+	}
+	if !MeetsTarget(hash, target) {
+		return reject(RejectInsufficientWork, "hash %s does not meet target %s", hash, block.DifficultyTarget)
+	}
+
+	if !sameHash(block.PrevBlockHash, ledger.LastBlockHash()) {
+		return reject(RejectBadPrevBlock, "previous block %s is not the last block %s",
+			hashString(block.PrevBlockHash), hashString(ledger.LastBlockHash()))
+	}
+
+	if block.PrevBlockHash != nil {
+		prevBlock, err := ledger.FetchBlock(*block.PrevBlockHash)
+		if err != nil {
+			return reject(RejectBadPrevBlock, "failed to fetch previous block: %v", err)
+		}
+		if block.Time <= prevBlock.Time {
+			return reject(RejectBadTime, "block time %d is not after previous block time %d",
+				block.Time, prevBlock.Time)
+		}
+	}
+
+	if block.Time > time.Now().UTC().Unix() {
+		return reject(RejectBadTime, "block time %d is in the future", block.Time)
+	}
+
+	if block.Reward != BlockReward {
+		return reject(RejectBadReward, "reward %d, expected %d", block.Reward, BlockReward)
+	}
+
+	if len(block.Txs) == 0 {
+		return reject(RejectEmptyBlock, "block has no transactions")
+	}
+
+	deltas := make(map[Username]Amount)
+	sent := make(map[Username]int)
+	for i, tx := range block.Txs {
+		if err := ValidatePendingTransaction(tx, ledger, -deltas[tx.From], sent[tx.From]); err != nil {
+			return reject(RejectInvalidTransaction, "transaction %d: %v", i, err)
+		}
+		// Идентификатор, получивший монеты раньше в этом же блоке, уже занят
+		if _, touched := deltas[tx.To]; CreatesAccount(tx) && touched {
+			return reject(RejectInvalidTransaction, "transaction %d: %v", i,
+				reject(RejectAccountExists, "account %q is already used in this block", tx.To))
+		}
+		sent[tx.From]++
+		deltas[tx.From] -= Cost(tx)
+		deltas[tx.To] += tx.Amount
+	}
+
+	if !sameDeltas(BalancesDelta(block.Txs, block.Miner), block.BalancesDelta) {
+		return reject(RejectBalancesMismatch, "balances delta does not match transactions")
+	}
+
+	return nil
+}
+
+// CheckBlockWork проверяет то, что не зависит от состояния цепочки: хеш блока,
+// работу по заявленной цели и время. Так проверяются блоки, предок которых
+// еще не получен.
+func CheckBlockWork(block Block) error {
+	target, err := ParseTarget(block.DifficultyTarget)
+	if err != nil {
+		return reject(RejectBadDifficulty, "%v", err)
+	}
+	if target.Cmp(MaxTarget) > 0 {
+		return reject(RejectBadDifficulty, "difficulty target %q is easier than %q",
+			block.DifficultyTarget, DifficultyTarget)
+	}
+
+	hash, err := CalculateBlockHash(block)
+	if err != nil {
+		return reject(RejectMalformed, "%v", err)
+	}
+	if hash != block.Hash {
+		return reject(RejectHashMismatch, "block hash %s, calculated %s", block.Hash, hash)
+	}
+	if !MeetsTarget(hash, target) {
+		return reject(RejectInsufficientWork, "hash %s does not meet target %s", hash, block.DifficultyTarget)
+	}
+
+	if block.Time > time.Now().UTC().Unix() {
+		return reject(RejectBadTime, "block time %d is in the future", block.Time)
+	}
+	return nil
+}
+
+func sameHash(a, b *Hash) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func hashString(hash *Hash) string {
+	if hash == nil {
+		return "<none>"
+	}
+	return *hash
+}
+
+func sameDeltas(a map[Username]Amount, b map[string]Amount) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for user, amount := range a {
+		other, ok := b[user]
+		if !ok || other != amount {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,831 @@
+package chain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"concoin/conrun/pkg/blockchain"
+	"concoin/consensus"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TipChange описывает смену последнего блока основной цепочки
+type TipChange struct {
+	Connected    []*blockchain.Block      // блоки, добавленные в основную цепочку (от старых к новым)
+	Disconnected []*blockchain.Block      // блоки, вытесненные из основной цепочки (от новых к старым)
+	Orphaned     []blockchain.Transaction // транзакции вытесненных блоков, не вошедшие в новую ветку
+}
+
+// TipListener вызывается после каждой смены последнего блока
+type TipListener func(change TipChange)
+
+// MissingBlockHandler вызывается, когда узлу нужен неизвестный блок - предок блока-сироты
+type MissingBlockHandler func(hash blockchain.Hash)
+
+// maxOrphans ограничивает число блоков, ожидающих своего предка
+const maxOrphans = 100
+
+// blockNode представляет блок в дереве блоков
+type blockNode struct {
+	block   *blockchain.Block
+	height  int
+	work    *big.Int              // суммарная работа от генезиса до блока включительно
+	created []blockchain.Username // балансы, которых не было в ветке до блока
+}
+
+// Chain хранит дерево блоков узла и состояние основной цепочки: балансы (cc-1),
+// публичные ключи (cc-3) и номера последних транзакций пользователей (cc-4). Основной считается ветка с наибольшей суммарной работой.
+// Состояние сохраняется на диск после каждого принятого блока.
+type Chain struct {
+	dataDir    string
+	params     blockchain.DifficultyParams
+	balances   map[blockchain.Username]blockchain.Amount
+	publicKeys map[blockchain.Username]blockchain.PubKey
+	nonces     map[blockchain.Username]blockchain.Nonce
+	tip        *blockNode
+	nodes      map[blockchain.Hash]*blockNode
+	listeners  []TipListener
+	mutex      sync.RWMutex
+	logger     *logrus.Logger
+
+	// Блоки, предок которых еще не получен, в порядке получения
+	orphans      map[blockchain.Hash]*blockchain.Block
+	orphanOrder  []blockchain.Hash
+	missingBlock MissingBlockHandler
+}
+
+// NewChain создает состояние блокчейна и загружает его из dataDir.
+// Если сохраненного состояния нет, в качестве генезиса используется
+// файл actual_state.json в dataDir (формат con-valid), если он существует.
+func NewChain(dataDir string, logger *logrus.Logger) (*Chain, error) {
+	c := &Chain{
+		dataDir: dataDir,
+		params: blockchain.DifficultyParams{
+			RetargetInterval: consensus.RetargetInterval,
+			BlockTime:        consensus.BlockTime,
+		},
+		balances:   make(map[blockchain.Username]blockchain.Amount),
+		publicKeys: make(map[blockchain.Username]blockchain.PubKey),
+		nonces:     make(map[blockchain.Username]blockchain.Nonce),
+		nodes:      make(map[blockchain.Hash]*blockNode),
+		orphans:    make(map[blockchain.Hash]*blockchain.Block),
+		logger:     logger,
+	}
+
+	for _, dir := range []string{c.blocksDir(), c.stateDir(), c.undoDir()} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create chain directory %s: %w", dir, err)
+		}
+	}
+
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// AddTipListener добавляет обработчик смены последнего блока
+func (c *Chain) AddTipListener(listener TipListener) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.listeners = append(c.listeners, listener)
+}
+
+// SetMissingBlockHandler задает запрос неизвестных предков блоков-сирот у пиров
+func (c *Chain) SetMissingBlockHandler(handler MissingBlockHandler) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.missingBlock = handler
+}
+
+// ValidateBlock проверяет блок против состояния ветки, на которую он ссылается.
+// У блока, предок которого еще не получен, проверяется только работа.
+func (c *Chain) ValidateBlock(block *blockchain.Block) error {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if c.isKnown(block.Hash) {
+		return nil
+	}
+	if c.isOrphan(block) {
+		return blockchain.CheckBlockWork(*block)
+	}
+	_, err := c.validateBlock(block)
+	return err
+}
+
+// AddBlock проверяет блок, добавляет его в дерево блоков и, если его ветка
+// стала самой тяжелой, переключает на нее основную цепочку. Блок, предок
+// которого еще не получен, ждет его в пуле сирот, а предок запрашивается у пиров.
+func (c *Chain) AddBlock(block *blockchain.Block) error {
+	c.mutex.Lock()
+
+	if c.isKnown(block.Hash) {
+		c.mutex.Unlock()
+		c.logger.Debugf("Chain: block %s already known", block.Hash)
+		return nil
+	}
+
+	if c.isOrphan(block) {
+		missing, err := c.addOrphan(block)
+		handler := c.missingBlock
+		c.mutex.Unlock()
+
+		if err != nil {
+			return err
+		}
+		if handler != nil {
+			handler(missing)
+		}
+		return nil
+	}
+
+	node, err := c.connectBlock(block)
+	if err != nil {
+		c.mutex.Unlock()
+		return err
+	}
+
+	// Сироты, ждавшие этот блок, присоединяются следом
+	best := node
+	for _, child := range c.connectOrphans(node) {
+		if child.work.Cmp(best.work) > 0 {
+			best = child
+		}
+	}
+
+	if c.tip != nil && best.work.Cmp(c.tip.work) <= 0 {
+		c.mutex.Unlock()
+		c.logger.Infof("Chain: stored side branch block %s at height %d", best.block.Hash, best.height)
+		return nil
+	}
+
+	change := c.setTip(best)
+	err = c.saveState()
+	listeners := append([]TipListener(nil), c.listeners...)
+	c.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	for _, listener := range listeners {
+		listener(change)
+	}
+	return nil
+}
+
+// FetchUser возвращает баланс, публичный ключ и номер последней транзакции пользователя в основной цепочке
+func (c *Chain) FetchUser(username blockchain.Username) (*blockchain.User, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.viewAt(c.tip).FetchUser(username)
+}
+
+// HasAccount сообщает, есть ли у пользователя баланс или публичный ключ в основной цепочке
+func (c *Chain) HasAccount(username blockchain.Username) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.viewAt(c.tip).HasAccount(username)
+}
+
+// FetchBlock возвращает известный блок по хешу, в том числе из боковой ветки
+func (c *Chain) FetchBlock(hash blockchain.Hash) (*blockchain.Block, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	node, ok := c.nodes[hash]
+	if !ok {
+		return nil, blockchain.ErrBlockNotFound
+	}
+	return node.block, nil
+}
+
+// LastBlockHash возвращает хеш последнего блока основной цепочки
+func (c *Chain) LastBlockHash() *blockchain.Hash {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return nodeHash(c.tip)
+}
+
+// Height возвращает высоту основной цепочки
+func (c *Chain) Height() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return nodeHeight(c.tip)
+}
+
+// NextTarget возвращает цель сложности для блока поверх последнего блока основной цепочки
+func (c *Chain) NextTarget() (string, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	target, err := c.nextTarget(c.tip)
+	if err != nil {
+		return "", err
+	}
+	return blockchain.FormatTarget(target), nil
+}
+
+// Snapshot возвращает копию текущего состояния в формате actual_state.json
+func (c *Chain) Snapshot() *blockchain.State {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	state := blockchain.NewState()
+	for user, balance := range c.balances {
+		state.Balances[user] = balance
+	}
+	for user, pubKey := range c.publicKeys {
+		state.PublicKeys[user] = pubKey
+	}
+	for user, nonce := range c.nonces {
+		state.Nonces[user] = nonce
+	}
+	state.Tip = nodeHash(c.tip)
+	state.Height = nodeHeight(c.tip)
+	return state
+}
+
+// Orphans возвращает число блоков, ожидающих своего предка
+func (c *Chain) Orphans() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return len(c.orphans)
+}
+
+// isKnown сообщает, есть ли блок в дереве или в пуле сирот.
+// Вызывается под блокировкой.
+func (c *Chain) isKnown(hash blockchain.Hash) bool {
+	if _, exists := c.nodes[hash]; exists {
+		return true
+	}
+	_, exists := c.orphans[hash]
+	return exists
+}
+
+// isOrphan сообщает, что предка блока нет в дереве. Вызывается под блокировкой.
+func (c *Chain) isOrphan(block *blockchain.Block) bool {
+	if block.PrevBlockHash == nil {
+		return false
+	}
+	_, exists := c.nodes[*block.PrevBlockHash]
+	return !exists
+}
+
+// connectBlock проверяет блок, предок которого есть в дереве, сохраняет его
+// и добавляет в дерево. Вызывается под блокировкой.
+func (c *Chain) connectBlock(block *blockchain.Block) (*blockNode, error) {
+	view, err := c.validateBlock(block)
+	if err != nil {
+		return nil, err
+	}
+
+	node := c.newNode(block, c.parentOf(block))
+	node.created = createdBalances(view.balances, block)
+	if err := c.saveUndo(node); err != nil {
+		return nil, err
+	}
+	if err := c.saveBlock(block); err != nil {
+		return nil, err
+	}
+	c.nodes[block.Hash] = node
+	return node, nil
+}
+
+// addOrphan кладет блок в пул сирот, вытесняя самый старый, и возвращает
+// хеш неизвестного блока, с которого начинается цепочка сирот.
+// Вызывается под блокировкой.
+func (c *Chain) addOrphan(block *blockchain.Block) (blockchain.Hash, error) {
+	if err := blockchain.CheckBlockWork(*block); err != nil {
+		return "", err
+	}
+
+	if len(c.orphanOrder) >= maxOrphans {
+		oldest := c.orphanOrder[0]
+		c.orphanOrder = c.orphanOrder[1:]
+		delete(c.orphans, oldest)
+		c.logger.Warnf("Chain: orphan pool is full, dropped block %s", oldest)
+	}
+	c.orphans[block.Hash] = block
+	c.orphanOrder = append(c.orphanOrder, block.Hash)
+
+	missing := *block.PrevBlockHash
+	for orphan, exists := c.orphans[missing]; exists; orphan, exists = c.orphans[missing] {
+		missing = *orphan.PrevBlockHash
+	}
+	c.logger.Infof("Chain: block %s waits for unknown block %s", block.Hash, missing)
+	return missing, nil
+}
+
+// connectOrphans присоединяет к дереву сирот, которые ждали блок parent, и
+// их потомков. Невалидные сироты отбрасываются. Вызывается под блокировкой.
+func (c *Chain) connectOrphans(parent *blockNode) []*blockNode {
+	var connected []*blockNode
+	queue := []blockchain.Hash{parent.block.Hash}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+
+		var children []*blockchain.Block
+		remaining := c.orphanOrder[:0]
+		for _, orphanHash := range c.orphanOrder {
+			orphan := c.orphans[orphanHash]
+			if *orphan.PrevBlockHash == hash {
+				children = append(children, orphan)
+				delete(c.orphans, orphanHash)
+				continue
+			}
+			remaining = append(remaining, orphanHash)
+		}
+		c.orphanOrder = remaining
+
+		for _, child := range children {
+			node, err := c.connectBlock(child)
+			if err != nil {
+				c.logger.Warnf("Chain: dropped orphan block %s: %v", child.Hash, err)
+				continue
+			}
+			connected = append(connected, node)
+			queue = append(queue, child.Hash)
+		}
+	}
+	return connected
+}
+
+// validateBlock проверяет блок против состояния на его родителе и возвращает
+// это состояние. Вызывается под блокировкой.
+func (c *Chain) validateBlock(block *blockchain.Block) (*branchView, error) {
+	if block.PrevBlockHash != nil {
+		if _, ok := c.nodes[*block.PrevBlockHash]; !ok {
+			return nil, &blockchain.ValidationError{
+				Code:   blockchain.RejectBadPrevBlock,
+				Reason: fmt.Sprintf("previous block %s is unknown", *block.PrevBlockHash),
+			}
+		}
+	}
+
+	parent := c.parentOf(block)
+	target, err := c.nextTarget(parent)
+	if err != nil {
+		return nil, err
+	}
+	view := c.viewAt(parent)
+	if err := blockchain.ValidateBlock(*block, view, target); err != nil {
+		return nil, err
+	}
+	return view, nil
+}
+
+// nextTarget вычисляет цель сложности для блока поверх parent.
+// Вызывается под блокировкой.
+func (c *Chain) nextTarget(parent *blockNode) (*big.Int, error) {
+	ancestor := func(height int) (*blockchain.Block, error) {
+		node := parent
+		for node != nil && node.height > height {
+			node = c.parentOf(node.block)
+		}
+		if node == nil || node.height != height {
+			return nil, fmt.Errorf("no block at height %d", height)
+		}
+		return node.block, nil
+	}
+	return blockchain.NextTarget(nodeHeight(parent)+1, ancestor, c.params)
+}
+
+// setTip переключает основную цепочку на ветку, заканчивающуюся node.
+// Вызывается под блокировкой.
+func (c *Chain) setTip(node *blockNode) TipChange {
+	disconnect, connect := c.branchPath(c.tip, node)
+
+	var change TipChange
+	for _, n := range disconnect {
+		revertBlock(c.balances, c.publicKeys, c.nonces, n)
+		change.Disconnected = append(change.Disconnected, n.block)
+	}
+	for _, n := range connect {
+		applyBlock(c.balances, c.publicKeys, c.nonces, n.block)
+		change.Connected = append(change.Connected, n.block)
+	}
+	change.Orphaned = orphanedTransactions(change.Connected, change.Disconnected)
+
+	c.tip = node
+	if len(disconnect) > 0 {
+		c.logger.Warnf("Chain: reorganization to %s at height %d: %d blocks disconnected, %d connected, %d transactions orphaned",
+			node.block.Hash, node.height, len(disconnect), len(connect), len(change.Orphaned))
+	} else {
+		c.logger.Infof("Chain: applied block %s at height %d", node.block.Hash, node.height)
+	}
+
+	return change
+}
+
+// branchPath возвращает блоки, которые нужно откатить (от from до точки ветвления)
+// и применить (от точки ветвления до to), чтобы перейти от одной ветки к другой
+func (c *Chain) branchPath(from, to *blockNode) (disconnect, connect []*blockNode) {
+	for from != to {
+		if from != nil && (to == nil || from.height >= to.height) {
+			disconnect = append(disconnect, from)
+			from = c.parentOf(from.block)
+		} else {
+			connect = append(connect, to)
+			to = c.parentOf(to.block)
+		}
+	}
+
+	for i, j := 0, len(connect)-1; i < j; i, j = i+1, j-1 {
+		connect[i], connect[j] = connect[j], connect[i]
+	}
+	return disconnect, connect
+}
+
+// viewAt возвращает состояние на конце ветки, заканчивающейся node.
+// Вызывается под блокировкой.
+func (c *Chain) viewAt(node *blockNode) *branchView {
+	view := &branchView{
+		chain:      c,
+		tip:        node,
+		balances:   c.balances,
+		publicKeys: c.publicKeys,
+		nonces:     c.nonces,
+	}
+	if node == c.tip {
+		return view
+	}
+
+	view.balances = make(map[blockchain.Username]blockchain.Amount, len(c.balances))
+	for user, balance := range c.balances {
+		view.balances[user] = balance
+	}
+	view.publicKeys = make(map[blockchain.Username]blockchain.PubKey, len(c.publicKeys))
+	for user, pubKey := range c.publicKeys {
+		view.publicKeys[user] = pubKey
+	}
+	view.nonces = make(map[blockchain.Username]blockchain.Nonce, len(c.nonces))
+	for user, nonce := range c.nonces {
+		view.nonces[user] = nonce
+	}
+
+	disconnect, connect := c.branchPath(c.tip, node)
+	for _, n := range disconnect {
+		revertBlock(view.balances, view.publicKeys, view.nonces, n)
+	}
+	for _, n := range connect {
+		applyBlock(view.balances, view.publicKeys, view.nonces, n.block)
+	}
+	return view
+}
+
+// parentOf возвращает узел родителя блока или nil для первого блока
+func (c *Chain) parentOf(block *blockchain.Block) *blockNode {
+	if block.PrevBlockHash == nil {
+		return nil
+	}
+	return c.nodes[*block.PrevBlockHash]
+}
+
+// newNode создает узел дерева для блока поверх parent
+func (c *Chain) newNode(block *blockchain.Block, parent *blockNode) *blockNode {
+	node := &blockNode{
+		block:  block,
+		height: 1,
+		work:   blockchain.BlockWork(*block),
+	}
+	if parent != nil {
+		node.height = parent.height + 1
+		node.work.Add(node.work, parent.work)
+	}
+	return node
+}
+
+// load загружает состояние и дерево блоков с диска
+func (c *Chain) load() error {
+	state, err := blockchain.LoadState(c.statePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return c.loadGenesis()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load chain state: %w", err)
+	}
+
+	c.balances = state.Balances
+	c.publicKeys = state.PublicKeys
+	c.nonces = state.Nonces
+
+	if err := c.loadBlocks(); err != nil {
+		return err
+	}
+
+	if state.Tip != nil {
+		tip, ok := c.nodes[*state.Tip]
+		if !ok {
+			return fmt.Errorf("tip block %s is missing from blocks directory", *state.Tip)
+		}
+		c.tip = tip
+	}
+
+	c.logger.Infof("Chain: loaded state at height %d with %d known blocks", nodeHeight(c.tip), len(c.nodes))
+	return nil
+}
+
+// loadBlocks читает все сохраненные блоки и восстанавливает дерево блоков
+func (c *Chain) loadBlocks() error {
+	entries, err := os.ReadDir(c.blocksDir())
+	if err != nil {
+		return fmt.Errorf("failed to read blocks directory: %w", err)
+	}
+
+	blocks := make(map[blockchain.Hash]*blockchain.Block)
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(c.blocksDir(), entry.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read block %s: %w", entry.Name(), err)
+		}
+		var block blockchain.Block
+		if err := json.Unmarshal(data, &block); err != nil {
+			return fmt.Errorf("failed to parse block %s: %w", entry.Name(), err)
+		}
+		blocks[block.Hash] = &block
+	}
+
+	var addNode func(block *blockchain.Block) *blockNode
+	addNode = func(block *blockchain.Block) *blockNode {
+		if node, ok := c.nodes[block.Hash]; ok {
+			return node
+		}
+		var parent *blockNode
+		if block.PrevBlockHash != nil {
+			parentBlock, ok := blocks[*block.PrevBlockHash]
+			if !ok {
+				c.logger.Warnf("Chain: skipping block %s with unknown parent %s", block.Hash, *block.PrevBlockHash)
+				return nil
+			}
+			if parent = addNode(parentBlock); parent == nil {
+				return nil
+			}
+		}
+		node := c.newNode(block, parent)
+		node.created = c.loadUndo(block.Hash)
+		c.nodes[block.Hash] = node
+		return node
+	}
+
+	for _, block := range blocks {
+		addNode(block)
+	}
+	return nil
+}
+
+// loadUndo читает список балансов, созданных блоком. Для блоков, сохраненных
+// до появления этих файлов, списка нет.
+func (c *Chain) loadUndo(hash blockchain.Hash) []blockchain.Username {
+	data, err := os.ReadFile(c.undoPath(hash))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			c.logger.Warnf("Chain: failed to read undo data of block %s: %v", hash, err)
+		}
+		return nil
+	}
+	var created []blockchain.Username
+	if err := json.Unmarshal(data, &created); err != nil {
+		c.logger.Warnf("Chain: failed to parse undo data of block %s: %v", hash, err)
+		return nil
+	}
+	return created
+}
+
+// loadGenesis инициализирует состояние из actual_state.json, если он есть
+func (c *Chain) loadGenesis() error {
+	genesisPath := filepath.Join(c.dataDir, "actual_state.json")
+	state, err := blockchain.LoadState(genesisPath)
+	if errors.Is(err, os.ErrNotExist) {
+		c.logger.Info("Chain: no saved state and no genesis file, starting with empty state")
+		return c.saveState()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load genesis state: %w", err)
+	}
+
+	c.balances = state.Balances
+	c.publicKeys = state.PublicKeys
+	c.nonces = state.Nonces
+	c.logger.Infof("Chain: initialized state from genesis file %s", genesisPath)
+	return c.saveState()
+}
+
+// saveBlock сохраняет блок в отдельный файл
+func (c *Chain) saveBlock(block *blockchain.Block) error {
+	data, err := json.MarshalIndent(block, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal block: %w", err)
+	}
+	return writeFileAtomic(filepath.Join(c.blocksDir(), fmt.Sprintf("%s.json", block.Hash)), data)
+}
+
+// saveUndo сохраняет балансы, созданные блоком, чтобы откат блока после
+// перезапуска удалил их так же, как до него
+func (c *Chain) saveUndo(node *blockNode) error {
+	data, err := json.Marshal(node.created)
+	if err != nil {
+		return fmt.Errorf("failed to marshal undo data: %w", err)
+	}
+	return writeFileAtomic(c.undoPath(node.block.Hash), data)
+}
+
+// saveState сохраняет состояние основной цепочки
+func (c *Chain) saveState() error {
+	state := blockchain.State{
+		Balances:   c.balances,
+		PublicKeys: c.publicKeys,
+		Nonces:     c.nonces,
+		Tip:        nodeHash(c.tip),
+		Height:     nodeHeight(c.tip),
+	}
+	data, err := json.MarshalIndent(&state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal chain state: %w", err)
+	}
+	return writeFileAtomic(c.statePath(), data)
+}
+
+func (c *Chain) blocksDir() string {
+	return filepath.Join(c.dataDir, "blocks")
+}
+
+func (c *Chain) stateDir() string {
+	return filepath.Join(c.dataDir, "chain")
+}
+
+func (c *Chain) statePath() string {
+	return filepath.Join(c.stateDir(), "state.json")
+}
+
+func (c *Chain) undoDir() string {
+	return filepath.Join(c.stateDir(), "undo")
+}
+
+func (c *Chain) undoPath(hash blockchain.Hash) string {
+	return filepath.Join(c.undoDir(), fmt.Sprintf("%s.json", hash))
+}
+
+// applyBlock применяет изменения балансов блока, регистрирует созданные им аккаунты
+// и запоминает номера его транзакций
+func applyBlock(balances map[blockchain.Username]blockchain.Amount, publicKeys map[blockchain.Username]blockchain.PubKey,
+	nonces map[blockchain.Username]blockchain.Nonce, block *blockchain.Block) {
+	for user, delta := range block.BalancesDelta {
+		balances[user] += delta
+	}
+	for _, tx := range block.Txs {
+		nonces[tx.From] = tx.Nonce
+		if blockchain.CreatesAccount(tx) {
+			publicKeys[tx.To] = tx.PubKey
+		}
+	}
+}
+
+// revertBlock откатывает изменения балансов блока, созданные им балансы и аккаунты
+// и номера его транзакций. После отката состояние совпадает с состоянием узла,
+// который этот блок не видел.
+func revertBlock(balances map[blockchain.Username]blockchain.Amount, publicKeys map[blockchain.Username]blockchain.PubKey,
+	nonces map[blockchain.Username]blockchain.Nonce, node *blockNode) {
+	block := node.block
+	for user, delta := range block.BalancesDelta {
+		balances[user] -= delta
+	}
+	for _, user := range node.created {
+		delete(balances, user)
+	}
+	for i := len(block.Txs) - 1; i >= 0; i-- {
+		tx := block.Txs[i]
+		// До создания аккаунта у получателя не было ни ключа, ни баланса
+		if blockchain.CreatesAccount(tx) {
+			delete(publicKeys, tx.To)
+			delete(balances, tx.To)
+		}
+		if tx.Nonce <= 1 {
+			delete(nonces, tx.From)
+		} else {
+			nonces[tx.From] = tx.Nonce - 1
+		}
+	}
+}
+
+// createdBalances возвращает пользователей, чьи балансы появятся при применении
+// блока к состоянию balances: получателей и майнера, которых еще не было
+func createdBalances(balances map[blockchain.Username]blockchain.Amount, block *blockchain.Block) []blockchain.Username {
+	var created []blockchain.Username
+	for user := range block.BalancesDelta {
+		if _, exists := balances[user]; !exists {
+			created = append(created, user)
+		}
+	}
+	sort.Strings(created)
+	return created
+}
+
+// orphanedTransactions возвращает транзакции вытесненных блоков, которых нет в новой ветке
+func orphanedTransactions(connected, disconnected []*blockchain.Block) []blockchain.Transaction {
+	included := make(map[blockchain.Hash]bool)
+	for _, block := range connected {
+		for _, tx := range block.Txs {
+			if hash, err := blockchain.TransactionHash(tx); err == nil {
+				included[hash] = true
+			}
+		}
+	}
+
+	var orphaned []blockchain.Transaction
+	for _, block := range disconnected {
+		for _, tx := range block.Txs {
+			hash, err := blockchain.TransactionHash(tx)
+			if err != nil || included[hash] {
+				continue
+			}
+			orphaned = append(orphaned, tx)
+		}
+	}
+	return orphaned
+}
+
+func nodeHash(node *blockNode) *blockchain.Hash {
+	if node == nil {
+		return nil
+	}
+	hash := node.block.Hash
+	return &hash
+}
+
+func nodeHeight(node *blockNode) int {
+	if node == nil {
+		return 0
+	}
+	return node.height
+}
+
+// writeFileAtomic записывает файл через временный файл, чтобы не оставить его
+// в частично записанном состоянии при падении узла
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to rename %s: %w", tmpPath, err)
+	}
+	return nil
+}
+
+// branchView представляет состояние на конце произвольной ветки дерева блоков
+type branchView struct {
+	chain      *Chain
+	tip        *blockNode
+	balances   map[blockchain.Username]blockchain.Amount
+	publicKeys map[blockchain.Username]blockchain.PubKey
+	nonces     map[blockchain.Username]blockchain.Nonce
+}
+
+func (v *branchView) FetchUser(username blockchain.Username) (*blockchain.User, error) {
+	balance, ok := v.balances[username]
+	if !ok {
+		return nil, blockchain.ErrUserNotFound
+	}
+	pubKey, ok := v.publicKeys[username]
+	if !ok {
+		return nil, blockchain.ErrUserNotFound
+	}
+	return &blockchain.User{
+		Username: username,
+		PubKey:   pubKey,
+		Balance:  balance,
+		Nonce:    v.nonces[username],
+	}, nil
+}
+
+func (v *branchView) HasAccount(username blockchain.Username) bool {
+	_, hasBalance := v.balances[username]
+	_, hasPubKey := v.publicKeys[username]
+	return hasBalance || hasPubKey
+}
+
+func (v *branchView) FetchBlock(hash blockchain.Hash) (*blockchain.Block, error) {
+	node, ok := v.chain.nodes[hash]
+	if !ok {
+		return nil, blockchain.ErrBlockNotFound
+	}
+	return node.block, nil
+}
+
+func (v *branchView) LastBlockHash() *blockchain.Hash {
+	return nodeHash(v.tip)
+}
@@ -0,0 +1,108 @@
+package chain
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"concoin/conrun/pkg/blockchain"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FetchFunc загружает блок с заданным хешем у пиров
+type FetchFunc func(ctx context.Context, hash blockchain.Hash) (*blockchain.Block, error)
+
+// Fetcher загружает у пиров блоки, которых не хватает сиротам, и добавляет их
+// в цепочку. Запросы выполняются по одному; запрос блока, который уже
+// ожидает загрузки, не повторяется.
+type Fetcher struct {
+	chain    *Chain
+	fetch    FetchFunc
+	requests chan blockchain.Hash
+	pending  map[blockchain.Hash]bool
+	mutex    sync.Mutex
+	logger   *logrus.Logger
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewFetcher создает загрузчик недостающих блоков
+func NewFetcher(chain *Chain, fetch FetchFunc, logger *logrus.Logger) *Fetcher {
+	return &Fetcher{
+		chain:    chain,
+		fetch:    fetch,
+		requests: make(chan blockchain.Hash, maxOrphans),
+		pending:  make(map[blockchain.Hash]bool),
+		logger:   logger,
+	}
+}
+
+// Request ставит блок в очередь загрузки. Не блокируется: при полной
+// очереди запрос отбрасывается, блок будет запрошен со следующей сиротой.
+func (f *Fetcher) Request(hash blockchain.Hash) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.pending[hash] {
+		return
+	}
+	select {
+	case f.requests <- hash:
+		f.pending[hash] = true
+	default:
+		f.logger.Warnf("Chain: fetch queue is full, dropped request for block %s", hash)
+	}
+}
+
+// Start запускает загрузку блоков
+func (f *Fetcher) Start(ctx context.Context) error {
+	ctx, f.cancel = context.WithCancel(ctx)
+	f.wg.Add(1)
+	go f.loop(ctx)
+	return nil
+}
+
+// Stop останавливает загрузку и дожидается текущего запроса
+func (f *Fetcher) Stop() {
+	if f.cancel != nil {
+		f.cancel()
+	}
+	f.wg.Wait()
+}
+
+func (f *Fetcher) loop(ctx context.Context) {
+	defer f.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case hash := <-f.requests:
+			if err := f.fetchBlock(ctx, hash); err != nil {
+				f.logger.Warnf("Chain: failed to fetch block %s: %v", hash, err)
+			}
+			f.mutex.Lock()
+			delete(f.pending, hash)
+			f.mutex.Unlock()
+		}
+	}
+}
+
+// fetchBlock загружает блок и добавляет его в цепочку. Если блок сам
+// окажется сиротой, цепочка запросит его предка.
+func (f *Fetcher) fetchBlock(ctx context.Context, hash blockchain.Hash) error {
+	if _, err := f.chain.FetchBlock(hash); err == nil {
+		return nil
+	}
+
+	block, err := f.fetch(ctx, hash)
+	if err != nil {
+		return err
+	}
+	if block.Hash != hash {
+		return fmt.Errorf("peer returned block %s", block.Hash)
+	}
+
+	f.logger.Infof("Chain: fetched missing block %s", hash)
+	return f.chain.AddBlock(block)
+}
@@ -0,0 +1,586 @@
+package tests
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"concoin/conrun/pkg/blockchain"
+	"concoin/conrun/pkg/chain"
+	"concoin/consensus"
+
+	"github.com/sirupsen/logrus"
+)
+
+// signTx подписывает транзакцию так же, как это делает con-send
+func signTx(t *testing.T, tx blockchain.Transaction, key *ecdsa.PrivateKey) blockchain.Transaction {
+	data, err := json.Marshal(struct {
+		From   string `json:"from"`
+		To     string `json:"to"`
+		Amount int    `json:"amount"`
+		Fee    int    `json:"fee,omitempty"`
+		Nonce  uint64 `json:"nonce"`
+		PubKey string `json:"pubKey,omitempty"`
+	}{tx.From, tx.To, tx.Amount, tx.Fee, tx.Nonce, tx.PubKey})
+	if err != nil {
+		t.Fatalf("Failed to marshal transaction: %v", err)
+	}
+	digest := sha256.Sum256(data)
+	tx.Signature, err = ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	return tx
+}
+
+// mineBlock собирает блок поверх prev и подбирает для него nonce
+func mineBlock(t *testing.T, prev *blockchain.Block, miner string, txs ...blockchain.Transaction) *blockchain.Block {
+	return mineBlockAt(t, prev, blockchain.DifficultyTarget, miner, txs...)
+}
+
+// mineBlockAt собирает блок поверх prev с заданной целью сложности и подбирает для него nonce
+func mineBlockAt(t *testing.T, prev *blockchain.Block, target string, miner string, txs ...blockchain.Transaction) *blockchain.Block {
+	deltas := map[string]blockchain.Amount{miner: blockchain.BlockReward}
+	for _, tx := range txs {
+		deltas[tx.From] -= tx.Amount
+		deltas[tx.To] += tx.Amount
+	}
+	block := blockchain.Block{
+		DifficultyTarget: target,
+		BalancesDelta:    deltas,
+		Txs:              txs,
+		Miner:            miner,
+		Reward:           blockchain.BlockReward,
+		Time:             time.Now().UTC().Unix() - 1000,
+	}
+	if prev != nil {
+		block.PrevBlockHash = &prev.Hash
+		block.Time = prev.Time + 1
+	}
+	targetValue, err := blockchain.ParseTarget(target)
+	if err != nil {
+		t.Fatalf("Failed to parse target: %v", err)
+	}
+	for nonce := 0; ; nonce++ {
+		block.Nonce = strconv.Itoa(nonce)
+		hash, err := blockchain.CalculateBlockHash(block)
+		if err != nil {
+			t.Fatalf("Failed to hash block: %v", err)
+		}
+		if blockchain.MeetsTarget(hash, targetValue) {
+			block.Hash = hash
+			return &block
+		}
+	}
+}
+
+// writeGenesis записывает actual_state.json с балансом и ключом Alice
+func writeGenesis(t *testing.T, dataDir string) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	pubKey := hex.EncodeToString(elliptic.Marshal(elliptic.P256(), key.PublicKey.X, key.PublicKey.Y))
+
+	genesis := map[string]interface{}{
+		"cc-1": map[string]int{"Alice": 50},
+		"cc-3": map[string]string{"Alice": pubKey},
+	}
+	data, err := json.Marshal(genesis)
+	if err != nil {
+		t.Fatalf("Failed to marshal genesis: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dataDir, "actual_state.json"), data, 0644); err != nil {
+		t.Fatalf("Failed to write genesis: %v", err)
+	}
+	return key
+}
+
+func TestChain_AddBlock(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	dataDir := t.TempDir()
+	key := writeGenesis(t, dataDir)
+
+	c, err := chain.NewChain(dataDir, logger)
+	if err != nil {
+		t.Fatalf("Failed to create chain: %v", err)
+	}
+
+	tx := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 20, Nonce: 1}, key)
+	block := mineBlock(t, nil, "Scrooge", tx)
+	if err := c.AddBlock(block); err != nil {
+		t.Fatalf("Failed to add block: %v", err)
+	}
+
+	state := c.Snapshot()
+	if state.Balances["Alice"] != 30 || state.Balances["Bob"] != 20 || state.Balances["Scrooge"] != 1 {
+		t.Errorf("Unexpected balances after block: %v", state.Balances)
+	}
+	if state.Tip == nil || *state.Tip != block.Hash {
+		t.Errorf("Expected tip %s, got %v", block.Hash, state.Tip)
+	}
+	if state.Height != 1 {
+		t.Errorf("Expected height 1, got %d", state.Height)
+	}
+	if state.Nonces["Alice"] != 1 {
+		t.Errorf("Expected Alice nonce 1, got %d", state.Nonces["Alice"])
+	}
+
+	// Блок поверх неизвестного предка ждет его в пуле сирот, а предок запрашивается
+	var requested []blockchain.Hash
+	c.SetMissingBlockHandler(func(hash blockchain.Hash) {
+		requested = append(requested, hash)
+	})
+	unknown := &blockchain.Block{Hash: "0000unknown", Time: block.Time}
+	orphan := mineBlock(t, unknown, "Scrooge", signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 1, Nonce: 2}, key))
+	if err := c.AddBlock(orphan); err != nil {
+		t.Errorf("Block with unknown previous hash should wait for it: %v", err)
+	}
+	if c.Height() != 1 || c.Orphans() != 1 {
+		t.Errorf("Expected height 1 and 1 orphan, got height %d and %d orphans", c.Height(), c.Orphans())
+	}
+	if len(requested) != 1 || requested[0] != unknown.Hash {
+		t.Errorf("Expected unknown block to be requested, got %v", requested)
+	}
+
+	// Состояние переживает перезапуск
+	reloaded, err := chain.NewChain(dataDir, logger)
+	if err != nil {
+		t.Fatalf("Failed to reload chain: %v", err)
+	}
+	if reloaded.Height() != 1 {
+		t.Errorf("Expected reloaded height 1, got %d", reloaded.Height())
+	}
+	user, err := reloaded.FetchUser("Alice")
+	if err != nil || user.Balance != 30 {
+		t.Errorf("Expected Alice balance 30 after reload, got %v (%v)", user, err)
+	}
+	if _, err := reloaded.FetchBlock(block.Hash); err != nil {
+		t.Errorf("Expected block %s after reload: %v", block.Hash, err)
+	}
+}
+
+func TestChain_EmptyGenesis(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	c, err := chain.NewChain(t.TempDir(), logger)
+	if err != nil {
+		t.Fatalf("Failed to create chain: %v", err)
+	}
+
+	if c.Height() != 0 || c.LastBlockHash() != nil {
+		t.Errorf("Expected empty chain, got height %d tip %v", c.Height(), c.LastBlockHash())
+	}
+	if _, err := c.FetchUser("Alice"); err != blockchain.ErrUserNotFound {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestChain_Reorganization(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	dataDir := t.TempDir()
+	key := writeGenesis(t, dataDir)
+
+	c, err := chain.NewChain(dataDir, logger)
+	if err != nil {
+		t.Fatalf("Failed to create chain: %v", err)
+	}
+
+	var changes []chain.TipChange
+	c.AddTipListener(func(change chain.TipChange) {
+		changes = append(changes, change)
+	})
+
+	a1 := mineBlock(t, nil, "MinerA", signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 20, Nonce: 1}, key))
+	b1 := mineBlock(t, nil, "MinerB", signTx(t, blockchain.Transaction{From: "Alice", To: "Carol", Amount: 10, Nonce: 1}, key))
+	b2 := mineBlock(t, b1, "MinerB", signTx(t, blockchain.Transaction{From: "Alice", To: "Dave", Amount: 5, Nonce: 2}, key))
+
+	if err := c.AddBlock(a1); err != nil {
+		t.Fatalf("Failed to add block a1: %v", err)
+	}
+
+	// Конкурирующий блок на той же высоте не меняет основную цепочку
+	if err := c.AddBlock(b1); err != nil {
+		t.Fatalf("Failed to add side block b1: %v", err)
+	}
+	if tip := c.LastBlockHash(); tip == nil || *tip != a1.Hash {
+		t.Fatalf("Expected tip to stay at a1, got %v", tip)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("Expected 1 tip change, got %d", len(changes))
+	}
+
+	// Блок поверх боковой ветки делает ее тяжелее и вызывает реорганизацию
+	if err := c.AddBlock(b2); err != nil {
+		t.Fatalf("Failed to add block b2: %v", err)
+	}
+	if tip := c.LastBlockHash(); tip == nil || *tip != b2.Hash {
+		t.Fatalf("Expected tip b2 after reorganization, got %v", tip)
+	}
+	if c.Height() != 2 {
+		t.Errorf("Expected height 2, got %d", c.Height())
+	}
+
+	state := c.Snapshot()
+	expected := map[string]int{"Alice": 35, "Bob": 0, "Carol": 10, "Dave": 5, "MinerA": 0, "MinerB": 2}
+	for user, balance := range expected {
+		if state.Balances[user] != balance {
+			t.Errorf("Expected %s balance %d, got %d", user, balance, state.Balances[user])
+		}
+	}
+
+	// Nonce откатывается вместе с блоками старой ветки
+	if state.Nonces["Alice"] != 2 {
+		t.Errorf("Expected Alice nonce 2 after reorganization, got %d", state.Nonces["Alice"])
+	}
+
+	if len(changes) != 2 {
+		t.Fatalf("Expected 2 tip changes, got %d", len(changes))
+	}
+	reorg := changes[1]
+	if len(reorg.Disconnected) != 1 || reorg.Disconnected[0].Hash != a1.Hash {
+		t.Errorf("Expected a1 to be disconnected, got %v", reorg.Disconnected)
+	}
+	if len(reorg.Connected) != 2 || reorg.Connected[0].Hash != b1.Hash || reorg.Connected[1].Hash != b2.Hash {
+		t.Errorf("Expected b1, b2 to be connected, got %v", reorg.Connected)
+	}
+	if len(reorg.Orphaned) != 1 || reorg.Orphaned[0].To != "Bob" {
+		t.Errorf("Expected transaction to Bob to be orphaned, got %v", reorg.Orphaned)
+	}
+
+	// Дерево блоков и основная цепочка переживают перезапуск
+	reloaded, err := chain.NewChain(dataDir, logger)
+	if err != nil {
+		t.Fatalf("Failed to reload chain: %v", err)
+	}
+	if tip := reloaded.LastBlockHash(); tip == nil || *tip != b2.Hash {
+		t.Errorf("Expected reloaded tip b2, got %v", tip)
+	}
+	if _, err := reloaded.FetchBlock(a1.Hash); err != nil {
+		t.Errorf("Expected side block a1 after reload: %v", err)
+	}
+
+	// Блок поверх старой ветки проверяется против ее собственного состояния
+	a2 := mineBlock(t, a1, "MinerA", signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 30, Nonce: 2}, key))
+	if err := reloaded.ValidateBlock(a2); err != nil {
+		t.Errorf("Block on side branch should be valid against its branch state: %v", err)
+	}
+}
+
+func TestChain_ReorganizationMatchesFreshState(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	dataDir := t.TempDir()
+	key := writeGenesis(t, dataDir)
+
+	// Losing branch pays an unknown id and rewards its own miner
+	a1 := mineBlock(t, nil, "MinerA", signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 20, Nonce: 1}, key))
+	b1 := mineBlock(t, nil, "MinerB", signTx(t, blockchain.Transaction{From: "Alice", To: "Carol", Amount: 10, Nonce: 1}, key))
+	b2 := mineBlock(t, b1, "MinerB", signTx(t, blockchain.Transaction{From: "Alice", To: "Dave", Amount: 5, Nonce: 2}, key))
+
+	reorged, err := chain.NewChain(dataDir, logger)
+	if err != nil {
+		t.Fatalf("Failed to create chain: %v", err)
+	}
+	for _, block := range []*blockchain.Block{a1, b1, b2} {
+		if err := reorged.AddBlock(block); err != nil {
+			t.Fatalf("Failed to add block %s: %v", block.Hash, err)
+		}
+	}
+
+	genesis, err := os.ReadFile(filepath.Join(dataDir, "actual_state.json"))
+	if err != nil {
+		t.Fatalf("Failed to read genesis: %v", err)
+	}
+	// freshChain applies blocks of one branch to a node that never saw the other one
+	freshChain := func(t *testing.T, blocks ...*blockchain.Block) *chain.Chain {
+		t.Helper()
+		freshDir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(freshDir, "actual_state.json"), genesis, 0644); err != nil {
+			t.Fatalf("Failed to write genesis: %v", err)
+		}
+		fresh, err := chain.NewChain(freshDir, logger)
+		if err != nil {
+			t.Fatalf("Failed to create chain: %v", err)
+		}
+		for _, block := range blocks {
+			if err := fresh.AddBlock(block); err != nil {
+				t.Fatalf("Failed to add block %s to fresh chain: %v", block.Hash, err)
+			}
+		}
+		return fresh
+	}
+
+	assertSameState := func(t *testing.T, got, fresh *chain.Chain) {
+		t.Helper()
+		gotState, wantState := got.Snapshot(), fresh.Snapshot()
+		if !reflect.DeepEqual(gotState, wantState) {
+			t.Errorf("State after reorganization differs from fresh state:\n got %+v\nwant %+v", gotState, wantState)
+		}
+		for _, user := range []string{"Alice", "Bob", "Carol", "Dave", "MinerA", "MinerB"} {
+			if got.HasAccount(user) != fresh.HasAccount(user) {
+				t.Errorf("HasAccount(%s) = %v, fresh chain has %v", user, got.HasAccount(user), fresh.HasAccount(user))
+			}
+		}
+	}
+	assertSameState(t, reorged, freshChain(t, b1, b2))
+	if reorged.HasAccount("Bob") || reorged.HasAccount("MinerA") {
+		t.Errorf("Accounts created only by the reverted block should not exist")
+	}
+
+	// The reverted block is undone the same way after a restart
+	a2 := mineBlock(t, a1, "MinerA", signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 1, Nonce: 2}, key))
+	a3 := mineBlock(t, a2, "MinerA", signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 1, Nonce: 3}, key))
+	reloaded, err := chain.NewChain(dataDir, logger)
+	if err != nil {
+		t.Fatalf("Failed to reload chain: %v", err)
+	}
+	for _, block := range []*blockchain.Block{a2, a3} {
+		if err := reloaded.AddBlock(block); err != nil {
+			t.Fatalf("Failed to add block %s: %v", block.Hash, err)
+		}
+	}
+	if tip := reloaded.LastBlockHash(); tip == nil || *tip != a3.Hash {
+		t.Fatalf("Expected tip a3, got %v", tip)
+	}
+	assertSameState(t, reloaded, freshChain(t, a1, a2, a3))
+	if reloaded.HasAccount("Carol") || reloaded.HasAccount("MinerB") {
+		t.Errorf("Accounts created only by the reverted branch should not exist after reload")
+	}
+}
+
+func TestChain_Orphans(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	dataDir := t.TempDir()
+	key := writeGenesis(t, dataDir)
+
+	c, err := chain.NewChain(dataDir, logger)
+	if err != nil {
+		t.Fatalf("Failed to create chain: %v", err)
+	}
+	var requested []blockchain.Hash
+	c.SetMissingBlockHandler(func(hash blockchain.Hash) {
+		requested = append(requested, hash)
+	})
+
+	b1 := mineBlock(t, nil, "Scrooge", signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 1, Nonce: 1}, key))
+	b2 := mineBlock(t, b1, "Scrooge", signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 1, Nonce: 2}, key))
+	b3 := mineBlock(t, b2, "Scrooge", signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 1, Nonce: 3}, key))
+
+	// Blocks arriving before their ancestors pass validation on work alone
+	if err := c.ValidateBlock(b3); err != nil {
+		t.Errorf("Orphan block should pass validation: %v", err)
+	}
+	forged := *b3
+	forged.Nonce = "forged"
+	if err := c.ValidateBlock(&forged); err == nil {
+		t.Errorf("Orphan block with a wrong hash should be rejected")
+	}
+
+	for _, block := range []*blockchain.Block{b3, b2} {
+		if err := c.AddBlock(block); err != nil {
+			t.Fatalf("Failed to add orphan %s: %v", block.Hash, err)
+		}
+	}
+	if c.Height() != 0 || c.Orphans() != 2 {
+		t.Fatalf("Expected empty chain with 2 orphans, got height %d and %d orphans", c.Height(), c.Orphans())
+	}
+	// The oldest missing ancestor is requested
+	if len(requested) != 2 || requested[0] != b2.Hash || requested[1] != b1.Hash {
+		t.Errorf("Expected requests for b2 then b1, got %v", requested)
+	}
+
+	// The missing block connects the waiting orphans in one tip change
+	var changes []chain.TipChange
+	c.AddTipListener(func(change chain.TipChange) {
+		changes = append(changes, change)
+	})
+	if err := c.AddBlock(b1); err != nil {
+		t.Fatalf("Failed to add block b1: %v", err)
+	}
+	if tip := c.LastBlockHash(); tip == nil || *tip != b3.Hash || c.Height() != 3 {
+		t.Fatalf("Expected tip b3 at height 3, got %v at %d", tip, c.Height())
+	}
+	if c.Orphans() != 0 {
+		t.Errorf("Expected orphan pool to be empty, got %d", c.Orphans())
+	}
+	if len(changes) != 1 || len(changes[0].Connected) != 3 {
+		t.Errorf("Expected one tip change connecting 3 blocks, got %v", changes)
+	}
+}
+
+func TestFetcher(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	sourceDir := t.TempDir()
+	key := writeGenesis(t, sourceDir)
+	genesis, err := os.ReadFile(filepath.Join(sourceDir, "actual_state.json"))
+	if err != nil {
+		t.Fatalf("Failed to read genesis: %v", err)
+	}
+	syncingDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(syncingDir, "actual_state.json"), genesis, 0644); err != nil {
+		t.Fatalf("Failed to write genesis: %v", err)
+	}
+
+	source, err := chain.NewChain(sourceDir, logger)
+	if err != nil {
+		t.Fatalf("Failed to create chain: %v", err)
+	}
+	var prev *blockchain.Block
+	for nonce := 1; nonce <= 3; nonce++ {
+		prev = mineBlock(t, prev, "Scrooge", signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 1, Nonce: blockchain.Nonce(nonce)}, key))
+		if err := source.AddBlock(prev); err != nil {
+			t.Fatalf("Failed to add block: %v", err)
+		}
+	}
+
+	syncing, err := chain.NewChain(syncingDir, logger)
+	if err != nil {
+		t.Fatalf("Failed to create chain: %v", err)
+	}
+	fetcher := chain.NewFetcher(syncing, func(ctx context.Context, hash blockchain.Hash) (*blockchain.Block, error) {
+		return source.FetchBlock(hash)
+	}, logger)
+	syncing.SetMissingBlockHandler(fetcher.Request)
+	if err := fetcher.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start fetcher: %v", err)
+	}
+	defer fetcher.Stop()
+
+	// Only the last block arrives, its ancestors are fetched one by one
+	if err := syncing.AddBlock(prev); err != nil {
+		t.Fatalf("Failed to add orphan: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for syncing.Height() != 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for missing blocks, height %d", syncing.Height())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if tip := syncing.LastBlockHash(); tip == nil || *tip != prev.Hash {
+		t.Errorf("Expected tip %s, got %v", prev.Hash, tip)
+	}
+}
+
+func TestChain_AccountCreation(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	dataDir := t.TempDir()
+	key := writeGenesis(t, dataDir)
+
+	c, err := chain.NewChain(dataDir, logger)
+	if err != nil {
+		t.Fatalf("Failed to create chain: %v", err)
+	}
+
+	bobKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	bobPubKey := hex.EncodeToString(elliptic.Marshal(elliptic.P256(), bobKey.PublicKey.X, bobKey.PublicKey.Y))
+
+	a1 := mineBlock(t, nil, "MinerA", signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 20, Nonce: 1, PubKey: bobPubKey}, key))
+	if err := c.AddBlock(a1); err != nil {
+		t.Fatalf("Failed to add block a1: %v", err)
+	}
+	bob, err := c.FetchUser("Bob")
+	if err != nil || bob.PubKey != bobPubKey || bob.Balance != 20 {
+		t.Fatalf("Expected Bob account with registered key, got %+v, %v", bob, err)
+	}
+
+	// Созданный аккаунт может тратить монеты
+	a2 := mineBlock(t, a1, "MinerA", signTx(t, blockchain.Transaction{From: "Bob", To: "Carol", Amount: 5, Nonce: 1}, bobKey))
+	if err := c.AddBlock(a2); err != nil {
+		t.Fatalf("Failed to add block a2: %v", err)
+	}
+
+	// Реорганизация на ветку без создания аккаунта удаляет его ключ и баланс
+	b1 := mineBlock(t, nil, "MinerB", signTx(t, blockchain.Transaction{From: "Alice", To: "Dave", Amount: 1, Nonce: 1}, key))
+	b2 := mineBlock(t, b1, "MinerB", signTx(t, blockchain.Transaction{From: "Alice", To: "Dave", Amount: 1, Nonce: 2}, key))
+	b3 := mineBlock(t, b2, "MinerB", signTx(t, blockchain.Transaction{From: "Alice", To: "Dave", Amount: 1, Nonce: 3}, key))
+	for _, block := range []*blockchain.Block{b1, b2, b3} {
+		if err := c.AddBlock(block); err != nil {
+			t.Fatalf("Failed to add block %s: %v", block.Hash, err)
+		}
+	}
+	if tip := c.LastBlockHash(); tip == nil || *tip != b3.Hash {
+		t.Fatalf("Expected tip b3 after reorganization, got %v", tip)
+	}
+	if c.HasAccount("Bob") {
+		t.Errorf("Expected Bob account to be reverted")
+	}
+	if _, ok := c.Snapshot().PublicKeys["Bob"]; ok {
+		t.Errorf("Expected Bob public key to be removed")
+	}
+}
+
+func TestChain_DifficultyRetarget(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	dataDir := t.TempDir()
+	key := writeGenesis(t, dataDir)
+
+	c, err := chain.NewChain(dataDir, logger)
+	if err != nil {
+		t.Fatalf("Failed to create chain: %v", err)
+	}
+
+	// Первое окно идет с начальной целью и блоками на секунду друг от друга
+	var prev *blockchain.Block
+	for nonce := 1; nonce <= consensus.RetargetInterval; nonce++ {
+		prev = mineBlock(t, prev, "Scrooge", signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 1, Nonce: blockchain.Nonce(nonce)}, key))
+		if err := c.AddBlock(prev); err != nil {
+			t.Fatalf("Failed to add block: %v", err)
+		}
+	}
+
+	// Окно найдено в 10 раз быстрее желаемого: цель уменьшается
+	// не более чем в 4 раза за пересчет
+	target, err := c.NextTarget()
+	if err != nil {
+		t.Fatalf("Failed to compute next target: %v", err)
+	}
+	targetValue, err := blockchain.ParseTarget(target)
+	if err != nil {
+		t.Fatalf("Failed to parse next target %q: %v", target, err)
+	}
+	window := int64(consensus.RetargetInterval-1) * int64(consensus.BlockTime/time.Second)
+	expected := new(big.Int).Div(new(big.Int).Mul(blockchain.MaxTarget, big.NewInt(window/4)), big.NewInt(window))
+	if targetValue.Cmp(expected) != 0 {
+		t.Errorf("Expected next target %s, got %s", blockchain.FormatTarget(expected), target)
+	}
+
+	tx := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 1, Nonce: consensus.RetargetInterval + 1}, key)
+	stale := mineBlock(t, prev, "Scrooge", tx)
+	if err := c.AddBlock(stale); err == nil {
+		t.Errorf("Block with outdated difficulty target should be rejected")
+	}
+
+	retargeted := mineBlockAt(t, prev, target, "Scrooge", tx)
+	if err := c.AddBlock(retargeted); err != nil {
+		t.Fatalf("Block with retargeted difficulty should be accepted: %v", err)
+	}
+
+	// Внутри окна цель не меняется
+	next, err := c.NextTarget()
+	if err != nil || next != target {
+		t.Errorf("Expected target %s inside retarget window, got %s (%v)", target, next, err)
+	}
+}
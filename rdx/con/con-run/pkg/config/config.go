@@ -3,63 +3,149 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"time"
 )
 
 // Config содержит все настройки узла
 type Config struct {
-	NodeID        string         `json:"node_id"`
-	Port          int            `json:"port"`
-	DataDir       string         `json:"data_dir"`
-	SeedNodes     []string       `json:"seed_nodes,omitempty"`
-	GossipConfig  GossipConfig   `json:"gossip"`
-	PexConfig     PexConfig      `json:"pex"`
+	NodeID           string           `json:"node_id"`
+	Port             int              `json:"port"`
+	ListenAddr       string           `json:"listen_addr,omitempty"`    // интерфейс для входящих соединений, пусто - все
+	AdvertiseAddr    string           `json:"advertise_addr,omitempty"` // адрес, который узел сообщает пирам, пусто - определить по пирам
+	DataDir          string           `json:"data_dir"`
+	StorageBackend   string           `json:"storage_backend"` // json, brix или kv
+	LogLevel         string           `json:"log_level"`       // error, warn, info, debug и т.д.
+	SeedNodes        []string         `json:"seed_nodes,omitempty"`
+	GossipConfig     GossipConfig     `json:"gossip"`
+	PexConfig        PexConfig        `json:"pex"`
 	BlockchainConfig BlockchainConfig `json:"blockchain"`
+	MempoolConfig    MempoolConfig    `json:"mempool"`
+	MinerConfig      MinerConfig      `json:"miner"`
+	TransportConfig  TransportConfig  `json:"transport"`
+	ReputationConfig ReputationConfig `json:"reputation"`
+	LimitsConfig     LimitsConfig     `json:"limits"`
+	RetentionConfig  RetentionConfig  `json:"retention"`
+	HooksConfig      HooksConfig      `json:"hooks"`
 }
 
 // GossipConfig содержит настройки для Gossip протокола
 type GossipConfig struct {
-	ProtocolType     string        `json:"protocol_type"`
-	BranchingFactor  int           `json:"branching_factor"`
-	MessageTTL       int           `json:"message_ttl"`
-	SyncInterval     time.Duration `json:"sync_interval"`
-	HistorySize      int           `json:"history_size"`
-	MessageMaxAge    time.Duration `json:"message_max_age"`
+	ProtocolType    string        `json:"protocol_type"`
+	BranchingFactor int           `json:"branching_factor"`
+	MessageTTL      int           `json:"message_ttl"`
+	SyncInterval    time.Duration `json:"sync_interval"`
+	HistorySize     int           `json:"history_size"`
+	MessageMaxAge   time.Duration `json:"message_max_age"`
+	SyncBatchSize   int           `json:"sync_batch_size"` // сообщений за раунд anti-entropy
 }
 
 // PexConfig содержит настройки для PEX протокола
 type PexConfig struct {
-	ExchangeInterval   time.Duration `json:"exchange_interval"`
-	MaxPeersPerExchange int          `json:"max_peers_per_exchange"`
-	PeerTTL            time.Duration `json:"peer_ttl"`
-	MaxPeers           int           `json:"max_peers"`
-	NewPeerShare       int           `json:"new_peer_share"`
-	LowConnectivityThreshold int     `json:"low_connectivity_threshold"`
+	ExchangeInterval         time.Duration `json:"exchange_interval"`
+	MaxPeersPerExchange      int           `json:"max_peers_per_exchange"`
+	PeerTTL                  time.Duration `json:"peer_ttl"`
+	MaxPeers                 int           `json:"max_peers"`      // проверенных пиров в таблице адресов
+	NewPeerShare             int           `json:"new_peer_share"` // процент корзин новых адресов на один источник
+	LowConnectivityThreshold int           `json:"low_connectivity_threshold"`
 }
 
-// BlockchainConfig содержит настройки для блокчейна
+// BlockchainConfig содержит настройки для блокчейна.
+// Параметры пересчета сложности - правила консенсуса, они задаются
+// константами пакета concoin/consensus, общими с con-valid.
 type BlockchainConfig struct {
-	BlockTime       time.Duration `json:"block_time"`
-	MaxTransactions int           `json:"max_transactions"`
+	MaxTransactions int `json:"max_transactions"`
+}
+
+// MempoolConfig содержит настройки мемпула
+type MempoolConfig struct {
+	MaxSize         int           `json:"max_size"`
+	TxTTL           time.Duration `json:"tx_ttl"`
+	CleanupInterval time.Duration `json:"cleanup_interval"`
+}
+
+// MinerConfig содержит настройки встроенного майнера
+type MinerConfig struct {
+	Enabled bool   `json:"enabled"`
+	MinerID string `json:"miner_id"`
+	Threads int    `json:"threads"`
+}
+
+// TransportConfig содержит настройки транспорта между узлами
+type TransportConfig struct {
+	Type        string        `json:"type"`         // http или tcp
+	SendTimeout time.Duration `json:"send_timeout"` // дедлайн отправки запроса и получения ответа
+	DialTimeout time.Duration `json:"dial_timeout"`
+	QueueSize   int           `json:"queue_size"`  // запросов в очереди на одно соединение
+	MaxBackoff  time.Duration `json:"max_backoff"` // наибольшая пауза перед переподключением
+}
+
+// ReputationConfig содержит настройки оценки пиров
+type ReputationConfig struct {
+	ValidReward        float64       `json:"valid_reward"`        // за новое валидное сообщение
+	RejectedPenalty    float64       `json:"rejected_penalty"`    // за сообщение, отклоненное хуками
+	InvalidPenalty     float64       `json:"invalid_penalty"`     // за сообщение с неверной подписью или хешем
+	UnreachablePenalty float64       `json:"unreachable_penalty"` // за анонс недоступного адреса
+	LimitPenalty       float64       `json:"limit_penalty"`       // за превышение лимитов запросов
+	BanThreshold       float64       `json:"ban_threshold"`       // оценка поведения, при которой пир банится
+	BanDuration        time.Duration `json:"ban_duration"`
+}
+
+// LimitsConfig содержит ограничения входящих запросов. Нулевое значение
+// снимает ограничение.
+type LimitsConfig struct {
+	PeerRate            float64        `json:"peer_rate"` // запросов в секунду от одного пира
+	PeerBurst           int            `json:"peer_burst"`
+	GlobalRate          float64        `json:"global_rate"` // запросов в секунду от всех пиров вместе
+	GlobalBurst         int            `json:"global_burst"`
+	MaxBodySize         int64          `json:"max_body_size"`    // байт в теле запроса
+	MaxPayloadSize      int            `json:"max_payload_size"` // байт в payload сообщения типа без своего лимита
+	PayloadSizes        map[string]int `json:"payload_sizes"`    // лимиты payload по типам сообщений
+	MaxConcurrent       int            `json:"max_concurrent"`   // одновременно обрабатываемых запросов
+	MaxConnections      int            `json:"max_connections"`  // открытых входящих соединений
+	MaxConnectionsPerIP int            `json:"max_connections_per_ip"`
+}
+
+// RetentionConfig содержит правила хранения сообщений (см. ParseRetentionRule)
+type RetentionConfig struct {
+	Interval time.Duration     `json:"interval"` // период очистки хранилища, 0 - не очищать
+	Default  string            `json:"default"`  // правило для сообщений без своего правила
+	Policies map[string]string `json:"policies"` // правила по типу или по тип/вид сообщения
+}
+
+// HooksConfig содержит порядок хуков и режим их работы. Хуки задаются
+// именами типов, например BlockchainHook.
+type HooksConfig struct {
+	Policy          string            `json:"policy"`           // all - сообщение должны принять все хуки, any - хотя бы один
+	Policies        map[string]string `json:"policies"`         // all или any по типам сообщений
+	Priorities      map[string]int    `json:"priorities"`       // приоритеты хуков, хук с большим приоритетом вызывается раньше
+	Workers         map[string]int    `json:"workers"`          // число фоновых обработчиков хука, 0 - обработка в вызывающей горутине
+	QueueSize       int               `json:"queue_size"`       // очередь сообщений хука с фоновыми обработчиками
+	External        map[string]string `json:"external"`         // внешние хуки: имя -> http:// адрес или команда запуска процесса
+	ExternalTimeout time.Duration     `json:"external_timeout"` // наибольшее время ответа внешнего хука
 }
 
 // DefaultConfig возвращает конфигурацию по умолчанию
 func DefaultConfig(port int, seedPort int) *Config {
 	nodeID := fmt.Sprintf("node-%d", port)
 	dataDir := filepath.Join(".nodedata", fmt.Sprintf("port%d", port))
-	
+
 	seedNodes := []string{}
 	if seedPort > 0 && seedPort != port {
 		seedNodes = append(seedNodes, fmt.Sprintf("127.0.0.1:%d", seedPort))
 	}
-	
+
 	return &Config{
-		NodeID:    nodeID,
-		Port:      port,
-		DataDir:   dataDir,
-		SeedNodes: seedNodes,
+		NodeID:         nodeID,
+		Port:           port,
+		DataDir:        dataDir,
+		SeedNodes:      seedNodes,
+		StorageBackend: "json",
+		LogLevel:       "info",
 		GossipConfig: GossipConfig{
 			ProtocolType:    "push",
 			BranchingFactor: 4,
@@ -67,6 +153,7 @@ func DefaultConfig(port int, seedPort int) *Config {
 			SyncInterval:    5 * time.Second,
 			HistorySize:     10000,
 			MessageMaxAge:   30 * time.Minute,
+			SyncBatchSize:   100,
 		},
 		PexConfig: PexConfig{
 			ExchangeInterval:         15 * time.Second,
@@ -77,24 +164,97 @@ func DefaultConfig(port int, seedPort int) *Config {
 			LowConnectivityThreshold: 10,
 		},
 		BlockchainConfig: BlockchainConfig{
-			BlockTime:       10 * time.Second,
 			MaxTransactions: 100,
 		},
+		MempoolConfig: MempoolConfig{
+			MaxSize:         5000,
+			TxTTL:           1 * time.Hour,
+			CleanupInterval: 1 * time.Minute,
+		},
+		MinerConfig: MinerConfig{
+			Enabled: false,
+			Threads: runtime.NumCPU(),
+		},
+		TransportConfig: TransportConfig{
+			Type:        "http",
+			SendTimeout: 5 * time.Second,
+			DialTimeout: 3 * time.Second,
+			QueueSize:   64,
+			MaxBackoff:  30 * time.Second,
+		},
+		ReputationConfig: ReputationConfig{
+			ValidReward:        1,
+			RejectedPenalty:    2,
+			InvalidPenalty:     25,
+			UnreachablePenalty: 5,
+			LimitPenalty:       1,
+			BanThreshold:       -100,
+			BanDuration:        24 * time.Hour,
+		},
+		LimitsConfig: LimitsConfig{
+			PeerRate:       50,
+			PeerBurst:      100,
+			GlobalRate:     500,
+			GlobalBurst:    1000,
+			MaxBodySize:    8 << 20,
+			MaxPayloadSize: 64 << 10,
+			PayloadSizes: map[string]int{
+				"blockchain_concoin": 1 << 20,
+				"user_message":       16 << 10,
+			},
+			MaxConcurrent:       256,
+			MaxConnections:      512,
+			MaxConnectionsPerIP: 32,
+		},
+		RetentionConfig: RetentionConfig{
+			Interval: 10 * time.Minute,
+			Default:  "keep",
+			Policies: map[string]string{
+				"blockchain_concoin/block":       "keep",
+				"blockchain_concoin/transaction": "settled",
+			},
+		},
+		HooksConfig: HooksConfig{
+			Policy:          "all",
+			Policies:        map[string]string{},
+			Priorities:      map[string]int{},
+			Workers:         map[string]int{},
+			QueueSize:       1000,
+			External:        map[string]string{},
+			ExternalTimeout: 5 * time.Second,
+		},
 	}
 }
 
-// LoadConfig загружает конфигурацию из файла
-func LoadConfig(configPath string) (*Config, error) {
-	data, err := os.ReadFile(configPath)
+// ParseSeed приводит адрес seed-узла к виду host:port. Один номер порта
+// означает узел на этой же машине.
+func ParseSeed(seed string) (string, error) {
+	if port, err := strconv.Atoi(seed); err == nil {
+		seed = net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	}
+
+	host, port, err := net.SplitHostPort(seed)
 	if err != nil {
-		return nil, fmt.Errorf("error reading config file: %w", err)
+		return "", fmt.Errorf("bad seed address %q: %w", seed, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 || host == "" {
+		return "", fmt.Errorf("bad seed address %q: expected host:port", seed)
 	}
-	
+	return seed, nil
+}
+
+// ListenAddress возвращает адрес, на котором узел принимает соединения
+func (c *Config) ListenAddress() string {
+	return net.JoinHostPort(c.ListenAddr, strconv.Itoa(c.Port))
+}
+
+// LoadConfig загружает конфигурацию из файла. Настройки, которых нет
+// в файле, остаются нулевыми; значения по умолчанию дополняет Load.
+func LoadConfig(configPath string) (*Config, error) {
 	var config Config
-	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("error parsing config file: %w", err)
+	if err := config.ApplyFile(configPath); err != nil {
+		return nil, err
 	}
-	
 	return &config, nil
 }
 
@@ -105,17 +265,17 @@ func (c *Config) SaveConfig() error {
 	if err := os.MkdirAll(configDir, 0755); err != nil {
 		return fmt.Errorf("error creating config directory: %w", err)
 	}
-	
+
 	configPath := filepath.Join(configDir, "config.json")
 	data, err := json.MarshalIndent(c, "", "  ")
 	if err != nil {
 		return fmt.Errorf("error serializing config: %w", err)
 	}
-	
+
 	if err := os.WriteFile(configPath, data, 0644); err != nil {
 		return fmt.Errorf("error writing config file: %w", err)
 	}
-	
+
 	return nil
 }
 
@@ -124,16 +284,17 @@ func (c *Config) CreateDataDirs() error {
 	dirs := []string{
 		filepath.Join(c.DataDir, "blocks"),
 		filepath.Join(c.DataDir, "transactions"),
+		filepath.Join(c.DataDir, "mempool"),
 		filepath.Join(c.DataDir, "peers"),
 		filepath.Join(c.DataDir, "config"),
 	}
-	
+
 	for _, dir := range dirs {
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			return fmt.Errorf("failed to create directory %s: %w", dir, err)
 		}
 	}
-	
+
 	return nil
 }
 
@@ -143,4 +304,4 @@ func (c *Config) CleanDataDirs() error {
 		return fmt.Errorf("failed to clean data directory: %w", err)
 	}
 	return nil
-}
\ No newline at end of file
+}
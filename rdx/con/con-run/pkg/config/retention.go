@@ -0,0 +1,58 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RetentionRule разобранное правило хранения сообщений
+type RetentionRule struct {
+	Keep    bool          // хранить всегда
+	MaxAge  time.Duration // удалять сообщения старше, 0 - не удалять по возрасту
+	Settled bool          // удалять сообщения, которые больше не нужны узлу
+}
+
+// ParseRetentionRule разбирает правило хранения. Правило keep хранит
+// сообщения всегда, иначе это условия удаления через |: длительность
+// (сообщение старше нее) и settled (сообщение больше не нужно узлу,
+// например транзакция попала в блок или истекла). Например: settled|72h.
+func ParseRetentionRule(rule string) (RetentionRule, error) {
+	rule = strings.TrimSpace(rule)
+	if rule == "keep" {
+		return RetentionRule{Keep: true}, nil
+	}
+	if rule == "" {
+		return RetentionRule{}, fmt.Errorf("empty retention rule, expected keep, settled or a duration")
+	}
+
+	var parsed RetentionRule
+	for _, condition := range strings.Split(rule, "|") {
+		condition = strings.TrimSpace(condition)
+		if condition == "settled" {
+			parsed.Settled = true
+			continue
+		}
+		age, err := time.ParseDuration(condition)
+		if err != nil || age <= 0 {
+			return RetentionRule{}, fmt.Errorf("bad retention condition %q in %q, expected settled or a positive duration", condition, rule)
+		}
+		parsed.MaxAge = age
+	}
+	return parsed, nil
+}
+
+// String записывает правило в том виде, в каком его принимает ParseRetentionRule
+func (r RetentionRule) String() string {
+	if r.Keep {
+		return "keep"
+	}
+	var conditions []string
+	if r.Settled {
+		conditions = append(conditions, "settled")
+	}
+	if r.MaxAge > 0 {
+		conditions = append(conditions, r.MaxAge.String())
+	}
+	return strings.Join(conditions, "|")
+}
@@ -0,0 +1,468 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EnvPrefix префикс переменных окружения с настройками узла
+const EnvPrefix = "CONRUN_"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Field описывает одну настройку конфигурации
+type Field struct {
+	Key   string // путь из JSON имен, например gossip.branching_factor
+	Flag  string // имя флага командной строки, например gossip.branching-factor
+	Env   string // имя переменной окружения, например CONRUN_GOSSIP_BRANCHING_FACTOR
+	Value string // текущее значение в том виде, в каком его принимает Set
+}
+
+// Fields возвращает все настройки конфигурации с их значениями в c
+func Fields(c *Config) []Field {
+	var fields []Field
+	walk(reflect.ValueOf(c).Elem(), "", func(key string, value reflect.Value) {
+		fields = append(fields, Field{
+			Key:   key,
+			Flag:  strings.ReplaceAll(key, "_", "-"),
+			Env:   EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_")),
+			Value: formatValue(value),
+		})
+	})
+	return fields
+}
+
+// Set задает настройку key из строки. Длительности записываются как 5s
+// или 1h30m, списки через запятую, словари как ключ=значение через запятую.
+func (c *Config) Set(key, value string) error {
+	field, ok := lookup(reflect.ValueOf(c).Elem(), key)
+	if !ok {
+		return fmt.Errorf("unknown setting %q", key)
+	}
+	if err := setValue(field, value); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	return nil
+}
+
+// ApplyFile заменяет настройки значениями из JSON файла. Длительности
+// в файле задаются строкой (5s) или числом наносекунд, как их сохраняет
+// SaveConfig. Неизвестные настройки считаются ошибкой.
+func (c *Config) ApplyFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	if err := applyJSON(reflect.ValueOf(c).Elem(), "", raw); err != nil {
+		return fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// ApplyEnv заменяет настройки значениями переменных окружения с префиксом
+// EnvPrefix. environ задается в формате os.Environ.
+func (c *Config) ApplyEnv(environ []string) error {
+	fields := make(map[string]string)
+	for _, field := range Fields(c) {
+		fields[field.Env] = field.Key
+	}
+
+	for _, entry := range environ {
+		name, value, _ := strings.Cut(entry, "=")
+		if !strings.HasPrefix(name, EnvPrefix) {
+			continue
+		}
+		key, ok := fields[name]
+		if !ok {
+			return fmt.Errorf("unknown environment variable %s", name)
+		}
+		if err := c.Set(key, value); err != nil {
+			return fmt.Errorf("environment variable %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Load собирает конфигурацию узла. Источники идут по возрастанию приоритета:
+// значения по умолчанию, файл path (если задан), переменные окружения
+// и overrides (настройка -> значение, например из флагов). Директория данных
+// и ID узла по умолчанию выводятся из итогового порта.
+func Load(path string, environ []string, overrides map[string]string) (*Config, error) {
+	c := DefaultConfig(3000, 0)
+	c.NodeID = ""
+	c.DataDir = ""
+
+	if path != "" {
+		if err := c.ApplyFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.ApplyEnv(environ); err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(overrides))
+	for key := range overrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := c.Set(key, overrides[key]); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.NodeID == "" {
+		c.NodeID = fmt.Sprintf("node-%d", c.Port)
+	}
+	if c.DataDir == "" {
+		c.DataDir = filepath.Join(".nodedata", fmt.Sprintf("port%d", c.Port))
+	}
+	for i, seed := range c.SeedNodes {
+		seedAddr, err := ParseSeed(seed)
+		if err != nil {
+			return nil, fmt.Errorf("seed_nodes: %w", err)
+		}
+		c.SeedNodes[i] = seedAddr
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Changed возвращает настройки, значения которых в next отличаются от c
+func (c *Config) Changed(next *Config) []string {
+	current := make(map[string]string)
+	for _, field := range Fields(c) {
+		current[field.Key] = field.Value
+	}
+
+	var changed []string
+	for _, field := range Fields(next) {
+		if current[field.Key] != field.Value {
+			changed = append(changed, field.Key)
+		}
+	}
+	return changed
+}
+
+// Validate проверяет настройки и возвращает все найденные ошибки
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, key string, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, key+" "+fmt.Sprintf(format, args...))
+		}
+	}
+	positive := func(key string, value float64) {
+		check(value > 0, key, "must be positive, got %v", value)
+	}
+	duration := func(key string, value time.Duration) {
+		check(value > 0, key, "must be positive, got %v", value)
+	}
+	notNegative := func(key string, value float64) {
+		check(value >= 0, key, "must not be negative, got %v", value)
+	}
+	oneOf := func(key, value string, allowed ...string) {
+		for _, a := range allowed {
+			if value == a {
+				return
+			}
+		}
+		check(false, key, "must be one of %s, got %q", strings.Join(allowed, ", "), value)
+	}
+
+	check(c.Port > 0 && c.Port <= 65535, "port", "must be between 1 and 65535, got %d", c.Port)
+	check(c.DataDir != "", "data_dir", "must not be empty")
+	oneOf("storage_backend", c.StorageBackend, "json", "brix", "kv")
+	_, err := logrus.ParseLevel(c.LogLevel)
+	check(err == nil, "log_level", "must be one of panic, fatal, error, warn, info, debug, trace, got %q", c.LogLevel)
+	for _, seed := range c.SeedNodes {
+		_, err := ParseSeed(seed)
+		check(err == nil, "seed_nodes", "%v", err)
+	}
+
+	gossip := c.GossipConfig
+	oneOf("gossip.protocol_type", gossip.ProtocolType, "push", "push-pull")
+	positive("gossip.branching_factor", float64(gossip.BranchingFactor))
+	positive("gossip.message_ttl", float64(gossip.MessageTTL))
+	duration("gossip.sync_interval", gossip.SyncInterval)
+	positive("gossip.history_size", float64(gossip.HistorySize))
+	duration("gossip.message_max_age", gossip.MessageMaxAge)
+	positive("gossip.sync_batch_size", float64(gossip.SyncBatchSize))
+
+	pex := c.PexConfig
+	duration("pex.exchange_interval", pex.ExchangeInterval)
+	positive("pex.max_peers_per_exchange", float64(pex.MaxPeersPerExchange))
+	duration("pex.peer_ttl", pex.PeerTTL)
+	positive("pex.max_peers", float64(pex.MaxPeers))
+	check(pex.NewPeerShare > 0 && pex.NewPeerShare <= 100, "pex.new_peer_share", "must be between 1 and 100, got %d", pex.NewPeerShare)
+	notNegative("pex.low_connectivity_threshold", float64(pex.LowConnectivityThreshold))
+
+	positive("blockchain.max_transactions", float64(c.BlockchainConfig.MaxTransactions))
+
+	mempool := c.MempoolConfig
+	positive("mempool.max_size", float64(mempool.MaxSize))
+	duration("mempool.tx_ttl", mempool.TxTTL)
+	duration("mempool.cleanup_interval", mempool.CleanupInterval)
+
+	check(!c.MinerConfig.Enabled || c.MinerConfig.MinerID != "", "miner.miner_id", "must be set when mining is enabled")
+	notNegative("miner.threads", float64(c.MinerConfig.Threads))
+
+	// Нулевые значения транспорта заменяются значениями по умолчанию
+	transport := c.TransportConfig
+	oneOf("transport.type", transport.Type, "http", "tcp")
+	notNegative("transport.send_timeout", float64(transport.SendTimeout))
+	notNegative("transport.dial_timeout", float64(transport.DialTimeout))
+	notNegative("transport.queue_size", float64(transport.QueueSize))
+	notNegative("transport.max_backoff", float64(transport.MaxBackoff))
+
+	reputation := c.ReputationConfig
+	notNegative("reputation.valid_reward", reputation.ValidReward)
+	notNegative("reputation.rejected_penalty", reputation.RejectedPenalty)
+	notNegative("reputation.invalid_penalty", reputation.InvalidPenalty)
+	notNegative("reputation.unreachable_penalty", reputation.UnreachablePenalty)
+	notNegative("reputation.limit_penalty", reputation.LimitPenalty)
+	check(reputation.BanThreshold < 0, "reputation.ban_threshold", "must be negative, got %v", reputation.BanThreshold)
+	duration("reputation.ban_duration", reputation.BanDuration)
+
+	limits := c.LimitsConfig
+	notNegative("limits.peer_rate", limits.PeerRate)
+	check(limits.PeerRate == 0 || limits.PeerBurst > 0, "limits.peer_burst", "must be positive when limits.peer_rate is set, got %d", limits.PeerBurst)
+	notNegative("limits.global_rate", limits.GlobalRate)
+	check(limits.GlobalRate == 0 || limits.GlobalBurst > 0, "limits.global_burst", "must be positive when limits.global_rate is set, got %d", limits.GlobalBurst)
+	notNegative("limits.max_body_size", float64(limits.MaxBodySize))
+	notNegative("limits.max_payload_size", float64(limits.MaxPayloadSize))
+	for messageType, size := range limits.PayloadSizes {
+		notNegative("limits.payload_sizes."+messageType, float64(size))
+	}
+	notNegative("limits.max_concurrent", float64(limits.MaxConcurrent))
+	notNegative("limits.max_connections", float64(limits.MaxConnections))
+	notNegative("limits.max_connections_per_ip", float64(limits.MaxConnectionsPerIP))
+
+	retention := c.RetentionConfig
+	notNegative("retention.interval", float64(retention.Interval))
+	_, err = ParseRetentionRule(retention.Default)
+	check(err == nil, "retention.default", "%v", err)
+	for key, rule := range retention.Policies {
+		_, err := ParseRetentionRule(rule)
+		check(err == nil, "retention.policies."+key, "%v", err)
+	}
+
+	hooks := c.HooksConfig
+	oneOf("hooks.policy", hooks.Policy, "all", "any")
+	for messageType, policy := range hooks.Policies {
+		oneOf("hooks.policies."+messageType, policy, "all", "any")
+	}
+	for hook, workers := range hooks.Workers {
+		notNegative("hooks.workers."+hook, float64(workers))
+	}
+	positive("hooks.queue_size", float64(hooks.QueueSize))
+	for name, endpoint := range hooks.External {
+		check(strings.TrimSpace(endpoint) != "", "hooks.external."+name, "must be an http:// address or a command")
+	}
+	duration("hooks.external_timeout", hooks.ExternalTimeout)
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// walk обходит настройки структуры v. Вложенные структуры становятся
+// разделами с ключами раздел.настройка.
+func walk(v reflect.Value, prefix string, visit func(key string, value reflect.Value)) {
+	for i := 0; i < v.NumField(); i++ {
+		name := jsonName(v.Type().Field(i))
+		if name == "" {
+			continue
+		}
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			walk(field, prefix+name+".", visit)
+			continue
+		}
+		visit(prefix+name, field)
+	}
+}
+
+// lookup находит настройку по ключу
+func lookup(v reflect.Value, key string) (reflect.Value, bool) {
+	var found reflect.Value
+	walk(v, "", func(k string, value reflect.Value) {
+		if k == key {
+			found = value
+		}
+	})
+	return found, found.IsValid()
+}
+
+// removedSettings настройки, которые больше не задаются, но могут остаться
+// в файлах, сохраненных прежними версиями узла
+var removedSettings = map[string]string{
+	"blockchain.block_time":        "it is the consensus constant consensus.BlockTime",
+	"blockchain.retarget_interval": "it is the consensus constant consensus.RetargetInterval",
+}
+
+// applyJSON записывает в структуру v значения из JSON объекта раздела prefix
+func applyJSON(v reflect.Value, prefix string, raw map[string]json.RawMessage) error {
+	fields := make(map[string]reflect.Value)
+	for i := 0; i < v.NumField(); i++ {
+		if name := jsonName(v.Type().Field(i)); name != "" {
+			fields[name] = v.Field(i)
+		}
+	}
+
+	names := make([]string, 0, len(raw))
+	for name := range raw {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		key, data := prefix+name, raw[name]
+		field, ok := fields[name]
+		if reason, removed := removedSettings[key]; !ok && removed {
+			logrus.Warnf("Config setting %s is ignored: %s", key, reason)
+			continue
+		}
+		if !ok {
+			return fmt.Errorf("unknown setting %q", key)
+		}
+
+		if field.Kind() == reflect.Struct {
+			var section map[string]json.RawMessage
+			if err := json.Unmarshal(data, &section); err != nil {
+				return fmt.Errorf("%s: expected an object: %w", key, err)
+			}
+			if err := applyJSON(field, key+".", section); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var text string
+		if field.Type() == durationType && json.Unmarshal(data, &text) == nil {
+			if err := setValue(field, text); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			continue
+		}
+
+		// Словарь из файла заменяет значение по умолчанию, а не дополняет его
+		target := reflect.New(field.Type())
+		if err := json.Unmarshal(data, target.Interface()); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		field.Set(target.Elem())
+	}
+	return nil
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// setValue разбирает строку в значение настройки
+func setValue(field reflect.Value, text string) error {
+	text = strings.TrimSpace(text)
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return fmt.Errorf("expected a duration such as 5s or 1h30m, got %q", text)
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(text)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", text)
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return fmt.Errorf("expected an integer, got %q", text)
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return fmt.Errorf("expected a number, got %q", text)
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		items := []string{}
+		for _, item := range strings.Split(text, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	case reflect.Map:
+		entries := reflect.MakeMap(field.Type())
+		for _, item := range strings.Split(text, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			name, value, ok := strings.Cut(item, "=")
+			if !ok {
+				return fmt.Errorf("expected name=value pairs separated by commas, got %q", item)
+			}
+			entry := reflect.New(field.Type().Elem()).Elem()
+			if err := setValue(entry, value); err != nil {
+				return fmt.Errorf("%s: %w", strings.TrimSpace(name), err)
+			}
+			entries.SetMapIndex(reflect.ValueOf(strings.TrimSpace(name)), entry)
+		}
+		field.Set(entries)
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
+
+// formatValue записывает значение настройки в виде, который принимает setValue
+func formatValue(field reflect.Value) string {
+	if field.Type() == durationType {
+		return time.Duration(field.Int()).String()
+	}
+
+	switch field.Kind() {
+	case reflect.Slice:
+		return strings.Join(field.Interface().([]string), ",")
+	case reflect.Map:
+		items := make([]string, 0, field.Len())
+		for _, name := range field.MapKeys() {
+			items = append(items, fmt.Sprintf("%s=%s", name.String(), formatValue(field.MapIndex(name))))
+		}
+		sort.Strings(items)
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(field.Interface())
+}
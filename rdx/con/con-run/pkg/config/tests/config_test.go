@@ -3,6 +3,7 @@ package tests
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -74,12 +75,17 @@ func TestDefaultConfig(t *testing.T) {
 	}
 
 	// Verify Blockchain config
-	if cfg.BlockchainConfig.BlockTime != 10*time.Second {
-		t.Errorf("Expected BlockchainConfig.BlockTime 10s, got %v", cfg.BlockchainConfig.BlockTime)
-	}
 	if cfg.BlockchainConfig.MaxTransactions != 100 {
 		t.Errorf("Expected BlockchainConfig.MaxTransactions 100, got %d", cfg.BlockchainConfig.MaxTransactions)
 	}
+
+	// Verify Transport config
+	if cfg.TransportConfig.Type != "http" {
+		t.Errorf("Expected TransportConfig.Type http, got %s", cfg.TransportConfig.Type)
+	}
+	if cfg.TransportConfig.SendTimeout != 5*time.Second {
+		t.Errorf("Expected TransportConfig.SendTimeout 5s, got %v", cfg.TransportConfig.SendTimeout)
+	}
 }
 
 func TestSaveAndLoadConfig(t *testing.T) {
@@ -199,4 +205,209 @@ func TestCleanDataDirs(t *testing.T) {
 	if _, err := os.Stat(tempDir); !os.IsNotExist(err) {
 		t.Errorf("Directory still exists after cleanup: %s", tempDir)
 	}
-}
\ No newline at end of file
+}
+
+func TestParseSeed(t *testing.T) {
+	tests := []struct {
+		seed     string
+		expected string
+		ok       bool
+	}{
+		{"3000", "127.0.0.1:3000", true},
+		{"10.0.0.5:3000", "10.0.0.5:3000", true},
+		{"seed.example.org:3000", "seed.example.org:3000", true},
+		{"[2001:db8::1]:3000", "[2001:db8::1]:3000", true},
+		{"seed.example.org", "", false},
+		{":3000", "", false},
+		{"10.0.0.5:http", "", false},
+		{"10.0.0.5:70000", "", false},
+	}
+	for _, test := range tests {
+		got, err := config.ParseSeed(test.seed)
+		if test.ok && (err != nil || got != test.expected) {
+			t.Errorf("ParseSeed(%q) = %q, %v, expected %q", test.seed, got, err, test.expected)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("Expected ParseSeed(%q) to fail, got %q", test.seed, got)
+		}
+	}
+}
+
+func TestParseRetentionRule(t *testing.T) {
+	tests := []struct {
+		rule     string
+		expected config.RetentionRule
+		ok       bool
+	}{
+		{"keep", config.RetentionRule{Keep: true}, true},
+		{"settled", config.RetentionRule{Settled: true}, true},
+		{"72h", config.RetentionRule{MaxAge: 72 * time.Hour}, true},
+		{" settled | 1h ", config.RetentionRule{Settled: true, MaxAge: time.Hour}, true},
+		{"", config.RetentionRule{}, false},
+		{"forever", config.RetentionRule{}, false},
+		{"-1h", config.RetentionRule{}, false},
+		{"keep|1h", config.RetentionRule{}, false},
+	}
+	for _, test := range tests {
+		got, err := config.ParseRetentionRule(test.rule)
+		if test.ok && (err != nil || got != test.expected) {
+			t.Errorf("ParseRetentionRule(%q) = %+v, %v, expected %+v", test.rule, got, err, test.expected)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("Expected ParseRetentionRule(%q) to fail, got %+v", test.rule, got)
+		}
+	}
+
+	// Rules are written back in the accepted form
+	rule, _ := config.ParseRetentionRule("1h|settled")
+	if rule.String() != "settled|1h0m0s" {
+		t.Errorf("Unexpected rule string %q", rule.String())
+	}
+}
+
+func TestListenAddress(t *testing.T) {
+	cfg := config.DefaultConfig(3001, 0)
+	if addr := cfg.ListenAddress(); addr != ":3001" {
+		t.Errorf("Expected to listen on all interfaces, got %s", addr)
+	}
+
+	cfg.ListenAddr = "10.0.0.5"
+	if addr := cfg.ListenAddress(); addr != "10.0.0.5:3001" {
+		t.Errorf("Expected listen address 10.0.0.5:3001, got %s", addr)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{
+		"port": 3005,
+		"seed_nodes": ["3000"],
+		"gossip": {"branching_factor": 2, "sync_interval": "2s", "message_max_age": 60000000000},
+		"limits": {"payload_sizes": {"user_message": 100}},
+		"blockchain": {"block_time": 5000000000, "retarget_interval": 2, "max_transactions": 50}
+	}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	environ := []string{"CONRUN_GOSSIP_BRANCHING_FACTOR=3", "CONRUN_PEX_PEER_TTL=1h", "HOME=/root"}
+	overrides := map[string]string{"pex.peer_ttl": "2h", "log_level": "debug"}
+	cfg, err := config.Load(path, environ, overrides)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	// The file overrides defaults, the environment overrides the file, flags override both
+	if cfg.Port != 3005 || cfg.GossipConfig.SyncInterval != 2*time.Second || cfg.GossipConfig.MessageMaxAge != time.Minute {
+		t.Errorf("Expected settings from the file, got %+v", cfg)
+	}
+	if cfg.GossipConfig.BranchingFactor != 3 {
+		t.Errorf("Expected the environment to override the file, got %d", cfg.GossipConfig.BranchingFactor)
+	}
+	if cfg.PexConfig.PeerTTL != 2*time.Hour || cfg.LogLevel != "debug" {
+		t.Errorf("Expected overrides to win, got %v %s", cfg.PexConfig.PeerTTL, cfg.LogLevel)
+	}
+	if cfg.GossipConfig.MessageTTL != 5 {
+		t.Errorf("Expected defaults for missing settings, got %d", cfg.GossipConfig.MessageTTL)
+	}
+
+	// Consensus parameters saved by older versions are ignored, not rejected
+	if cfg.BlockchainConfig.MaxTransactions != 50 {
+		t.Errorf("Expected max transactions from the file, got %d", cfg.BlockchainConfig.MaxTransactions)
+	}
+
+	// A map from the file replaces the default one
+	if len(cfg.LimitsConfig.PayloadSizes) != 1 || cfg.LimitsConfig.PayloadSizes["user_message"] != 100 {
+		t.Errorf("Expected payload sizes from the file, got %v", cfg.LimitsConfig.PayloadSizes)
+	}
+
+	// Derived settings follow the final port
+	if cfg.DataDir != filepath.Join(".nodedata", "port3005") || cfg.NodeID != "node-3005" {
+		t.Errorf("Expected data dir and node ID derived from port 3005, got %s %s", cfg.DataDir, cfg.NodeID)
+	}
+	if len(cfg.SeedNodes) != 1 || cfg.SeedNodes[0] != "127.0.0.1:3000" {
+		t.Errorf("Expected seed nodes to be normalized, got %v", cfg.SeedNodes)
+	}
+
+	// A saved config loads back unchanged
+	cfg.DataDir = t.TempDir()
+	if err := cfg.SaveConfig(); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+	saved, err := config.Load(filepath.Join(cfg.DataDir, "config", "config.json"), nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to load saved config: %v", err)
+	}
+	if changed := cfg.Changed(saved); len(changed) != 0 {
+		t.Errorf("Expected the saved config to load unchanged, got changes in %v", changed)
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	tests := []struct {
+		file      string
+		environ   []string
+		overrides map[string]string
+		expected  string
+	}{
+		{file: `{"gossip": {"branching": 2}}`, expected: `unknown setting "gossip.branching"`},
+		{file: `{"pex": {"peer_ttl": "soon"}}`, expected: "pex.peer_ttl: expected a duration"},
+		{environ: []string{"CONRUN_GOSIP_TTL=1"}, expected: "unknown environment variable CONRUN_GOSIP_TTL"},
+		{environ: []string{"CONRUN_BLOCKCHAIN_RETARGET_INTERVAL=2"}, expected: "unknown environment variable CONRUN_BLOCKCHAIN_RETARGET_INTERVAL"},
+		{overrides: map[string]string{"blockchain.block_time": "5s"}, expected: `unknown setting "blockchain.block_time"`},
+		{environ: []string{"CONRUN_PORT=http"}, expected: "CONRUN_PORT: port: expected an integer"},
+		{overrides: map[string]string{"seed_nodes": "seed.example.org"}, expected: "seed_nodes: bad seed address"},
+		{overrides: map[string]string{"limits.payload_sizes": "user_message"}, expected: "expected name=value pairs"},
+		{overrides: map[string]string{"limits.payload_sizes": "user_message=big"}, expected: "user_message: expected an integer"},
+		{overrides: map[string]string{"retention.policies": "user_message=soon"}, expected: "retention.policies.user_message bad retention condition"},
+		{overrides: map[string]string{"hooks.policies": "user_message=some"}, expected: `hooks.policies.user_message must be one of all, any, got "some"`},
+		{overrides: map[string]string{"hooks.external": "moderator= "}, expected: "hooks.external.moderator must be an http:// address or a command"},
+	}
+	for _, test := range tests {
+		configPath := ""
+		if test.file != "" {
+			configPath = path
+			os.WriteFile(path, []byte(test.file), 0644)
+		}
+		_, err := config.Load(configPath, test.environ, test.overrides)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("Expected an error containing %q, got %v", test.expected, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := config.DefaultConfig(3000, 0).Validate(); err != nil {
+		t.Fatalf("Expected the default config to be valid, got %v", err)
+	}
+
+	cfg := config.DefaultConfig(3000, 0)
+	cfg.GossipConfig.ProtocolType = "flood"
+	cfg.PexConfig.ExchangeInterval = 0
+	cfg.MinerConfig.Enabled = true
+	cfg.LimitsConfig.PeerBurst = 0
+
+	// All problems are reported at once
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatalf("Expected the config to be invalid")
+	}
+	for _, key := range []string{"gossip.protocol_type", "pex.exchange_interval", "miner.miner_id", "limits.peer_burst"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("Expected the error to mention %s, got %v", key, err)
+		}
+	}
+}
+
+func TestChanged(t *testing.T) {
+	cfg := config.DefaultConfig(3000, 0)
+	next := config.DefaultConfig(3000, 0)
+	next.LogLevel = "debug"
+	next.LimitsConfig.PayloadSizes = map[string]int{"user_message": 1}
+
+	changed := cfg.Changed(next)
+	if len(changed) != 2 || changed[0] != "log_level" || changed[1] != "limits.payload_sizes" {
+		t.Errorf("Expected log_level and limits.payload_sizes to change, got %v", changed)
+	}
+}
@@ -1,17 +1,20 @@
 package gossip
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
 	"sync"
 	"time"
 
 	"concoin/conrun/pkg/config"
+	"concoin/conrun/pkg/identity"
 	"concoin/conrun/pkg/interfaces"
 	"concoin/conrun/pkg/models"
+	"concoin/conrun/pkg/reputation"
+	"concoin/conrun/pkg/transport"
 
 	"github.com/sirupsen/logrus"
 )
@@ -26,6 +29,10 @@ type GossipProtocol struct {
 	hookManager    interfaces.HookManagerInterface
 	logger         *logrus.Logger
 	storage        interfaces.StorageInterface
+	transport      interfaces.TransportInterface
+	reputation     interfaces.ReputationInterface
+	cancel         context.CancelFunc
+	wg             sync.WaitGroup
 }
 
 // NewGossipProtocol создает новый экземпляр Gossip протокола
@@ -36,9 +43,21 @@ func NewGossipProtocol(config *config.Config, logger *logrus.Logger, storage int
 		logger:         logger,
 		storage:        storage,
 		hookManager:    hookManager,
+		transport:      transport.NewHTTPTransport(config.TransportConfig),
+		reputation:     reputation.NewManager(config.ReputationConfig, nil, logger),
 	}
 }
 
+// SetTransport задает транспорт для отправки сообщений пирам
+func (g *GossipProtocol) SetTransport(transport interfaces.TransportInterface) {
+	g.transport = transport
+}
+
+// SetReputation задает общие для узла оценки пиров
+func (g *GossipProtocol) SetReputation(reputation interfaces.ReputationInterface) {
+	g.reputation = reputation
+}
+
 // UpdatePeers обновляет список пиров
 func (g *GossipProtocol) UpdatePeers(peers []models.Peer) {
 	g.peerMutex.Lock()
@@ -47,23 +66,59 @@ func (g *GossipProtocol) UpdatePeers(peers []models.Peer) {
 }
 
 // Start запускает протокол
-func (g *GossipProtocol) Start() {
-	g.logger.Info("Starting Gossip protocol")
+func (g *GossipProtocol) Start(ctx context.Context) error {
+	protocolType := g.config.GossipConfig.ProtocolType
+	g.logger.Infof("Starting Gossip protocol (%s)", protocolType)
+	if protocolType != ProtocolPush && protocolType != ProtocolPushPull {
+		g.logger.Warnf("Unknown gossip protocol type %q, using %s", protocolType, ProtocolPush)
+	}
+
+	ctx, g.cancel = context.WithCancel(ctx)
 
 	// Периодически отправляем сообщения
+	g.wg.Add(1)
 	go func() {
+		defer g.wg.Done()
+
 		ticker := time.NewTicker(g.config.GossipConfig.SyncInterval)
 		defer ticker.Stop()
 
-		for range ticker.C {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
 			// Очищаем историю сообщений от старых записей
 			g.cleanMessageHistory()
+
+			// Сверяем сводки сообщений со случайным пиром
+			if protocolType == ProtocolPushPull {
+				g.antiEntropy()
+			}
 		}
 	}()
+	return nil
+}
+
+// Stop останавливает протокол и дожидается завершения текущего раунда
+func (g *GossipProtocol) Stop() {
+	if g.cancel != nil {
+		g.cancel()
+	}
+	g.wg.Wait()
+	g.logger.Info("Gossip protocol stopped")
 }
 
-// HandleMessage обрабатывает входящее сообщение
-func (g *GossipProtocol) HandleMessage(message *models.GossipMessage) error {
+// HandleMessage обрабатывает входящее сообщение. from - пир, переславший
+// сообщение (заявленный ID и адрес соединения), nil для сообщений самого узла.
+func (g *GossipProtocol) HandleMessage(message *models.GossipMessage, from *models.Peer) error {
+	sender := g.senderID(from)
+	if g.reputation.IsBanned(sender) {
+		return reputation.ErrBanned
+	}
+
 	// Проверяем TTL
 	if message.TTL <= 0 {
 		g.logger.Debugf("Message TTL expired: %s", message.MessageID)
@@ -82,11 +137,20 @@ func (g *GossipProtocol) HandleMessage(message *models.GossipMessage) error {
 		return nil
 	}
 
+	// Проверяем хеш и подпись до того, как сообщение увидят хуки
+	if err := identity.Verify(message); err != nil {
+		g.logger.Warnf("Message envelope rejected: %s: %v", message.MessageID, err)
+		g.reputation.RecordInvalid(sender, err.Error())
+		return fmt.Errorf("message envelope rejected: %w", err)
+	}
+
 	// Проверяем валидность сообщения через хуки
 	if !g.hookManager.ValidateMessage(message, interfaces.MessageTypePull) {
 		g.logger.Warnf("Message validation failed: %s", message.MessageID)
+		g.reputation.RecordRejected(sender, "message rejected by hooks")
 		return fmt.Errorf("message validation failed: %s", message.MessageID)
 	}
+	g.reputation.RecordValid(sender)
 
 	// Добавляем сообщение в историю
 	g.addToMessageHistory(message.MessageID)
@@ -103,6 +167,29 @@ func (g *GossipProtocol) HandleMessage(message *models.GossipMessage) error {
 	return nil
 }
 
+// PublishMessage публикует сообщение, созданное самим узлом: обрабатывает его хуками,
+// сохраняет и рассылает пирам
+func (g *GossipProtocol) PublishMessage(message *models.GossipMessage) error {
+	if err := models.DecodeMessage(message); err != nil {
+		return fmt.Errorf("invalid message %s: %w", message.MessageID, err)
+	}
+	if !g.hookManager.ProcessMessage(message, interfaces.MessageTypePush) {
+		return fmt.Errorf("message validation failed: %s", message.MessageID)
+	}
+
+	if err := g.storage.SaveMessage(message); err != nil {
+		return fmt.Errorf("failed to save message: %w", err)
+	}
+
+	g.addToMessageHistory(message.MessageID)
+
+	if err := g.spreadMessage(message); err != nil {
+		g.logger.Warnf("Failed to spread message: %v", err)
+	}
+
+	return nil
+}
+
 // spreadMessage отправляет сообщение случайным пирам
 func (g *GossipProtocol) spreadMessage(message *models.GossipMessage) error {
 	g.peerMutex.RLock()
@@ -132,44 +219,54 @@ func (g *GossipProtocol) spreadMessage(message *models.GossipMessage) error {
 	return nil
 }
 
-// selectRandomPeers выбирает случайные пиры
+// selectRandomPeers выбирает случайные незабаненные пиры, пиры с лучшей
+// оценкой выбираются чаще
 func (g *GossipProtocol) selectRandomPeers(count int) []models.Peer {
-	if len(g.peerList) <= count {
-		return g.peerList
-	}
-
-	// Создаем случайную выборку
-	indices := rand.Perm(len(g.peerList))[:count]
-	selectedPeers := make([]models.Peer, count)
-
-	for i, idx := range indices {
-		selectedPeers[i] = g.peerList[idx]
-	}
-
-	return selectedPeers
+	return g.reputation.SelectPeers(g.peerList, count)
 }
 
 // sendMessageToPeer отправляет сообщение конкретному пиру
 func (g *GossipProtocol) sendMessageToPeer(message *models.GossipMessage, peer models.Peer) error {
-	// Сериализуем сообщение
-	data, err := json.Marshal(message)
-	if err != nil {
-		return fmt.Errorf("failed to marshal message: %w", err)
+	if err := g.send(peer, "/gossip", message, nil); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
 	}
+	return nil
+}
+
+// send отправляет запрос пиру от имени узла и учитывает задержку ответа
+func (g *GossipProtocol) send(peer models.Peer, path string, request, response interface{}) error {
+	path += "?sender=" + url.QueryEscape(g.config.NodeID)
+
+	start := time.Now()
+	err := g.transport.Send(peer, http.MethodPost, path, request, response)
+	g.reputation.RecordRequest(peer.NodeID, time.Since(start), err)
+	return err
+}
 
-	// Отправляем HTTP запрос
-	url := fmt.Sprintf("http://%s/gossip", peer.Address)
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+// senderID возвращает ID известного пира, от которого пришел запрос. Заявленный
+// ID принимается, только если запрос пришел с хоста из адреса этого пира,
+// иначе любой узел мог бы получить штрафы за другого.
+func (g *GossipProtocol) senderID(from *models.Peer) string {
+	if from == nil || from.NodeID == "" {
+		return ""
+	}
+	remoteHost, _, err := net.SplitHostPort(from.Address)
 	if err != nil {
-		return fmt.Errorf("failed to send message: %w", err)
+		return ""
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("received bad status code: %d", resp.StatusCode)
-	}
+	g.peerMutex.RLock()
+	defer g.peerMutex.RUnlock()
 
-	return nil
+	for _, peer := range g.peerList {
+		if peer.NodeID != from.NodeID {
+			continue
+		}
+		if host, _, err := net.SplitHostPort(peer.Address); err == nil && host == remoteHost {
+			return peer.NodeID
+		}
+	}
+	return ""
 }
 
 // addToMessageHistory добавляет сообщение в историю
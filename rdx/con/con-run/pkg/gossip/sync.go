@@ -0,0 +1,294 @@
+package gossip
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+
+	"concoin/conrun/pkg/identity"
+	"concoin/conrun/pkg/interfaces"
+	"concoin/conrun/pkg/models"
+	"concoin/conrun/pkg/reputation"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Режимы работы протокола (GossipConfig.ProtocolType)
+const (
+	ProtocolPush     = "push"      // только рассылка новых сообщений
+	ProtocolPushPull = "push-pull" // рассылка и периодический обмен сводками с догрузкой недостающего
+)
+
+// Параметры фильтра Блума: 10 бит и 7 хешей на сообщение дают около 1% ложных срабатываний
+const (
+	bloomBitsPerMessage = 10
+	bloomHashes         = 7
+	bloomMaxHashes      = 32
+)
+
+// bloomFilter фильтр Блума по ID сообщений
+type bloomFilter struct {
+	bits   []byte
+	seed   uint64
+	hashes int
+}
+
+// newBloomFilter создает пустой фильтр для count сообщений
+func newBloomFilter(count int, seed uint64) *bloomFilter {
+	return &bloomFilter{
+		bits:   make([]byte, count*bloomBitsPerMessage/8+8),
+		seed:   seed,
+		hashes: bloomHashes,
+	}
+}
+
+// bloomFromDigest восстанавливает фильтр из сводки пира
+func bloomFromDigest(digest *models.GossipDigest) (*bloomFilter, error) {
+	if len(digest.Filter) == 0 {
+		return nil, fmt.Errorf("empty digest filter")
+	}
+	if digest.Hashes < 1 || digest.Hashes > bloomMaxHashes {
+		return nil, fmt.Errorf("bad number of digest hashes: %d", digest.Hashes)
+	}
+	return &bloomFilter{
+		bits:   digest.Filter,
+		seed:   digest.Seed,
+		hashes: digest.Hashes,
+	}, nil
+}
+
+func (f *bloomFilter) add(messageID string) {
+	for _, pos := range f.positions(messageID) {
+		f.bits[pos/8] |= 1 << (pos % 8)
+	}
+}
+
+func (f *bloomFilter) contains(messageID string) bool {
+	for _, pos := range f.positions(messageID) {
+		if f.bits[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// positions вычисляет номера битов двойным хешированием соленого SHA-256
+func (f *bloomFilter) positions(messageID string) []uint64 {
+	var seed [8]byte
+	binary.LittleEndian.PutUint64(seed[:], f.seed)
+	sum := sha256.Sum256(append(seed[:], messageID...))
+
+	h1 := binary.LittleEndian.Uint64(sum[0:8])
+	h2 := binary.LittleEndian.Uint64(sum[8:16]) | 1
+	size := uint64(len(f.bits)) * 8
+
+	positions := make([]uint64, f.hashes)
+	for i := range positions {
+		positions[i] = (h1 + uint64(i)*h2) % size
+	}
+	return positions
+}
+
+// Digest строит сводку сохраненных сообщений. Соль каждый раз новая,
+// чтобы ложные срабатывания фильтра не повторялись от раунда к раунду.
+// Удаленные хранилищем сообщения тоже входят в сводку, чтобы пиры
+// не присылали их снова.
+func (g *GossipProtocol) Digest() (*models.GossipDigest, error) {
+	messageIDs, err := g.storage.GetMessageList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message list: %w", err)
+	}
+	if pruner, ok := g.storage.(interfaces.MessagePruner); ok {
+		pruned, err := pruner.PrunedMessages()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pruned messages: %w", err)
+		}
+		messageIDs = append(messageIDs, pruned...)
+	}
+
+	filter := newBloomFilter(len(messageIDs), rand.Uint64())
+	for _, messageID := range messageIDs {
+		filter.add(messageID)
+	}
+
+	return &models.GossipDigest{
+		NodeID: g.config.NodeID,
+		Count:  len(messageIDs),
+		Seed:   filter.seed,
+		Hashes: filter.hashes,
+		Filter: filter.bits,
+	}, nil
+}
+
+// HandleSync обрабатывает anti-entropy запрос пира: принимает присланные сообщения
+// и, если пир прислал сводку, отвечает своей сводкой и сообщениями, которых у пира нет
+func (g *GossipProtocol) HandleSync(request *models.GossipSync, from *models.Peer) (*models.GossipSync, error) {
+	sender := g.senderID(from)
+	if g.reputation.IsBanned(sender) {
+		return nil, reputation.ErrBanned
+	}
+
+	received := g.acceptSyncedMessages(request.Messages, sender)
+	if received > 0 {
+		g.logger.Infof("Anti-entropy: received %d messages", received)
+	}
+
+	response := &models.GossipSync{}
+	if request.Digest == nil {
+		return response, nil
+	}
+
+	missing, err := g.missingMessages(request.Digest)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := g.Digest()
+	if err != nil {
+		return nil, err
+	}
+
+	response.Digest = digest
+	response.Messages = missing
+	return response, nil
+}
+
+// antiEntropy проводит раунд обмена сводками со случайным пиром:
+// забирает у него недостающие сообщения и отдает ему то, чего нет у него
+func (g *GossipProtocol) antiEntropy() {
+	g.peerMutex.RLock()
+	peers := g.selectRandomPeers(1)
+	g.peerMutex.RUnlock()
+
+	if len(peers) == 0 {
+		return
+	}
+	peer := peers[0]
+
+	digest, err := g.Digest()
+	if err != nil {
+		g.logger.Warnf("Anti-entropy: %v", err)
+		return
+	}
+
+	response, err := g.sendSync(peer, &models.GossipSync{Digest: digest})
+	if err != nil {
+		g.logger.Warnf("Anti-entropy with peer %s failed: %v", peer.NodeID, err)
+		return
+	}
+
+	received := g.acceptSyncedMessages(response.Messages, peer.NodeID)
+
+	sent := 0
+	if response.Digest != nil {
+		missing, err := g.missingMessages(response.Digest)
+		if err != nil {
+			g.logger.Warnf("Anti-entropy: bad digest from peer %s: %v", peer.NodeID, err)
+			return
+		}
+		if len(missing) > 0 {
+			if _, err := g.sendSync(peer, &models.GossipSync{Messages: missing}); err != nil {
+				g.logger.Warnf("Anti-entropy: failed to push messages to peer %s: %v", peer.NodeID, err)
+				return
+			}
+			sent = len(missing)
+		}
+	}
+
+	g.logger.Debugf("Anti-entropy with peer %s: received %d, sent %d messages", peer.NodeID, received, sent)
+}
+
+// missingMessages возвращает сохраненные сообщения, которых нет в сводке пира,
+// не больше SyncBatchSize за раз
+func (g *GossipProtocol) missingMessages(digest *models.GossipDigest) ([]models.GossipMessage, error) {
+	filter, err := bloomFromDigest(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	messageIDs, err := g.storage.GetMessageList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message list: %w", err)
+	}
+
+	var missing []models.GossipMessage
+	for _, messageID := range messageIDs {
+		if len(missing) >= g.config.GossipConfig.SyncBatchSize {
+			break
+		}
+		if filter.contains(messageID) {
+			continue
+		}
+		message, err := g.storage.GetMessage(messageID)
+		if err != nil {
+			g.logger.Warnf("Anti-entropy: failed to load message %s: %v", messageID, err)
+			continue
+		}
+		missing = append(missing, *message)
+	}
+
+	return missing, nil
+}
+
+// acceptSyncedMessages проверяет, сохраняет и обрабатывает сообщения,
+// полученные при синхронизации от пира sender, и возвращает число новых
+func (g *GossipProtocol) acceptSyncedMessages(messages []models.GossipMessage, sender string) int {
+	accepted := 0
+	for i := range messages {
+		message := &messages[i]
+		if g.isMessageProcessed(message.MessageID) {
+			continue
+		}
+		if !AcceptMessage(message, sender, g.storage, g.hookManager, g.reputation, g.logger) {
+			continue
+		}
+		g.addToMessageHistory(message.MessageID)
+		accepted++
+	}
+	return accepted
+}
+
+// AcceptMessage проверяет, сохраняет и обрабатывает еще не известное узлу
+// сообщение, полученное при синхронизации от пира sender: через anti-entropy
+// или через PEX. Отклоненные сообщения учитываются в оценке пира.
+// Возвращает false, если сообщение отклонено или не сохранено.
+func AcceptMessage(message *models.GossipMessage, sender string, storage interfaces.StorageInterface,
+	hookManager interfaces.HookManagerInterface, reputation interfaces.ReputationInterface, logger *logrus.Logger) bool {
+	// Проверяем хеш и подпись до того, как сообщение увидят хуки
+	if err := identity.Verify(message); err != nil {
+		logger.Warnf("Message envelope rejected during sync: %s: %v", message.MessageID, err)
+		reputation.RecordInvalid(sender, err.Error())
+		return false
+	}
+
+	// Разбираем payload один раз, хуки получат его готовым
+	if err := models.DecodeMessage(message); err != nil {
+		logger.Warnf("Message payload rejected during sync: %s: %v", message.MessageID, err)
+		reputation.RecordRejected(sender, err.Error())
+		return false
+	}
+
+	if !hookManager.ValidateMessage(message, interfaces.MessageTypeLoaded) {
+		logger.Warnf("Message validation failed during sync: %s", message.MessageID)
+		reputation.RecordRejected(sender, "message rejected by hooks")
+		return false
+	}
+	reputation.RecordValid(sender)
+
+	if err := storage.SaveMessage(message); err != nil {
+		logger.Warnf("Failed to save synced message %s: %v", message.MessageID, err)
+		return false
+	}
+
+	hookManager.ProcessMessage(message, interfaces.MessageTypeLoaded)
+	return true
+}
+
+// sendSync отправляет anti-entropy запрос пиру
+func (g *GossipProtocol) sendSync(peer models.Peer, request *models.GossipSync) (*models.GossipSync, error) {
+	var response models.GossipSync
+	if err := g.send(peer, "/gossip/sync", request, &response); err != nil {
+		return nil, fmt.Errorf("failed to send sync request: %w", err)
+	}
+	return &response, nil
+}
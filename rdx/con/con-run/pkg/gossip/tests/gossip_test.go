@@ -1,19 +1,49 @@
 package tests
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"concoin/conrun/pkg/config"
 	"concoin/conrun/pkg/gossip"
+	"concoin/conrun/pkg/identity"
 	"concoin/conrun/pkg/interfaces"
 	"concoin/conrun/pkg/models"
+	"concoin/conrun/pkg/reputation"
+	"concoin/conrun/pkg/storage"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/mock"
 )
 
+// testIdentity signs the messages of the tests
+var testIdentity, _ = identity.Generate()
+
+// syncTime is the timestamp of synced messages: the same content gives the same message ID
+var syncTime = time.Now().UTC().Add(-24 * time.Hour).Truncate(time.Second)
+
+// sealedMessage creates a message signed by testIdentity
+func sealedMessage(t *testing.T, timestamp time.Time, content string) *models.GossipMessage {
+	message := &models.GossipMessage{
+		Timestamp:   timestamp,
+		TTL:         5,
+		MessageType: "test_message",
+		Payload:     map[string]interface{}{"content": content},
+	}
+	if err := testIdentity.Seal(message); err != nil {
+		t.Fatalf("Failed to seal message: %v", err)
+	}
+	return message
+}
+
 // MockHookManager mocks the hook manager for testing
 type MockHookManager struct {
 	mock.Mock
@@ -71,6 +101,35 @@ func (m *MockStorage) HasMessage(messageID string) bool {
 	return args.Bool(0)
 }
 
+func (m *MockStorage) GetMessagesSince(seq uint64, limit int) ([]*models.GossipMessage, uint64, uint64, error) {
+	args := m.Called(seq, limit)
+	return args.Get(0).([]*models.GossipMessage), args.Get(1).(uint64), args.Get(2).(uint64), args.Error(3)
+}
+
+func (m *MockStorage) GetSyncCursor(nodeID string) (uint64, error) {
+	args := m.Called(nodeID)
+	return args.Get(0).(uint64), args.Error(1)
+}
+
+func (m *MockStorage) SaveSyncCursor(nodeID string, cursor uint64) error {
+	args := m.Called(nodeID, cursor)
+	return args.Error(0)
+}
+
+func (m *MockStorage) SaveBan(ban *models.Ban) error {
+	args := m.Called(ban)
+	return args.Error(0)
+}
+
+func (m *MockStorage) Close() error {
+	return nil
+}
+
+func (m *MockStorage) GetBans() ([]*models.Ban, error) {
+	args := m.Called()
+	return args.Get(0).([]*models.Ban), args.Error(1)
+}
+
 // MockHook mocks a hook for testing
 type MockHook struct {
 	mock.Mock
@@ -129,7 +188,10 @@ func TestGossipProtocol_UpdatePeers(t *testing.T) {
 	gossipProtocol.UpdatePeers(peers)
 
 	// Start the gossip protocol
-	gossipProtocol.Start()
+	if err := gossipProtocol.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start gossip protocol: %v", err)
+	}
+	gossipProtocol.Stop()
 }
 
 func TestGossipProtocol_HandleMessage(t *testing.T) {
@@ -151,7 +213,7 @@ func TestGossipProtocol_HandleMessage(t *testing.T) {
 			Payload:     map[string]interface{}{"content": "Expired message"},
 		}
 
-		err := gossipProtocol.HandleMessage(expiredMessage)
+		err := gossipProtocol.HandleMessage(expiredMessage, nil)
 		if err != nil {
 			t.Errorf("HandleMessage with expired TTL should succeed, got error: %v", err)
 		}
@@ -178,7 +240,7 @@ func TestGossipProtocol_HandleMessage(t *testing.T) {
 			Payload:     map[string]interface{}{"content": "Old message"},
 		}
 
-		err := gossipProtocol.HandleMessage(oldMessage)
+		err := gossipProtocol.HandleMessage(oldMessage, nil)
 		if err != nil {
 			t.Errorf("HandleMessage with old timestamp should succeed, got error: %v", err)
 		}
@@ -207,7 +269,7 @@ func TestGossipProtocol_HandleMessage(t *testing.T) {
 
 		mockStorage.On("HasMessage", "processed-message").Return(true)
 
-		err := gossipProtocol.HandleMessage(processedMessage)
+		err := gossipProtocol.HandleMessage(processedMessage, nil)
 		if err != nil {
 			t.Errorf("HandleMessage with already processed message should succeed, got error: %v", err)
 		}
@@ -225,21 +287,14 @@ func TestGossipProtocol_HandleMessage(t *testing.T) {
 		cfg := config.DefaultConfig(3000, 0)
 		gossipProtocol := gossip.NewGossipProtocol(cfg, logger, mockStorage, mockHookManager)
 
-		validMessage := &models.GossipMessage{
-			MessageID:   "valid-message",
-			OriginID:    "test-node",
-			Timestamp:   time.Now().UTC(),
-			TTL:         5,
-			MessageType: "test_message",
-			Payload:     map[string]interface{}{"content": "Valid message"},
-		}
+		validMessage := sealedMessage(t, time.Now().UTC(), "Valid message")
 
 		// Настраиваем моки для этого теста
-		mockStorage.On("HasMessage", "valid-message").Return(false).Once() // первая проверка истории
+		mockStorage.On("HasMessage", validMessage.MessageID).Return(false).Once() // первая проверка истории
 		mockHookManager.On("ValidateMessage", mock.AnythingOfType("*models.GossipMessage"), interfaces.MessageTypePull).Return(true)
 		mockHookManager.On("ProcessMessage", mock.AnythingOfType("*models.GossipMessage"), interfaces.MessageTypePull).Return(true)
 
-		err := gossipProtocol.HandleMessage(validMessage)
+		err := gossipProtocol.HandleMessage(validMessage, nil)
 		if err != nil {
 			t.Errorf("HandleMessage with valid message failed: %v", err)
 		}
@@ -257,27 +312,228 @@ func TestGossipProtocol_HandleMessage(t *testing.T) {
 		cfg := config.DefaultConfig(3000, 0)
 		gossipProtocol := gossip.NewGossipProtocol(cfg, logger, mockStorage, mockHookManager)
 
-		invalidMessage := &models.GossipMessage{
-			MessageID:   "invalid-message",
-			OriginID:    "test-node",
-			Timestamp:   time.Now().UTC(),
-			TTL:         5,
-			MessageType: "test_message",
-			Payload:     map[string]interface{}{"content": "Invalid message"},
-		}
+		invalidMessage := sealedMessage(t, time.Now().UTC(), "Invalid message")
 
 		// Настраиваем моки для этого теста
-		mockStorage.On("HasMessage", "invalid-message").Return(false).Once()
+		mockStorage.On("HasMessage", invalidMessage.MessageID).Return(false).Once()
 		mockHookManager.On("ValidateMessage", mock.AnythingOfType("*models.GossipMessage"), interfaces.MessageTypePull).Return(false)
 
-		err := gossipProtocol.HandleMessage(invalidMessage)
+		err := gossipProtocol.HandleMessage(invalidMessage, nil)
 		if err == nil {
 			t.Error("HandleMessage with invalid message should fail")
-		} else if err.Error() != "message validation failed: invalid-message" {
-			t.Errorf("Expected error 'message validation failed: invalid-message', got '%v'", err)
+		} else if err.Error() != "message validation failed: "+invalidMessage.MessageID {
+			t.Errorf("Expected error 'message validation failed: %s', got '%v'", invalidMessage.MessageID, err)
+		}
+
+		mockStorage.AssertExpectations(t)
+		mockHookManager.AssertExpectations(t)
+	})
+
+	t.Run("Forged_Message", func(t *testing.T) {
+		mockStorage := new(MockStorage)
+		mockHookManager := new(MockHookManager)
+		logger := logrus.New()
+		logger.SetLevel(logrus.ErrorLevel)
+
+		cfg := config.DefaultConfig(3000, 0)
+		gossipProtocol := gossip.NewGossipProtocol(cfg, logger, mockStorage, mockHookManager)
+
+		// Payload changed after signing, hooks must not see the message
+		forgedMessage := sealedMessage(t, time.Now().UTC(), "Original message")
+		forgedMessage.Payload = map[string]interface{}{"content": "Forged message"}
+
+		mockStorage.On("HasMessage", forgedMessage.MessageID).Return(false).Once()
+
+		if err := gossipProtocol.HandleMessage(forgedMessage, nil); err == nil {
+			t.Error("HandleMessage with forged message should fail")
 		}
 
 		mockStorage.AssertExpectations(t)
 		mockHookManager.AssertExpectations(t)
 	})
 }
+
+func TestGossipProtocol_Reputation(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	cfg := config.DefaultConfig(3000, 0)
+	cfg.DataDir = t.TempDir()
+	gossipProtocol := gossip.NewGossipProtocol(cfg, logger, storage.NewStorage(cfg.DataDir), new(MockHookManager))
+	manager := reputation.NewManager(cfg.ReputationConfig, nil, logger)
+	gossipProtocol.SetReputation(manager)
+	gossipProtocol.UpdatePeers([]models.Peer{{NodeID: "peer-1", Address: "127.0.0.1:3001"}})
+
+	forged := func(content string) *models.GossipMessage {
+		message := sealedMessage(t, time.Now().UTC(), content)
+		message.Payload = map[string]interface{}{"content": "forged"}
+		return message
+	}
+	invalid := func() int {
+		for _, score := range manager.Scores() {
+			if score.NodeID == "peer-1" {
+				return score.Invalid
+			}
+		}
+		return 0
+	}
+
+	// The peer is recognized by its ID and the host it connects from
+	gossipProtocol.HandleMessage(forged("first"), &models.Peer{NodeID: "peer-1", Address: "127.0.0.1:50000"})
+	if invalid() != 1 {
+		t.Fatalf("Expected the forged message to be counted against peer-1")
+	}
+
+	// Another host can not get peer-1 penalized
+	gossipProtocol.HandleMessage(forged("spoofed"), &models.Peer{NodeID: "peer-1", Address: "10.0.0.9:50000"})
+	if invalid() != 1 {
+		t.Errorf("Expected a message from another host not to be counted against peer-1")
+	}
+
+	// Repeated forgeries get the peer banned, then its messages are refused
+	for i := 0; ; i++ {
+		err := gossipProtocol.HandleMessage(forged(fmt.Sprintf("msg-%d", i)), &models.Peer{NodeID: "peer-1", Address: "127.0.0.1:50000"})
+		if errors.Is(err, reputation.ErrBanned) {
+			break
+		}
+		if i > 10 {
+			t.Fatalf("Peer was not banned")
+		}
+	}
+	if !manager.IsBanned("peer-1") {
+		t.Errorf("Expected peer-1 to be banned")
+	}
+}
+
+// newSyncNode creates a gossip node with its own storage and messages with the given contents
+func newSyncNode(t *testing.T, nodeID string, contents ...string) (*gossip.GossipProtocol, *storage.Storage) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	cfg := config.DefaultConfig(3000, 0)
+	cfg.NodeID = nodeID
+	cfg.DataDir = t.TempDir()
+	cfg.GossipConfig.ProtocolType = gossip.ProtocolPushPull
+	cfg.GossipConfig.SyncInterval = 20 * time.Millisecond
+
+	store := storage.NewStorage(cfg.DataDir)
+	for _, content := range contents {
+		if err := store.SaveMessage(sealedMessage(t, syncTime, content)); err != nil {
+			t.Fatalf("Failed to save message: %v", err)
+		}
+	}
+
+	hookManager := new(MockHookManager)
+	hookManager.On("ValidateMessage", mock.Anything, interfaces.MessageTypeLoaded).Return(true)
+	hookManager.On("ProcessMessage", mock.Anything, interfaces.MessageTypeLoaded).Return(true)
+
+	return gossip.NewGossipProtocol(cfg, logger, store, hookManager), store
+}
+
+func TestGossipProtocol_AntiEntropy(t *testing.T) {
+	nodeA, storeA := newSyncNode(t, "node-a", "msg-1", "msg-2", "msg-3")
+	nodeB, storeB := newSyncNode(t, "node-b", "msg-3", "msg-4", "msg-5")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/gossip/sync" {
+			http.NotFound(w, r)
+			return
+		}
+		var request models.GossipSync
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		response, err := nodeB.HandleSync(&request, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	nodeA.UpdatePeers([]models.Peer{{
+		NodeID:   "node-b",
+		Address:  strings.TrimPrefix(server.URL, "http://"),
+		LastSeen: time.Now(),
+	}})
+	nodeA.Start(context.Background())
+	defer nodeA.Stop()
+
+	// Old messages are synced too: anti-entropy is not limited by MessageMaxAge
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		listA, _ := storeA.GetMessageList()
+		listB, _ := storeB.GetMessageList()
+		if len(listA) == 5 && len(listB) == 5 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Nodes did not converge: A has %v, B has %v", listA, listB)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGossipProtocol_HandleSync(t *testing.T) {
+	node, _ := newSyncNode(t, "node-b", "msg-1", "msg-2", "msg-3")
+	peer, _ := newSyncNode(t, "node-a", "msg-1")
+
+	digest, err := peer.Digest()
+	if err != nil {
+		t.Fatalf("Failed to build digest: %v", err)
+	}
+	if digest.Count != 1 || digest.NodeID != "node-a" {
+		t.Errorf("Unexpected digest %+v", digest)
+	}
+
+	response, err := node.HandleSync(&models.GossipSync{Digest: digest}, nil)
+	if err != nil {
+		t.Fatalf("HandleSync failed: %v", err)
+	}
+	if response.Digest == nil || response.Digest.Count != 3 {
+		t.Errorf("Expected the node's own digest in the response")
+	}
+	if len(response.Messages) != 2 {
+		t.Fatalf("Expected 2 missing messages, got %d", len(response.Messages))
+	}
+	known := sealedMessage(t, syncTime, "msg-1")
+	for _, message := range response.Messages {
+		if message.MessageID == known.MessageID {
+			t.Errorf("Message known to the peer was sent back")
+		}
+	}
+
+	t.Run("Bad_Digest", func(t *testing.T) {
+		_, err := node.HandleSync(&models.GossipSync{Digest: &models.GossipDigest{Hashes: 7}}, nil)
+		if err == nil {
+			t.Errorf("Expected an error for an empty filter")
+		}
+	})
+
+	t.Run("Without_Digest", func(t *testing.T) {
+		response, err := node.HandleSync(&models.GossipSync{}, nil)
+		if err != nil {
+			t.Fatalf("HandleSync failed: %v", err)
+		}
+		if response.Digest != nil || len(response.Messages) != 0 {
+			t.Errorf("Expected an empty response to a push-only request")
+		}
+	})
+
+	t.Run("Forged_Messages", func(t *testing.T) {
+		node, store := newSyncNode(t, "node-b")
+
+		forged := sealedMessage(t, syncTime, "msg-1")
+		forged.OriginID = "node-a"
+		unsigned := models.GossipMessage{MessageID: "msg-2", OriginID: "node-a", Timestamp: syncTime}
+
+		if _, err := node.HandleSync(&models.GossipSync{Messages: []models.GossipMessage{*forged, unsigned}}, nil); err != nil {
+			t.Fatalf("HandleSync failed: %v", err)
+		}
+		if messageIDs, _ := store.GetMessageList(); len(messageIDs) != 0 {
+			t.Errorf("Forged messages were saved: %v", messageIDs)
+		}
+	})
+}
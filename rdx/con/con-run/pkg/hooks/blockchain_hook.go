@@ -1,13 +1,10 @@
 package hooks
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"math/rand"
-	"os"
-	"os/exec"
-	"path/filepath"
 
+	"concoin/conrun/pkg/blockchain"
 	"concoin/conrun/pkg/interfaces"
 	"concoin/conrun/pkg/models"
 
@@ -16,106 +13,102 @@ import (
 
 // BlockchainHook представляет собой хук для обработки сообщений блокчейна
 type BlockchainHook struct {
-	logger     *logrus.Logger
-	rootDir    string
-	scriptsDir string
+	logger  *logrus.Logger
+	chain   interfaces.ChainInterface
+	mempool interfaces.MempoolInterface
 }
 
 // NewBlockchainHook создает новый хук для блокчейна
-func NewBlockchainHook(rootDir string, logger *logrus.Logger) *BlockchainHook {
-	// Получаем абсолютный путь к директории скриптов
-	scriptsDir := filepath.Join("pkg", "hooks", "blockchain_tools")
-	absScriptsDir, err := filepath.Abs(scriptsDir)
-	if err != nil {
-		logger.Errorf("Failed to get absolute path to scripts: %v", err)
-	}
-
-	logger.Infof("BlockchainHook: Root directory: %s", rootDir)
-	logger.Infof("BlockchainHook: Scripts directory: %s", absScriptsDir)
-
+func NewBlockchainHook(chain interfaces.ChainInterface, mempool interfaces.MempoolInterface, logger *logrus.Logger) *BlockchainHook {
 	return &BlockchainHook{
-		logger:     logger,
-		rootDir:    rootDir,
-		scriptsDir: absScriptsDir,
+		logger:  logger,
+		chain:   chain,
+		mempool: mempool,
 	}
 }
 
+// Priority ставит проверку по состоянию блокчейна раньше остальных хуков
+func (h *BlockchainHook) Priority() int {
+	return 100
+}
+
 // ShouldHandle проверяет, должен ли хук обрабатывать сообщение
 func (h *BlockchainHook) ShouldHandle(messageType string) bool {
 	h.logger.Infof("BlockchainHook: ShouldHandle: %s", messageType)
-	return messageType == "blockchain_concoin"
-}
-
-func (h *BlockchainHook) save_temp_message(message *models.GossipMessage, messagesDir string) (string, error) {
-	// Сохраняем сообщение во временный файл
-	messageFile := filepath.Join(messagesDir, fmt.Sprintf("%s_%d.json", message.MessageID, rand.Intn(1000000)))
-	messageData, err := json.MarshalIndent(message, "", "  ")
-	if err != nil {
-		h.logger.Errorf("Failed to marshal message: %v", err)
-		return "", err
-	}
-
-	if err := os.WriteFile(messageFile, messageData, 0644); err != nil {
-		h.logger.Errorf("Failed to write message file: %v", err)
-		return "", err
-	}
-
-	return messageFile, nil
+	return messageType == blockchain.MessageType
 }
 
 // Validate проверяет валидность сообщения
 func (h *BlockchainHook) Validate(message *models.GossipMessage, msgType interfaces.MessageType) bool {
+	return h.ValidateWithReason(message, msgType) == nil
+}
+
+// ValidateWithReason проверяет транзакцию или блок против состояния узла
+// и возвращает причину отказа, если сообщение невалидно
+func (h *BlockchainHook) ValidateWithReason(message *models.GossipMessage, msgType interfaces.MessageType) *models.Rejection {
 	h.logger.Infof("BlockchainHook: Validate start: %s", message.MessageID)
-	// Создаем директорию для сообщений, если её нет
-	messagesDir := filepath.Join(h.rootDir, "messages_to_check")
-	if err := os.MkdirAll(messagesDir, 0755); err != nil {
-		h.logger.Errorf("Failed to create messages directory: %v", err)
-		return false
-	}
 
-	messageFile, err := h.save_temp_message(message, messagesDir)
+	payload, err := blockchain.PayloadOf(message)
 	if err != nil {
-		h.logger.Errorf("Failed to save message: %v", err)
-		return false
+		h.logger.Warnf("BlockchainHook: failed to decode payload of %s: %v", message.MessageID, err)
+		return &models.Rejection{
+			Code:   string(blockchain.RejectMalformed),
+			Reason: err.Error(),
+		}
+	}
+
+	if payload.Block != nil {
+		err = h.chain.ValidateBlock(payload.Block)
 	} else {
-		h.logger.Infof("Message saved to: %s", messageFile)
+		err = h.mempool.Check(*payload.Transaction)
 	}
 
-	// Запускаем coin_valid
-	cmd := exec.Command(filepath.Join(h.scriptsDir, "coin_valid"), messageFile)
-	output, err := cmd.CombinedOutput()
 	if err != nil {
-		h.logger.Errorf("coin_valid failed: %v\nOutput: %s", err, string(output))
-		os.Remove(messageFile)
-		return false
+		h.logger.Warnf("BlockchainHook: Validate end - invalid: %s: %v", message.MessageID, err)
+		return rejectionFromError(err)
 	}
-	h.logger.Infof("coin_valid output: %s", string(output))
+
 	h.logger.Infof("BlockchainHook: Validate end - valid: %s", message.MessageID)
-	os.Remove(messageFile)
-	return true
+	return nil
+}
+
+// rejectionFromError превращает ошибку валидации в причину отказа
+func rejectionFromError(err error) *models.Rejection {
+	var validationErr *blockchain.ValidationError
+	if errors.As(err, &validationErr) {
+		return &models.Rejection{
+			Code:   string(validationErr.Code),
+			Reason: validationErr.Reason,
+		}
+	}
+	return &models.Rejection{
+		Code:   "internal_error",
+		Reason: err.Error(),
+	}
 }
 
-// Handle обрабатывает сообщение
+// Handle применяет валидный блок к состоянию блокчейна или добавляет транзакцию в мемпул
 func (h *BlockchainHook) Handle(message *models.GossipMessage, msgType interfaces.MessageType) error {
 	h.logger.Infof("BlockchainHook: Handle start: %s", message.MessageID)
 
-	messagesDir := filepath.Join(h.rootDir, "messages_to_check")
-	messageFile, err := h.save_temp_message(message, messagesDir)
+	payload, err := blockchain.PayloadOf(message)
 	if err != nil {
-		h.logger.Errorf("Failed to save message: %v", err)
-		return err
+		return fmt.Errorf("failed to decode payload: %w", err)
 	}
 
-	// Запускаем process_message
-	cmd := exec.Command(filepath.Join(h.scriptsDir, "process_message"), messageFile)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		h.logger.Errorf("process_message failed: %v\nOutput: %s", err, string(output))
-		return err
+	if payload.Block == nil {
+		hash, err := h.mempool.Add(*payload.Transaction)
+		if err != nil {
+			return fmt.Errorf("failed to add transaction to mempool: %w", err)
+		}
+		h.logger.Infof("BlockchainHook: transaction %s added to mempool as %s", message.MessageID, hash)
+		return nil
 	}
-	h.logger.Infof("process_message output: %s", string(output))
+
+	if err := h.chain.AddBlock(payload.Block); err != nil {
+		return fmt.Errorf("failed to apply block %s: %w", payload.Block.Hash, err)
+	}
+
 	h.logger.Infof("BlockchainHook: Handle end: %s", message.MessageID)
-	// Удаляем временный файл
-	os.Remove(messageFile)
 	return nil
 }
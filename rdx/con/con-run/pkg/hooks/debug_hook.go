@@ -30,6 +30,11 @@ func (h *DebugHook) Validate(message *models.GossipMessage, msgType interfaces.M
 	return true
 }
 
+// Concurrent сообщает, что сообщения можно обрабатывать в любом порядке
+func (h *DebugHook) Concurrent() bool {
+	return true
+}
+
 // Handle обрабатывает сообщение
 func (h *DebugHook) Handle(message *models.GossipMessage, msgType interfaces.MessageType) error {
 	h.logger.Infof("Debug hook received message: Type=%s, ID=%s, Origin=%s, Payload=%v",
@@ -0,0 +1,227 @@
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"concoin/conrun/pkg/interfaces"
+	"concoin/conrun/pkg/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Операции протокола внешних хуков
+const (
+	ExternalHello    = "hello"    // узнать типы сообщений, которые обрабатывает хук
+	ExternalValidate = "validate" // проверить сообщение
+	ExternalHandle   = "handle"   // обработать принятое сообщение
+)
+
+// Решения внешнего хука по сообщению
+const (
+	VerdictAccept = "accept"
+	VerdictReject = "reject"
+)
+
+// ExternalRequest запрос к внешнему хуку. По stdio запросы и ответы
+// передаются по одному JSON объекту в строке, ответ находится по ID.
+type ExternalRequest struct {
+	ID      uint64                `json:"id"`
+	Op      string                `json:"op"`
+	Hook    string                `json:"hook"`
+	Source  string                `json:"source,omitempty"` // откуда сообщение: loaded, pull или push
+	Message *models.GossipMessage `json:"message,omitempty"`
+}
+
+// ExternalResponse ответ внешнего хука
+type ExternalResponse struct {
+	ID           uint64           `json:"id"`
+	Error        string           `json:"error,omitempty"`         // хук не смог выполнить запрос
+	MessageTypes []string         `json:"message_types,omitempty"` // ответ на hello, * - все типы
+	Verdict      string           `json:"verdict,omitempty"`       // ответ на validate: accept или reject
+	Code         string           `json:"code,omitempty"`          // код причины отказа
+	Reason       string           `json:"reason,omitempty"`        // описание причины отказа
+	Messages     []DerivedMessage `json:"messages,omitempty"`      // новые сообщения, которые узел опубликует после handle
+}
+
+// DerivedMessage сообщение, созданное внешним хуком при обработке
+type DerivedMessage struct {
+	MessageType string      `json:"message_type"`
+	Payload     interface{} `json:"payload"`
+}
+
+// Publisher подписывает и рассылает новое сообщение от имени узла
+type Publisher func(messageType string, payload interface{}) error
+
+// externalTransport доставляет запросы внешнему хуку
+type externalTransport interface {
+	// start подключается к хуку и вызывает ready после каждого (пере)подключения
+	start(ctx context.Context, ready func(ctx context.Context) error) error
+	call(ctx context.Context, request *ExternalRequest) (*ExternalResponse, error)
+	stop()
+}
+
+// ExternalHook хук, который проверяет и обрабатывает сообщения во внешней
+// программе: в долгоживущем процессе по stdio или в локальном HTTP сервисе.
+// Пока хук недоступен, его сообщения отклоняются.
+type ExternalHook struct {
+	name      string
+	endpoint  string
+	timeout   time.Duration
+	transport externalTransport
+	publish   Publisher
+	logger    *logrus.Logger
+
+	typesMutex sync.RWMutex
+	types      map[string]bool
+}
+
+// NewExternalHook создает внешний хук. endpoint - адрес http:// или https://
+// либо команда запуска процесса с аргументами через пробел.
+func NewExternalHook(name, endpoint string, timeout time.Duration, logger *logrus.Logger) *ExternalHook {
+	var transport externalTransport
+	if strings.HasPrefix(endpoint, "http://") || strings.HasPrefix(endpoint, "https://") {
+		transport = newHTTPTransport(endpoint, logger)
+	} else {
+		transport = newStdioTransport(name, strings.Fields(endpoint), logger)
+	}
+
+	return &ExternalHook{
+		name:      name,
+		endpoint:  endpoint,
+		timeout:   timeout,
+		transport: transport,
+		logger:    logger,
+		types:     make(map[string]bool),
+	}
+}
+
+// SetPublisher задает публикацию сообщений, которые создает хук
+func (h *ExternalHook) SetPublisher(publish Publisher) {
+	h.publish = publish
+}
+
+// Name возвращает имя хука из конфигурации
+func (h *ExternalHook) Name() string {
+	return h.name
+}
+
+// Start подключается к хуку и узнает типы его сообщений
+func (h *ExternalHook) Start(ctx context.Context) error {
+	h.logger.Infof("Starting external hook %s: %s", h.name, h.endpoint)
+	if err := h.transport.start(ctx, h.hello); err != nil {
+		return fmt.Errorf("failed to start external hook %s: %w", h.name, err)
+	}
+	return nil
+}
+
+// Stop отключается от хука и останавливает его процесс
+func (h *ExternalHook) Stop() {
+	h.transport.stop()
+}
+
+// Concurrent сообщает, что сообщения можно обрабатывать в любом порядке:
+// протокол и так допускает ответы не по порядку запросов
+func (h *ExternalHook) Concurrent() bool {
+	return true
+}
+
+// ShouldHandle проверяет, должен ли хук обрабатывать сообщение
+func (h *ExternalHook) ShouldHandle(messageType string) bool {
+	h.typesMutex.RLock()
+	defer h.typesMutex.RUnlock()
+	return h.types[messageType] || h.types["*"]
+}
+
+// Validate проверяет валидность сообщения
+func (h *ExternalHook) Validate(message *models.GossipMessage, msgType interfaces.MessageType) bool {
+	return h.ValidateWithReason(message, msgType) == nil
+}
+
+// ValidateWithReason спрашивает решение у внешнего хука
+func (h *ExternalHook) ValidateWithReason(message *models.GossipMessage, msgType interfaces.MessageType) *models.Rejection {
+	response, err := h.call(ExternalValidate, message, msgType)
+	if err != nil {
+		h.logger.Warnf("External hook %s failed to validate %s: %v", h.name, message.MessageID, err)
+		return &models.Rejection{Code: "hook_unavailable", Reason: err.Error()}
+	}
+
+	switch response.Verdict {
+	case VerdictAccept:
+		return nil
+	case VerdictReject:
+		rejection := &models.Rejection{Code: response.Code, Reason: response.Reason}
+		if rejection.Code == "" {
+			rejection.Code = "rejected"
+		}
+		return rejection
+	default:
+		return &models.Rejection{Code: "bad_verdict", Reason: fmt.Sprintf("unknown verdict %q", response.Verdict)}
+	}
+}
+
+// Handle передает сообщение внешнему хуку и публикует созданные им сообщения
+func (h *ExternalHook) Handle(message *models.GossipMessage, msgType interfaces.MessageType) error {
+	response, err := h.call(ExternalHandle, message, msgType)
+	if err != nil {
+		return fmt.Errorf("external hook %s failed to handle %s: %w", h.name, message.MessageID, err)
+	}
+
+	for _, derived := range response.Messages {
+		if h.publish == nil {
+			return fmt.Errorf("external hook %s: no publisher for derived messages", h.name)
+		}
+		if err := h.publish(derived.MessageType, derived.Payload); err != nil {
+			return fmt.Errorf("external hook %s: failed to publish derived %s message: %w", h.name, derived.MessageType, err)
+		}
+	}
+	return nil
+}
+
+// hello узнает у хука типы сообщений, которые он обрабатывает
+func (h *ExternalHook) hello(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	response, err := h.transport.call(ctx, &ExternalRequest{Op: ExternalHello, Hook: h.name})
+	if err == nil && response.Error != "" {
+		err = fmt.Errorf("%s", response.Error)
+	}
+	if err != nil {
+		return fmt.Errorf("hello to external hook %s failed: %w", h.name, err)
+	}
+
+	types := make(map[string]bool)
+	for _, messageType := range response.MessageTypes {
+		types[messageType] = true
+	}
+	h.typesMutex.Lock()
+	h.types = types
+	h.typesMutex.Unlock()
+
+	h.logger.Infof("External hook %s handles message types %v", h.name, response.MessageTypes)
+	return nil
+}
+
+// call отправляет хуку запрос о сообщении и ждет ответ не дольше timeout
+func (h *ExternalHook) call(op string, message *models.GossipMessage, msgType interfaces.MessageType) (*ExternalResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	response, err := h.transport.call(ctx, &ExternalRequest{
+		Op:      op,
+		Hook:    h.name,
+		Source:  string(msgType),
+		Message: message,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("%s", response.Error)
+	}
+	return response, nil
+}
@@ -0,0 +1,392 @@
+package hooks
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	maxExternalResponse = 16 << 20 // наибольший ответ внешнего хука в байтах
+	externalStopWait    = 5 * time.Second
+	externalMinBackoff  = time.Second
+	externalMaxBackoff  = 30 * time.Second
+)
+
+// errHookDown процесс внешнего хука не запущен
+var errHookDown = errors.New("external hook is not running")
+
+// stdioTransport общается с долгоживущим процессом хука через stdin и stdout.
+// Упавший процесс перезапускается с нарастающей паузой.
+type stdioTransport struct {
+	name   string
+	args   []string
+	logger *logrus.Logger
+	nextID atomic.Uint64
+
+	mutex   sync.Mutex
+	process *stdioProcess // nil, пока процесс не запущен
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// stdioProcess запущенный процесс хука и ожидающие ответа запросы.
+// Запросы пишет в stdin отдельная горутина: процесс, который перестал
+// читать stdin, блокирует запись, но не вызывающих call.
+type stdioProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	writes chan []byte
+
+	mutex   sync.Mutex
+	pending map[uint64]chan *ExternalResponse
+	exited  bool
+	err     error
+	done    chan struct{}
+}
+
+func newStdioTransport(name string, args []string, logger *logrus.Logger) *stdioTransport {
+	return &stdioTransport{name: name, args: args, logger: logger}
+}
+
+// start запускает процесс и ждет ответа на первый запрос ready: процесс,
+// который не понимает протокол, - ошибка конфигурации
+func (t *stdioTransport) start(ctx context.Context, ready func(ctx context.Context) error) error {
+	process, err := t.spawn()
+	if err != nil {
+		return err
+	}
+	t.setProcess(process)
+	if err := ready(ctx); err != nil {
+		t.setProcess(nil)
+		process.shutdown()
+		return err
+	}
+
+	ctx, t.cancel = context.WithCancel(ctx)
+	t.wg.Add(1)
+	go t.supervise(ctx, process, ready)
+	return nil
+}
+
+func (t *stdioTransport) stop() {
+	if t.cancel != nil {
+		t.cancel()
+	}
+	t.wg.Wait()
+}
+
+func (t *stdioTransport) call(ctx context.Context, request *ExternalRequest) (*ExternalResponse, error) {
+	t.mutex.Lock()
+	process := t.process
+	t.mutex.Unlock()
+	if process == nil {
+		return nil, errHookDown
+	}
+
+	request.ID = t.nextID.Add(1)
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	responses := make(chan *ExternalResponse, 1)
+	if !process.register(request.ID, responses) {
+		return nil, errHookDown
+	}
+	defer process.unregister(request.ID)
+
+	select {
+	case process.writes <- append(data, '\n'):
+	case <-process.done:
+		return nil, fmt.Errorf("external hook exited: %v", process.err)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("failed to send request: %w", ctx.Err())
+	}
+
+	select {
+	case response := <-responses:
+		return response, nil
+	case <-process.done:
+		return nil, fmt.Errorf("external hook exited: %v", process.err)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// supervise перезапускает процесс хука, пока не отменен ctx
+func (t *stdioTransport) supervise(ctx context.Context, process *stdioProcess, ready func(ctx context.Context) error) {
+	defer t.wg.Done()
+
+	backoff := externalMinBackoff
+	for {
+		started := time.Now()
+		select {
+		case <-ctx.Done():
+			t.setProcess(nil)
+			process.shutdown()
+			return
+		case <-process.done:
+		}
+		t.setProcess(nil)
+		t.logger.Warnf("External hook %s exited: %v", t.name, process.err)
+
+		// Процесс, проработавший дольше наибольшей паузы, перезапускается сразу с наименьшей
+		if time.Since(started) > externalMaxBackoff {
+			backoff = externalMinBackoff
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, externalMaxBackoff)
+
+			var err error
+			if process, err = t.spawn(); err == nil {
+				break
+			}
+			t.logger.Warnf("Failed to restart external hook %s: %v", t.name, err)
+		}
+
+		t.setProcess(process)
+		if err := ready(ctx); err != nil {
+			t.logger.Warnf("%v", err)
+		}
+	}
+}
+
+func (t *stdioTransport) setProcess(process *stdioProcess) {
+	t.mutex.Lock()
+	t.process = process
+	t.mutex.Unlock()
+}
+
+// spawn запускает процесс хука. Ответы читаются из stdout, stderr пишется в лог.
+func (t *stdioTransport) spawn() (*stdioProcess, error) {
+	if len(t.args) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+
+	cmd := exec.Command(t.args[0], t.args[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	process := &stdioProcess{
+		cmd:     cmd,
+		stdin:   stdin,
+		writes:  make(chan []byte),
+		pending: make(map[uint64]chan *ExternalResponse),
+		done:    make(chan struct{}),
+	}
+	go t.writeRequests(process)
+
+	var reads sync.WaitGroup
+	reads.Add(2)
+	go func() {
+		defer reads.Done()
+		t.readResponses(process, stdout)
+	}()
+	go func() {
+		defer reads.Done()
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			t.logger.Infof("External hook %s: %s", t.name, scanner.Text())
+		}
+	}()
+	go func() {
+		// Wait закрывает stdout и stderr, поэтому вызывается после чтения всего вывода
+		reads.Wait()
+		err := cmd.Wait()
+		process.mutex.Lock()
+		process.exited = true
+		process.err = err
+		process.mutex.Unlock()
+		close(process.done)
+	}()
+	return process, nil
+}
+
+// readResponses передает ответы процесса ожидающим их запросам
+func (t *stdioTransport) readResponses(process *stdioProcess, stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64<<10), maxExternalResponse)
+	for scanner.Scan() {
+		var response ExternalResponse
+		if err := json.Unmarshal(scanner.Bytes(), &response); err != nil {
+			t.logger.Warnf("External hook %s: bad response line: %v", t.name, err)
+			continue
+		}
+		process.deliver(&response)
+	}
+	if err := scanner.Err(); err != nil {
+		t.logger.Warnf("External hook %s: failed to read responses: %v", t.name, err)
+		process.cmd.Process.Kill()
+		io.Copy(io.Discard, stdout)
+	}
+}
+
+// writeRequests пишет запросы в stdin процесса по одному, пока процесс
+// не завершится. Процесс, в stdin которого не удалось записать, завершается:
+// без запросов он бесполезен, а супервизор запустит его заново.
+func (t *stdioTransport) writeRequests(process *stdioProcess) {
+	for {
+		select {
+		case <-process.done:
+			return
+		case data := <-process.writes:
+			if _, err := process.stdin.Write(data); err != nil {
+				t.logger.Warnf("External hook %s: failed to write request: %v", t.name, err)
+				process.cmd.Process.Kill()
+				return
+			}
+		}
+	}
+}
+
+// register добавляет ожидающий ответа запрос, если процесс еще работает
+func (p *stdioProcess) register(id uint64, responses chan *ExternalResponse) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.exited {
+		return false
+	}
+	p.pending[id] = responses
+	return true
+}
+
+func (p *stdioProcess) unregister(id uint64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.pending, id)
+}
+
+// deliver отдает ответ запросу с тем же ID. Ответы на запросы, которые
+// уже не ждут, отбрасываются.
+func (p *stdioProcess) deliver(response *ExternalResponse) {
+	p.mutex.Lock()
+	responses, exists := p.pending[response.ID]
+	delete(p.pending, response.ID)
+	p.mutex.Unlock()
+
+	if exists {
+		responses <- response
+	}
+}
+
+// shutdown закрывает stdin процесса и ждет его завершения, затем завершает принудительно
+func (p *stdioProcess) shutdown() {
+	p.stdin.Close()
+	select {
+	case <-p.done:
+	case <-time.After(externalStopWait):
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+}
+
+// httpTransport отправляет запросы локальному HTTP сервису хука: каждый
+// запрос - POST с JSON телом, ответ - JSON объект
+type httpTransport struct {
+	url    string
+	client *http.Client
+	logger *logrus.Logger
+	nextID atomic.Uint64
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newHTTPTransport(url string, logger *logrus.Logger) *httpTransport {
+	return &httpTransport{url: url, client: &http.Client{}, logger: logger}
+}
+
+// start повторяет ready в фоне, пока сервис хука не ответит
+func (t *httpTransport) start(ctx context.Context, ready func(ctx context.Context) error) error {
+	ctx, t.cancel = context.WithCancel(ctx)
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+
+		backoff := externalMinBackoff
+		for {
+			err := ready(ctx)
+			if err == nil {
+				return
+			}
+			t.logger.Warnf("%v", err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, externalMaxBackoff)
+		}
+	}()
+	return nil
+}
+
+func (t *httpTransport) stop() {
+	if t.cancel != nil {
+		t.cancel()
+	}
+	t.wg.Wait()
+}
+
+func (t *httpTransport) call(ctx context.Context, request *ExternalRequest) (*ExternalResponse, error) {
+	request.ID = t.nextID.Add(1)
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+
+	httpResponse, err := t.client.Do(httpRequest)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(httpResponse.Body, 1024))
+		return nil, fmt.Errorf("status %d: %s", httpResponse.StatusCode, bytes.TrimSpace(body))
+	}
+
+	var response ExternalResponse
+	if err := json.NewDecoder(io.LimitReader(httpResponse.Body, maxExternalResponse)).Decode(&response); err != nil {
+		return nil, fmt.Errorf("bad response: %w", err)
+	}
+	return &response, nil
+}
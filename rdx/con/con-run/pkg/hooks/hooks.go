@@ -1,6 +1,15 @@
 package hooks
 
 import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"concoin/conrun/pkg/config"
 	"concoin/conrun/pkg/interfaces"
 
 	"concoin/conrun/pkg/models"
@@ -8,84 +17,524 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// maxRejections ограничивает число сообщений, для которых хранятся причины отказа
+const maxRejections = 1000
+
+// Результаты проверки хранятся до обработки сообщения, но не дольше
+// validationTTL: состояние узла за это время могло измениться
+const (
+	maxValidations = 1000
+	validationTTL  = time.Minute
+)
+
+// Правила проверки сообщения несколькими хуками
+const (
+	PolicyAll = "all" // сообщение должны принять все подходящие хуки, отказ любого из них - вето
+	PolicyAny = "any" // достаточно, чтобы сообщение принял один хук
+)
+
 // HookManager управляет всеми хуками
 type HookManager struct {
-	hooks   []interfaces.Hook
-	logger  *logrus.Logger
-	rootDir string
+	hooks          []*hookEntry // по убыванию приоритета, при равном - в порядке добавления
+	config         config.HooksConfig
+	mutex          sync.RWMutex
+	logger         *logrus.Logger
+	rootDir        string
+	rejections     map[string][]models.Rejection
+	rejectionOrder []string
+	rejectionMutex sync.RWMutex
+
+	validations     map[validationKey]*validation
+	validationMutex sync.Mutex
+
+	wg sync.WaitGroup
+}
+
+// hookEntry хук со своими настройками и счетчиками
+type hookEntry struct {
+	hook     interfaces.Hook
+	name     string
+	order    int
+	priority int
+	queue    chan handleJob // nil, если хук обрабатывает сообщения в вызывающей горутине
+	workers  int
+	// queueMutex защищает queue и workers. Отправка в очередь идет под
+	// RLock, чтобы Stop не закрыл очередь под ждущим места сообщением.
+	queueMutex sync.RWMutex
+
+	validated  atomic.Int64
+	rejected   atomic.Int64
+	handled    atomic.Int64
+	failed     atomic.Int64
+	overflows  atomic.Int64
+	handleTime atomic.Int64
+}
+
+// handleJob сообщение в очереди хука
+type handleJob struct {
+	message *models.GossipMessage
+	msgType interfaces.MessageType
+}
+
+// validationKey определяет проверку сообщения
+type validationKey struct {
+	messageID string
+	msgType   interfaces.MessageType
+}
+
+// validation результат проверки, который ProcessMessage использует
+// вместо повторной проверки того же сообщения
+type validation struct {
+	message *models.GossipMessage
+	passed  []*hookEntry
+	at      time.Time
 }
 
 // NewHookManager создает новый менеджер хуков
 func NewHookManager(rootDir string, logger *logrus.Logger) *HookManager {
 	return &HookManager{
-		hooks:   make([]interfaces.Hook, 0),
-		logger:  logger,
-		rootDir: rootDir,
+		hooks:       make([]*hookEntry, 0),
+		config:      config.HooksConfig{Policy: PolicyAll},
+		logger:      logger,
+		rootDir:     rootDir,
+		rejections:  make(map[string][]models.Rejection),
+		validations: make(map[validationKey]*validation),
 	}
 }
 
+// SetConfig задает правила проверки и приоритеты хуков. Число фоновых
+// обработчиков и размер очередей применяются при запуске.
+func (hm *HookManager) SetConfig(cfg config.HooksConfig) {
+	hm.mutex.Lock()
+	defer hm.mutex.Unlock()
+
+	hm.config = cfg
+	for _, entry := range hm.hooks {
+		entry.priority = hm.priorityOf(entry.hook, entry.name)
+	}
+	hm.sortHooks()
+}
+
 // AddHook добавляет новый хук
 func (hm *HookManager) AddHook(hook interfaces.Hook) {
-	hm.hooks = append(hm.hooks, hook)
+	hm.mutex.Lock()
+	defer hm.mutex.Unlock()
+
+	name := hookName(hook)
+	hm.hooks = append(hm.hooks, &hookEntry{
+		hook:     hook,
+		name:     name,
+		order:    len(hm.hooks),
+		priority: hm.priorityOf(hook, name),
+	})
+	hm.sortHooks()
 }
 
-// ValidateMessage проверяет валидность сообщения через все подходящие хуки
-func (hm *HookManager) ValidateMessage(message *models.GossipMessage, msgType interfaces.MessageType) bool {
-	// Проверяем, есть ли хотя бы один хук, который должен обрабатывать это сообщение
-	hasHandler := false
-	for _, hook := range hm.hooks {
-		if hook.ShouldHandle(message.MessageType) {
-			hasHandler = true
-			break
+// Start запускает хуки с фоновой работой (interfaces.Component) и фоновые
+// обработчики хуков, для которых они настроены. Фоновые обработчики можно
+// задать только хукам interfaces.ConcurrentHook. До запуска и после остановки
+// хуки обрабатывают сообщения в вызывающей горутине.
+func (hm *HookManager) Start(ctx context.Context) error {
+	hm.mutex.Lock()
+	defer hm.mutex.Unlock()
+
+	for _, entry := range hm.hooks {
+		if hm.config.Workers[entry.name] <= 0 {
+			continue
+		}
+		// Обработчики берут сообщения параллельно с проверкой следующих,
+		// поэтому хук с состоянием проверял бы их до применения предыдущих
+		if concurrent, ok := entry.hook.(interfaces.ConcurrentHook); !ok || !concurrent.Concurrent() {
+			return fmt.Errorf("hook %s handles messages in order, hooks.workers cannot be set for it", entry.name)
+		}
+	}
+
+	for i, entry := range hm.hooks {
+		component, ok := entry.hook.(interfaces.Component)
+		if !ok {
+			continue
+		}
+		if err := component.Start(ctx); err != nil {
+			for _, started := range hm.hooks[:i] {
+				if component, ok := started.hook.(interfaces.Component); ok {
+					component.Stop()
+				}
+			}
+			return err
+		}
+	}
+
+	for _, entry := range hm.hooks {
+		workers := hm.config.Workers[entry.name]
+		if workers <= 0 {
+			continue
+		}
+		hm.logger.Infof("Starting %d workers for %s", workers, entry.name)
+		queue := make(chan handleJob, hm.config.QueueSize)
+		entry.queueMutex.Lock()
+		entry.queue = queue
+		entry.workers = workers
+		entry.queueMutex.Unlock()
+		for i := 0; i < workers; i++ {
+			hm.wg.Add(1)
+			go hm.worker(entry, queue)
 		}
 	}
+	return nil
+}
 
-	if !hasHandler {
+// Stop останавливает фоновые обработчики и затем хуки с фоновой работой.
+// Сообщения, оставшиеся в очередях, обрабатываются по порядку до возврата.
+func (hm *HookManager) Stop() {
+	hm.mutex.RLock()
+	entries := append([]*hookEntry(nil), hm.hooks...)
+	hm.mutex.RUnlock()
+
+	// Обработчики продолжают разбирать очереди, пока Lock ждет сообщения,
+	// которые ждут места в очереди
+	for _, entry := range entries {
+		entry.queueMutex.Lock()
+		if entry.queue != nil {
+			close(entry.queue)
+			entry.queue = nil
+			entry.workers = 0
+		}
+		entry.queueMutex.Unlock()
+	}
+	hm.wg.Wait()
+
+	hm.mutex.RLock()
+	defer hm.mutex.RUnlock()
+	for _, entry := range hm.hooks {
+		if component, ok := entry.hook.(interfaces.Component); ok {
+			component.Stop()
+		}
+	}
+}
+
+// ValidateMessage проверяет валидность сообщения через подходящие хуки по
+// правилу его типа. Результат запоминается для ProcessMessage.
+func (hm *HookManager) ValidateMessage(message *models.GossipMessage, msgType interfaces.MessageType) bool {
+	entries := hm.matching(message.MessageType)
+	if len(entries) == 0 {
 		hm.logger.Infof("HookManager: ValidateMessage: no handler for message: %s", message.MessageID)
+		hm.saveRejections(message.MessageID, []models.Rejection{noHandlerRejection(message)})
 		return false
 	}
 
-	// Проверяем валидность через все подходящие хуки
-	for _, hook := range hm.hooks {
-		if hook.ShouldHandle(message.MessageType) {
-			if hook.Validate(message, msgType) {
-				return true // Достаточно одного валидного хука
-			}
-		}
+	passed, rejections := hm.validate(entries, message, msgType)
+	if len(passed) == 0 {
+		hm.saveRejections(message.MessageID, rejections)
+		return false
 	}
 
-	return false
+	hm.clearRejections(message.MessageID)
+	hm.saveValidation(message, msgType, passed)
+	return true
 }
 
-// ProcessMessage обрабатывает сообщение через все подходящие хуки
+// ProcessMessage обрабатывает сообщение хуками, которые его приняли.
+// Если сообщение уже проверено ValidateMessage, проверка не повторяется.
 func (hm *HookManager) ProcessMessage(message *models.GossipMessage, msgType interfaces.MessageType) bool {
-	// Проверяем, есть ли хотя бы один хук, который должен обрабатывать это сообщение
-	hasHandler := false
-	for _, hook := range hm.hooks {
-		if hook.ShouldHandle(message.MessageType) {
-			hasHandler = true
-			break
+	passed, cached := hm.takeValidation(message, msgType)
+	if !cached {
+		entries := hm.matching(message.MessageType)
+		if len(entries) == 0 {
+			hm.logger.Infof("HookManager: ProcessMessage: no handler for message: %s", message.MessageID)
+			hm.saveRejections(message.MessageID, []models.Rejection{noHandlerRejection(message)})
+			return false
+		}
+
+		var rejections []models.Rejection
+		passed, rejections = hm.validate(entries, message, msgType)
+		if len(passed) == 0 {
+			hm.saveRejections(message.MessageID, rejections)
+			return false
 		}
+		hm.clearRejections(message.MessageID)
 	}
 
-	if !hasHandler {
-		hm.logger.Infof("HookManager: ProcessMessage: no handler for message: %s", message.MessageID)
-		return false
+	for _, entry := range passed {
+		hm.dispatch(entry, handleJob{message: message, msgType: msgType})
 	}
+	return true
+}
 
-	// Проверяем валидность через все подходящие хуки
-	isValid := false
-	for _, hook := range hm.hooks {
-		if hook.ShouldHandle(message.MessageType) {
-			if hook.Validate(message, msgType) {
-				isValid = true
-				// Обрабатываем сообщение
-				if err := hook.Handle(message, msgType); err != nil {
-					hm.logger.Errorf("Failed to handle message with hook: %v", err)
-				}
+// HookStats возвращает счетчики хуков в порядке их вызова
+func (hm *HookManager) HookStats() []models.HookStats {
+	hm.mutex.RLock()
+	defer hm.mutex.RUnlock()
+
+	stats := make([]models.HookStats, 0, len(hm.hooks))
+	for _, entry := range hm.hooks {
+		stat := models.HookStats{
+			Hook:       entry.name,
+			Priority:   entry.priority,
+			Validated:  entry.validated.Load(),
+			Rejected:   entry.rejected.Load(),
+			Handled:    entry.handled.Load(),
+			Failed:     entry.failed.Load(),
+			Overflows:  entry.overflows.Load(),
+			HandleTime: time.Duration(entry.handleTime.Load()),
+		}
+		entry.queueMutex.RLock()
+		if entry.queue != nil {
+			stat.Workers = entry.workers
+			stat.Queued = len(entry.queue)
+			stat.QueueSize = cap(entry.queue)
+		}
+		entry.queueMutex.RUnlock()
+		stats = append(stats, stat)
+	}
+	return stats
+}
+
+// matching возвращает хуки, которые обрабатывают сообщения типа messageType
+func (hm *HookManager) matching(messageType string) []*hookEntry {
+	hm.mutex.RLock()
+	defer hm.mutex.RUnlock()
+
+	var entries []*hookEntry
+	for _, entry := range hm.hooks {
+		if entry.hook.ShouldHandle(messageType) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// validate проверяет сообщение хуками entries по правилу его типа и
+// возвращает хуки, принявшие сообщение. При правиле all отказ любого хука
+// означает отказ, остальные хуки не вызываются. При правиле any
+// вызываются все хуки, чтобы сообщение обработали все принявшие его.
+func (hm *HookManager) validate(entries []*hookEntry, message *models.GossipMessage, msgType interfaces.MessageType) ([]*hookEntry, []models.Rejection) {
+	policy := hm.policy(message.MessageType)
+
+	var passed []*hookEntry
+	var rejections []models.Rejection
+	for _, entry := range entries {
+		rejection := hm.validateWithHook(entry.hook, message, msgType)
+		if rejection == nil {
+			entry.validated.Add(1)
+			passed = append(passed, entry)
+			continue
+		}
+
+		entry.rejected.Add(1)
+		rejections = append(rejections, *rejection)
+		if policy == PolicyAll {
+			return nil, rejections
+		}
+	}
+
+	if len(passed) == 0 {
+		return nil, rejections
+	}
+	return passed, nil
+}
+
+// policy возвращает правило проверки сообщений типа messageType
+func (hm *HookManager) policy(messageType string) string {
+	hm.mutex.RLock()
+	defer hm.mutex.RUnlock()
+
+	if policy, exists := hm.config.Policies[messageType]; exists {
+		return policy
+	}
+	return hm.config.Policy
+}
+
+// dispatch ставит сообщение в очередь хука. Если очередь полна, вызывающая
+// горутина ждет места: обработка на месте обогнала бы сообщения в очереди.
+// Без фоновых обработчиков сообщение обрабатывается в вызывающей горутине.
+func (hm *HookManager) dispatch(entry *hookEntry, job handleJob) {
+	entry.queueMutex.RLock()
+	if queue := entry.queue; queue != nil {
+		select {
+		case queue <- job:
+		default:
+			entry.overflows.Add(1)
+			hm.logger.Warnf("HookManager: queue of %s is full, message %s waits for a free slot", entry.name, job.message.MessageID)
+			queue <- job
+		}
+		entry.queueMutex.RUnlock()
+		return
+	}
+	entry.queueMutex.RUnlock()
+
+	hm.handle(entry, job)
+}
+
+// worker обрабатывает сообщения из очереди хука, пока Stop ее не закроет
+func (hm *HookManager) worker(entry *hookEntry, queue chan handleJob) {
+	defer hm.wg.Done()
+	for job := range queue {
+		hm.handle(entry, job)
+	}
+}
+
+// handle вызывает Handle хука и обновляет счетчики
+func (hm *HookManager) handle(entry *hookEntry, job handleJob) {
+	start := time.Now()
+	err := entry.hook.Handle(job.message, job.msgType)
+	entry.handleTime.Add(int64(time.Since(start)))
+	entry.handled.Add(1)
+	if err != nil {
+		entry.failed.Add(1)
+		hm.logger.Errorf("Failed to handle message with hook: %v", err)
+	}
+}
+
+// saveValidation запоминает хуки, принявшие сообщение
+func (hm *HookManager) saveValidation(message *models.GossipMessage, msgType interfaces.MessageType, passed []*hookEntry) {
+	hm.validationMutex.Lock()
+	defer hm.validationMutex.Unlock()
+
+	now := time.Now()
+	if len(hm.validations) >= maxValidations {
+		for key, cached := range hm.validations {
+			if now.Sub(cached.at) > validationTTL {
+				delete(hm.validations, key)
 			}
 		}
 	}
+	if len(hm.validations) >= maxValidations {
+		// Сообщения, которые проверили, но не обработали, вытесняются
+		for key := range hm.validations {
+			delete(hm.validations, key)
+			break
+		}
+	}
+	hm.validations[validationKey{message.MessageID, msgType}] = &validation{
+		message: message,
+		passed:  passed,
+		at:      now,
+	}
+}
+
+// takeValidation забирает результат проверки того же сообщения, если он
+// свежий. Результат проверки сообщения с тем же ID, но другим содержимым
+// не используется.
+func (hm *HookManager) takeValidation(message *models.GossipMessage, msgType interfaces.MessageType) ([]*hookEntry, bool) {
+	hm.validationMutex.Lock()
+	defer hm.validationMutex.Unlock()
+
+	key := validationKey{message.MessageID, msgType}
+	cached, exists := hm.validations[key]
+	if !exists {
+		return nil, false
+	}
+	delete(hm.validations, key)
+	if cached.message != message || time.Since(cached.at) > validationTTL {
+		return nil, false
+	}
+	return cached.passed, true
+}
+
+// sortHooks упорядочивает хуки по убыванию приоритета
+func (hm *HookManager) sortHooks() {
+	sort.SliceStable(hm.hooks, func(i, j int) bool {
+		if hm.hooks[i].priority != hm.hooks[j].priority {
+			return hm.hooks[i].priority > hm.hooks[j].priority
+		}
+		return hm.hooks[i].order < hm.hooks[j].order
+	})
+}
+
+// priorityOf возвращает приоритет хука: из конфигурации, иначе собственный
+func (hm *HookManager) priorityOf(hook interfaces.Hook, name string) int {
+	if priority, exists := hm.config.Priorities[name]; exists {
+		return priority
+	}
+	if prioritized, ok := hook.(interfaces.PrioritizedHook); ok {
+		return prioritized.Priority()
+	}
+	return 0
+}
+
+// GetRejections возвращает причины, по которым хуки отклонили сообщение
+func (hm *HookManager) GetRejections(messageID string) []models.Rejection {
+	hm.rejectionMutex.RLock()
+	defer hm.rejectionMutex.RUnlock()
+
+	rejections := hm.rejections[messageID]
+	result := make([]models.Rejection, len(rejections))
+	copy(result, rejections)
+	return result
+}
+
+// validateWithHook проверяет сообщение одним хуком и возвращает причину отказа
+func (hm *HookManager) validateWithHook(hook interfaces.Hook, message *models.GossipMessage, msgType interfaces.MessageType) *models.Rejection {
+	hookName := hookName(hook)
+
+	if reasoningHook, ok := hook.(interfaces.ReasoningHook); ok {
+		rejection := reasoningHook.ValidateWithReason(message, msgType)
+		if rejection != nil {
+			rejection.Hook = hookName
+			hm.logger.Infof("HookManager: %s rejected message %s: %s (%s)",
+				hookName, message.MessageID, rejection.Code, rejection.Reason)
+		}
+		return rejection
+	}
+
+	if hook.Validate(message, msgType) {
+		return nil
+	}
+	return &models.Rejection{
+		Hook:   hookName,
+		Code:   "rejected",
+		Reason: "hook rejected the message",
+	}
+}
+
+// saveRejections запоминает причины отказа, вытесняя самые старые записи
+func (hm *HookManager) saveRejections(messageID string, rejections []models.Rejection) {
+	hm.rejectionMutex.Lock()
+	defer hm.rejectionMutex.Unlock()
+
+	if _, exists := hm.rejections[messageID]; !exists {
+		if len(hm.rejectionOrder) >= maxRejections {
+			delete(hm.rejections, hm.rejectionOrder[0])
+			hm.rejectionOrder = hm.rejectionOrder[1:]
+		}
+		hm.rejectionOrder = append(hm.rejectionOrder, messageID)
+	}
+	hm.rejections[messageID] = rejections
+}
 
-	return isValid
+// clearRejections забывает причины отказа для сообщения, прошедшего проверку
+func (hm *HookManager) clearRejections(messageID string) {
+	hm.rejectionMutex.Lock()
+	defer hm.rejectionMutex.Unlock()
+
+	if _, exists := hm.rejections[messageID]; !exists {
+		return
+	}
+	delete(hm.rejections, messageID)
+	for i, id := range hm.rejectionOrder {
+		if id == messageID {
+			hm.rejectionOrder = append(hm.rejectionOrder[:i], hm.rejectionOrder[i+1:]...)
+			break
+		}
+	}
+}
+
+// noHandlerRejection описывает отказ из-за отсутствия подходящего хука
+func noHandlerRejection(message *models.GossipMessage) models.Rejection {
+	return models.Rejection{
+		Code:   "no_handler",
+		Reason: fmt.Sprintf("no hook handles message type %q", message.MessageType),
+	}
+}
+
+// hookName возвращает имя хука, по умолчанию - имя его типа без пакета
+func hookName(hook interfaces.Hook) string {
+	if named, ok := hook.(interfaces.NamedHook); ok {
+		return named.Name()
+	}
+	name := fmt.Sprintf("%T", hook)
+	if idx := strings.LastIndex(name, "."); idx >= 0 {
+		name = name[idx+1:]
+	}
+	return name
 }
@@ -1,11 +1,24 @@
 package tests
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"concoin/conrun/pkg/blockchain"
+	"concoin/conrun/pkg/chain"
+	"concoin/conrun/pkg/config"
 	"concoin/conrun/pkg/hooks"
 	"concoin/conrun/pkg/interfaces"
+	"concoin/conrun/pkg/mempool"
 	"concoin/conrun/pkg/models"
 
 	"github.com/sirupsen/logrus"
@@ -97,6 +110,7 @@ func TestHookManager(t *testing.T) {
 	// Test ValidateMessage with matching hook that passes validation
 	mockHook1.On("ShouldHandle", "test_type").Return(true)
 	mockHook1.On("Validate", message, interfaces.MessageTypePull).Return(true)
+	mockHook2.On("ShouldHandle", "test_type").Return(false)
 
 	result = hookManager.ValidateMessage(message, interfaces.MessageTypePull)
 	if !result {
@@ -105,6 +119,7 @@ func TestHookManager(t *testing.T) {
 
 	// Reset mocks
 	mockHook1.AssertExpectations(t)
+	mockHook2.AssertExpectations(t)
 	mockHook1 = new(MockHook)
 	mockHook2 = new(MockHook)
 	hookManager = hooks.NewHookManager("/tmp/test", logger)
@@ -126,6 +141,255 @@ func TestHookManager(t *testing.T) {
 	mockHook2.AssertExpectations(t)
 }
 
+// callLog collects hook calls in order
+type callLog struct {
+	mutex sync.Mutex
+	calls []string
+}
+
+func (l *callLog) add(call string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.calls = append(l.calls, call)
+}
+
+func (l *callLog) String() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return fmt.Sprint(l.calls)
+}
+
+// recordingHook handles test_type messages and records its calls
+type recordingHook struct {
+	name     string
+	accept   bool
+	priority int
+	log      *callLog
+}
+
+func (h *recordingHook) ShouldHandle(messageType string) bool { return messageType == "test_type" }
+func (h *recordingHook) Priority() int                        { return h.priority }
+
+func (h *recordingHook) Validate(message *models.GossipMessage, msgType interfaces.MessageType) bool {
+	h.log.add("validate " + h.name)
+	return h.accept
+}
+
+func (h *recordingHook) Handle(message *models.GossipMessage, msgType interfaces.MessageType) error {
+	h.log.add("handle " + h.name)
+	return nil
+}
+
+// strictHook and permissiveHook are distinct types, so they have their own names in the config
+type strictHook struct{ recordingHook }
+type permissiveHook struct{ recordingHook }
+
+func newTestHooks(log *callLog) (*strictHook, *permissiveHook) {
+	return &strictHook{recordingHook{name: "strict", log: log}},
+		&permissiveHook{recordingHook{name: "permissive", accept: true, priority: 10, log: log}}
+}
+
+func testMessage(id string) *models.GossipMessage {
+	return &models.GossipMessage{MessageID: id, MessageType: "test_type", Payload: map[string]interface{}{}}
+}
+
+func TestHookManager_Policies(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	log := &callLog{}
+	strict, permissive := newTestHooks(log)
+	hookManager := hooks.NewHookManager(t.TempDir(), logger)
+	hookManager.AddHook(strict)
+	hookManager.AddHook(permissive)
+
+	// By default every hook must accept the message, hooks run by priority
+	if hookManager.ValidateMessage(testMessage("a"), interfaces.MessageTypePull) {
+		t.Errorf("Expected the strict hook to veto the message")
+	}
+	if log.String() != "[validate permissive validate strict]" {
+		t.Errorf("Unexpected calls %s", log)
+	}
+	rejections := hookManager.GetRejections("a")
+	if len(rejections) != 1 || rejections[0].Hook != "strictHook" {
+		t.Errorf("Expected a rejection from strictHook, got %+v", rejections)
+	}
+
+	// A veto stops the validation, hooks after it are not called
+	cfg := config.DefaultConfig(3000, 0).HooksConfig
+	cfg.Priorities = map[string]int{"strictHook": 20}
+	hookManager.SetConfig(cfg)
+	log.calls = nil
+	hookManager.ValidateMessage(testMessage("b"), interfaces.MessageTypePull)
+	if log.String() != "[validate strict]" {
+		t.Errorf("Expected only the strict hook to be called, got %s", log)
+	}
+
+	// With the any policy one hook is enough, only the hooks that accepted handle the message
+	cfg.Policies = map[string]string{"test_type": hooks.PolicyAny}
+	hookManager.SetConfig(cfg)
+	log.calls = nil
+	if !hookManager.ProcessMessage(testMessage("c"), interfaces.MessageTypePush) {
+		t.Errorf("Expected the permissive hook to accept the message")
+	}
+	if log.String() != "[validate strict validate permissive handle permissive]" {
+		t.Errorf("Unexpected calls %s", log)
+	}
+	if rejections := hookManager.GetRejections("c"); len(rejections) != 0 {
+		t.Errorf("Expected no rejections, got %+v", rejections)
+	}
+
+	stats := hookManager.HookStats()
+	if len(stats) != 2 || stats[0].Hook != "strictHook" || stats[0].Priority != 20 || stats[0].Rejected != 3 ||
+		stats[1].Hook != "permissiveHook" || stats[1].Validated != 2 || stats[1].Handled != 1 {
+		t.Errorf("Unexpected hook stats %+v", stats)
+	}
+}
+
+func TestHookManager_ValidationCache(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	log := &callLog{}
+	_, permissive := newTestHooks(log)
+	hookManager := hooks.NewHookManager(t.TempDir(), logger)
+	hookManager.AddHook(permissive)
+
+	// The result of ValidateMessage is reused by ProcessMessage
+	message := testMessage("a")
+	hookManager.ValidateMessage(message, interfaces.MessageTypePull)
+	hookManager.ProcessMessage(message, interfaces.MessageTypePull)
+	if log.String() != "[validate permissive handle permissive]" {
+		t.Errorf("Expected a single validation, got %s", log)
+	}
+
+	// The result is used once, for the same message and message type only
+	log.calls = nil
+	hookManager.ValidateMessage(message, interfaces.MessageTypePull)
+	hookManager.ProcessMessage(message, interfaces.MessageTypeLoaded)
+	hookManager.ProcessMessage(testMessage("a"), interfaces.MessageTypePull)
+	hookManager.ProcessMessage(message, interfaces.MessageTypePull)
+	expected := "[validate permissive validate permissive handle permissive validate permissive handle permissive validate permissive handle permissive]"
+	if log.String() != expected {
+		t.Errorf("Expected every other call to validate again, got %s", log)
+	}
+}
+
+// blockingHook handles test_type messages until it is released
+type blockingHook struct {
+	release chan struct{}
+	handled chan string
+}
+
+func (h *blockingHook) ShouldHandle(messageType string) bool { return messageType == "test_type" }
+func (h *blockingHook) Validate(message *models.GossipMessage, msgType interfaces.MessageType) bool {
+	return true
+}
+func (h *blockingHook) Handle(message *models.GossipMessage, msgType interfaces.MessageType) error {
+	<-h.release
+	h.handled <- message.MessageID
+	return nil
+}
+func (h *blockingHook) Concurrent() bool { return true }
+
+// orderedHook handles test_type messages and needs them in order
+type orderedHook struct{}
+
+func (orderedHook) ShouldHandle(messageType string) bool { return messageType == "test_type" }
+func (orderedHook) Validate(message *models.GossipMessage, msgType interfaces.MessageType) bool {
+	return true
+}
+func (orderedHook) Handle(message *models.GossipMessage, msgType interfaces.MessageType) error {
+	return nil
+}
+
+func TestHookManager_Workers(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	hook := &blockingHook{release: make(chan struct{}), handled: make(chan string, 10)}
+	hookManager := hooks.NewHookManager(t.TempDir(), logger)
+	hookManager.AddHook(hook)
+	cfg := config.DefaultConfig(3000, 0).HooksConfig
+	cfg.Workers = map[string]int{"blockingHook": 1}
+	cfg.QueueSize = 1
+	hookManager.SetConfig(cfg)
+	if err := hookManager.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start hook manager: %v", err)
+	}
+
+	// Handling does not block the caller: the worker takes the first
+	// message, the second one waits in the queue
+	done := make(chan struct{})
+	go func() {
+		hookManager.ProcessMessage(testMessage("a"), interfaces.MessageTypePush)
+		for hookManager.HookStats()[0].Queued != 0 {
+			time.Sleep(time.Millisecond)
+		}
+		hookManager.ProcessMessage(testMessage("b"), interfaces.MessageTypePush)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected ProcessMessage to return before the hook finished")
+	}
+	stats := hookManager.HookStats()[0]
+	if stats.Workers != 1 || stats.Queued != 1 || stats.QueueSize != 1 || stats.Handled != 0 {
+		t.Errorf("Unexpected stats with a busy worker %+v", stats)
+	}
+
+	// With a full queue the caller waits for a free slot instead of
+	// handling the message ahead of the queued one
+	overflow := make(chan struct{})
+	go func() {
+		hookManager.ProcessMessage(testMessage("c"), interfaces.MessageTypePush)
+		close(overflow)
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for hookManager.HookStats()[0].Overflows != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the queue to overflow")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-overflow:
+		t.Fatalf("Expected ProcessMessage to wait while the queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Stop waits for the queued messages
+	close(hook.release)
+	<-overflow
+	hookManager.Stop()
+	if len(hook.handled) != 3 {
+		t.Fatalf("Expected all messages to be handled before Stop returns, got %d", len(hook.handled))
+	}
+	if order := []string{<-hook.handled, <-hook.handled, <-hook.handled}; fmt.Sprint(order) != "[a b c]" {
+		t.Errorf("Expected messages to be handled in order, got %v", order)
+	}
+	stats = hookManager.HookStats()[0]
+	if stats.Handled != 3 || stats.Workers != 0 || stats.Overflows != 1 {
+		t.Errorf("Unexpected stats after stop %+v", stats)
+	}
+
+	// After Stop messages are handled in place
+	hookManager.ProcessMessage(testMessage("d"), interfaces.MessageTypePush)
+	if len(hook.handled) != 1 {
+		t.Errorf("Expected the message to be handled in place")
+	}
+
+	// Hooks that need messages in order cannot have workers
+	ordered := hooks.NewHookManager(t.TempDir(), logger)
+	ordered.AddHook(orderedHook{})
+	cfg.Workers = map[string]int{"orderedHook": 2}
+	ordered.SetConfig(cfg)
+	if err := ordered.Start(context.Background()); err == nil || !strings.Contains(err.Error(), "orderedHook") {
+		t.Errorf("Expected workers of orderedHook to be rejected, got %v", err)
+	}
+}
+
 func TestDebugHook(t *testing.T) {
 	// Create logger for testing
 	logger := logrus.New()
@@ -162,3 +426,261 @@ func TestDebugHook(t *testing.T) {
 		t.Errorf("Debug hook Handle failed: %v", err)
 	}
 }
+
+func TestBlockchainHookRejections(t *testing.T) {
+	// Create logger for testing
+	logger := logrus.New()
+	logger.SetOutput(logrus.StandardLogger().Out)
+
+	hookManager := hooks.NewHookManager(t.TempDir(), logger)
+	chainState, err := chain.NewChain(t.TempDir(), logger)
+	if err != nil {
+		t.Fatalf("Failed to create chain: %v", err)
+	}
+	txPool, err := mempool.NewMempool(t.TempDir(), config.DefaultConfig(3000, 0).MempoolConfig, chainState, logger)
+	if err != nil {
+		t.Fatalf("Failed to create mempool: %v", err)
+	}
+	hookManager.AddHook(hooks.NewBlockchainHook(chainState, txPool, logger))
+
+	message := &models.GossipMessage{
+		MessageID:   "test-tx-id",
+		OriginID:    "test-node-id",
+		Timestamp:   time.Now().UTC(),
+		TTL:         5,
+		MessageType: blockchain.MessageType,
+		Payload:     map[string]interface{}{"from": "Alice", "to": "Bob", "amount": 10},
+	}
+
+	if hookManager.ValidateMessage(message, interfaces.MessageTypePush) {
+		t.Fatalf("Transaction from unknown sender should be rejected")
+	}
+
+	rejections := hookManager.GetRejections(message.MessageID)
+	if len(rejections) != 1 {
+		t.Fatalf("Expected 1 rejection, got %d", len(rejections))
+	}
+	if rejections[0].Hook != "BlockchainHook" {
+		t.Errorf("Expected rejection from BlockchainHook, got %s", rejections[0].Hook)
+	}
+	if rejections[0].Code != string(blockchain.RejectUnknownSender) {
+		t.Errorf("Expected code %s, got %s", blockchain.RejectUnknownSender, rejections[0].Code)
+	}
+
+	message.Payload = "not an object"
+	if hookManager.ValidateMessage(message, interfaces.MessageTypePush) {
+		t.Fatalf("Malformed payload should be rejected")
+	}
+	rejections = hookManager.GetRejections(message.MessageID)
+	if len(rejections) != 1 || rejections[0].Code != string(blockchain.RejectMalformed) {
+		t.Errorf("Expected malformed rejection, got %+v", rejections)
+	}
+
+	// A payload decoded on receipt is used as is, the raw payload is not parsed again
+	message.Decoded = &blockchain.Payload{Transaction: &blockchain.Transaction{From: "Carol", To: "Bob", Amount: 1}}
+	if hookManager.ValidateMessage(message, interfaces.MessageTypePush) {
+		t.Fatalf("Transaction from unknown sender should be rejected")
+	}
+	rejections = hookManager.GetRejections(message.MessageID)
+	if len(rejections) != 1 || rejections[0].Code != string(blockchain.RejectUnknownSender) {
+		t.Errorf("Expected the decoded payload to be validated, got %+v", rejections)
+	}
+}
+
+// externalHookReply answers a request to the test external hook
+func externalHookReply(request hooks.ExternalRequest) hooks.ExternalResponse {
+	response := hooks.ExternalResponse{ID: request.ID}
+	switch request.Op {
+	case hooks.ExternalHello:
+		response.MessageTypes = []string{"test_type"}
+	case hooks.ExternalValidate:
+		text, _ := request.Message.Payload.(map[string]interface{})["text"].(string)
+		switch text {
+		case "bad":
+			response.Verdict, response.Code, response.Reason = hooks.VerdictReject, "bad_text", "text is bad"
+		case "crash":
+			os.Exit(3)
+		default:
+			response.Verdict = hooks.VerdictAccept
+		}
+	case hooks.ExternalHandle:
+		if request.Source != string(interfaces.MessageTypePush) {
+			response.Error = "unexpected source " + request.Source
+			break
+		}
+		response.Messages = []hooks.DerivedMessage{{MessageType: "derived", Payload: request.Message.MessageID}}
+	}
+	return response
+}
+
+// TestExternalHookProcess is not a test: it runs as the external hook process
+// started by TestExternalHook_Stdio
+func TestExternalHookProcess(t *testing.T) {
+	if os.Getenv("CONRUN_EXTERNAL_HOOK_PROCESS") != "1" {
+		return
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var request hooks.ExternalRequest
+		json.Unmarshal(scanner.Bytes(), &request)
+		data, _ := json.Marshal(externalHookReply(request))
+		fmt.Println(string(data))
+		// A stalled hook stops reading stdin after hello for a while
+		if request.Op == hooks.ExternalHello && os.Getenv("CONRUN_EXTERNAL_HOOK_STALL") == "1" {
+			time.Sleep(2 * time.Second)
+		}
+	}
+	os.Exit(0)
+}
+
+func externalMessage(id, text string) *models.GossipMessage {
+	return &models.GossipMessage{MessageID: id, MessageType: "test_type", Payload: map[string]interface{}{"text": text}}
+}
+
+func TestExternalHook_Stdio(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	t.Setenv("CONRUN_EXTERNAL_HOOK_PROCESS", "1")
+
+	// A command that does not start is a start error
+	missing := hooks.NewExternalHook("missing", "/nonexistent/hook", time.Second, logger)
+	if err := missing.Start(context.Background()); err == nil {
+		t.Errorf("Expected a missing command to fail")
+	}
+
+	hook := hooks.NewExternalHook("checker", os.Args[0]+" -test.run=^TestExternalHookProcess$", 5*time.Second, logger)
+	var published []string
+	hook.SetPublisher(func(messageType string, payload interface{}) error {
+		published = append(published, fmt.Sprintf("%s:%v", messageType, payload))
+		return nil
+	})
+
+	hookManager := hooks.NewHookManager(t.TempDir(), logger)
+	hookManager.AddHook(hook)
+	if err := hookManager.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start hook manager: %v", err)
+	}
+	defer hookManager.Stop()
+
+	// The hook reports its message types on start
+	if !hook.ShouldHandle("test_type") || hook.ShouldHandle("user_message") {
+		t.Errorf("Expected the hook to handle test_type only")
+	}
+
+	if hookManager.ValidateMessage(externalMessage("a", "bad"), interfaces.MessageTypePush) {
+		t.Errorf("Expected the message to be rejected")
+	}
+	rejections := hookManager.GetRejections("a")
+	if len(rejections) != 1 || rejections[0].Hook != "checker" || rejections[0].Code != "bad_text" || rejections[0].Reason != "text is bad" {
+		t.Errorf("Expected the rejection of the external hook, got %+v", rejections)
+	}
+
+	// Derived messages are published after handling
+	if !hookManager.ProcessMessage(externalMessage("b", "good"), interfaces.MessageTypePush) {
+		t.Errorf("Expected the message to be accepted")
+	}
+	if fmt.Sprint(published) != "[derived:b]" {
+		t.Errorf("Expected a derived message, got %v", published)
+	}
+	if stats := hookManager.HookStats(); stats[0].Failed != 0 {
+		t.Errorf("Expected no handle errors, got %+v", stats)
+	}
+	hookManager.ProcessMessage(externalMessage("c", "good"), interfaces.MessageTypeLoaded)
+	if stats := hookManager.HookStats(); stats[0].Failed != 1 {
+		t.Errorf("Expected the hook error to be counted, got %+v", stats)
+	}
+
+	// While the process restarts its messages are rejected
+	if hookManager.ValidateMessage(externalMessage("d", "crash"), interfaces.MessageTypePush) {
+		t.Errorf("Expected the message to be rejected")
+	}
+	if rejections := hookManager.GetRejections("d"); len(rejections) != 1 || rejections[0].Code != "hook_unavailable" {
+		t.Errorf("Expected the hook to be unavailable, got %+v", rejections)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !hookManager.ValidateMessage(externalMessage("e", "good"), interfaces.MessageTypePush) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the hook process to be restarted")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestExternalHook_StalledStdin(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	t.Setenv("CONRUN_EXTERNAL_HOOK_PROCESS", "1")
+	t.Setenv("CONRUN_EXTERNAL_HOOK_STALL", "1")
+
+	hook := hooks.NewExternalHook("stalled", os.Args[0]+" -test.run=^TestExternalHookProcess$", 200*time.Millisecond, logger)
+	if err := hook.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start hook: %v", err)
+	}
+	defer hook.Stop()
+
+	// The first request is larger than the pipe buffer, so writing it blocks;
+	// both requests still give up after the timeout
+	large := externalMessage("large", strings.Repeat("x", 1<<20))
+	for _, message := range []*models.GossipMessage{large, externalMessage("small", "good")} {
+		started := time.Now()
+		rejection := hook.ValidateWithReason(message, interfaces.MessageTypePush)
+		if rejection == nil || rejection.Code != "hook_unavailable" {
+			t.Errorf("Expected %s to be rejected as unavailable, got %+v", message.MessageID, rejection)
+		}
+		if elapsed := time.Since(started); elapsed > time.Second {
+			t.Errorf("Expected %s to give up after the timeout, took %s", message.MessageID, elapsed)
+		}
+	}
+}
+
+func TestExternalHook_HTTP(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request hooks.ExternalRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		if request.Message != nil && request.Message.MessageID == "down" {
+			http.Error(w, "hook is down", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(externalHookReply(request))
+	}))
+	defer server.Close()
+
+	hook := hooks.NewExternalHook("service", server.URL, time.Second, logger)
+	if err := hook.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start hook: %v", err)
+	}
+	defer hook.Stop()
+
+	// The service is asked for its message types in the background
+	deadline := time.Now().Add(2 * time.Second)
+	for !hook.ShouldHandle("test_type") {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the hook to learn its message types")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if rejection := hook.ValidateWithReason(externalMessage("a", "bad"), interfaces.MessageTypePull); rejection == nil || rejection.Code != "bad_text" {
+		t.Errorf("Expected the message to be rejected, got %+v", rejection)
+	}
+	if rejection := hook.ValidateWithReason(externalMessage("down", "good"), interfaces.MessageTypePull); rejection == nil ||
+		rejection.Code != "hook_unavailable" || !strings.Contains(rejection.Reason, "hook is down") {
+		t.Errorf("Expected the hook to be unavailable, got %+v", rejection)
+	}
+
+	// Without a publisher derived messages cannot be published
+	if err := hook.Handle(externalMessage("b", "good"), interfaces.MessageTypePush); err == nil {
+		t.Errorf("Expected an error without a publisher")
+	}
+	var published []string
+	hook.SetPublisher(func(messageType string, payload interface{}) error {
+		published = append(published, fmt.Sprintf("%s:%v", messageType, payload))
+		return nil
+	})
+	if err := hook.Handle(externalMessage("b", "good"), interfaces.MessageTypePush); err != nil || fmt.Sprint(published) != "[derived:b]" {
+		t.Errorf("Expected a derived message, got %v (%v)", published, err)
+	}
+}
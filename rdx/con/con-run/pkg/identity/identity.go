@@ -0,0 +1,176 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"concoin/conrun/pkg/models"
+)
+
+// KeyFile файл с ключом узла в директории config
+const KeyFile = "node_key"
+
+// nodeIDLen число байт хеша публичного ключа в ID узла
+const nodeIDLen = 20
+
+var (
+	ErrBadMessageID = errors.New("message id does not match its content")
+	ErrBadOrigin    = errors.New("origin id does not match the public key")
+	ErrBadSignature = errors.New("bad message signature")
+)
+
+// Identity ключевая пара узла. ID узла выводится из публичного ключа,
+// поэтому подделать происхождение сообщения без приватного ключа нельзя.
+type Identity struct {
+	NodeID     string
+	PublicKey  ed25519.PublicKey
+	privateKey ed25519.PrivateKey
+}
+
+// Generate создает новую ключевую пару
+func Generate() (*Identity, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate node key: %w", err)
+	}
+	return fromKey(key), nil
+}
+
+// Load читает ключ узла из dataDir/config, создавая его при первом запуске
+func Load(dataDir string) (*Identity, error) {
+	keyPath := filepath.Join(dataDir, "config", KeyFile)
+
+	data, err := os.ReadFile(keyPath)
+	if err == nil {
+		seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("bad node key in %s", keyPath)
+		}
+		return fromKey(ed25519.NewKeyFromSeed(seed)), nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read node key: %w", err)
+	}
+
+	identity, err := Generate()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create config directory: %w", err)
+	}
+	seed := hex.EncodeToString(identity.privateKey.Seed())
+	if err := os.WriteFile(keyPath, []byte(seed+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("failed to save node key: %w", err)
+	}
+	return identity, nil
+}
+
+func fromKey(key ed25519.PrivateKey) *Identity {
+	publicKey := key.Public().(ed25519.PublicKey)
+	return &Identity{
+		NodeID:     NodeID(publicKey),
+		PublicKey:  publicKey,
+		privateKey: key,
+	}
+}
+
+// NodeID выводит ID узла из публичного ключа: hex первых 20 байт его SHA-256
+func NodeID(publicKey ed25519.PublicKey) string {
+	sum := sha256.Sum256(publicKey)
+	return hex.EncodeToString(sum[:nodeIDLen])
+}
+
+// Seal подписывает сообщение от имени узла: заполняет отправителя, ключ,
+// ID как хеш содержимого и подпись этого хеша
+func (id *Identity) Seal(message *models.GossipMessage) error {
+	message.OriginID = id.NodeID
+	message.PublicKey = hex.EncodeToString(id.PublicKey)
+
+	hash, err := MessageHash(message)
+	if err != nil {
+		return err
+	}
+	message.MessageID = hex.EncodeToString(hash)
+	message.Signature = hex.EncodeToString(ed25519.Sign(id.privateKey, hash))
+	return nil
+}
+
+// Verify проверяет, что ID сообщения - хеш его содержимого, отправитель
+// соответствует ключу, а подпись сделана этим ключом
+func Verify(message *models.GossipMessage) error {
+	publicKey, err := hex.DecodeString(message.PublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("%w: bad public key", ErrBadSignature)
+	}
+	if message.OriginID != NodeID(publicKey) {
+		return ErrBadOrigin
+	}
+
+	hash, err := MessageHash(message)
+	if err != nil {
+		return err
+	}
+	if message.MessageID != hex.EncodeToString(hash) {
+		return ErrBadMessageID
+	}
+
+	signature, err := hex.DecodeString(message.Signature)
+	if err != nil || !ed25519.Verify(publicKey, hash, signature) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// MessageHash считает SHA-256 неизменяемой части сообщения. TTL уменьшается
+// по пути и в хеш не входит. Содержимое приводится к виду, в котором его
+// получит другой узел после разбора JSON, чтобы хеш совпал у обеих сторон.
+func MessageHash(message *models.GossipMessage) ([]byte, error) {
+	payload, err := canonicalPayload(message.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(struct {
+		OriginID    string          `json:"origin_id"`
+		PublicKey   string          `json:"public_key"`
+		Timestamp   time.Time       `json:"timestamp"`
+		MessageType string          `json:"message_type"`
+		Payload     json.RawMessage `json:"payload"`
+	}{
+		OriginID:    message.OriginID,
+		PublicKey:   message.PublicKey,
+		Timestamp:   message.Timestamp,
+		MessageType: message.MessageType,
+		Payload:     payload,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return sum[:], nil
+}
+
+// canonicalPayload кодирует содержимое так, как его закодирует получатель:
+// поля объектов по алфавиту, числа как float64
+func canonicalPayload(payload interface{}) (json.RawMessage, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, fmt.Errorf("failed to decode payload: %w", err)
+	}
+	return json.Marshal(decoded)
+}
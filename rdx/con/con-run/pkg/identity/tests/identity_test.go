@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"concoin/conrun/pkg/identity"
+	"concoin/conrun/pkg/models"
+)
+
+func TestLoad(t *testing.T) {
+	dataDir := t.TempDir()
+
+	first, err := identity.Load(dataDir)
+	if err != nil {
+		t.Fatalf("Failed to create identity: %v", err)
+	}
+	if first.NodeID != identity.NodeID(first.PublicKey) || len(first.NodeID) != 40 {
+		t.Errorf("Unexpected node id %q", first.NodeID)
+	}
+
+	// The key is kept between restarts
+	second, err := identity.Load(dataDir)
+	if err != nil {
+		t.Fatalf("Failed to load identity: %v", err)
+	}
+	if second.NodeID != first.NodeID {
+		t.Errorf("Expected the same node id after reload, got %s and %s", first.NodeID, second.NodeID)
+	}
+
+	if other, _ := identity.Load(t.TempDir()); other.NodeID == first.NodeID {
+		t.Errorf("Expected different nodes to get different ids")
+	}
+}
+
+func TestSealAndVerify(t *testing.T) {
+	node, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+
+	// Struct payloads are hashed the way the receiver sees them after JSON decoding
+	newMessage := func() *models.GossipMessage {
+		message := &models.GossipMessage{
+			Timestamp:   time.Now().UTC(),
+			TTL:         5,
+			MessageType: "blockchain_concoin",
+			Payload: struct {
+				Type   string `json:"type"`
+				Amount int64  `json:"amount"`
+				Sender string `json:"sender"`
+			}{"transaction", 10, "Alice"},
+		}
+		if err := node.Seal(message); err != nil {
+			t.Fatalf("Failed to seal message: %v", err)
+		}
+		return message
+	}
+
+	message := newMessage()
+	if message.OriginID != node.NodeID || len(message.MessageID) != 64 {
+		t.Fatalf("Unexpected envelope %+v", message)
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		t.Fatalf("Failed to marshal message: %v", err)
+	}
+	var received models.GossipMessage
+	if err := json.Unmarshal(data, &received); err != nil {
+		t.Fatalf("Failed to unmarshal message: %v", err)
+	}
+	if err := identity.Verify(&received); err != nil {
+		t.Fatalf("Failed to verify received message: %v", err)
+	}
+
+	// TTL changes on the way and is not signed
+	received.TTL--
+	if err := identity.Verify(&received); err != nil {
+		t.Errorf("Expected TTL change to keep the message valid, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(message *models.GossipMessage)
+		err    error
+	}{
+		{"Payload", func(m *models.GossipMessage) { m.Payload = map[string]interface{}{"amount": 1000} }, identity.ErrBadMessageID},
+		{"MessageID", func(m *models.GossipMessage) { m.MessageID = "msg-1" }, identity.ErrBadMessageID},
+		{"Timestamp", func(m *models.GossipMessage) { m.Timestamp = m.Timestamp.Add(time.Second) }, identity.ErrBadMessageID},
+		{"Origin", func(m *models.GossipMessage) { m.OriginID = "node-3000" }, identity.ErrBadOrigin},
+		{"Signature", func(m *models.GossipMessage) { m.Signature = newMessage().Signature }, identity.ErrBadSignature},
+		{"Unsigned", func(m *models.GossipMessage) { m.PublicKey, m.Signature = "", "" }, identity.ErrBadSignature},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tampered := *message
+			test.tamper(&tampered)
+			if err := identity.Verify(&tampered); !errors.Is(err, test.err) {
+				t.Errorf("Expected %v, got %v", test.err, err)
+			}
+		})
+	}
+
+	t.Run("OtherKey", func(t *testing.T) {
+		// A key of another node can not sign for this node
+		other, _ := identity.Generate()
+		forged := *message
+		forged.PublicKey = newMessageKey(other)
+		if err := identity.Verify(&forged); !errors.Is(err, identity.ErrBadOrigin) {
+			t.Errorf("Expected ErrBadOrigin, got %v", err)
+		}
+	})
+}
+
+func newMessageKey(node *identity.Identity) string {
+	message := &models.GossipMessage{}
+	node.Seal(message)
+	return message.PublicKey
+}
@@ -1,23 +1,38 @@
 package interfaces
 
 import (
+	"context"
+	"time"
+
+	"concoin/conrun/pkg/blockchain"
+	"concoin/conrun/pkg/mempool"
 	"concoin/conrun/pkg/models"
 )
 
+// Component определяет компонент узла с фоновой работой
+type Component interface {
+	// Start запускает компонент. Фоновая работа завершается при отмене ctx или вызове Stop.
+	Start(ctx context.Context) error
+	// Stop останавливает компонент и дожидается завершения фоновой работы
+	Stop()
+}
+
 // GossipProtocolInterface определяет интерфейс для Gossip протокола
 type GossipProtocolInterface interface {
-	Start()
+	Component
 	UpdatePeers(peers []models.Peer)
-	HandleMessage(message *models.GossipMessage) error
+	HandleMessage(message *models.GossipMessage, from *models.Peer) error
+	HandleSync(request *models.GossipSync, from *models.Peer) (*models.GossipSync, error)
 }
 
 // PexProtocolInterface определяет интерфейс для PEX протокола
 type PexProtocolInterface interface {
-	Start()
+	Component
 	SetOnPeersListHandler(handler func(peers []models.Peer))
 	AddPeer(peer models.Peer) bool
 	GetPeers() []models.Peer
-	HandlePexRequest(request models.PexMessage) models.PexMessage
+	AdvertisedAddress() string
+	HandlePexRequest(request models.PexMessage, remoteAddr string) models.PexMessage
 }
 
 // StorageInterface определяет интерфейс для хранилища
@@ -28,6 +43,82 @@ type StorageInterface interface {
 	GetMessage(messageID string) (*models.GossipMessage, error)
 	GetMessageList() ([]string, error)
 	HasMessage(messageID string) bool
+	GetMessagesSince(seq uint64, limit int) ([]*models.GossipMessage, uint64, uint64, error)
+	GetSyncCursor(nodeID string) (uint64, error)
+	SaveSyncCursor(nodeID string, cursor uint64) error
+	SaveBan(ban *models.Ban) error
+	GetBans() ([]*models.Ban, error)
+	// Close дожидается завершения начатых записей перед остановкой узла
+	Close() error
+}
+
+// MessageIndex определяет выборку сообщений по индексам типа и времени.
+// Реализуется хранилищами, в которых есть такие индексы.
+type MessageIndex interface {
+	// MessagesByType возвращает сообщения типа messageType с временем в [from, to)
+	MessagesByType(messageType string, from, to time.Time, limit int) ([]*models.GossipMessage, error)
+	// MessagesBetween возвращает сообщения с временем в [from, to)
+	MessagesBetween(from, to time.Time, limit int) ([]*models.GossipMessage, error)
+}
+
+// MessagePruner определяет удаление сообщений из хранилища. ID удаленного
+// сообщения остается известным: HasMessage возвращает true, чтобы пиры
+// не прислали сообщение снова, а GetMessagesSince его пропускает.
+type MessagePruner interface {
+	DeleteMessages(messageIDs []string) error
+	// PrunedMessages возвращает ID удаленных сообщений
+	PrunedMessages() ([]string, error)
+	// ExpirePruned забывает ID сообщений, удаленных раньше before,
+	// и возвращает их число
+	ExpirePruned(before time.Time) (int, error)
+}
+
+// Compactor определяет хранилище, которое освобождает место после удалений
+type Compactor interface {
+	Compact() error
+}
+
+// ReputationInterface определяет интерфейс оценки и бана пиров
+type ReputationInterface interface {
+	RecordValid(nodeID string)
+	RecordRejected(nodeID string, reason string)
+	RecordInvalid(nodeID string, reason string)
+	RecordUnreachable(nodeID string, address string)
+	RecordLimited(nodeID string, reason string)
+	RecordRequest(nodeID string, latency time.Duration, err error)
+	Score(nodeID string) float64
+	SelectPeers(peers []models.Peer, count int) []models.Peer
+	IsBanned(nodeID string) bool
+	AddBanListener(listener func(nodeID string))
+	Scores() []models.PeerScore
+	Bans() []models.Ban
+}
+
+// TransportInterface определяет интерфейс для отправки запросов пирам
+type TransportInterface interface {
+	// Send отправляет запрос и декодирует JSON ответ в response, если он не nil
+	Send(peer models.Peer, method, path string, request, response interface{}) error
+	Close() error
+}
+
+// ChainInterface определяет интерфейс состояния блокчейна
+type ChainInterface interface {
+	blockchain.Ledger
+	ValidateBlock(block *blockchain.Block) error
+	AddBlock(block *blockchain.Block) error
+	Height() int
+	NextTarget() (string, error)
+	Snapshot() *blockchain.State
+}
+
+// MempoolInterface определяет интерфейс мемпула
+type MempoolInterface interface {
+	Check(tx blockchain.Transaction) error
+	Add(tx blockchain.Transaction) (blockchain.Hash, error)
+	Has(hash blockchain.Hash) bool
+	Entries() []mempool.Entry
+	Pick(limit int) []blockchain.Transaction
+	Size() int
 }
 
 // MessageType представляет тип сообщения
@@ -49,6 +140,46 @@ type Hook interface {
 	Handle(message *models.GossipMessage, msgType MessageType) error
 }
 
+// ReasoningHook представляет собой хук, который умеет объяснять причину отклонения сообщения
+type ReasoningHook interface {
+	Hook
+	// ValidateWithReason проверяет валидность сообщения и возвращает причину отказа (nil, если сообщение валидно)
+	ValidateWithReason(message *models.GossipMessage, msgType MessageType) *models.Rejection
+}
+
+// PrioritizedHook хук с приоритетом. Хуки с большим приоритетом проверяют
+// и обрабатывают сообщения раньше, приоритет остальных хуков - 0.
+type PrioritizedHook interface {
+	Hook
+	Priority() int
+}
+
+// NamedHook хук со своим именем. Имя остальных хуков - имя их типа.
+type NamedHook interface {
+	Hook
+	Name() string
+}
+
+// ConcurrentHook хук, которому можно задать фоновые обработчики
+// (hooks.workers): его Handle не зависит от порядка сообщений и от того,
+// обработаны ли уже сообщения, проверенные раньше. Остальные хуки,
+// например применяющие блоки по порядку, обрабатывают сообщения
+// в вызывающей горутине.
+type ConcurrentHook interface {
+	Hook
+	Concurrent() bool
+}
+
+// HookStatsReporter отдает счетчики работы хуков
+type HookStatsReporter interface {
+	HookStats() []models.HookStats
+}
+
+// RejectionReporter отдает причины, по которым хуки отклонили сообщение
+type RejectionReporter interface {
+	GetRejections(messageID string) []models.Rejection
+}
+
 // HookManagerInterface определяет интерфейс для менеджера хуков
 type HookManagerInterface interface {
 	ValidateMessage(message *models.GossipMessage, msgType MessageType) bool
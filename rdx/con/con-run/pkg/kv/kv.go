@@ -0,0 +1,552 @@
+// Package kv встроенное упорядоченное хранилище ключ-значение в виде
+// LSM-дерева: записи попадают в журнал и таблицу в памяти, а при ее
+// заполнении сбрасываются в неизменяемые отсортированные сегменты.
+package kv
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrClosed возвращается при обращении к закрытой базе
+var ErrClosed = errors.New("kv: database is closed")
+
+const (
+	walFile      = "wal.log"
+	manifestFile = "MANIFEST"
+
+	defaultMemtableSize = 4 << 20
+	defaultMaxSegments  = 8
+)
+
+// Options параметры базы
+type Options struct {
+	MemtableSize int64 // объем таблицы в памяти, после которого она сбрасывается в сегмент
+	MaxSegments  int   // число сегментов, после которого они сливаются в один
+	Sync         bool  // fsync журнала после каждой записи
+}
+
+// Stats размер базы
+type Stats struct {
+	Segments      int   `json:"segments"`
+	SegmentBytes  int64 `json:"segment_bytes"`
+	MemtableKeys  int   `json:"memtable_keys"`
+	MemtableBytes int64 `json:"memtable_bytes"`
+	WALBytes      int64 `json:"wal_bytes"`
+}
+
+type entry struct {
+	value   []byte
+	deleted bool
+}
+
+type op struct {
+	kind  byte
+	key   string
+	value []byte
+}
+
+// Batch набор изменений, который применяется атомарно
+type Batch struct {
+	ops []op
+}
+
+// Put добавляет в пакет запись значения
+func (b *Batch) Put(key string, value []byte) {
+	b.ops = append(b.ops, op{kind: opPut, key: key, value: append([]byte{}, value...)})
+}
+
+// Delete добавляет в пакет удаление ключа
+func (b *Batch) Delete(key string) {
+	b.ops = append(b.ops, op{kind: opDelete, key: key})
+}
+
+// Len возвращает число операций в пакете
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// DB база ключ-значение в директории. Список действующих сегментов хранится
+// в MANIFEST: файл заменяется атомарно и служит точкой фиксации сброса
+// и слияния, файлы сегментов вне списка удаляются при открытии.
+type DB struct {
+	dir     string
+	options Options
+
+	mutex    sync.RWMutex
+	mem      map[string]entry
+	memSize  int64
+	segments []*segment // от старых к новым
+	nextID   uint64
+	wal      *wal
+	closed   bool
+}
+
+// Open открывает базу в dir, создавая директорию при необходимости
+func Open(dir string, options Options) (*DB, error) {
+	if options.MemtableSize <= 0 {
+		options.MemtableSize = defaultMemtableSize
+	}
+	if options.MaxSegments <= 0 {
+		options.MaxSegments = defaultMaxSegments
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+
+	db := &DB{dir: dir, options: options, mem: make(map[string]entry), nextID: 1}
+
+	ids, err := db.readManifest()
+	if err != nil {
+		return nil, err
+	}
+	if err := db.removeStray(ids); err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		s, err := openSegment(dir, id)
+		if err != nil {
+			db.closeSegments()
+			return nil, err
+		}
+		db.segments = append(db.segments, s)
+		if id >= db.nextID {
+			db.nextID = id + 1
+		}
+	}
+
+	db.wal, err = openWAL(filepath.Join(dir, walFile), options.Sync, db.apply)
+	if err != nil {
+		db.closeSegments()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Get возвращает значение ключа и признак его наличия
+func (db *DB) Get(key string) ([]byte, bool, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	if db.closed {
+		return nil, false, ErrClosed
+	}
+	if e, ok := db.mem[key]; ok {
+		if e.deleted {
+			return nil, false, nil
+		}
+		return append([]byte{}, e.value...), true, nil
+	}
+	for i := len(db.segments) - 1; i >= 0; i-- {
+		s := db.segments[i]
+		if e, ok := s.lookup(key); ok {
+			if e.deleted {
+				return nil, false, nil
+			}
+			value, err := s.read(e)
+			if err != nil {
+				return nil, false, err
+			}
+			return value, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// Has проверяет наличие ключа, не читая значение с диска
+func (db *DB) Has(key string) (bool, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	if db.closed {
+		return false, ErrClosed
+	}
+	if e, ok := db.mem[key]; ok {
+		return !e.deleted, nil
+	}
+	for i := len(db.segments) - 1; i >= 0; i-- {
+		if e, ok := db.segments[i].lookup(key); ok {
+			return !e.deleted, nil
+		}
+	}
+	return false, nil
+}
+
+// Put записывает значение ключа
+func (db *DB) Put(key string, value []byte) error {
+	var batch Batch
+	batch.Put(key, value)
+	return db.Write(&batch)
+}
+
+// Delete удаляет ключ
+func (db *DB) Delete(key string) error {
+	var batch Batch
+	batch.Delete(key)
+	return db.Write(&batch)
+}
+
+// Write атомарно применяет пакет: после сбоя в базе окажутся либо все
+// его изменения, либо ни одного.
+func (db *DB) Write(batch *Batch) error {
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	if err := db.wal.append(batch.ops); err != nil {
+		return err
+	}
+	db.apply(batch.ops)
+
+	if db.memSize >= db.options.MemtableSize {
+		return db.flush()
+	}
+	return nil
+}
+
+// Range передает fn ключи из [start, end) по возрастанию вместе со
+// значениями. Пустой end означает конец базы. Обход прекращается, если
+// fn возвращает false. База заблокирована на чтение на время обхода,
+// поэтому fn не должна обращаться к ней.
+func (db *DB) Range(start, end string, fn func(key string, value []byte) bool) error {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	var memKeys []string
+	for key := range db.mem {
+		if key >= start && (end == "" || key < end) {
+			memKeys = append(memKeys, key)
+		}
+	}
+	sort.Strings(memKeys)
+
+	// Позиции в сегментах, от новых к старым
+	positions := make([]int, len(db.segments))
+	for i, s := range db.segments {
+		positions[i] = s.find(start)
+	}
+	memPos := 0
+
+	for {
+		// Наименьший из текущих ключей всех источников
+		var key string
+		found := false
+		if memPos < len(memKeys) {
+			key, found = memKeys[memPos], true
+		}
+		for i, s := range db.segments {
+			if positions[i] < len(s.index) {
+				k := s.index[positions[i]].key
+				if end != "" && k >= end {
+					continue
+				}
+				if !found || k < key {
+					key, found = k, true
+				}
+			}
+		}
+		if !found {
+			return nil
+		}
+
+		// Действует запись самого нового источника, остальные пропускаются
+		var value []byte
+		var deleted, resolved bool
+		if memPos < len(memKeys) && memKeys[memPos] == key {
+			e := db.mem[key]
+			value, deleted, resolved = e.value, e.deleted, true
+			memPos++
+		}
+		for i := len(db.segments) - 1; i >= 0; i-- {
+			s := db.segments[i]
+			if positions[i] >= len(s.index) || s.index[positions[i]].key != key {
+				continue
+			}
+			e := s.index[positions[i]]
+			positions[i]++
+			if resolved {
+				continue
+			}
+			resolved, deleted = true, e.deleted
+			if !deleted {
+				var err error
+				if value, err = s.read(e); err != nil {
+					return err
+				}
+			}
+		}
+
+		if !deleted && !fn(key, value) {
+			return nil
+		}
+	}
+}
+
+// Compact сбрасывает таблицу в памяти и сливает все сегменты в один,
+// отбрасывая перезаписанные значения и удаленные ключи.
+func (db *DB) Compact() error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	if len(db.mem) > 0 {
+		if err := db.flush(); err != nil {
+			return err
+		}
+	}
+	if len(db.segments) > 1 || (len(db.segments) == 1 && db.hasTombstones(db.segments[0])) {
+		return db.merge()
+	}
+	return nil
+}
+
+// Stats возвращает размер базы
+func (db *DB) Stats() Stats {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	stats := Stats{Segments: len(db.segments), MemtableKeys: len(db.mem), MemtableBytes: db.memSize}
+	for _, s := range db.segments {
+		stats.SegmentBytes += s.size
+	}
+	if db.wal != nil {
+		stats.WALBytes = db.wal.size
+	}
+	return stats
+}
+
+// Close сбрасывает таблицу в памяти на диск и закрывает базу
+func (db *DB) Close() error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+
+	var err error
+	if len(db.mem) > 0 {
+		err = db.flush()
+	}
+	if walErr := db.wal.close(); err == nil {
+		err = walErr
+	}
+	if segErr := db.closeSegments(); err == nil {
+		err = segErr
+	}
+	return err
+}
+
+// apply применяет операции к таблице в памяти
+func (db *DB) apply(ops []op) {
+	for _, o := range ops {
+		if old, ok := db.mem[o.key]; ok {
+			db.memSize -= int64(len(o.key) + len(old.value))
+		}
+		db.mem[o.key] = entry{value: o.value, deleted: o.kind == opDelete}
+		db.memSize += int64(len(o.key) + len(o.value))
+	}
+}
+
+// flush сбрасывает таблицу в памяти в новый сегмент и очищает журнал
+func (db *DB) flush() error {
+	keys := make([]string, 0, len(db.mem))
+	for key := range db.mem {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	s, err := writeSegment(db.dir, db.nextID, keys, db.mem)
+	if err != nil {
+		return err
+	}
+	segments := append(db.segments[:len(db.segments):len(db.segments)], s)
+	if err := db.writeManifest(segments); err != nil {
+		s.close()
+		os.Remove(s.path)
+		return err
+	}
+
+	db.nextID++
+	db.segments = segments
+	db.mem = make(map[string]entry)
+	db.memSize = 0
+	if err := db.wal.reset(); err != nil {
+		return err
+	}
+
+	if len(db.segments) > db.options.MaxSegments {
+		return db.merge()
+	}
+	return nil
+}
+
+// merge сливает все сегменты в один. Удаленные ключи отбрасываются,
+// так как более старых сегментов, где они могли бы остаться, нет.
+func (db *DB) merge() error {
+	live := make(map[string]entry)
+	for _, s := range db.segments {
+		for _, e := range s.index {
+			if e.deleted {
+				delete(live, e.key)
+				continue
+			}
+			value, err := s.read(e)
+			if err != nil {
+				return err
+			}
+			live[e.key] = entry{value: value}
+		}
+	}
+	keys := make([]string, 0, len(live))
+	for key := range live {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	merged, err := writeSegment(db.dir, db.nextID, keys, live)
+	if err != nil {
+		return err
+	}
+	if err := db.writeManifest([]*segment{merged}); err != nil {
+		merged.close()
+		os.Remove(merged.path)
+		return err
+	}
+
+	db.nextID++
+	old := db.segments
+	db.segments = []*segment{merged}
+	for _, s := range old {
+		s.close()
+		os.Remove(s.path)
+	}
+	return nil
+}
+
+func (db *DB) hasTombstones(s *segment) bool {
+	for _, e := range s.index {
+		if e.deleted {
+			return true
+		}
+	}
+	return false
+}
+
+// readManifest возвращает номера действующих сегментов
+func (db *DB) readManifest() ([]uint64, error) {
+	file, err := os.Open(filepath.Join(db.dir, manifestFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	defer file.Close()
+
+	var ids []uint64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(line, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid manifest entry %q", line)
+		}
+		ids = append(ids, id)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	return ids, nil
+}
+
+// writeManifest атомарно заменяет список сегментов
+func (db *DB) writeManifest(segments []*segment) error {
+	var data strings.Builder
+	for _, s := range segments {
+		fmt.Fprintf(&data, "%d\n", s.id)
+	}
+
+	path := filepath.Join(db.dir, manifestFile)
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if _, err := file.WriteString(data.String()); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync manifest: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// removeStray удаляет сегменты, не попавшие в MANIFEST, и временные файлы,
+// оставшиеся после прерванного сброса или слияния.
+func (db *DB) removeStray(ids []uint64) error {
+	live := make(map[string]bool)
+	for _, id := range ids {
+		live[segmentName(id)] = true
+	}
+
+	entries, err := os.ReadDir(db.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read database directory: %w", err)
+	}
+	for _, e := range entries {
+		name := e.Name()
+		stray := strings.HasSuffix(name, ".tmp") || (strings.HasSuffix(name, ".sst") && !live[name])
+		if stray {
+			if err := os.Remove(filepath.Join(db.dir, name)); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", name, err)
+			}
+		}
+		if strings.HasSuffix(name, ".sst") {
+			if id, err := strconv.ParseUint(strings.TrimSuffix(name, ".sst"), 10, 64); err == nil && id >= db.nextID {
+				db.nextID = id + 1
+			}
+		}
+	}
+	return nil
+}
+
+func (db *DB) closeSegments() error {
+	var err error
+	for _, s := range db.segments {
+		if closeErr := s.close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
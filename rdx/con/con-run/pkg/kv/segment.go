@@ -0,0 +1,192 @@
+package kv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// segmentMagic отмечает конец файла сегмента
+const segmentMagic uint32 = 0x6b767367
+
+// footerSize смещение индекса, CRC32 индекса и segmentMagic
+const footerSize = 16
+
+// segment неизменяемый файл с отсортированными по ключу записями. В начале
+// файла лежат значения, за ними индекс: ключ, смещение и длина значения,
+// CRC32 значения и признак удаления. Индекс целиком держится в памяти,
+// поэтому проверка наличия ключа не читает диск.
+type segment struct {
+	id    uint64
+	path  string
+	file  *os.File
+	index []indexEntry
+	size  int64
+}
+
+type indexEntry struct {
+	key     string
+	offset  int64
+	length  uint32
+	crc     uint32
+	deleted bool
+}
+
+// segmentName возвращает имя файла сегмента с номером id
+func segmentName(id uint64) string {
+	return fmt.Sprintf("%08d.sst", id)
+}
+
+// writeSegment записывает отсортированные записи в файл сегмента id
+// и открывает его. Файл появляется под своим именем только целиком.
+func writeSegment(dir string, id uint64, keys []string, entries map[string]entry) (*segment, error) {
+	path := filepath.Join(dir, segmentName(id))
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create segment: %w", err)
+	}
+	defer file.Close()
+
+	var index []byte
+	var offset int64
+	for _, key := range keys {
+		e := entries[key]
+		if _, err := file.Write(e.value); err != nil {
+			return nil, fmt.Errorf("failed to write segment: %w", err)
+		}
+
+		index = binary.AppendUvarint(index, uint64(len(key)))
+		index = append(index, key...)
+		index = binary.AppendUvarint(index, uint64(offset))
+		index = binary.AppendUvarint(index, uint64(len(e.value)))
+		index = binary.LittleEndian.AppendUint32(index, crc32.ChecksumIEEE(e.value))
+		if e.deleted {
+			index = append(index, 1)
+		} else {
+			index = append(index, 0)
+		}
+		offset += int64(len(e.value))
+	}
+
+	footer := binary.LittleEndian.AppendUint64(nil, uint64(offset))
+	footer = binary.LittleEndian.AppendUint32(footer, crc32.ChecksumIEEE(index))
+	footer = binary.LittleEndian.AppendUint32(footer, segmentMagic)
+	if _, err := file.Write(append(index, footer...)); err != nil {
+		return nil, fmt.Errorf("failed to write segment: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to sync segment: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return nil, fmt.Errorf("failed to write segment: %w", err)
+	}
+
+	return openSegment(dir, id)
+}
+
+// openSegment открывает файл сегмента и читает его индекс
+func openSegment(dir string, id uint64) (*segment, error) {
+	path := filepath.Join(dir, segmentName(id))
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open segment: %w", err)
+	}
+
+	s, err := readSegmentIndex(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("segment %s: %w", path, err)
+	}
+	s.id, s.path, s.file = id, path, file
+	return s, nil
+}
+
+func readSegmentIndex(file *os.File) (*segment, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size < footerSize {
+		return nil, errCorrupt
+	}
+
+	footer := make([]byte, footerSize)
+	if _, err := file.ReadAt(footer, size-footerSize); err != nil {
+		return nil, err
+	}
+	indexOffset := int64(binary.LittleEndian.Uint64(footer[0:8]))
+	if binary.LittleEndian.Uint32(footer[12:16]) != segmentMagic || indexOffset > size-footerSize {
+		return nil, errCorrupt
+	}
+
+	data := make([]byte, size-footerSize-indexOffset)
+	if _, err := file.ReadAt(data, indexOffset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(footer[8:12]) {
+		return nil, errCorrupt
+	}
+
+	s := &segment{size: size}
+	for len(data) > 0 {
+		key, rest, err := readBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		offset, n := binary.Uvarint(rest)
+		if n <= 0 {
+			return nil, errCorrupt
+		}
+		rest = rest[n:]
+		length, n := binary.Uvarint(rest)
+		if n <= 0 || len(rest) < n+5 {
+			return nil, errCorrupt
+		}
+		rest = rest[n:]
+
+		s.index = append(s.index, indexEntry{
+			key:     string(key),
+			offset:  int64(offset),
+			length:  uint32(length),
+			crc:     binary.LittleEndian.Uint32(rest[0:4]),
+			deleted: rest[4] == 1,
+		})
+		data = rest[5:]
+	}
+	return s, nil
+}
+
+// find возвращает позицию первого ключа не меньше key
+func (s *segment) find(key string) int {
+	return sort.Search(len(s.index), func(i int) bool { return s.index[i].key >= key })
+}
+
+// lookup ищет ключ в индексе сегмента
+func (s *segment) lookup(key string) (indexEntry, bool) {
+	i := s.find(key)
+	if i < len(s.index) && s.index[i].key == key {
+		return s.index[i], true
+	}
+	return indexEntry{}, false
+}
+
+// read читает значение записи индекса с проверкой CRC32
+func (s *segment) read(e indexEntry) ([]byte, error) {
+	value := make([]byte, e.length)
+	if _, err := s.file.ReadAt(value, e.offset); err != nil && !(err == io.EOF && e.length == 0) {
+		return nil, fmt.Errorf("failed to read segment %s: %w", s.path, err)
+	}
+	if crc32.ChecksumIEEE(value) != e.crc {
+		return nil, fmt.Errorf("segment %s: key %q: %w", s.path, e.key, errCorrupt)
+	}
+	return value, nil
+}
+
+func (s *segment) close() error {
+	return s.file.Close()
+}
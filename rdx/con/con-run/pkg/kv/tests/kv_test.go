@@ -0,0 +1,253 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"concoin/conrun/pkg/kv"
+)
+
+func open(t *testing.T, dir string, options kv.Options) *kv.DB {
+	db, err := kv.Open(dir, options)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	return db
+}
+
+func get(t *testing.T, db *kv.DB, key string) (string, bool) {
+	value, ok, err := db.Get(key)
+	if err != nil {
+		t.Fatalf("Failed to get %s: %v", key, err)
+	}
+	return string(value), ok
+}
+
+func keys(t *testing.T, db *kv.DB, start, end string) []string {
+	var result []string
+	if err := db.Range(start, end, func(key string, value []byte) bool {
+		result = append(result, key+"="+string(value))
+		return true
+	}); err != nil {
+		t.Fatalf("Failed to range: %v", err)
+	}
+	return result
+}
+
+func TestDB_PutGetDelete(t *testing.T) {
+	dir := t.TempDir()
+	db := open(t, dir, kv.Options{})
+
+	if err := db.Put("a", []byte("1")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if value, ok := get(t, db, "a"); !ok || value != "1" {
+		t.Errorf("Expected a=1, got %q %v", value, ok)
+	}
+	if ok, _ := db.Has("a"); !ok {
+		t.Errorf("Expected a to exist")
+	}
+
+	if err := db.Delete("a"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if _, ok := get(t, db, "a"); ok {
+		t.Errorf("Expected a to be deleted")
+	}
+	if ok, _ := db.Has("a"); ok {
+		t.Errorf("Expected a to be deleted")
+	}
+
+	// Empty values are stored as values, not as deletions
+	db.Put("empty", nil)
+	if value, ok := get(t, db, "empty"); !ok || value != "" {
+		t.Errorf("Expected an empty value, got %q %v", value, ok)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	if _, _, err := db.Get("a"); err != kv.ErrClosed {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+	if err := db.Put("a", nil); err != kv.ErrClosed {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
+
+func TestDB_Batch(t *testing.T) {
+	dir := t.TempDir()
+	db := open(t, dir, kv.Options{})
+
+	db.Put("old", []byte("x"))
+	var batch kv.Batch
+	batch.Put("a", []byte("1"))
+	batch.Put("b", []byte("2"))
+	batch.Delete("old")
+	batch.Put("a", []byte("3"))
+	if batch.Len() != 4 {
+		t.Errorf("Expected 4 operations, got %d", batch.Len())
+	}
+	if err := db.Write(&batch); err != nil {
+		t.Fatalf("Failed to write batch: %v", err)
+	}
+
+	// Later operations in a batch win
+	if got := keys(t, db, "", ""); fmt.Sprint(got) != "[a=3 b=2]" {
+		t.Errorf("Unexpected contents: %v", got)
+	}
+}
+
+func TestDB_Recovery(t *testing.T) {
+	dir := t.TempDir()
+	db := open(t, dir, kv.Options{})
+
+	var batch kv.Batch
+	batch.Put("a", []byte("1"))
+	batch.Put("b", []byte("2"))
+	db.Write(&batch)
+
+	// The database is never closed, as after a crash, so the batch is only in the log
+	reopened := open(t, dir, kv.Options{})
+	if got := keys(t, reopened, "", ""); fmt.Sprint(got) != "[a=1 b=2]" {
+		t.Errorf("Expected the log to be replayed, got %v", got)
+	}
+	reopened.Close()
+
+	// A batch cut short by a crash is dropped as a whole
+	db = open(t, dir, kv.Options{})
+	batch = kv.Batch{}
+	batch.Put("c", []byte("3"))
+	batch.Put("d", []byte("4"))
+	db.Write(&batch)
+
+	walPath := filepath.Join(dir, "wal.log")
+	data, err := os.ReadFile(walPath)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	if err := os.WriteFile(walPath, data[:len(data)-3], 0644); err != nil {
+		t.Fatalf("Failed to cut log: %v", err)
+	}
+
+	reopened = open(t, dir, kv.Options{})
+	defer reopened.Close()
+	if got := keys(t, reopened, "", ""); fmt.Sprint(got) != "[a=1 b=2]" {
+		t.Errorf("Expected the partial batch to be dropped, got %v", got)
+	}
+
+	// Writes after recovery go after the last good record
+	reopened.Put("e", []byte("5"))
+	reopened.Close()
+	final := open(t, dir, kv.Options{})
+	defer final.Close()
+	if got := keys(t, final, "", ""); fmt.Sprint(got) != "[a=1 b=2 e=5]" {
+		t.Errorf("Unexpected contents after recovery: %v", got)
+	}
+}
+
+func TestDB_Segments(t *testing.T) {
+	dir := t.TempDir()
+	options := kv.Options{MemtableSize: 256, MaxSegments: 4}
+	db := open(t, dir, options)
+
+	for i := 0; i < 200; i++ {
+		if err := db.Put(fmt.Sprintf("key-%03d", i), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	// Overwrites and deletions shadow values in older segments
+	for i := 0; i < 200; i += 2 {
+		db.Delete(fmt.Sprintf("key-%03d", i))
+	}
+	db.Put("key-001", []byte("new"))
+
+	stats := db.Stats()
+	if stats.Segments == 0 || stats.Segments > options.MaxSegments {
+		t.Errorf("Expected between 1 and %d segments, got %d", options.MaxSegments, stats.Segments)
+	}
+
+	check := func(db *kv.DB) {
+		got := keys(t, db, "key-000", "key-010")
+		if fmt.Sprint(got) != "[key-001=new key-003=value-3 key-005=value-5 key-007=value-7 key-009=value-9]" {
+			t.Errorf("Unexpected range: %v", got)
+		}
+		if all := keys(t, db, "", ""); len(all) != 100 {
+			t.Errorf("Expected 100 keys, got %d", len(all))
+		}
+		if value, ok := get(t, db, "key-199"); !ok || value != "value-199" {
+			t.Errorf("Expected key-199=value-199, got %q %v", value, ok)
+		}
+		if _, ok := get(t, db, "key-198"); ok {
+			t.Errorf("Expected key-198 to be deleted")
+		}
+	}
+	check(db)
+
+	// Range stops when the callback returns false
+	var seen int
+	db.Range("", "", func(key string, value []byte) bool {
+		seen++
+		return seen < 3
+	})
+	if seen != 3 {
+		t.Errorf("Expected range to stop after 3 keys, got %d", seen)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	db = open(t, dir, options)
+	check(db)
+
+	// Compaction leaves one segment without deleted keys
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+	if stats := db.Stats(); stats.Segments != 1 || stats.MemtableKeys != 0 {
+		t.Errorf("Expected one segment after compaction, got %+v", stats)
+	}
+	check(db)
+	db.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.sst"))
+	if len(files) != 1 {
+		t.Errorf("Expected old segment files to be removed, got %v", files)
+	}
+	db = open(t, dir, options)
+	defer db.Close()
+	check(db)
+}
+
+func TestDB_StrayFiles(t *testing.T) {
+	dir := t.TempDir()
+	db := open(t, dir, kv.Options{})
+	db.Put("a", []byte("1"))
+	db.Close()
+
+	// Files left by an interrupted flush are not in the manifest and are removed
+	os.WriteFile(filepath.Join(dir, "00000099.sst"), []byte("garbage"), 0644)
+	os.WriteFile(filepath.Join(dir, "00000100.sst.tmp"), []byte("garbage"), 0644)
+
+	db = open(t, dir, kv.Options{})
+	defer db.Close()
+	if value, ok := get(t, db, "a"); !ok || value != "1" {
+		t.Errorf("Expected a=1, got %q %v", value, ok)
+	}
+	for _, name := range []string{"00000099.sst", "00000100.sst.tmp"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed", name)
+		}
+	}
+
+	// The database keeps working after the cleanup
+	db.Put("b", []byte("2"))
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+	if value, ok := get(t, db, "b"); !ok || value != "2" {
+		t.Errorf("Expected b=2, got %q %v", value, ok)
+	}
+}
@@ -0,0 +1,169 @@
+package kv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+)
+
+// Операции пакета записи
+const (
+	opPut    byte = 0
+	opDelete byte = 1
+)
+
+// walHeaderSize длина и CRC32 записи журнала
+const walHeaderSize = 8
+
+var errCorrupt = errors.New("corrupt record")
+
+// wal журнал записей, еще не сброшенных в сегмент. Каждый пакет - одна
+// запись с длиной и CRC32, поэтому недописанный при сбое пакет
+// отбрасывается целиком.
+type wal struct {
+	file *os.File
+	size int64
+	sync bool
+}
+
+// openWAL открывает журнал и передает apply все целые пакеты из него.
+// Хвост после первой поврежденной записи обрезается.
+func openWAL(path string, sync bool, apply func(ops []op)) (*wal, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read write-ahead log: %w", err)
+	}
+
+	var good int
+	for good < len(data) {
+		ops, n, err := readWALRecord(data[good:])
+		if err != nil {
+			break
+		}
+		apply(ops)
+		good += n
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open write-ahead log: %w", err)
+	}
+	if good < len(data) {
+		if err := file.Truncate(int64(good)); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to truncate write-ahead log: %w", err)
+		}
+	}
+	return &wal{file: file, size: int64(good), sync: sync}, nil
+}
+
+// append дописывает пакет в журнал
+func (w *wal) append(ops []op) error {
+	payload := encodeOps(ops)
+	record := make([]byte, walHeaderSize, walHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	record = append(record, payload...)
+
+	if _, err := w.file.Write(record); err != nil {
+		return fmt.Errorf("failed to write to write-ahead log: %w", err)
+	}
+	w.size += int64(len(record))
+	if w.sync {
+		if err := w.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync write-ahead log: %w", err)
+		}
+	}
+	return nil
+}
+
+// reset очищает журнал после сброса его записей в сегмент
+func (w *wal) reset() error {
+	if err := w.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate write-ahead log: %w", err)
+	}
+	w.size = 0
+	return w.file.Sync()
+}
+
+func (w *wal) close() error {
+	if err := w.file.Sync(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// readWALRecord разбирает одну запись журнала и возвращает ее длину
+func readWALRecord(data []byte) ([]op, int, error) {
+	if len(data) < walHeaderSize {
+		return nil, 0, errCorrupt
+	}
+	length := int(binary.LittleEndian.Uint32(data[0:4]))
+	if length > len(data)-walHeaderSize {
+		return nil, 0, errCorrupt
+	}
+	payload := data[walHeaderSize : walHeaderSize+length]
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(data[4:8]) {
+		return nil, 0, errCorrupt
+	}
+	ops, err := decodeOps(payload)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ops, walHeaderSize + length, nil
+}
+
+// encodeOps записывает операции: тип, длина ключа, ключ, длина значения, значение
+func encodeOps(ops []op) []byte {
+	var buf []byte
+	for _, o := range ops {
+		buf = append(buf, o.kind)
+		buf = binary.AppendUvarint(buf, uint64(len(o.key)))
+		buf = append(buf, o.key...)
+		if o.kind == opPut {
+			buf = binary.AppendUvarint(buf, uint64(len(o.value)))
+			buf = append(buf, o.value...)
+		}
+	}
+	return buf
+}
+
+func decodeOps(data []byte) ([]op, error) {
+	var ops []op
+	for len(data) > 0 {
+		o := op{kind: data[0]}
+		data = data[1:]
+		if o.kind != opPut && o.kind != opDelete {
+			return nil, errCorrupt
+		}
+
+		key, rest, err := readBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		o.key, data = string(key), rest
+
+		if o.kind == opPut {
+			value, rest, err := readBytes(data)
+			if err != nil {
+				return nil, err
+			}
+			o.value, data = append([]byte{}, value...), rest
+		}
+		ops = append(ops, o)
+	}
+	return ops, nil
+}
+
+// readBytes читает байты с длиной в uvarint
+func readBytes(data []byte) ([]byte, []byte, error) {
+	length, n := binary.Uvarint(data)
+	if n <= 0 || length > uint64(len(data)-n) {
+		return nil, nil, errCorrupt
+	}
+	end := n + int(length)
+	return data[n:end], data[end:], nil
+}
@@ -0,0 +1,229 @@
+package limits
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"concoin/conrun/pkg/config"
+	"concoin/conrun/pkg/models"
+)
+
+var (
+	// ErrRateLimited возвращается, когда пир или все пиры вместе исчерпали лимит запросов
+	ErrRateLimited = errors.New("rate limit exceeded")
+
+	// ErrPayloadTooLarge возвращается для сообщений с payload больше лимита их типа
+	ErrPayloadTooLarge = errors.New("message payload too large")
+)
+
+const (
+	idleTimeout   = 10 * time.Minute // ведро пира без запросов удаляется
+	maxViolations = 256              // счетчиков превышений в статистике
+)
+
+// Причины превышений в статистике
+const (
+	ReasonPeerRate    = "peer rate"
+	ReasonGlobalRate  = "global rate"
+	ReasonConcurrency = "concurrent requests"
+	ReasonConnections = "connections"
+	ReasonBodySize    = "body size"
+	ReasonPayloadSize = "payload size"
+)
+
+// Limiter ограничивает входящие запросы: частоту запросов от одного пира
+// и от всех вместе (token bucket), число одновременно обрабатываемых
+// запросов, число соединений и размер сообщений. Превышения считаются
+// по пирам для отладочной страницы.
+type Limiter struct {
+	config   config.LimitsConfig
+	mutex    sync.Mutex
+	global   bucket
+	peers    map[string]*bucket
+	inflight int
+
+	connections int
+	perIP       map[string]int
+
+	violations map[string]*models.LimitViolation
+	lastPrune  time.Time
+}
+
+// Stats текущая загрузка и превышения лимитов
+type Stats struct {
+	Connections int                     `json:"connections"`
+	Inflight    int                     `json:"inflight"`
+	Violations  []models.LimitViolation `json:"violations"`
+}
+
+// bucket ведро токенов: пополняется со скоростью rate до burst токенов
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(burst int, now time.Time) *bucket {
+	return &bucket{tokens: float64(burst), last: now}
+}
+
+func (b *bucket) allow(rate float64, burst int, now time.Time) bool {
+	b.tokens = math.Min(float64(burst), b.tokens+rate*now.Sub(b.last).Seconds())
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// NewLimiter создает ограничитель с настройками cfg
+func NewLimiter(cfg config.LimitsConfig) *Limiter {
+	now := time.Now()
+	l := &Limiter{
+		config:     cfg,
+		global:     *newBucket(cfg.GlobalBurst, now),
+		peers:      make(map[string]*bucket),
+		perIP:      make(map[string]int),
+		violations: make(map[string]*models.LimitViolation),
+		lastPrune:  now,
+	}
+	return l
+}
+
+// SetConfig заменяет лимиты на ходу. Накопленные токены и открытые
+// соединения сохраняются, новые лимиты действуют для следующих запросов.
+func (l *Limiter) SetConfig(cfg config.LimitsConfig) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.config = cfg
+}
+
+// MaxBodySize возвращает наибольший размер тела запроса, 0 - без ограничения
+func (l *Limiter) MaxBodySize() int64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.config.MaxBodySize
+}
+
+// Allow расходует токен пира peer и общий токен. Возвращает ErrRateLimited
+// и причину, если один из них исчерпан.
+func (l *Limiter) Allow(peer string) (string, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastPrune) > idleTimeout {
+		for key, b := range l.peers {
+			if now.Sub(b.last) > idleTimeout {
+				delete(l.peers, key)
+			}
+		}
+		l.lastPrune = now
+	}
+
+	if l.config.PeerRate > 0 {
+		b, exists := l.peers[peer]
+		if !exists {
+			b = newBucket(l.config.PeerBurst, now)
+			l.peers[peer] = b
+		}
+		if !b.allow(l.config.PeerRate, l.config.PeerBurst, now) {
+			return ReasonPeerRate, ErrRateLimited
+		}
+	}
+	if l.config.GlobalRate > 0 && !l.global.allow(l.config.GlobalRate, l.config.GlobalBurst, now) {
+		return ReasonGlobalRate, ErrRateLimited
+	}
+	return "", nil
+}
+
+// Acquire занимает место для обработки запроса, не дожидаясь его.
+// Занятое место освобождается вызовом Release.
+func (l *Limiter) Acquire() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.config.MaxConcurrent > 0 && l.inflight >= l.config.MaxConcurrent {
+		return false
+	}
+	l.inflight++
+	return true
+}
+
+// Release освобождает место, занятое Acquire
+func (l *Limiter) Release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.inflight--
+}
+
+// CheckPayload проверяет размер payload сообщения по лимиту его типа
+func (l *Limiter) CheckPayload(message *models.GossipMessage) error {
+	l.mutex.Lock()
+	limit, exists := l.config.PayloadSizes[message.MessageType]
+	if !exists {
+		limit = l.config.MaxPayloadSize
+	}
+	l.mutex.Unlock()
+	if limit <= 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(message.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode payload: %w", err)
+	}
+	if len(data) > limit {
+		return fmt.Errorf("%w: %d bytes of %s, limit %d", ErrPayloadTooLarge, len(data), message.MessageType, limit)
+	}
+	return nil
+}
+
+// Report учитывает превышение лимита reason пиром peer
+func (l *Limiter) Report(peer, reason string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	key := peer + "|" + reason
+	violation, exists := l.violations[key]
+	if !exists {
+		// Вытесняем самый давний счетчик
+		if len(l.violations) >= maxViolations {
+			var oldest string
+			for k, v := range l.violations {
+				if oldest == "" || v.Last.Before(l.violations[oldest].Last) {
+					oldest = k
+				}
+			}
+			delete(l.violations, oldest)
+		}
+		violation = &models.LimitViolation{Peer: peer, Reason: reason}
+		l.violations[key] = violation
+	}
+	violation.Count++
+	violation.Last = time.Now()
+}
+
+// Stats возвращает загрузку и превышения, последние превышения первыми
+func (l *Limiter) Stats() Stats {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	stats := Stats{
+		Connections: l.connections,
+		Inflight:    l.inflight,
+		Violations:  make([]models.LimitViolation, 0, len(l.violations)),
+	}
+	for _, violation := range l.violations {
+		stats.Violations = append(stats.Violations, *violation)
+	}
+	sort.Slice(stats.Violations, func(i, j int) bool {
+		return stats.Violations[i].Last.After(stats.Violations[j].Last)
+	})
+	return stats
+}
@@ -0,0 +1,86 @@
+package limits
+
+import (
+	"net"
+	"sync"
+)
+
+// Listener ограничивает число открытых входящих соединений: всего и с одного
+// IP. Соединения сверх лимита закрываются сразу после приема. Соединения
+// с loopback не ограничиваются по IP, чтобы узлы тестовой сети на одной
+// машине не мешали друг другу.
+func (l *Limiter) Listener(inner net.Listener) net.Listener {
+	return &listener{Listener: inner, limiter: l}
+}
+
+type listener struct {
+	net.Listener
+	limiter *Limiter
+}
+
+func (ln *listener) Accept() (net.Conn, error) {
+	for {
+		conn, err := ln.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		ip := remoteIP(conn)
+		if !ln.limiter.open(ip) {
+			ln.limiter.Report(ip, ReasonConnections)
+			conn.Close()
+			continue
+		}
+		return &limitedConn{Conn: conn, release: func() { ln.limiter.close(ip) }}, nil
+	}
+}
+
+// open учитывает новое соединение, если лимиты это позволяют
+func (l *Limiter) open(ip string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.config.MaxConnections > 0 && l.connections >= l.config.MaxConnections {
+		return false
+	}
+	parsed := net.ParseIP(ip)
+	limitIP := l.config.MaxConnectionsPerIP > 0 && (parsed == nil || !parsed.IsLoopback())
+	if limitIP && l.perIP[ip] >= l.config.MaxConnectionsPerIP {
+		return false
+	}
+
+	l.connections++
+	l.perIP[ip]++
+	return true
+}
+
+// close учитывает закрытие соединения
+func (l *Limiter) close(ip string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.connections--
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+}
+
+// limitedConn освобождает место в лимите при первом закрытии
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
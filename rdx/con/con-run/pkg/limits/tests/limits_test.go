@@ -0,0 +1,167 @@
+package tests
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"concoin/conrun/pkg/config"
+	"concoin/conrun/pkg/limits"
+	"concoin/conrun/pkg/models"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	cfg := config.LimitsConfig{PeerRate: 20, PeerBurst: 2, GlobalRate: 20, GlobalBurst: 3}
+	limiter := limits.NewLimiter(cfg)
+
+	for i := 0; i < 2; i++ {
+		if _, err := limiter.Allow("peer-a"); err != nil {
+			t.Fatalf("Expected request %d within the burst to pass, got %v", i, err)
+		}
+	}
+	if reason, err := limiter.Allow("peer-a"); !errors.Is(err, limits.ErrRateLimited) || reason != limits.ReasonPeerRate {
+		t.Errorf("Expected the peer limit, got %q %v", reason, err)
+	}
+
+	// Another peer has its own bucket but shares the global one
+	if _, err := limiter.Allow("peer-b"); err != nil {
+		t.Errorf("Expected another peer to pass, got %v", err)
+	}
+	if reason, err := limiter.Allow("peer-b"); !errors.Is(err, limits.ErrRateLimited) || reason != limits.ReasonGlobalRate {
+		t.Errorf("Expected the global limit, got %q %v", reason, err)
+	}
+
+	// Tokens are refilled over time
+	time.Sleep(150 * time.Millisecond)
+	if _, err := limiter.Allow("peer-a"); err != nil {
+		t.Errorf("Expected the bucket to be refilled, got %v", err)
+	}
+
+	// Zero values disable the limits
+	unlimited := limits.NewLimiter(config.LimitsConfig{})
+	for i := 0; i < 1000; i++ {
+		if _, err := unlimited.Allow("peer-a"); err != nil || !unlimited.Acquire() {
+			t.Fatalf("Expected no limits, got %v", err)
+		}
+	}
+}
+
+func TestLimiter_Concurrency(t *testing.T) {
+	limiter := limits.NewLimiter(config.LimitsConfig{MaxConcurrent: 2})
+
+	if !limiter.Acquire() || !limiter.Acquire() {
+		t.Fatalf("Expected two requests to be admitted")
+	}
+	if limiter.Acquire() {
+		t.Errorf("Expected the third request to be refused")
+	}
+	if stats := limiter.Stats(); stats.Inflight != 2 {
+		t.Errorf("Expected 2 requests in progress, got %d", stats.Inflight)
+	}
+	limiter.Release()
+	if !limiter.Acquire() {
+		t.Errorf("Expected a released place to be reused")
+	}
+}
+
+func TestLimiter_CheckPayload(t *testing.T) {
+	limiter := limits.NewLimiter(config.LimitsConfig{
+		MaxPayloadSize: 100,
+		PayloadSizes:   map[string]int{"user_message": 10},
+	})
+
+	small := &models.GossipMessage{MessageType: "user_message", Payload: "hi"}
+	if err := limiter.CheckPayload(small); err != nil {
+		t.Errorf("Expected a small payload to pass, got %v", err)
+	}
+
+	// The limit depends on the message type
+	payload := strings.Repeat("x", 50)
+	if err := limiter.CheckPayload(&models.GossipMessage{MessageType: "user_message", Payload: payload}); !errors.Is(err, limits.ErrPayloadTooLarge) {
+		t.Errorf("Expected ErrPayloadTooLarge, got %v", err)
+	}
+	if err := limiter.CheckPayload(&models.GossipMessage{MessageType: "other", Payload: payload}); err != nil {
+		t.Errorf("Expected the default limit for other types, got %v", err)
+	}
+}
+
+func TestLimiter_Listener(t *testing.T) {
+	limiter := limits.NewLimiter(config.LimitsConfig{MaxConnections: 2})
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	listener := limiter.Listener(inner)
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 3)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to dial: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	dial()
+	first := <-accepted
+	dial()
+	<-accepted
+
+	// The connection over the limit is closed by the server
+	extra := dial()
+	extra.SetReadDeadline(time.Now().Add(time.Second))
+	var netErr net.Error
+	if _, err := extra.Read(make([]byte, 1)); err == nil || errors.As(err, &netErr) && netErr.Timeout() {
+		t.Errorf("Expected the connection over the limit to be closed, got %v", err)
+	}
+	if stats := limiter.Stats(); stats.Connections != 2 || len(stats.Violations) != 1 || stats.Violations[0].Reason != limits.ReasonConnections {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	// Closing a connection frees a place
+	first.Close()
+	dial()
+	select {
+	case <-accepted:
+	case <-time.After(time.Second):
+		t.Errorf("Expected a new connection to be accepted after one was closed")
+	}
+}
+
+func TestLimiter_SetConfig(t *testing.T) {
+	limiter := limits.NewLimiter(config.LimitsConfig{MaxConcurrent: 1, MaxPayloadSize: 10})
+	if !limiter.Acquire() || limiter.Acquire() {
+		t.Fatalf("Expected a single request to be admitted")
+	}
+
+	// New limits apply to requests already in progress
+	limiter.SetConfig(config.LimitsConfig{MaxConcurrent: 2, MaxPayloadSize: 100})
+	if !limiter.Acquire() {
+		t.Errorf("Expected the raised limit to admit another request")
+	}
+	limiter.Release()
+	limiter.Release()
+	if stats := limiter.Stats(); stats.Inflight != 0 {
+		t.Errorf("Expected no requests in progress, got %d", stats.Inflight)
+	}
+
+	payload := strings.Repeat("x", 50)
+	if err := limiter.CheckPayload(&models.GossipMessage{MessageType: "other", Payload: payload}); err != nil {
+		t.Errorf("Expected the raised payload limit to apply, got %v", err)
+	}
+}
@@ -0,0 +1,494 @@
+package mempool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"concoin/conrun/pkg/blockchain"
+	"concoin/conrun/pkg/chain"
+	"concoin/conrun/pkg/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Entry представляет собой транзакцию, ожидающую включения в блок
+type Entry struct {
+	Hash       blockchain.Hash        `json:"hash"`
+	Tx         blockchain.Transaction `json:"tx"`
+	Commission blockchain.Amount      `json:"commission"`
+	ReceivedAt time.Time              `json:"received_at"`
+}
+
+// Mempool хранит валидные транзакции, еще не вошедшие в основную цепочку.
+// Транзакции упорядочены по комиссии майнеру, затем по времени получения.
+type Mempool struct {
+	dataDir string
+	config  config.MempoolConfig
+	ledger  blockchain.Ledger
+	entries map[blockchain.Hash]*Entry
+	mutex   sync.RWMutex
+	logger  *logrus.Logger
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// NewMempool создает мемпул и загружает сохраненные транзакции из dataDir
+func NewMempool(dataDir string, cfg config.MempoolConfig, ledger blockchain.Ledger, logger *logrus.Logger) (*Mempool, error) {
+	m := &Mempool{
+		dataDir: dataDir,
+		config:  cfg,
+		ledger:  ledger,
+		entries: make(map[blockchain.Hash]*Entry),
+		logger:  logger,
+	}
+
+	if err := os.MkdirAll(m.mempoolDir(), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create mempool directory: %w", err)
+	}
+
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Start запускает периодическое удаление устаревших транзакций
+func (m *Mempool) Start(ctx context.Context) error {
+	m.logger.Info("Starting mempool")
+
+	ctx, m.cancel = context.WithCancel(ctx)
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		ticker := time.NewTicker(m.config.CleanupInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.removeExpired()
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop останавливает удаление устаревших транзакций. Транзакции сохраняются
+// на диск при добавлении, поэтому сбрасывать нечего.
+func (m *Mempool) Stop() {
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.wg.Wait()
+}
+
+// Check проверяет, может ли транзакция быть принята в мемпул
+func (m *Mempool) Check(tx blockchain.Transaction) error {
+	hash, err := blockchain.TransactionHash(tx)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if _, exists := m.entries[hash]; exists {
+		return nil
+	}
+	_, err = m.admit(tx)
+	return err
+}
+
+// Add проверяет транзакцию и добавляет ее в мемпул
+func (m *Mempool) Add(tx blockchain.Transaction) (blockchain.Hash, error) {
+	hash, err := blockchain.TransactionHash(tx)
+	if err != nil {
+		return "", err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.entries[hash]; exists {
+		return hash, nil
+	}
+
+	evicted, err := m.admit(tx)
+	if err != nil {
+		return "", err
+	}
+	if evicted != nil {
+		m.logger.Infof("Mempool: evicting transaction %s with commission %d", evicted.Hash, evicted.Commission)
+		m.remove(evicted.Hash)
+	}
+
+	entry := &Entry{
+		Hash:       hash,
+		Tx:         tx,
+		Commission: blockchain.Commission(tx),
+		ReceivedAt: time.Now().UTC(),
+	}
+	if err := m.saveEntry(entry); err != nil {
+		return "", err
+	}
+	m.entries[hash] = entry
+
+	m.logger.Infof("Mempool: added transaction %s (%s -> %s, %d)", hash, tx.From, tx.To, tx.Amount)
+	return hash, nil
+}
+
+// Has проверяет наличие транзакции в мемпуле
+func (m *Mempool) Has(hash blockchain.Hash) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	_, exists := m.entries[hash]
+	return exists
+}
+
+// Size возвращает количество транзакций в мемпуле
+func (m *Mempool) Size() int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return len(m.entries)
+}
+
+// Entries возвращает все транзакции мемпула в порядке приоритета
+func (m *Mempool) Entries() []Entry {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	sorted := m.sortedEntries()
+	result := make([]Entry, len(sorted))
+	for i, entry := range sorted {
+		result[i] = *entry
+	}
+	return result
+}
+
+// Pick выбирает до limit транзакций для нового блока в порядке приоритета так,
+// чтобы транзакции каждого отправителя шли подряд по nonce и не превышали его баланс,
+// а аккаунт создавался до любых других переводов на него в блоке
+func (m *Mempool) Pick(limit int) []blockchain.Transaction {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	senders := make(map[blockchain.Username]*blockchain.User)
+	spent := make(map[blockchain.Username]blockchain.Amount)
+	sent := make(map[blockchain.Username]blockchain.Nonce)
+	picked := make(map[blockchain.Hash]bool)
+	touched := make(map[blockchain.Username]bool)
+	var result []blockchain.Transaction
+
+	// Транзакция с высокой комиссией может ждать менее выгодную транзакцию
+	// того же отправителя с меньшим nonce, поэтому проходим очередь, пока что-то выбирается
+	sorted := m.sortedEntries()
+	for progress := true; progress && len(result) < limit; {
+		progress = false
+		for _, entry := range sorted {
+			if len(result) >= limit {
+				break
+			}
+			tx := entry.Tx
+			if picked[entry.Hash] {
+				continue
+			}
+			sender, ok := senders[tx.From]
+			if !ok {
+				sender, _ = m.ledger.FetchUser(tx.From)
+				senders[tx.From] = sender
+			}
+			if sender == nil || tx.Nonce != sender.Nonce+sent[tx.From]+1 || !blockchain.Affordable(tx, sender.Balance, spent[tx.From]) {
+				continue
+			}
+			if blockchain.CreatesAccount(tx) && touched[tx.To] {
+				continue
+			}
+			picked[entry.Hash] = true
+			touched[tx.From] = true
+			touched[tx.To] = true
+			spent[tx.From] += blockchain.Cost(tx)
+			sent[tx.From]++
+			result = append(result, tx)
+			progress = true
+		}
+	}
+	return result
+}
+
+// HandleTipChange убирает из мемпула транзакции новых блоков основной цепочки,
+// возвращает в него транзакции вытесненных блоков и удаляет ставшие невалидными
+func (m *Mempool) HandleTipChange(change chain.TipChange) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, block := range change.Connected {
+		for _, tx := range block.Txs {
+			if hash, err := blockchain.TransactionHash(tx); err == nil {
+				m.remove(hash)
+			}
+		}
+	}
+
+	orphaned := make(map[blockchain.Hash]bool)
+	for _, tx := range change.Orphaned {
+		hash, err := blockchain.TransactionHash(tx)
+		if err != nil {
+			continue
+		}
+		if _, exists := m.entries[hash]; exists {
+			continue
+		}
+		entry := &Entry{
+			Hash:       hash,
+			Tx:         tx,
+			Commission: blockchain.Commission(tx),
+			ReceivedAt: time.Now().UTC(),
+		}
+		if err := m.saveEntry(entry); err != nil {
+			m.logger.Warnf("Mempool: %v", err)
+			continue
+		}
+		m.entries[hash] = entry
+		orphaned[hash] = true
+	}
+
+	// Перепроверяем все транзакции, включая возвращенные, против нового состояния
+	m.revalidate()
+
+	returned := 0
+	for hash := range orphaned {
+		if _, exists := m.entries[hash]; exists {
+			returned++
+		}
+	}
+	if returned > 0 {
+		m.logger.Infof("Mempool: returned %d orphaned transactions", returned)
+	}
+}
+
+// admit проверяет транзакцию против состояния цепочки, других транзакций
+// отправителя и лимита размера. Если мемпул полон, возвращает транзакцию,
+// которую нужно вытеснить. Вызывается под блокировкой.
+func (m *Mempool) admit(tx blockchain.Transaction) (*Entry, error) {
+	pending := m.senderEntries(tx.From)
+	for _, entry := range pending {
+		if entry.Tx.Nonce == tx.Nonce {
+			return nil, &blockchain.ValidationError{
+				Code: blockchain.RejectDoubleSpend,
+				Reason: fmt.Sprintf("nonce %d of %q is already used by pending transaction %s",
+					tx.Nonce, tx.From, entry.Hash),
+			}
+		}
+	}
+
+	if blockchain.CreatesAccount(tx) {
+		for _, entry := range m.entries {
+			if blockchain.CreatesAccount(entry.Tx) && entry.Tx.To == tx.To {
+				return nil, &blockchain.ValidationError{
+					Code:   blockchain.RejectAccountExists,
+					Reason: fmt.Sprintf("account %q is already created by pending transaction %s", tx.To, entry.Hash),
+				}
+			}
+		}
+	}
+
+	// Nonce должен продолжать цепочку ожидающих транзакций отправителя
+	if err := blockchain.ValidatePendingTransaction(tx, m.ledger, 0, len(pending)); err != nil {
+		return nil, err
+	}
+
+	sender, err := m.ledger.FetchUser(tx.From)
+	if err != nil {
+		return nil, err
+	}
+	spent := blockchain.Amount(0)
+	for _, entry := range pending {
+		spent += blockchain.Cost(entry.Tx)
+	}
+	if !blockchain.Affordable(tx, sender.Balance, spent) {
+		return nil, &blockchain.ValidationError{
+			Code: blockchain.RejectDoubleSpend,
+			Reason: fmt.Sprintf("pending spends %d of %q plus amount %d with fee %d exceed balance %d",
+				spent, tx.From, tx.Amount, tx.Fee, sender.Balance),
+		}
+	}
+
+	if len(m.entries) < m.config.MaxSize {
+		return nil, nil
+	}
+
+	// Вытеснять можно только последнюю транзакцию отправителя,
+	// иначе следующие за ней никогда не попадут в блок
+	sorted := m.sortedEntries()
+	for i := len(sorted) - 1; i >= 0; i-- {
+		lowest := sorted[i]
+		if lowest.Commission >= blockchain.Commission(tx) {
+			break
+		}
+		if lowest.Tx.From == tx.From {
+			continue
+		}
+		if last := m.senderEntries(lowest.Tx.From); last[len(last)-1] == lowest {
+			return lowest, nil
+		}
+	}
+	return nil, &blockchain.ValidationError{
+		Code:   blockchain.RejectMempoolFull,
+		Reason: fmt.Sprintf("mempool is full (%d transactions) and commission is too low", len(m.entries)),
+	}
+}
+
+// revalidate проверяет транзакции каждого отправителя по порядку nonce против
+// текущего состояния и удаляет невалидные вместе со всеми следующими за ними.
+// Вызывается под блокировкой.
+func (m *Mempool) revalidate() {
+	senders := make(map[blockchain.Username]bool)
+	for _, entry := range m.entries {
+		senders[entry.Tx.From] = true
+	}
+
+	for sender := range senders {
+		spent := blockchain.Amount(0)
+		var invalid error
+		for i, entry := range m.senderEntries(sender) {
+			if invalid == nil {
+				invalid = blockchain.ValidatePendingTransaction(entry.Tx, m.ledger, spent, i)
+			}
+			if invalid != nil {
+				m.logger.Infof("Mempool: dropping transaction %s: %v", entry.Hash, invalid)
+				m.remove(entry.Hash)
+				continue
+			}
+			spent += blockchain.Cost(entry.Tx)
+		}
+	}
+}
+
+// senderEntries возвращает транзакции отправителя по возрастанию nonce.
+// Вызывается под блокировкой.
+func (m *Mempool) senderEntries(sender blockchain.Username) []*Entry {
+	var entries []*Entry
+	for _, entry := range m.entries {
+		if entry.Tx.From == sender {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Tx.Nonce < entries[j].Tx.Nonce
+	})
+	return entries
+}
+
+// removeExpired удаляет транзакции, пролежавшие в мемпуле дольше TxTTL
+func (m *Mempool) removeExpired() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	expired := 0
+	for hash, entry := range m.entries {
+		if now.Sub(entry.ReceivedAt) > m.config.TxTTL {
+			m.logger.Infof("Mempool: transaction %s expired", hash)
+			m.remove(hash)
+			expired++
+		}
+	}
+
+	// Транзакции после устаревшей больше не могут попасть в блок
+	if expired > 0 {
+		m.revalidate()
+	}
+}
+
+// sortedEntries возвращает транзакции по убыванию комиссии, затем по времени получения.
+// Вызывается под блокировкой.
+func (m *Mempool) sortedEntries() []*Entry {
+	entries := make([]*Entry, 0, len(m.entries))
+	for _, entry := range m.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Commission != entries[j].Commission {
+			return entries[i].Commission > entries[j].Commission
+		}
+		if !entries[i].ReceivedAt.Equal(entries[j].ReceivedAt) {
+			return entries[i].ReceivedAt.Before(entries[j].ReceivedAt)
+		}
+		return entries[i].Hash < entries[j].Hash
+	})
+	return entries
+}
+
+// remove удаляет транзакцию из памяти и с диска. Вызывается под блокировкой.
+func (m *Mempool) remove(hash blockchain.Hash) {
+	if _, exists := m.entries[hash]; !exists {
+		return
+	}
+	delete(m.entries, hash)
+	if err := os.Remove(m.entryPath(hash)); err != nil && !os.IsNotExist(err) {
+		m.logger.Warnf("Mempool: failed to remove transaction file %s: %v", hash, err)
+	}
+}
+
+// load загружает сохраненные транзакции, отбрасывая устаревшие и невалидные
+func (m *Mempool) load() error {
+	entries, err := os.ReadDir(m.mempoolDir())
+	if err != nil {
+		return fmt.Errorf("failed to read mempool directory: %w", err)
+	}
+
+	for _, dirEntry := range entries {
+		if dirEntry.IsDir() || filepath.Ext(dirEntry.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(m.mempoolDir(), dirEntry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			m.logger.Warnf("Mempool: skipping broken transaction file %s: %v", dirEntry.Name(), err)
+			continue
+		}
+		if time.Since(entry.ReceivedAt) > m.config.TxTTL {
+			os.Remove(path)
+			continue
+		}
+		m.entries[entry.Hash] = &entry
+	}
+	m.revalidate()
+
+	m.logger.Infof("Mempool: loaded %d transactions", len(m.entries))
+	return nil
+}
+
+// saveEntry сохраняет транзакцию на диск
+func (m *Mempool) saveEntry(entry *Entry) error {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal mempool entry: %w", err)
+	}
+	if err := os.WriteFile(m.entryPath(entry.Hash), data, 0644); err != nil {
+		return fmt.Errorf("failed to save mempool entry: %w", err)
+	}
+	return nil
+}
+
+func (m *Mempool) mempoolDir() string {
+	return filepath.Join(m.dataDir, "mempool")
+}
+
+func (m *Mempool) entryPath(hash blockchain.Hash) string {
+	return filepath.Join(m.mempoolDir(), fmt.Sprintf("%s.json", hash))
+}
@@ -0,0 +1,278 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"concoin/conrun/pkg/blockchain"
+	"concoin/conrun/pkg/chain"
+	"concoin/conrun/pkg/config"
+	"concoin/conrun/pkg/mempool"
+
+	"github.com/sirupsen/logrus"
+)
+
+// signTx подписывает транзакцию так же, как это делает con-send
+func signTx(t *testing.T, tx blockchain.Transaction, key *ecdsa.PrivateKey) blockchain.Transaction {
+	data, err := json.Marshal(struct {
+		From   string `json:"from"`
+		To     string `json:"to"`
+		Amount int    `json:"amount"`
+		Fee    int    `json:"fee,omitempty"`
+		Nonce  uint64 `json:"nonce"`
+		PubKey string `json:"pubKey,omitempty"`
+	}{tx.From, tx.To, tx.Amount, tx.Fee, tx.Nonce, tx.PubKey})
+	if err != nil {
+		t.Fatalf("Failed to marshal transaction: %v", err)
+	}
+	digest := sha256.Sum256(data)
+	tx.Signature, err = ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	return tx
+}
+
+// addUser добавляет в состояние пользователя с балансом и возвращает его ключ
+func addUser(t *testing.T, state *blockchain.State, user blockchain.Username, balance blockchain.Amount) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	state.Balances[user] = balance
+	state.PublicKeys[user] = hex.EncodeToString(elliptic.Marshal(elliptic.P256(), key.PublicKey.X, key.PublicKey.Y))
+	return key
+}
+
+func newTestState(t *testing.T) (*blockchain.State, *ecdsa.PrivateKey) {
+	state := blockchain.NewState()
+	return state, addUser(t, state, "Alice", 50)
+}
+
+func newTestMempool(t *testing.T, dataDir string, maxSize int, ledger blockchain.Ledger) *mempool.Mempool {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	cfg := config.DefaultConfig(3000, 0).MempoolConfig
+	cfg.MaxSize = maxSize
+	pool, err := mempool.NewMempool(dataDir, cfg, ledger, logger)
+	if err != nil {
+		t.Fatalf("Failed to create mempool: %v", err)
+	}
+	return pool
+}
+
+func requireRejectCode(t *testing.T, err error, code blockchain.RejectCode) {
+	t.Helper()
+	var validationErr *blockchain.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected validation error with code %s, got %v", code, err)
+	}
+	if validationErr.Code != code {
+		t.Errorf("Expected reject code %s, got %s (%s)", code, validationErr.Code, validationErr.Reason)
+	}
+}
+
+func TestMempool_AddAndConflicts(t *testing.T) {
+	state, key := newTestState(t)
+	dataDir := t.TempDir()
+	pool := newTestMempool(t, dataDir, 10, state)
+
+	tx1 := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 30, Nonce: 1}, key)
+	hash, err := pool.Add(tx1)
+	if err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+	if !pool.Has(hash) {
+		t.Errorf("Expected transaction %s in mempool", hash)
+	}
+
+	// Повторное добавление не создает дубликат
+	if _, err := pool.Add(tx1); err != nil || pool.Size() != 1 {
+		t.Errorf("Expected duplicate to be ignored, size %d, err %v", pool.Size(), err)
+	}
+
+	// Вторая трата превышает баланс вместе с первой
+	tx2 := signTx(t, blockchain.Transaction{From: "Alice", To: "Carol", Amount: 30, Nonce: 2}, key)
+	requireRejectCode(t, pool.Check(tx2), blockchain.RejectDoubleSpend)
+	_, err = pool.Add(tx2)
+	requireRejectCode(t, err, blockchain.RejectDoubleSpend)
+
+	// Вторая транзакция с тем же nonce конфликтует с ожидающей
+	sameNonce := signTx(t, blockchain.Transaction{From: "Alice", To: "Carol", Amount: 10, Nonce: 1}, key)
+	_, err = pool.Add(sameNonce)
+	requireRejectCode(t, err, blockchain.RejectDoubleSpend)
+
+	// Пропуск nonce не допускается
+	gap := signTx(t, blockchain.Transaction{From: "Alice", To: "Carol", Amount: 10, Nonce: 3}, key)
+	_, err = pool.Add(gap)
+	requireRejectCode(t, err, blockchain.RejectNonceOutOfOrder)
+
+	// Невалидная транзакция не принимается
+	bad := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 10, Nonce: 2}, key)
+	bad.Amount = 5
+	_, err = pool.Add(bad)
+	requireRejectCode(t, err, blockchain.RejectBadSignature)
+
+	// Мемпул переживает перезапуск
+	reloaded := newTestMempool(t, dataDir, 10, state)
+	if !reloaded.Has(hash) {
+		t.Errorf("Expected transaction %s after reload", hash)
+	}
+}
+
+func TestMempool_AccountCreation(t *testing.T) {
+	state, aliceKey := newTestState(t)
+	bobKey := addUser(t, state, "Bob", 50)
+	pool := newTestMempool(t, t.TempDir(), 10, state)
+
+	carolKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	carolPubKey := hex.EncodeToString(elliptic.Marshal(elliptic.P256(), carolKey.PublicKey.X, carolKey.PublicKey.Y))
+
+	create := signTx(t, blockchain.Transaction{From: "Alice", To: "Carol", Amount: 10, Nonce: 1, PubKey: carolPubKey}, aliceKey)
+	if _, err := pool.Add(create); err != nil {
+		t.Fatalf("Failed to add account creation: %v", err)
+	}
+
+	// Аккаунт уже создается ожидающей транзакцией другого отправителя
+	another := signTx(t, blockchain.Transaction{From: "Bob", To: "Carol", Amount: 10, Nonce: 1, PubKey: carolPubKey}, bobKey)
+	_, err = pool.Add(another)
+	requireRejectCode(t, err, blockchain.RejectAccountExists)
+
+	// Перевод на создаваемый аккаунт попадает в блок после его создания
+	transfer := signTx(t, blockchain.Transaction{From: "Bob", To: "Carol", Amount: 5, Nonce: 1}, bobKey)
+	if _, err := pool.Add(transfer); err != nil {
+		t.Fatalf("Failed to add transfer: %v", err)
+	}
+	picked := pool.Pick(10)
+	if len(picked) != 2 || picked[0].PubKey != carolPubKey {
+		t.Errorf("Expected account creation to be picked before the transfer, got %+v", picked)
+	}
+}
+
+func TestMempool_OrderingAndLimits(t *testing.T) {
+	state, aliceKey := newTestState(t)
+	bobKey := addUser(t, state, "Bob", 50)
+	pool := newTestMempool(t, t.TempDir(), 2, state)
+
+	plain := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 10, Nonce: 1}, aliceKey)
+	commission := signTx(t, blockchain.Transaction{From: "Bob", To: blockchain.MinerUsername, Amount: 2, Nonce: 1}, bobKey)
+
+	if _, err := pool.Add(plain); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+	if _, err := pool.Add(commission); err != nil {
+		t.Fatalf("Failed to add commission transaction: %v", err)
+	}
+
+	entries := pool.Entries()
+	if len(entries) != 2 || entries[0].Tx.To != blockchain.MinerUsername {
+		t.Fatalf("Expected commission transaction first, got %+v", entries)
+	}
+
+	// Мемпул полон: транзакция без комиссии отклоняется
+	another := signTx(t, blockchain.Transaction{From: "Alice", To: "Carol", Amount: 1, Nonce: 2}, aliceKey)
+	_, err := pool.Add(another)
+	requireRejectCode(t, err, blockchain.RejectMempoolFull)
+
+	// Транзакция с большей комиссией вытесняет транзакцию без комиссии
+	richer := signTx(t, blockchain.Transaction{From: "Bob", To: blockchain.MinerUsername, Amount: 3, Nonce: 2}, bobKey)
+	if _, err := pool.Add(richer); err != nil {
+		t.Fatalf("Expected higher commission transaction to evict, got %v", err)
+	}
+	if pool.Size() != 2 || pool.Has(mustHash(t, plain)) {
+		t.Errorf("Expected transaction without commission to be evicted, size %d", pool.Size())
+	}
+
+	// Транзакция с большей комиссией ждет предыдущую транзакцию отправителя
+	picked := pool.Pick(1)
+	if len(picked) != 1 || picked[0].Nonce != 1 {
+		t.Errorf("Expected transaction with the lowest nonce to be picked, got %+v", picked)
+	}
+	picked = pool.Pick(2)
+	if len(picked) != 2 || picked[0].Nonce != 1 || picked[1].Nonce != 2 {
+		t.Errorf("Expected transactions in nonce order, got %+v", picked)
+	}
+}
+
+func mustHash(t *testing.T, tx blockchain.Transaction) blockchain.Hash {
+	hash, err := blockchain.TransactionHash(tx)
+	if err != nil {
+		t.Fatalf("Failed to hash transaction: %v", err)
+	}
+	return hash
+}
+
+func TestMempool_FeePriority(t *testing.T) {
+	state, aliceKey := newTestState(t)
+	bobKey := addUser(t, state, "Bob", 50)
+	pool := newTestMempool(t, t.TempDir(), 10, state)
+
+	withFee := signTx(t, blockchain.Transaction{From: "Alice", To: "Carol", Amount: 10, Fee: 2, Nonce: 1}, aliceKey)
+	withFeeAndTip := signTx(t, blockchain.Transaction{From: "Bob", To: blockchain.MinerUsername, Amount: 1, Fee: 2, Nonce: 1}, bobKey)
+	for _, tx := range []blockchain.Transaction{withFee, withFeeAndTip} {
+		if _, err := pool.Add(tx); err != nil {
+			t.Fatalf("Failed to add transaction: %v", err)
+		}
+	}
+
+	entries := pool.Entries()
+	if len(entries) != 2 || entries[0].Tx.From != "Bob" || entries[0].Commission != 3 || entries[1].Commission != 2 {
+		t.Fatalf("Expected transactions ordered by fee plus transfer to cc, got %+v", entries)
+	}
+
+	// Комиссия списывается с отправителя вместе с переводом
+	tooMuch := signTx(t, blockchain.Transaction{From: "Alice", To: "Carol", Amount: 38, Fee: 1, Nonce: 2}, aliceKey)
+	_, err := pool.Add(tooMuch)
+	requireRejectCode(t, err, blockchain.RejectDoubleSpend)
+
+	// Сумма перевода с комиссией не переполняется
+	overflow := signTx(t, blockchain.Transaction{From: "Alice", To: "Carol", Amount: math.MaxInt64, Fee: 1, Nonce: 2}, aliceKey)
+	_, err = pool.Add(overflow)
+	requireRejectCode(t, err, blockchain.RejectInsufficientFunds)
+}
+
+func TestMempool_HandleTipChange(t *testing.T) {
+	state, key := newTestState(t)
+	pool := newTestMempool(t, t.TempDir(), 10, state)
+
+	included := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 10, Nonce: 1}, key)
+	next := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 10, Nonce: 2}, key)
+	for _, tx := range []blockchain.Transaction{included, next} {
+		if _, err := pool.Add(tx); err != nil {
+			t.Fatalf("Failed to add transaction: %v", err)
+		}
+	}
+
+	// Транзакция вошла в блок основной цепочки, следующая за ней остается
+	state.Balances["Alice"] = 40
+	state.Nonces["Alice"] = 1
+	pool.HandleTipChange(chain.TipChange{
+		Connected: []*blockchain.Block{{Hash: "block", Txs: []blockchain.Transaction{included}, Time: time.Now().Unix()}},
+	})
+	if pool.Size() != 1 || !pool.Has(mustHash(t, next)) {
+		t.Fatalf("Expected only the included transaction to be removed, size %d", pool.Size())
+	}
+
+	// Блок вытеснен реорганизацией, транзакция возвращается в мемпул
+	state.Balances["Alice"] = 50
+	delete(state.Nonces, "Alice")
+	pool.HandleTipChange(chain.TipChange{
+		Disconnected: []*blockchain.Block{{Hash: "block", Txs: []blockchain.Transaction{included}}},
+		Orphaned:     []blockchain.Transaction{included},
+	})
+	if pool.Size() != 2 {
+		t.Errorf("Expected orphaned transaction to return to mempool, size %d", pool.Size())
+	}
+}
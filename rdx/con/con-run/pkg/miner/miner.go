@@ -0,0 +1,252 @@
+package miner
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"concoin/conrun/pkg/blockchain"
+	"concoin/conrun/pkg/chain"
+	"concoin/conrun/pkg/config"
+	"concoin/conrun/pkg/interfaces"
+
+	"github.com/sirupsen/logrus"
+)
+
+// idleInterval период, с которым майнер проверяет мемпул, когда собирать блок не из чего
+const idleInterval = time.Second
+
+// abortCheckInterval число попыток nonce между проверками сигнала остановки
+const abortCheckInterval = 1024
+
+// PublishFunc публикует найденный блок в сети
+type PublishFunc func(block *blockchain.Block) error
+
+// Miner собирает блоки из транзакций мемпула и подбирает для них nonce
+// в нескольких горутинах. Работа начинается заново при смене последнего блока.
+type Miner struct {
+	config          config.MinerConfig
+	maxTransactions int
+	chain           interfaces.ChainInterface
+	mempool         interfaces.MempoolInterface
+	publish         PublishFunc
+	restart         chan struct{}
+	hashes          atomic.Uint64
+	logger          *logrus.Logger
+	cancel          context.CancelFunc
+	wg              sync.WaitGroup
+}
+
+// NewMiner создает новый майнер
+func NewMiner(cfg config.MinerConfig, maxTransactions int, chain interfaces.ChainInterface, mempool interfaces.MempoolInterface, publish PublishFunc, logger *logrus.Logger) *Miner {
+	if cfg.Threads < 1 {
+		cfg.Threads = 1
+	}
+
+	return &Miner{
+		config:          cfg,
+		maxTransactions: maxTransactions,
+		chain:           chain,
+		mempool:         mempool,
+		publish:         publish,
+		restart:         make(chan struct{}, 1),
+		logger:          logger,
+	}
+}
+
+// Start запускает майнинг
+func (m *Miner) Start(ctx context.Context) error {
+	m.logger.Infof("Starting miner %s with %d threads", m.config.MinerID, m.config.Threads)
+
+	ctx, m.cancel = context.WithCancel(ctx)
+	m.wg.Add(1)
+	go m.loop(ctx)
+	return nil
+}
+
+// Stop прерывает подбор nonce и дожидается остановки потоков майнинга
+func (m *Miner) Stop() {
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.wg.Wait()
+}
+
+// HandleTipChange прерывает подбор nonce для устаревшего кандидата
+func (m *Miner) HandleTipChange(change chain.TipChange) {
+	select {
+	case m.restart <- struct{}{}:
+	default:
+	}
+}
+
+// Hashes возвращает число вычисленных хешей
+func (m *Miner) Hashes() uint64 {
+	return m.hashes.Load()
+}
+
+// NewCandidate собирает блок-кандидат поверх последнего блока цепочки.
+// Возвращает nil, если подходящих транзакций нет или время блока еще не наступило.
+func (m *Miner) NewCandidate() (*blockchain.Block, error) {
+	txs := m.mempool.Pick(m.maxTransactions)
+	if len(txs) == 0 {
+		return nil, nil
+	}
+
+	target, err := m.chain.NextTarget()
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute difficulty target: %w", err)
+	}
+
+	block := &blockchain.Block{
+		DifficultyTarget: target,
+		BalancesDelta:    blockchain.BalancesDelta(txs, m.config.MinerID),
+		Txs:              txs,
+		Miner:            m.config.MinerID,
+		Reward:           blockchain.BlockReward,
+		Time:             time.Now().UTC().Unix(),
+		PrevBlockHash:    m.chain.LastBlockHash(),
+	}
+
+	if block.PrevBlockHash != nil {
+		prevBlock, err := m.chain.FetchBlock(*block.PrevBlockHash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch last block: %w", err)
+		}
+		// Время блока должно быть строго больше времени предыдущего
+		if block.Time <= prevBlock.Time {
+			return nil, nil
+		}
+	}
+
+	return block, nil
+}
+
+// loop собирает кандидатов и подбирает для них nonce, пока узел работает
+func (m *Miner) loop(ctx context.Context) {
+	defer m.wg.Done()
+
+	for {
+		// Сигнал о смене блока, пришедший до сборки кандидата, уже учтен
+		select {
+		case <-m.restart:
+		default:
+		}
+
+		candidate, err := m.NewCandidate()
+		if err != nil {
+			m.logger.Warnf("Miner: failed to assemble candidate: %v", err)
+		}
+		if candidate == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-m.restart:
+			case <-time.After(idleInterval):
+			}
+			continue
+		}
+
+		abort := make(chan struct{})
+		result := make(chan *blockchain.Block, 1)
+		go func() {
+			result <- m.solve(candidate, abort)
+		}()
+
+		select {
+		case block := <-result:
+			if block == nil {
+				continue
+			}
+			m.logger.Infof("Miner: found block %s with %d transactions", block.Hash, len(block.Txs))
+			if err := m.publish(block); err != nil {
+				m.logger.Warnf("Miner: failed to publish block %s: %v", block.Hash, err)
+			}
+		case <-m.restart:
+			close(abort)
+			<-result
+			m.logger.Debug("Miner: tip changed, restarting")
+		case <-ctx.Done():
+			close(abort)
+			<-result
+			return
+		}
+	}
+}
+
+// solve подбирает nonce для блока в config.Threads горутинах.
+// Горутина i перебирает nonce i, i+Threads, i+2*Threads, ...
+// Возвращает nil, если подбор прерван через abort.
+func (m *Miner) solve(candidate *blockchain.Block, abort <-chan struct{}) *blockchain.Block {
+	target, err := blockchain.ParseTarget(candidate.DifficultyTarget)
+	if err != nil {
+		m.logger.Warnf("Miner: %v", err)
+		return nil
+	}
+
+	done := make(chan struct{})
+	found := make(chan *blockchain.Block, 1)
+	var once sync.Once
+	stop := func() { once.Do(func() { close(done) }) }
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < m.config.Threads; worker++ {
+		wg.Add(1)
+		go func(start uint64) {
+			defer wg.Done()
+
+			block := *candidate
+			step := uint64(m.config.Threads)
+			for nonce, tries := start, uint64(1); ; nonce, tries = nonce+step, tries+1 {
+				block.Nonce = strconv.FormatUint(nonce, 10)
+				hash, err := blockchain.CalculateBlockHash(block)
+				if err != nil {
+					m.logger.Warnf("Miner: failed to hash block: %v", err)
+					stop()
+					return
+				}
+				if blockchain.MeetsTarget(hash, target) {
+					m.hashes.Add(tries)
+					block.Hash = hash
+					select {
+					case found <- &block:
+					default:
+					}
+					stop()
+					return
+				}
+
+				if tries == abortCheckInterval {
+					m.hashes.Add(tries)
+					tries = 0
+					select {
+					case <-done:
+						return
+					default:
+					}
+				}
+			}
+		}(uint64(worker))
+	}
+
+	go func() {
+		select {
+		case <-abort:
+			stop()
+		case <-done:
+		}
+	}()
+
+	wg.Wait()
+	stop()
+
+	select {
+	case block := <-found:
+		return block
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"concoin/conrun/pkg/blockchain"
+	"concoin/conrun/pkg/chain"
+	"concoin/conrun/pkg/config"
+	"concoin/conrun/pkg/mempool"
+	"concoin/conrun/pkg/miner"
+
+	"github.com/sirupsen/logrus"
+)
+
+// signTx подписывает транзакцию так же, как это делает con-send
+func signTx(t *testing.T, tx blockchain.Transaction, key *ecdsa.PrivateKey) blockchain.Transaction {
+	data, err := json.Marshal(struct {
+		From   string `json:"from"`
+		To     string `json:"to"`
+		Amount int    `json:"amount"`
+		Fee    int    `json:"fee,omitempty"`
+		Nonce  uint64 `json:"nonce"`
+		PubKey string `json:"pubKey,omitempty"`
+	}{tx.From, tx.To, tx.Amount, tx.Fee, tx.Nonce, tx.PubKey})
+	if err != nil {
+		t.Fatalf("Failed to marshal transaction: %v", err)
+	}
+	digest := sha256.Sum256(data)
+	tx.Signature, err = ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	return tx
+}
+
+// writeGenesis записывает actual_state.json с балансом и ключом Alice
+func writeGenesis(t *testing.T, dataDir string) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	pubKey := hex.EncodeToString(elliptic.Marshal(elliptic.P256(), key.PublicKey.X, key.PublicKey.Y))
+
+	data, err := json.Marshal(map[string]interface{}{
+		"cc-1": map[string]int{"Alice": 50},
+		"cc-3": map[string]string{"Alice": pubKey},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal genesis: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dataDir, "actual_state.json"), data, 0644); err != nil {
+		t.Fatalf("Failed to write genesis: %v", err)
+	}
+	return key
+}
+
+func TestMiner_MinesBlockFromMempool(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	dataDir := t.TempDir()
+	key := writeGenesis(t, dataDir)
+
+	chainState, err := chain.NewChain(dataDir, logger)
+	if err != nil {
+		t.Fatalf("Failed to create chain: %v", err)
+	}
+	pool, err := mempool.NewMempool(dataDir, config.DefaultConfig(3000, 0).MempoolConfig, chainState, logger)
+	if err != nil {
+		t.Fatalf("Failed to create mempool: %v", err)
+	}
+	chainState.AddTipListener(pool.HandleTipChange)
+
+	published := make(chan *blockchain.Block, 1)
+	cfg := config.MinerConfig{Enabled: true, MinerID: "Scrooge", Threads: 4}
+	blockMiner := miner.NewMiner(cfg, 10, chainState, pool, func(block *blockchain.Block) error {
+		if err := chainState.AddBlock(block); err != nil {
+			return err
+		}
+		published <- block
+		return nil
+	}, logger)
+	chainState.AddTipListener(blockMiner.HandleTipChange)
+
+	// Без транзакций кандидат не собирается
+	candidate, err := blockMiner.NewCandidate()
+	if err != nil || candidate != nil {
+		t.Fatalf("Expected no candidate for empty mempool, got %+v, %v", candidate, err)
+	}
+
+	tx := signTx(t, blockchain.Transaction{From: "Alice", To: "Bob", Amount: 20, Fee: 2, Nonce: 1}, key)
+	if _, err := pool.Add(tx); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+
+	if err := blockMiner.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start miner: %v", err)
+	}
+	defer blockMiner.Stop()
+
+	var block *blockchain.Block
+	select {
+	case block = <-published:
+	case <-time.After(30 * time.Second):
+		t.Fatal("Miner did not find a block in time")
+	}
+
+	if block.Miner != "Scrooge" || len(block.Txs) != 1 || block.PrevBlockHash != nil {
+		t.Errorf("Unexpected block: %+v", block)
+	}
+	if block.BalancesDelta["Scrooge"] != blockchain.BlockReward+2 {
+		t.Errorf("Expected miner reward and fee in balances delta, got %v", block.BalancesDelta)
+	}
+
+	state := chainState.Snapshot()
+	if state.Tip == nil || *state.Tip != block.Hash {
+		t.Errorf("Expected tip %s, got %v", block.Hash, state.Tip)
+	}
+	if state.Balances["Alice"] != 28 || state.Balances["Bob"] != 20 || state.Balances["Scrooge"] != 3 {
+		t.Errorf("Unexpected balances after mined block: %v", state.Balances)
+	}
+	if pool.Size() != 0 {
+		t.Errorf("Expected mined transaction to leave mempool, size %d", pool.Size())
+	}
+	if blockMiner.Hashes() == 0 {
+		t.Errorf("Expected hash counter to grow")
+	}
+}
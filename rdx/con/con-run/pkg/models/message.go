@@ -4,20 +4,53 @@ import "time"
 
 // GossipMessage представляет собой сообщение в Gossip протоколе
 type GossipMessage struct {
-	MessageID   string      `json:"message_id"`   // SHA-256 хеш
-	OriginID    string      `json:"origin_id"`    // идентификатор_узла
+	MessageID   string      `json:"message_id"`   // SHA-256 хеш содержимого без TTL
+	OriginID    string      `json:"origin_id"`    // идентификатор_узла, выведенный из PublicKey
 	Timestamp   time.Time   `json:"timestamp"`    // UTC timestamp
 	TTL         int         `json:"ttl"`          // число
 	MessageType string      `json:"message_type"` // тип сообщения
 	Payload     interface{} `json:"payload"`      // Содержимое сообщения
+	PublicKey   string      `json:"public_key"`   // hex Ed25519 ключа отправителя
+	Signature   string      `json:"signature"`    // hex подписи MessageID
+
+	// Decoded содержимое в Go типе из реестра типов сообщений, заполняется DecodeMessage
+	Decoded interface{} `json:"-"`
+}
+
+// GossipDigest компактная сводка сообщений узла для anti-entropy синхронизации:
+// фильтр Блума по ID всех сохраненных сообщений
+type GossipDigest struct {
+	NodeID string `json:"node_id"` // идентификатор_узла
+	Count  int    `json:"count"`   // число сообщений в фильтре
+	Seed   uint64 `json:"seed"`    // соль хеш-функций, меняется каждый раунд
+	Hashes int    `json:"hashes"`  // число хеш-функций
+	Filter []byte `json:"filter"`  // биты фильтра
+}
+
+// GossipSync сообщение anti-entropy обмена: сводка отправителя
+// и сообщения, которых, судя по сводке, нет у получателя
+type GossipSync struct {
+	Digest   *GossipDigest   `json:"digest,omitempty"`
+	Messages []GossipMessage `json:"messages,omitempty"`
+}
+
+// MessageBatch порция сообщений для инкрементальной синхронизации.
+// Номера сообщений локальны для узла и растут в порядке сохранения.
+type MessageBatch struct {
+	Messages []GossipMessage `json:"messages"` // сообщения с номерами после запрошенного
+	Cursor   uint64          `json:"cursor"`   // номер последнего сообщения порции
+	Head     uint64          `json:"head"`     // номер последнего сохраненного сообщения
+	More     bool            `json:"more"`     // есть ли сообщения после Cursor
 }
 
 // PexMessage представляет собой сообщение в PEX протоколе
 type PexMessage struct {
-	MessageID string    `json:"message_id"` // уникальный_идентификатор
-	Type      PexType   `json:"type"`       // pex_request|pex_response
-	Timestamp time.Time `json:"timestamp"`  // UTC timestamp
-	Peers     []Peer    `json:"peers"`      // Список пиров
+	MessageID    string    `json:"message_id"`              // уникальный_идентификатор
+	Type         PexType   `json:"type"`                    // pex_request|pex_response
+	Timestamp    time.Time `json:"timestamp"`               // UTC timestamp
+	Peers        []Peer    `json:"peers"`                   // Список пиров
+	SenderID     string    `json:"sender_id,omitempty"`     // идентификатор_отправителя
+	ObservedAddr string    `json:"observed_addr,omitempty"` // IP отправителя запроса, каким его видит получатель
 }
 
 // PexType тип сообщения в PEX протоколе
@@ -34,3 +67,55 @@ type Peer struct {
 	Address  string    `json:"address"`   // IP:PORT
 	LastSeen time.Time `json:"last_seen"` // UTC timestamp
 }
+
+// Ban запрет на обмен с пиром, нарушавшим протокол
+type Ban struct {
+	NodeID string    `json:"node_id"` // идентификатор_узла
+	Reason string    `json:"reason"`  // последнее нарушение перед баном
+	Until  time.Time `json:"until"`   // UTC timestamp окончания бана
+}
+
+// LimitViolation счетчик превышений лимитов входящих запросов
+type LimitViolation struct {
+	Peer   string    `json:"peer"`   // ID или IP пира
+	Reason string    `json:"reason"` // какой лимит превышен
+	Count  int       `json:"count"`
+	Last   time.Time `json:"last"` // время последнего превышения
+}
+
+// PeerScore оценка пира по его поведению и доступности
+type PeerScore struct {
+	NodeID       string        `json:"node_id"`      // идентификатор_узла
+	Score        float64       `json:"score"`        // итоговая оценка
+	Behaviour    float64       `json:"behaviour"`    // оценка по присланным сообщениям и адресам
+	Availability float64       `json:"availability"` // скользящая доля успешных запросов
+	Latency      time.Duration `json:"latency"`      // скользящее среднее задержки ответа
+	Valid        int           `json:"valid"`        // принятых сообщений
+	Rejected     int           `json:"rejected"`     // сообщений, отклоненных хуками
+	Invalid      int           `json:"invalid"`      // сообщений с неверной подписью или хешем
+	Unreachable  int           `json:"unreachable"`  // анонсированных недоступных адресов
+	Limited      int           `json:"limited"`      // превышений лимитов запросов
+	Failures     int           `json:"failures"`     // неудачных запросов
+}
+
+// Rejection описывает причину, по которой хук отклонил сообщение
+type Rejection struct {
+	Hook   string `json:"hook"`   // имя хука
+	Code   string `json:"code"`   // машиночитаемый код причины
+	Reason string `json:"reason"` // описание для человека
+}
+
+// HookStats счетчики работы хука
+type HookStats struct {
+	Hook       string        `json:"hook"`
+	Priority   int           `json:"priority"`
+	Validated  int64         `json:"validated"`   // сообщений принято
+	Rejected   int64         `json:"rejected"`    // сообщений отклонено
+	Handled    int64         `json:"handled"`     // сообщений обработано
+	Failed     int64         `json:"failed"`      // ошибок обработки
+	Workers    int           `json:"workers"`     // фоновых обработчиков, 0 - обработка в вызывающей горутине
+	Queued     int           `json:"queued"`      // сообщений в очереди
+	QueueSize  int           `json:"queue_size"`  // размер очереди
+	Overflows  int64         `json:"overflows"`   // ожиданий свободного места в полной очереди
+	HandleTime time.Duration `json:"handle_time"` // суммарное время обработки
+}
@@ -0,0 +1,140 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// UserMessageType тип сообщений пользователей, которые узел только хранит
+// и рассылает. Их содержимое - любое значение JSON.
+const UserMessageType = "user_message"
+
+var (
+	// ErrInvalidPayload содержимое не соответствует типу сообщения
+	ErrInvalidPayload = errors.New("invalid payload")
+	// ErrPayloadTooLarge содержимое больше наибольшего размера типа сообщения
+	ErrPayloadTooLarge = errors.New("payload too large")
+)
+
+// MessageSpec описывает тип gossip-сообщения в реестре
+type MessageSpec struct {
+	Type     string                          // значение MessageType
+	New      func() interface{}              // новое значение Go типа содержимого (указатель), в него декодируется JSON
+	Schema   *Schema                         // схема JSON содержимого, nil - не проверяется
+	Validate func(payload interface{}) error // проверка декодированного содержимого, nil - не проверяется
+	MaxSize  int                             // наибольший размер содержимого в байтах JSON, 0 - без ограничения
+}
+
+var (
+	registryMutex sync.RWMutex
+	registry      = make(map[string]MessageSpec)
+)
+
+func init() {
+	RegisterMessageType(MessageSpec{
+		Type:    UserMessageType,
+		New:     func() interface{} { return new(interface{}) },
+		MaxSize: 64 << 10,
+	})
+}
+
+// RegisterMessageType добавляет тип сообщения в реестр. Типы регистрируются
+// при инициализации пакетов, поэтому повторная регистрация - ошибка
+// программы и вызывает панику.
+func RegisterMessageType(spec MessageSpec) {
+	if spec.Type == "" || spec.New == nil {
+		panic("models: message spec needs a type and a payload constructor")
+	}
+
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if _, exists := registry[spec.Type]; exists {
+		panic(fmt.Sprintf("models: message type %q registered twice", spec.Type))
+	}
+	registry[spec.Type] = spec
+}
+
+// LookupMessageType возвращает описание типа сообщения
+func LookupMessageType(messageType string) (MessageSpec, bool) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	spec, exists := registry[messageType]
+	return spec, exists
+}
+
+// MessageTypes возвращает описания всех зарегистрированных типов по алфавиту
+func MessageTypes() []MessageSpec {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	specs := make([]MessageSpec, 0, len(registry))
+	for _, spec := range registry {
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Type < specs[j].Type })
+	return specs
+}
+
+// DecodeMessage разбирает содержимое сообщения по реестру: проверяет размер
+// и схему, декодирует JSON в Go тип содержимого и проверяет результат.
+// Разобранное содержимое сохраняется в message.Decoded, хуки берут его
+// оттуда без повторного разбора. Сообщения незарегистрированных типов
+// не разбираются.
+func DecodeMessage(message *GossipMessage) error {
+	decoded, err := decodePayload(message)
+	if err != nil {
+		return err
+	}
+	message.Decoded = decoded
+	return nil
+}
+
+// DecodedPayload возвращает разобранное содержимое сообщения. Если
+// DecodeMessage еще не вызывался, содержимое разбирается, но не сохраняется,
+// чтобы сообщение можно было читать из нескольких горутин. Для
+// незарегистрированных типов возвращается nil.
+func (m *GossipMessage) DecodedPayload() (interface{}, error) {
+	if m.Decoded != nil {
+		return m.Decoded, nil
+	}
+	return decodePayload(m)
+}
+
+func decodePayload(message *GossipMessage) (interface{}, error) {
+	spec, exists := LookupMessageType(message.MessageType)
+	if !exists {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(message.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	if spec.MaxSize > 0 && len(data) > spec.MaxSize {
+		return nil, fmt.Errorf("%w: %d bytes of %s, limit %d", ErrPayloadTooLarge, len(data), spec.Type, spec.MaxSize)
+	}
+
+	if spec.Schema != nil {
+		var value interface{}
+		if err := json.Unmarshal(data, &value); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+		}
+		if err := spec.Schema.Validate(value); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+		}
+	}
+
+	payload := spec.New()
+	if err := json.Unmarshal(data, payload); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	if spec.Validate != nil {
+		if err := spec.Validate(payload); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+		}
+	}
+	return payload, nil
+}
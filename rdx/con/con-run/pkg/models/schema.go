@@ -0,0 +1,208 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Schema подмножество JSON Schema для проверки содержимого сообщений:
+// type, enum, properties, required, additionalProperties, items,
+// minimum, maximum, minLength, maxLength, maxItems и oneOf
+type Schema struct {
+	Type                 SchemaType         `json:"type,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"` // nil - разрешены
+	Items                *Schema            `json:"items,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+}
+
+// SchemaType допустимые типы JSON значения: object, array, string, number,
+// integer, boolean или null. В схеме задается строкой или списком строк.
+type SchemaType []string
+
+// UnmarshalJSON принимает тип строкой или списком
+func (t *SchemaType) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*t = SchemaType{name}
+		return nil
+	}
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return fmt.Errorf("schema type must be a string or a list of strings")
+	}
+	*t = names
+	return nil
+}
+
+// MarshalJSON записывает один тип строкой
+func (t SchemaType) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+// ParseSchema разбирает JSON схему
+func ParseSchema(data string) (*Schema, error) {
+	var schema Schema
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&schema); err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+	return &schema, nil
+}
+
+// MustParseSchema разбирает JSON схему, объявленную в коде, и паникует при ошибке
+func MustParseSchema(data string) *Schema {
+	schema, err := ParseSchema(data)
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// Validate проверяет значение, разобранное encoding/json в interface{}.
+// Ошибка указывает путь к неверному полю.
+func (s *Schema) Validate(value interface{}) error {
+	return s.validate("payload", value)
+}
+
+func (s *Schema) validate(path string, value interface{}) error {
+	if len(s.OneOf) > 0 {
+		var reasons []string
+		matched := 0
+		for _, option := range s.OneOf {
+			if err := option.validate(path, value); err != nil {
+				reasons = append(reasons, err.Error())
+			} else {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return fmt.Errorf("%s must match exactly one schema, matched %d: %s", path, matched, strings.Join(reasons, "; "))
+		}
+	}
+
+	if len(s.Type) > 0 && !hasType(value, s.Type) {
+		return fmt.Errorf("%s must be %s, got %s", path, strings.Join(s.Type, " or "), typeName(value))
+	}
+	if len(s.Enum) > 0 && !inEnum(value, s.Enum) {
+		return fmt.Errorf("%s must be one of %v, got %v", path, s.Enum, value)
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, exists := v[name]; !exists {
+				return fmt.Errorf("%s.%s is required", path, name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, known := s.Properties[name]
+			if !known {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s.%s is not allowed", path, name)
+				}
+				continue
+			}
+			if err := property.validate(path+"."+name, v[name]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			return fmt.Errorf("%s must have at most %d items, got %d", path, *s.MaxItems, len(v))
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := len([]rune(v))
+		if s.MinLength != nil && length < *s.MinLength {
+			return fmt.Errorf("%s must be at least %d characters long", path, *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			return fmt.Errorf("%s must be at most %d characters long", path, *s.MaxLength)
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			return fmt.Errorf("%s must be at least %v, got %v", path, *s.Minimum, v)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			return fmt.Errorf("%s must be at most %v, got %v", path, *s.Maximum, v)
+		}
+	}
+	return nil
+}
+
+// hasType проверяет, что значение имеет один из типов JSON Schema
+func hasType(value interface{}, names SchemaType) bool {
+	for _, name := range names {
+		switch name {
+		case "integer":
+			if number, ok := value.(float64); ok && number == math.Trunc(number) {
+				return true
+			}
+		case "number":
+			if _, ok := value.(float64); ok {
+				return true
+			}
+		default:
+			if typeName(value) == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// typeName возвращает название типа JSON значения
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func inEnum(value interface{}, enum []interface{}) bool {
+	for _, allowed := range enum {
+		if reflect.DeepEqual(value, allowed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"concoin/conrun/pkg/models"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	schema := models.MustParseSchema(`{
+		"type": "object",
+		"required": ["name"],
+		"additionalProperties": false,
+		"properties": {
+			"name": {"type": "string", "minLength": 1, "maxLength": 5},
+			"kind": {"enum": ["a", "b"]},
+			"count": {"type": "integer", "minimum": 0, "maximum": 10},
+			"tags": {"type": ["array", "null"], "maxItems": 2, "items": {"type": "string"}},
+			"value": {"oneOf": [{"type": "string"}, {"type": "number"}]}
+		}
+	}`)
+
+	tests := []struct {
+		name  string
+		value interface{}
+		err   string
+	}{
+		{"valid", map[string]interface{}{"name": "x", "kind": "a", "count": 3.0, "tags": []interface{}{"t"}, "value": 1.5}, ""},
+		{"null list", map[string]interface{}{"name": "x", "tags": nil}, ""},
+		{"not an object", "x", "payload must be object, got string"},
+		{"missing field", map[string]interface{}{}, "payload.name is required"},
+		{"unknown field", map[string]interface{}{"name": "x", "extra": 1.0}, "payload.extra is not allowed"},
+		{"too long", map[string]interface{}{"name": "abcdef"}, "payload.name must be at most 5"},
+		{"enum", map[string]interface{}{"name": "x", "kind": "c"}, "payload.kind must be one of"},
+		{"fraction", map[string]interface{}{"name": "x", "count": 1.5}, "payload.count must be integer"},
+		{"maximum", map[string]interface{}{"name": "x", "count": 11.0}, "payload.count must be at most 10"},
+		{"item", map[string]interface{}{"name": "x", "tags": []interface{}{1.0}}, "payload.tags[0] must be string"},
+		{"max items", map[string]interface{}{"name": "x", "tags": []interface{}{"a", "b", "c"}}, "at most 2 items"},
+		{"one of", map[string]interface{}{"name": "x", "value": true}, "payload.value must match exactly one schema"},
+	}
+	for _, test := range tests {
+		err := schema.Validate(test.value)
+		if test.err == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: expected error %q, got %v", test.name, test.err, err)
+		}
+	}
+
+	if _, err := models.ParseSchema(`{"type": "object", "unknown": true}`); err == nil {
+		t.Error("Expected unknown schema keywords to be rejected")
+	}
+}
+
+type testPayload struct {
+	Text string `json:"text"`
+}
+
+func TestDecodeMessage(t *testing.T) {
+	models.RegisterMessageType(models.MessageSpec{
+		Type:   "test_registry",
+		New:    func() interface{} { return new(testPayload) },
+		Schema: models.MustParseSchema(`{"type": "object", "required": ["text"]}`),
+		Validate: func(payload interface{}) error {
+			if payload.(*testPayload).Text == "bad" {
+				return errors.New("bad text")
+			}
+			return nil
+		},
+		MaxSize: 32,
+	})
+
+	// A type can only be registered once
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected duplicate registration to panic")
+			}
+		}()
+		models.RegisterMessageType(models.MessageSpec{Type: "test_registry", New: func() interface{} { return new(testPayload) }})
+	}()
+
+	message := &models.GossipMessage{MessageType: "test_registry", Payload: map[string]interface{}{"text": "hello"}}
+	if err := models.DecodeMessage(message); err != nil {
+		t.Fatalf("Failed to decode message: %v", err)
+	}
+	if payload, ok := message.Decoded.(*testPayload); !ok || payload.Text != "hello" {
+		t.Errorf("Expected a typed payload, got %#v", message.Decoded)
+	}
+
+	tests := []struct {
+		name    string
+		payload interface{}
+		err     error
+	}{
+		{"schema", map[string]interface{}{}, models.ErrInvalidPayload},
+		{"validate", map[string]interface{}{"text": "bad"}, models.ErrInvalidPayload},
+		{"size", map[string]interface{}{"text": strings.Repeat("x", 32)}, models.ErrPayloadTooLarge},
+	}
+	for _, test := range tests {
+		message := &models.GossipMessage{MessageType: "test_registry", Payload: test.payload}
+		if err := models.DecodeMessage(message); !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+		if message.Decoded != nil {
+			t.Errorf("%s: expected no decoded payload", test.name)
+		}
+	}
+
+	// Unregistered types are passed through untouched
+	message = &models.GossipMessage{MessageType: "unregistered", Payload: "anything"}
+	if err := models.DecodeMessage(message); err != nil || message.Decoded != nil {
+		t.Errorf("Expected unregistered type to be skipped, got %v %#v", err, message.Decoded)
+	}
+
+	// Without DecodeMessage the payload is decoded on demand
+	message = &models.GossipMessage{MessageType: "test_registry", Payload: map[string]interface{}{"text": "lazy"}}
+	if payload, err := message.DecodedPayload(); err != nil || payload.(*testPayload).Text != "lazy" || message.Decoded != nil {
+		t.Errorf("Expected an uncached decoded payload, got %#v %v", payload, err)
+	}
+
+	if _, exists := models.LookupMessageType(models.UserMessageType); !exists {
+		t.Errorf("Expected %s to be registered", models.UserMessageType)
+	}
+}
@@ -0,0 +1,311 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"concoin/conrun/pkg/api"
+	"concoin/conrun/pkg/blockchain"
+	"concoin/conrun/pkg/chain"
+	"concoin/conrun/pkg/config"
+	"concoin/conrun/pkg/gossip"
+	"concoin/conrun/pkg/hooks"
+	"concoin/conrun/pkg/identity"
+	"concoin/conrun/pkg/interfaces"
+	"concoin/conrun/pkg/mempool"
+	"concoin/conrun/pkg/miner"
+	"concoin/conrun/pkg/models"
+	"concoin/conrun/pkg/pex"
+	"concoin/conrun/pkg/reputation"
+	"concoin/conrun/pkg/retention"
+	"concoin/conrun/pkg/storage"
+	"concoin/conrun/pkg/transport"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fetchPeers число пиров, у которых по очереди запрашивается недостающий блок
+const fetchPeers = 3
+
+// Node связывает компоненты узла. Узел запускается и останавливается
+// из кода, поэтому его можно встроить в тест или другой процесс.
+type Node struct {
+	Config     *config.Config
+	Identity   *identity.Identity
+	Storage    interfaces.StorageInterface
+	Chain      *chain.Chain
+	Fetcher    *chain.Fetcher
+	Mempool    *mempool.Mempool
+	Hooks      *hooks.HookManager
+	Transport  interfaces.TransportInterface
+	Reputation *reputation.Manager
+	Gossip     *gossip.GossipProtocol
+	Pex        *pex.PexProtocol
+	Miner      *miner.Miner // nil, если майнинг выключен
+	Retention  *retention.Manager
+	API        *api.API
+
+	logger   *logrus.Logger
+	started  []interfaces.Component
+	stopOnce sync.Once
+	applied  *config.Config // последняя примененная конфигурация, для Reload
+}
+
+// New создает директории данных, загружает ключ и состояние узла и связывает
+// компоненты. ID узла в cfg заменяется выведенным из ключа.
+func New(cfg *config.Config, logger *logrus.Logger) (*Node, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	n := &Node{Config: cfg, logger: logger}
+
+	// Создаем директории для хранения данных
+	if err := cfg.CreateDataDirs(); err != nil {
+		return nil, fmt.Errorf("failed to create data directories: %w", err)
+	}
+
+	// Загружаем ключ узла, ID узла выводится из него
+	nodeIdentity, err := identity.Load(cfg.DataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load node identity: %w", err)
+	}
+	n.Identity = nodeIdentity
+	cfg.NodeID = nodeIdentity.NodeID
+	logger.Infof("Node ID: %s", cfg.NodeID)
+
+	// Сохраняем конфигурацию
+	if err := cfg.SaveConfig(); err != nil {
+		return nil, fmt.Errorf("failed to save config: %w", err)
+	}
+
+	// Создаем хранилище
+	n.Storage, err = storage.NewStorageBackend(cfg.StorageBackend, cfg.DataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+	if cfg.StorageBackend == storage.BackendKV && storage.HasJSONMessages(cfg.DataDir) {
+		if _, _, head, err := n.Storage.GetMessagesSince(0, 0); err == nil && head == 0 {
+			logger.Warnf("Messages of the json storage in %s are not in the kv storage, import them with: go run ./cmd/migrate %s", cfg.DataDir, cfg.DataDir)
+		}
+	}
+
+	// Загружаем состояние блокчейна
+	n.Chain, err = chain.NewChain(cfg.DataDir, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chain state: %w", err)
+	}
+
+	// Создаем мемпул
+	n.Mempool, err = mempool.NewMempool(cfg.DataDir, cfg.MempoolConfig, n.Chain, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load mempool: %w", err)
+	}
+	n.Chain.AddTipListener(n.Mempool.HandleTipChange)
+
+	// Создаем очистку хранилища: блоки хранятся всегда, транзакции
+	// удаляются после включения в цепочку или истечения
+	n.Retention = retention.NewManager(cfg.RetentionConfig, n.Storage, logger)
+	n.Retention.SetTombstoneAge(cfg.GossipConfig.MessageMaxAge)
+	n.Retention.SetClassifier(blockchain.MessageType, retention.NewBlockchainClassifier(n.Chain, n.Mempool, cfg.MempoolConfig.TxTTL))
+
+	// Создаем менеджер хуков
+	n.Hooks = hooks.NewHookManager(cfg.DataDir, logger)
+	n.Hooks.SetConfig(cfg.HooksConfig)
+	n.Hooks.AddHook(hooks.NewDebugHook(logger))
+	n.Hooks.AddHook(hooks.NewBlockchainHook(n.Chain, n.Mempool, logger))
+	externalNames := make([]string, 0, len(cfg.HooksConfig.External))
+	for name := range cfg.HooksConfig.External {
+		externalNames = append(externalNames, name)
+	}
+	sort.Strings(externalNames)
+	for _, name := range externalNames {
+		hook := hooks.NewExternalHook(name, cfg.HooksConfig.External[name], cfg.HooksConfig.ExternalTimeout, logger)
+		hook.SetPublisher(n.publishMessage)
+		n.Hooks.AddHook(hook)
+	}
+
+	// Создаем транспорт между узлами
+	n.Transport, err = transport.New(cfg.TransportConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transport: %w", err)
+	}
+
+	// Создаем оценки пиров, баны сохраняются в хранилище
+	n.Reputation = reputation.NewManager(cfg.ReputationConfig, n.Storage, logger)
+
+	// Создаем Gossip протокол
+	n.Gossip = gossip.NewGossipProtocol(cfg, logger, n.Storage, n.Hooks)
+	n.Gossip.SetTransport(n.Transport)
+	n.Gossip.SetReputation(n.Reputation)
+
+	// Создаем PEX протокол
+	n.Pex = pex.NewPexProtocol(cfg, n.Storage, logger, n.Hooks)
+	n.Pex.SetTransport(n.Transport)
+	n.Pex.SetReputation(n.Reputation)
+
+	// Недостающие предки блоков-сирот загружаются у пиров
+	n.Fetcher = chain.NewFetcher(n.Chain, n.fetchBlock, logger)
+	n.Chain.SetMissingBlockHandler(n.Fetcher.Request)
+
+	// Создаем майнер
+	if cfg.MinerConfig.Enabled {
+		n.Miner = miner.NewMiner(cfg.MinerConfig, cfg.BlockchainConfig.MaxTransactions, n.Chain, n.Mempool, n.publishBlock, logger)
+		n.Chain.AddTipListener(n.Miner.HandleTipChange)
+	}
+
+	// Создаем API
+	n.API = api.NewAPI(cfg, n.Gossip, n.Pex, logger, n.Storage, n.Hooks)
+	n.API.SetChain(n.Chain)
+	n.API.SetMempool(n.Mempool)
+	n.API.SetIdentity(n.Identity)
+	n.API.SetReputation(n.Reputation)
+	n.API.SetRetention(n.Retention)
+
+	// Настраиваем взаимодействие компонентов
+	n.Pex.SetOnPeersListHandler(func(peers []models.Peer) {
+		n.Gossip.UpdatePeers(peers)
+	})
+
+	applied := *cfg
+	n.applied = &applied
+	return n, nil
+}
+
+// Start запускает компоненты узла. Если компонент не запустился, уже
+// запущенные останавливаются и возвращается ошибка.
+func (n *Node) Start(ctx context.Context) error {
+	// Хуки запускаются раньше протоколов, которые передают им сообщения,
+	// и останавливаются после них, успев разобрать свои очереди
+	components := []interfaces.Component{n.Mempool, n.Hooks, n.Fetcher, n.Pex, n.Gossip, n.Retention}
+	if n.Miner != nil {
+		components = append(components, n.Miner)
+	}
+	components = append(components, n.API)
+
+	for _, component := range components {
+		if err := component.Start(ctx); err != nil {
+			n.Stop()
+			return err
+		}
+		n.started = append(n.started, component)
+	}
+
+	n.logger.Infof("Node started on %s with seed nodes %v", n.Config.ListenAddress(), n.Config.SeedNodes)
+	return nil
+}
+
+// Stop останавливает компоненты в обратном порядке запуска: сначала API
+// перестает принимать запросы, затем завершается фоновая работа. После
+// этого закрываются соединения с пирами и хранилище.
+func (n *Node) Stop() {
+	n.stopOnce.Do(func() {
+		for i := len(n.started) - 1; i >= 0; i-- {
+			n.started[i].Stop()
+		}
+
+		if err := n.Transport.Close(); err != nil {
+			n.logger.Warnf("Failed to close transport: %v", err)
+		}
+		if err := n.Storage.Close(); err != nil {
+			n.logger.Warnf("Failed to close storage: %v", err)
+		}
+		n.logger.Info("Node stopped")
+	})
+}
+
+// Reload применяет на ходу безопасные настройки next: уровень логов,
+// лимиты входящих запросов, правила хранения сообщений, правила проверки
+// и приоритеты хуков. Об остальных изменившихся настройках
+// пишется предупреждение, они вступят в силу после перезапуска.
+func (n *Node) Reload(next *config.Config) {
+	next.NodeID = n.Config.NodeID
+	changed := n.applied.Changed(next)
+	if len(changed) == 0 {
+		n.logger.Info("Config reloaded, nothing changed")
+		return
+	}
+
+	var restart []string
+	hooksChanged := false
+	for _, key := range changed {
+		switch {
+		case key == "log_level":
+			level, _ := logrus.ParseLevel(next.LogLevel)
+			n.logger.SetLevel(level)
+		case strings.HasPrefix(key, "limits."):
+			n.API.SetLimits(next.LimitsConfig)
+		case strings.HasPrefix(key, "retention."):
+			n.Retention.SetConfig(next.RetentionConfig)
+		case key == "hooks.policy" || key == "hooks.policies" || key == "hooks.priorities":
+			hooksChanged = true
+		default:
+			restart = append(restart, key)
+		}
+	}
+
+	n.applied.LogLevel = next.LogLevel
+	n.applied.LimitsConfig = next.LimitsConfig
+	n.applied.RetentionConfig = next.RetentionConfig
+	n.applied.HooksConfig.Policy = next.HooksConfig.Policy
+	n.applied.HooksConfig.Policies = next.HooksConfig.Policies
+	n.applied.HooksConfig.Priorities = next.HooksConfig.Priorities
+	if hooksChanged {
+		// Фоновые обработчики хуков меняются только после перезапуска
+		n.Hooks.SetConfig(n.applied.HooksConfig)
+	}
+	n.logger.Infof("Config reloaded, changed: %s", strings.Join(changed, ", "))
+	if len(restart) > 0 {
+		n.logger.Warnf("Settings %s take effect after restart", strings.Join(restart, ", "))
+	}
+}
+
+// publishBlock подписывает найденный майнером блок и рассылает его пирам
+func (n *Node) publishBlock(block *blockchain.Block) error {
+	return n.publishMessage(blockchain.MessageType, blockchain.BlockPayload(block))
+}
+
+// publishMessage подписывает новое сообщение узла и рассылает его пирам
+func (n *Node) publishMessage(messageType string, payload interface{}) error {
+	message := &models.GossipMessage{
+		Timestamp:   time.Now().UTC(),
+		TTL:         n.Config.GossipConfig.MessageTTL,
+		MessageType: messageType,
+		Payload:     payload,
+	}
+	if err := n.Identity.Seal(message); err != nil {
+		return err
+	}
+	return n.Gossip.PublishMessage(message)
+}
+
+// fetchBlock запрашивает блок по хешу у нескольких пиров по очереди
+func (n *Node) fetchBlock(ctx context.Context, hash blockchain.Hash) (*blockchain.Block, error) {
+	peers := n.Reputation.SelectPeers(n.Pex.GetPeers(), fetchPeers)
+	if len(peers) == 0 {
+		return nil, fmt.Errorf("no peers to fetch block from")
+	}
+
+	var lastErr error
+	for _, peer := range peers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		var block blockchain.Block
+		start := time.Now()
+		err := n.Transport.Send(peer, http.MethodGet, "/chain/blocks/"+url.PathEscape(hash), nil, &block)
+		n.Reputation.RecordRequest(peer.NodeID, time.Since(start), err)
+		if err != nil {
+			lastErr = fmt.Errorf("peer %s: %w", peer.NodeID, err)
+			continue
+		}
+		return &block, nil
+	}
+	return nil, lastErr
+}
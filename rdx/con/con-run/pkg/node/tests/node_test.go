@@ -0,0 +1,224 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"concoin/conrun/pkg/config"
+	"concoin/conrun/pkg/hooks"
+	"concoin/conrun/pkg/interfaces"
+	"concoin/conrun/pkg/models"
+	"concoin/conrun/pkg/node"
+
+	"github.com/sirupsen/logrus"
+)
+
+// freePort returns a port that is free at the moment of the call
+func freePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func newConfig(t *testing.T, dataDir string, seeds ...string) *config.Config {
+	cfg := config.DefaultConfig(freePort(t), 0)
+	cfg.ListenAddr = "127.0.0.1"
+	cfg.DataDir = dataDir
+	cfg.SeedNodes = seeds
+	cfg.TransportConfig.Type = "tcp"
+	cfg.PexConfig.ExchangeInterval = 250 * time.Millisecond
+	return cfg
+}
+
+func startNode(t *testing.T, cfg *config.Config) *node.Node {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	n, err := node.New(cfg, logger)
+	if err != nil {
+		t.Fatalf("Failed to create node: %v", err)
+	}
+	if err := n.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start node: %v", err)
+	}
+	t.Cleanup(n.Stop)
+	return n
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestNode_Lifecycle(t *testing.T) {
+	cfgA := newConfig(t, t.TempDir())
+	nodeA := startNode(t, cfgA)
+	cfgB := newConfig(t, t.TempDir(), cfgA.ListenAddress())
+	nodeB := startNode(t, cfgB)
+
+	waitFor(t, "the nodes to exchange peers", func() bool {
+		return len(nodeA.Pex.GetPeers()) == 1 && len(nodeB.Pex.GetPeers()) == 1
+	})
+
+	// A message added on B reaches A
+	resp, err := http.Post(fmt.Sprintf("http://%s/add_message", cfgB.ListenAddress()), "application/json",
+		strings.NewReader(`{"type":"user_message","payload":{"text":"hello"}}`))
+	if err != nil {
+		t.Fatalf("Failed to add message: %v", err)
+	}
+	resp.Body.Close()
+	waitFor(t, "the message to reach node A", func() bool {
+		messages, _ := nodeA.Storage.GetMessageList()
+		return len(messages) == 1
+	})
+
+	// Stop returns once the node no longer accepts connections
+	nodeB.Stop()
+	if conn, err := net.Dial("tcp", cfgB.ListenAddress()); err == nil {
+		conn.Close()
+		t.Errorf("Expected node B to stop listening")
+	}
+	nodeB.Stop()
+
+	// Verified peers are saved on stop, so a restarted node starts with them
+	cfgB.Port = freePort(t)
+	restarted := startNode(t, cfgB)
+	if peers := restarted.Pex.GetPeers(); len(peers) != 1 || peers[0].NodeID != cfgA.NodeID {
+		t.Errorf("Expected the restarted node to load node A from storage, got %v", peers)
+	}
+	if messages, _ := restarted.Storage.GetMessageList(); len(messages) != 1 {
+		t.Errorf("Expected the restarted node to keep its message, got %d", len(messages))
+	}
+}
+
+func TestNode_StartError(t *testing.T) {
+	cfg := newConfig(t, t.TempDir())
+
+	// The API port is taken, so the node fails to start
+	listener, err := net.Listen("tcp", cfg.ListenAddress())
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	n, err := node.New(cfg, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create node: %v", err)
+	}
+	if err := n.Start(context.Background()); err == nil {
+		t.Errorf("Expected an error for a busy port")
+	}
+
+	// Components started before the failure are stopped
+	n.Stop()
+}
+
+func TestNode_Reload(t *testing.T) {
+	cfg := newConfig(t, t.TempDir())
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	n, err := node.New(cfg, logger)
+	if err != nil {
+		t.Fatalf("Failed to create node: %v", err)
+	}
+	defer n.Stop()
+
+	next := *cfg
+	next.LogLevel = "debug"
+	next.LimitsConfig.PeerRate = 1
+	next.GossipConfig.BranchingFactor = 1
+	next.RetentionConfig.Default = "24h"
+	next.HooksConfig.Priorities = map[string]int{"DebugHook": 200}
+	next.HooksConfig.Workers = map[string]int{"DebugHook": 2}
+	n.Reload(&next)
+
+	// Safe settings apply at once, the rest waits for a restart
+	if logger.GetLevel() != logrus.DebugLevel {
+		t.Errorf("Expected the log level to be reloaded, got %v", logger.GetLevel())
+	}
+	if policy := n.Retention.Stats().Policies["default"]; policy != "24h0m0s" {
+		t.Errorf("Expected the retention rules to be reloaded, got default %q", policy)
+	}
+	if stats := n.Hooks.HookStats(); stats[0].Hook != "DebugHook" || stats[0].Priority != 200 || stats[0].Workers != 0 {
+		t.Errorf("Expected the hook priorities to be reloaded without workers, got %+v", stats)
+	}
+	if cfg.GossipConfig.BranchingFactor != 4 {
+		t.Errorf("Expected the running config to keep its branching factor, got %d", cfg.GossipConfig.BranchingFactor)
+	}
+}
+
+func TestNode_ExternalHook(t *testing.T) {
+	// The moderator rejects spam and records an audit message for every accepted user message
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request hooks.ExternalRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		response := hooks.ExternalResponse{ID: request.ID, Verdict: hooks.VerdictAccept}
+		switch {
+		case request.Op == hooks.ExternalHello:
+			response.MessageTypes = []string{"user_message", "audit"}
+		case request.Op == hooks.ExternalValidate && strings.Contains(fmt.Sprint(request.Message.Payload), "spam"):
+			response.Verdict, response.Code, response.Reason = hooks.VerdictReject, "spam", "looks like spam"
+		case request.Op == hooks.ExternalHandle && request.Message.MessageType == "user_message":
+			response.Messages = []hooks.DerivedMessage{{MessageType: "audit", Payload: map[string]string{"accepted": request.Message.MessageID}}}
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer service.Close()
+
+	cfg := newConfig(t, t.TempDir())
+	cfg.HooksConfig.External = map[string]string{"moderator": service.URL}
+	n := startNode(t, cfg)
+	send := func(text string) (int, string) {
+		resp, err := http.Post(fmt.Sprintf("http://%s/add_message", cfg.ListenAddress()), "application/json",
+			strings.NewReader(fmt.Sprintf(`{"type":"user_message","payload":{"text":%q}}`, text)))
+		if err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	// The moderator learns its message types in the background, then both
+	// the debug hook and the moderator must accept a user message
+	probe := &models.GossipMessage{MessageID: "probe", MessageType: "user_message", Payload: map[string]interface{}{"text": "spam"}}
+	waitFor(t, "the moderator to handle user messages", func() bool {
+		return !n.Hooks.ValidateMessage(probe, interfaces.MessageTypePush)
+	})
+	if code, body := send("buy spam"); code != http.StatusBadRequest || !strings.Contains(body, "looks like spam") {
+		t.Errorf("Expected spam to be rejected by the moderator, got %d %s", code, body)
+	}
+	if code, body := send("hello"); code != http.StatusOK {
+		t.Fatalf("Expected the message to be accepted, got %d %s", code, body)
+	}
+
+	// The derived audit message is signed and stored by the node
+	messages, _ := n.Storage.GetMessageList()
+	if len(messages) != 2 {
+		t.Fatalf("Expected the message and its audit record, got %v", messages)
+	}
+	types := map[string]bool{}
+	for _, id := range messages {
+		message, _ := n.Storage.GetMessage(id)
+		types[message.MessageType] = message.OriginID == cfg.NodeID
+	}
+	if !types["user_message"] || !types["audit"] {
+		t.Errorf("Expected both messages to come from the node, got %v", types)
+	}
+}
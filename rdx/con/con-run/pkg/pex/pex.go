@@ -1,18 +1,23 @@
 package pex
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"math/rand"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"concoin/conrun/pkg/addrman"
 	"concoin/conrun/pkg/config"
+	"concoin/conrun/pkg/gossip"
 	"concoin/conrun/pkg/interfaces"
 	"concoin/conrun/pkg/models"
+	"concoin/conrun/pkg/reputation"
+	"concoin/conrun/pkg/transport"
 
 	"github.com/sirupsen/logrus"
 )
@@ -20,23 +25,48 @@ import (
 // PexProtocol представляет собой реализацию PEX протокола
 type PexProtocol struct {
 	config      *config.Config
-	peerTable   map[string]models.Peer // nodeId -> peer
+	addrMan     *addrman.AddrMan // проверенные и новые адреса пиров
 	storage     interfaces.StorageInterface
 	mutex       sync.RWMutex
 	logger      *logrus.Logger
 	hookManager interfaces.HookManagerInterface
 	onPeersList func(peers []models.Peer)
+	transport   interfaces.TransportInterface
+	reputation  interfaces.ReputationInterface
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+
+	// observedHost наш IP, каким его видят пиры; используется, если
+	// адрес для пиров не задан в конфигурации
+	observedHost string
 }
 
 // NewPexProtocol создает новый экземпляр PEX протокола
 func NewPexProtocol(config *config.Config, storage interfaces.StorageInterface, logger *logrus.Logger, hookManager interfaces.HookManagerInterface) *PexProtocol {
-	return &PexProtocol{
+	p := &PexProtocol{
 		config:      config,
-		peerTable:   make(map[string]models.Peer),
 		storage:     storage,
 		logger:      logger,
 		hookManager: hookManager,
+		transport:   transport.NewHTTPTransport(config.TransportConfig),
 	}
+	p.addrMan = addrman.New(config.PexConfig, func(nodeID string) float64 {
+		return p.reputation.Score(nodeID)
+	})
+	p.SetReputation(reputation.NewManager(config.ReputationConfig, nil, logger))
+	return p
+}
+
+// SetReputation задает общие для узла оценки пиров. Забаненные пиры
+// удаляются из таблицы.
+func (p *PexProtocol) SetReputation(reputation interfaces.ReputationInterface) {
+	p.reputation = reputation
+	reputation.AddBanListener(p.removePeer)
+}
+
+// SetTransport задает транспорт для запросов к пирам
+func (p *PexProtocol) SetTransport(transport interfaces.TransportInterface) {
+	p.transport = transport
 }
 
 // SetOnPeersListHandler устанавливает обработчик для обновления списка пиров
@@ -45,28 +75,66 @@ func (p *PexProtocol) SetOnPeersListHandler(handler func(peers []models.Peer)) {
 }
 
 // Start запускает протокол
-func (p *PexProtocol) Start() {
+func (p *PexProtocol) Start(ctx context.Context) error {
 	p.logger.Info("Starting PEX protocol")
 
 	// Загружаем известных пиров из хранилища
 	p.loadPeersFromStorage()
 
 	// Добавляем seed-узлы, если список пиров пуст
-	if len(p.peerTable) == 0 {
+	p.mutex.RLock()
+	triedCount, newCount := p.addrMan.Size()
+	p.mutex.RUnlock()
+	if triedCount+newCount == 0 {
 		p.logger.Info("No peers found, adding seed nodes")
 		p.addSeedNodes()
+
+		p.mutex.RLock()
+		triedCount, newCount = p.addrMan.Size()
+		p.mutex.RUnlock()
 	}
 
-	p.logger.Infof("Initial peer table size: %d", len(p.peerTable))
+	p.logger.Infof("Initial peer table size: %d tried, %d new", triedCount, newCount)
+
+	ctx, p.cancel = context.WithCancel(ctx)
+	p.wg.Add(2)
 
 	// Периодически обмениваемся пирами
-	go p.exchangeLoop()
+	go p.exchangeLoop(ctx)
 
 	// Периодически очищаем неактивных пиров
-	go p.cleanupLoop()
+	go p.cleanupLoop(ctx)
+	return nil
 }
 
-// loadPeersFromStorage загружает пиров из хранилища
+// Stop останавливает обмен пирами и сохраняет проверенных пиров,
+// чтобы следующий запуск начался с них
+func (p *PexProtocol) Stop() {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	p.wg.Wait()
+
+	peers := p.GetPeers()
+	for i := range peers {
+		if err := p.storage.SavePeer(&peers[i]); err != nil {
+			p.logger.Warnf("Failed to save peer %s: %v", peers[i].NodeID, err)
+		}
+	}
+	p.logger.Infof("PEX protocol stopped, saved %d peers", len(peers))
+}
+
+// background выполняет work в отдельной горутине, Stop дожидается ее завершения
+func (p *PexProtocol) background(work func()) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		work()
+	}()
+}
+
+// loadPeersFromStorage загружает пиров из хранилища. В хранилище попадают
+// только проверенные пиры, поэтому они сразу занимают таблицу tried.
 func (p *PexProtocol) loadPeersFromStorage() {
 	peers, err := p.storage.GetPeers()
 	if err != nil {
@@ -86,30 +154,33 @@ func (p *PexProtocol) loadPeersFromStorage() {
 			p.logger.Debugf("Skipping expired peer: %s (last seen: %v)", peer.NodeID, peer.LastSeen)
 			continue
 		}
+		if p.reputation.IsBanned(peer.NodeID) {
+			p.logger.Debugf("Skipping banned peer: %s", peer.NodeID)
+			continue
+		}
 
-		p.peerTable[peer.NodeID] = *peer
-		activePeers++
+		p.addrMan.Add(*peer, models.Peer{})
+		if p.addrMan.Good(peer.NodeID) {
+			activePeers++
+		}
 	}
 
 	p.logger.Infof("Added %d active peers to peer table", activePeers)
 	p.notifyPeersUpdated()
 }
 
-// addSeedNodes добавляет seed-узлы в таблицу пиров
+// addSeedNodes добавляет seed-узлы в таблицу новых адресов
 func (p *PexProtocol) addSeedNodes() {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
 	p.logger.Infof("Adding seed nodes: %v", p.config.SeedNodes)
 
+	// ID seed-узла неизвестен до первого обмена, поэтому он добавляется
+	// под временным ID. Если seed окажется этим же узлом, запись удалится
+	// после ответа на первый PEX запрос.
 	addedSeeds := 0
 	for _, seedNode := range p.config.SeedNodes {
-		// Пропускаем свой собственный адрес
-		if seedNode == fmt.Sprintf("127.0.0.1:%d", p.config.Port) {
-			p.logger.Debugf("Skipping self address: %s", seedNode)
-			continue
-		}
-
 		nodeID := fmt.Sprintf("seed-%s", seedNode)
 		peer := models.Peer{
 			NodeID:   nodeID,
@@ -117,7 +188,9 @@ func (p *PexProtocol) addSeedNodes() {
 			LastSeen: time.Now(),
 		}
 
-		p.peerTable[nodeID] = peer
+		if !p.addrMan.Add(peer, models.Peer{}) {
+			continue
+		}
 		addedSeeds++
 		p.logger.Infof("Added seed node: %s", seedNode)
 	}
@@ -126,19 +199,11 @@ func (p *PexProtocol) addSeedNodes() {
 	p.notifyPeersUpdated()
 }
 
-// AddPeer добавляет новый пир в таблицу
+// AddPeer проверяет доступность пира и добавляет его в таблицу проверенных
 func (p *PexProtocol) AddPeer(peer models.Peer) bool {
 	p.logger.Debugf("Attempting to add peer: %s (%s)", peer.NodeID, peer.Address)
 
-	// Пропускаем свой собственный адрес
-	if peer.Address == fmt.Sprintf("127.0.0.1:%d", p.config.Port) {
-		p.logger.Debugf("Skipping self address: %s", peer.Address)
-		return false
-	}
-
-	// Проверяем формат адреса
-	if !p.isValidAddress(peer.Address) {
-		p.logger.Warnf("Invalid peer address format: %s", peer.Address)
+	if !p.acceptable(peer) {
 		return false
 	}
 
@@ -152,52 +217,83 @@ func (p *PexProtocol) AddPeer(peer models.Peer) bool {
 	defer p.mutex.Unlock()
 
 	// Пропускаем, если уже добавлен и недавно обновлен
-	existingPeer, exists := p.peerTable[peer.NodeID]
+	existingPeer, tried, exists := p.addrMan.Lookup(peer.NodeID)
 	if exists {
 		if existingPeer.LastSeen.After(peer.LastSeen) {
 			p.logger.Debugf("Skipping peer %s: existing peer is newer", peer.NodeID)
 			return false
 		}
 		p.logger.Infof("Updating existing peer: %s", peer.NodeID)
-	}
-
-	// Ограничиваем размер таблицы пиров
-	if len(p.peerTable) >= p.config.PexConfig.MaxPeers && !exists {
-		// Удаляем самого старого пира
-		var oldestID string
-		var oldestTime time.Time
 
-		for id, p := range p.peerTable {
-			if oldestID == "" || p.LastSeen.Before(oldestTime) {
-				oldestID = id
-				oldestTime = p.LastSeen
-			}
+		// Проверенный адрес заменяет прежний
+		if existingPeer.Address != peer.Address {
+			p.addrMan.Remove(peer.NodeID)
+			tried = false
 		}
-
-		p.logger.Infof("Removing oldest peer: %s (last seen: %v)", oldestID, oldestTime)
-		delete(p.peerTable, oldestID)
 	}
 
-	// Добавляем нового пира
-	p.peerTable[peer.NodeID] = peer
+	p.addrMan.Add(peer, models.Peer{})
+	if !p.addrMan.Good(peer.NodeID) && !tried {
+		p.logger.Infof("Peer table is full, keeping %s (%s) as a new address", peer.NodeID, peer.Address)
+		return false
+	}
 	p.logger.Infof("Added new peer: %s (%s)", peer.NodeID, peer.Address)
 
 	// Сохраняем пира в хранилище
-	go p.storage.SavePeer(&peer)
+	saved, _, _ := p.addrMan.Lookup(peer.NodeID)
+	p.background(func() { p.storage.SavePeer(&saved) })
 
 	// Запускаем синхронизацию с новым пиром
-	go func() {
-		if err := p.syncMessagesWithPeer(peer); err != nil {
-			p.logger.Warnf("Failed to sync messages with peer %s: %v", peer.NodeID, err)
+	p.background(func() {
+		if err := p.syncMessagesWithPeer(saved); err != nil {
+			p.logger.Warnf("Failed to sync messages with peer %s: %v", saved.NodeID, err)
 		}
-	}()
+	})
 
 	p.notifyPeersUpdated()
 	return true
 }
 
+// learnPeers добавляет в таблицу новых адресов пиров, о которых сообщил
+// пир source. Адреса не проверяются, пока до них не дойдет очередь обмена.
+func (p *PexProtocol) learnPeers(peers []models.Peer, source models.Peer) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	learned := 0
+	for _, peer := range peers {
+		if peer.NodeID == source.NodeID || !p.acceptable(peer) {
+			continue
+		}
+		if p.addrMan.Add(peer, source) {
+			p.logger.Debugf("Learned address of peer %s (%s) from %s", peer.NodeID, peer.Address, source.NodeID)
+			learned++
+		}
+	}
+	return learned
+}
+
+// acceptable отсеивает себя, забаненных пиров и неверные адреса
+func (p *PexProtocol) acceptable(peer models.Peer) bool {
+	if peer.NodeID == p.config.NodeID {
+		p.logger.Debugf("Skipping self: %s (%s)", peer.NodeID, peer.Address)
+		return false
+	}
+	if p.reputation.IsBanned(peer.NodeID) {
+		p.logger.Debugf("Skipping banned peer: %s", peer.NodeID)
+		return false
+	}
+	if !p.isValidAddress(peer.Address) {
+		p.logger.Warnf("Invalid peer address format: %s", peer.Address)
+		return false
+	}
+	return true
+}
+
 // exchangeLoop периодически обменивается пирами
-func (p *PexProtocol) exchangeLoop() {
+func (p *PexProtocol) exchangeLoop(ctx context.Context) {
+	defer p.wg.Done()
+
 	// Определяем интервал обмена
 	interval := p.config.PexConfig.ExchangeInterval
 
@@ -206,10 +302,16 @@ func (p *PexProtocol) exchangeLoop() {
 	ticker := time.NewTicker(1)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		// Проверяем количество известных пиров
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Проверяем количество проверенных пиров
 		p.mutex.RLock()
-		peerCount := len(p.peerTable)
+		peerCount, _ := p.addrMan.Size()
 		p.mutex.RUnlock()
 
 		p.logger.Debugf("Current peer count: %d", peerCount)
@@ -230,29 +332,19 @@ func (p *PexProtocol) exchangeLoop() {
 	}
 }
 
-// exchangePeers отправляет PEX запросы случайным пирам
+// exchangePeers отправляет PEX запрос случайному пиру. Пир выбирается
+// из проверенных или новых адресов, так что новые адреса постепенно
+// проверяются обменом.
 func (p *PexProtocol) exchangePeers() {
 	p.mutex.RLock()
-	if len(p.peerTable) == 0 {
-		p.mutex.RUnlock()
-		p.logger.Warn("No peers available for exchange")
-		return
-	}
-
-	// Выбираем случайного пира
-	peers := make([]models.Peer, 0, len(p.peerTable))
-	for _, peer := range p.peerTable {
-		peers = append(peers, peer)
-	}
+	targetPeer, ok := p.addrMan.Select()
 	p.mutex.RUnlock()
 
-	// Выбираем случайного пира
-	if len(peers) == 0 {
+	if !ok {
 		p.logger.Warn("No peers available for exchange")
 		return
 	}
 
-	targetPeer := peers[rand.Intn(len(peers))]
 	p.logger.Infof("Selected peer for exchange: %s (%s)", targetPeer.NodeID, targetPeer.Address)
 
 	// Создаем PEX запрос
@@ -272,130 +364,155 @@ func (p *PexProtocol) sendPexRequest(peer models.Peer, request models.PexMessage
 	p.logger.Debugf("Sending PEX request to %s (%s)", peer.NodeID, peer.Address)
 
 	// Добавляем информацию о себе в запрос
-	selfPeer := models.Peer{
-		NodeID:   p.config.NodeID,
-		Address:  fmt.Sprintf("127.0.0.1:%d", p.config.Port),
-		LastSeen: time.Now(),
-	}
-	request.Peers = append(request.Peers, selfPeer)
+	request.SenderID = p.config.NodeID
+	request.Peers = append(request.Peers, p.selfPeer())
 
-	// Сериализуем запрос
-	data, err := json.Marshal(request)
-	if err != nil {
-		p.logger.Warnf("Failed to marshal PEX request: %v", err)
-		return
-	}
-
-	// Отправляем HTTP запрос
-	url := fmt.Sprintf("http://%s/pex", peer.Address)
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+	// Отправляем запрос
+	var response models.PexMessage
+	start := time.Now()
+	err := p.transport.Send(peer, http.MethodPost, "/pex", request, &response)
+	p.reputation.RecordRequest(peer.NodeID, time.Since(start), err)
 	if err != nil {
 		p.logger.Warnf("Failed to send PEX request to %s: %v", peer.Address, err)
+		if !errors.Is(err, transport.ErrBackoff) {
+			p.recordAttempt(peer)
+		}
 		return
 	}
-	defer resp.Body.Close()
 
-	// Обновляем время последнего обращения к пиру
-	p.updatePeerLastSeen(peer.NodeID)
-
-	// Обрабатываем ответ
-	if resp.StatusCode != http.StatusOK {
-		p.logger.Warnf("Received bad status code from PEX request: %d", resp.StatusCode)
+	// Адрес seed-узла может оказаться нашим собственным
+	if response.SenderID == p.config.NodeID {
+		p.logger.Infof("Peer %s is this node, removing it", peer.Address)
+		p.removePeer(peer.NodeID)
 		return
 	}
 
-	// Декодируем ответ
-	var response models.PexMessage
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		p.logger.Warnf("Failed to decode PEX response: %v", err)
-		return
+	// Запись seed-узла с временным ID заменяется записью с его настоящим ID
+	// из ответа
+	if response.SenderID != "" && response.SenderID != peer.NodeID {
+		p.logger.Debugf("Peer %s has node ID %s", peer.NodeID, response.SenderID)
+		p.removePeer(peer.NodeID)
+	} else {
+		// Обновляем время последнего обращения к пиру
+		p.updatePeerLastSeen(peer.NodeID)
 	}
 
+	p.learnObservedHost(response.ObservedAddr)
+
 	p.logger.Infof("Received PEX response from %s with %d peers", peer.NodeID, len(response.Peers))
 
-	// Обрабатываем полученных пиров
-	addedPeers := 0
+	// Пир без известного ему внешнего адреса сообщает только порт,
+	// хост берем из адреса, по которому к нему обратились
+	dialedHost, _, _ := net.SplitHostPort(peer.Address)
+
+	// Ответивший пир проверен обменом, остальные адреса попадают в новые
+	source := models.Peer{NodeID: response.SenderID, Address: peer.Address}
 	for _, receivedPeer := range response.Peers {
-		if p.AddPeer(receivedPeer) {
-			addedPeers++
+		if receivedPeer.NodeID == response.SenderID {
+			receivedPeer.Address = fillHost(receivedPeer.Address, dialedHost)
+			source.Address = receivedPeer.Address
+			p.AddPeer(receivedPeer)
 		}
 	}
+	addedPeers := p.learnPeers(response.Peers, source)
 
-	p.logger.Infof("Added %d new peers from PEX response", addedPeers)
+	p.logger.Infof("Learned %d new addresses from PEX response", addedPeers)
 }
 
-// HandlePexRequest обрабатывает входящий PEX запрос
-func (p *PexProtocol) HandlePexRequest(request models.PexMessage) models.PexMessage {
+// recordAttempt учитывает неудачный обмен с пиром. Пир, сообщивший
+// недоступный адрес, получает штраф.
+func (p *PexProtocol) recordAttempt(peer models.Peer) {
+	p.mutex.Lock()
+	source := p.addrMan.Attempt(peer.NodeID)
+	p.mutex.Unlock()
+
+	if source != "" && source != peer.NodeID {
+		p.reputation.RecordUnreachable(source, peer.Address)
+	}
+}
+
+// HandlePexRequest обрабатывает входящий PEX запрос. remoteAddr - адрес,
+// с которого пришел запрос; его IP сообщается отправителю в ответе.
+func (p *PexProtocol) HandlePexRequest(request models.PexMessage, remoteAddr string) models.PexMessage {
 	p.logger.Infof("Received PEX request with %d peers", len(request.Peers))
 
-	// Обновляем информацию об отправителе, если она есть
+	observedHost, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		observedHost = ""
+	}
+
+	// Заявленный ID отправителя принимается для оценки, только если его
+	// адрес указывает на хост, с которого пришел запрос
+	sender := ""
+	source := models.Peer{Address: remoteAddr}
+	for i, peer := range request.Peers {
+		if peer.NodeID != request.SenderID {
+			continue
+		}
+		request.Peers[i].Address = fillHost(peer.Address, observedHost)
+		if host, _, err := net.SplitHostPort(request.Peers[i].Address); err == nil && host == observedHost {
+			sender = peer.NodeID
+			source = request.Peers[i]
+		}
+	}
+	if p.reputation.IsBanned(sender) {
+		p.logger.Infof("Ignoring PEX request from banned peer %s", sender)
+		return models.PexMessage{
+			MessageID: fmt.Sprintf("pex-res-%d", time.Now().UnixNano()),
+			Type:      models.PexResponse,
+			Timestamp: time.Now().UTC(),
+			SenderID:  p.config.NodeID,
+		}
+	}
+
+	// Отправитель проверяется сразу, остальные адреса попадают в новые
 	if len(request.Peers) > 0 {
-		addedPeers := 0
-		for _, peer := range request.Peers {
-			if p.AddPeer(peer) {
-				addedPeers++
-			}
+		if sender != "" {
+			p.AddPeer(source)
 		}
-		p.logger.Infof("Added %d new peers from PEX request", addedPeers)
+		addedPeers := p.learnPeers(request.Peers, source)
+		p.logger.Infof("Learned %d new addresses from PEX request", addedPeers)
 	}
 
 	// Создаем ответ
 	response := models.PexMessage{
-		MessageID: fmt.Sprintf("pex-res-%d", time.Now().UnixNano()),
-		Type:      models.PexResponse,
-		Timestamp: time.Now().UTC(),
-		Peers:     p.getRandomPeers(p.config.PexConfig.MaxPeersPerExchange),
+		MessageID:    fmt.Sprintf("pex-res-%d", time.Now().UnixNano()),
+		Type:         models.PexResponse,
+		Timestamp:    time.Now().UTC(),
+		Peers:        p.getRandomPeers(p.config.PexConfig.MaxPeersPerExchange),
+		SenderID:     p.config.NodeID,
+		ObservedAddr: observedHost,
 	}
 
 	// Добавляем информацию о себе в ответ
-	selfPeer := models.Peer{
-		NodeID:   p.config.NodeID,
-		Address:  fmt.Sprintf("127.0.0.1:%d", p.config.Port),
-		LastSeen: time.Now(),
-	}
-	response.Peers = append(response.Peers, selfPeer)
+	response.Peers = append(response.Peers, p.selfPeer())
 
 	p.logger.Infof("Sending PEX response with %d peers", len(response.Peers))
 	return response
 }
 
-// getRandomPeers возвращает случайных пиров из таблицы
+// getRandomPeers возвращает случайных недавно активных пиров из обеих таблиц
 func (p *PexProtocol) getRandomPeers(count int) []models.Peer {
 	p.mutex.RLock()
 	defer p.mutex.RUnlock()
 
-	// Собираем всех активных пиров
-	activePeers := make([]models.Peer, 0, len(p.peerTable))
-	for _, peer := range p.peerTable {
-		// Отбираем только недавно активных пиров
-		if time.Since(peer.LastSeen) < 30*time.Minute {
-			activePeers = append(activePeers, peer)
-		}
-	}
-
-	// Если пиров меньше, чем запрошено
-	if len(activePeers) <= count {
-		return activePeers
-	}
-
-	// Выбираем случайных пиров
-	result := make([]models.Peer, 0, count)
-	indices := rand.Perm(len(activePeers))
-
-	for i := 0; i < count; i++ {
-		result = append(result, activePeers[indices[i]])
-	}
-
-	return result
+	return p.addrMan.Random(count)
 }
 
 // cleanupLoop периодически удаляет неактивных пиров
-func (p *PexProtocol) cleanupLoop() {
+func (p *PexProtocol) cleanupLoop(ctx context.Context) {
+	defer p.wg.Done()
+
 	ticker := time.NewTicker(1 * time.Hour)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		p.cleanupInactivePeers()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.cleanupInactivePeers()
+		}
 	}
 }
 
@@ -404,42 +521,132 @@ func (p *PexProtocol) cleanupInactivePeers() {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
-	now := time.Now()
-	for id, peer := range p.peerTable {
-		if now.Sub(peer.LastSeen) > p.config.PexConfig.PeerTTL {
-			delete(p.peerTable, id)
-		}
+	if removed := p.addrMan.Expire(p.config.PexConfig.PeerTTL); removed > 0 {
+		p.logger.Infof("Removed %d inactive peers", removed)
 	}
 
 	p.notifyPeersUpdated()
 }
 
 // updatePeerLastSeen обновляет время последнего обращения к пиру
+// и переносит его в таблицу проверенных
 func (p *PexProtocol) updatePeerLastSeen(nodeID string) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
-	if peer, exists := p.peerTable[nodeID]; exists {
-		peer.LastSeen = time.Now()
-		p.peerTable[nodeID] = peer
+	peer, _, exists := p.addrMan.Lookup(nodeID)
+	if !exists {
+		return
+	}
+	peer.LastSeen = time.Now()
+	p.addrMan.Add(peer, models.Peer{})
 
+	promoted := p.addrMan.Good(nodeID)
+	if _, tried, _ := p.addrMan.Lookup(nodeID); tried {
 		// Асинхронно сохраняем в хранилище
-		peerCopy := peer
-		go p.storage.SavePeer(&peerCopy)
+		p.background(func() { p.storage.SavePeer(&peer) })
+	}
+	if promoted {
+		p.notifyPeersUpdated()
+	}
+}
+
+// removePeer удаляет пира из таблицы
+func (p *PexProtocol) removePeer(nodeID string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.addrMan.Remove(nodeID) {
+		p.notifyPeersUpdated()
+	}
+}
+
+// selfPeer возвращает запись о себе для PEX сообщений
+func (p *PexProtocol) selfPeer() models.Peer {
+	return models.Peer{
+		NodeID:   p.config.NodeID,
+		Address:  p.AdvertisedAddress(),
+		LastSeen: time.Now(),
+	}
+}
+
+// AdvertisedAddress возвращает адрес, который узел сообщает пирам: заданный
+// в конфигурации, иначе IP, под которым нас видят пиры, иначе IP интерфейса.
+// Если IP неизвестен, возвращается только порт, и хост подставляет получатель.
+func (p *PexProtocol) AdvertisedAddress() string {
+	port := strconv.Itoa(p.config.Port)
+
+	if addr := p.config.AdvertiseAddr; addr != "" {
+		if _, _, err := net.SplitHostPort(addr); err == nil {
+			return addr
+		}
+		return net.JoinHostPort(addr, port)
+	}
+
+	p.mutex.RLock()
+	host := p.observedHost
+	p.mutex.RUnlock()
+
+	if host == "" && !isUnspecified(p.config.ListenAddr) {
+		host = p.config.ListenAddr
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// learnObservedHost запоминает IP, под которым нас видит пир. Адрес
+// loopback не заменяет внешний: пиры на этой же машине видят 127.0.0.1.
+func (p *PexProtocol) learnObservedHost(host string) {
+	ip := net.ParseIP(host)
+	if p.config.AdvertiseAddr != "" || ip == nil || ip.IsUnspecified() {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if host == p.observedHost {
+		return
+	}
+	if current := net.ParseIP(p.observedHost); current != nil && !current.IsLoopback() && ip.IsLoopback() {
+		return
+	}
+	p.logger.Infof("Peers see this node at %s", host)
+	p.observedHost = host
+}
+
+// fillHost подставляет host в адрес без хоста или с неопределенным IP
+func fillHost(address, host string) string {
+	addrHost, port, err := net.SplitHostPort(address)
+	if err != nil || host == "" || !isUnspecified(addrHost) {
+		return address
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// isUnspecified сообщает, что хост не указывает на конкретный узел
+func isUnspecified(host string) bool {
+	if host == "" {
+		return true
 	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsUnspecified()
 }
 
-// GetPeers возвращает список всех известных пиров
+// GetPeers возвращает список проверенных пиров
 func (p *PexProtocol) GetPeers() []models.Peer {
 	p.mutex.RLock()
 	defer p.mutex.RUnlock()
 
-	peers := make([]models.Peer, 0, len(p.peerTable))
-	for _, peer := range p.peerTable {
-		peers = append(peers, peer)
-	}
+	return p.addrMan.Tried()
+}
 
-	return peers
+// GetNewAddresses возвращает адреса, о которых сообщили пиры, но с которыми
+// еще не было обмена
+func (p *PexProtocol) GetNewAddresses() []models.Peer {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return p.addrMan.NewAddresses()
 }
 
 // isValidAddress проверяет формат адреса
@@ -449,19 +656,40 @@ func (p *PexProtocol) isValidAddress(address string) bool {
 		return false
 	}
 
-	// Проверяем хост
-	if net.ParseIP(host) == nil && host != "localhost" {
+	// Проверяем хост: IP или доменное имя
+	if isUnspecified(host) {
+		return false
+	}
+	if net.ParseIP(host) == nil && !isValidHostname(host) {
 		return false
 	}
 
 	// Проверяем порт
-	if _, err := net.LookupPort("tcp", port); err != nil {
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
 		return false
 	}
 
 	return true
 }
 
+// isValidHostname проверяет синтаксис доменного имени
+func isValidHostname(host string) bool {
+	if len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 // testConnection проверяет доступность пира
 func (p *PexProtocol) testConnection(address string) bool {
 	// Простая проверка доступности
@@ -482,57 +710,56 @@ func (p *PexProtocol) testConnection(address string) bool {
 // notifyPeersUpdated уведомляет об обновлении списка пиров
 func (p *PexProtocol) notifyPeersUpdated() {
 	if p.onPeersList != nil {
-		peers := make([]models.Peer, 0, len(p.peerTable))
-		for _, peer := range p.peerTable {
-			peers = append(peers, peer)
-		}
-
-		p.onPeersList(peers)
+		p.onPeersList(p.addrMan.Tried())
 	}
 }
 
-// syncMessagesWithPeer синхронизирует сообщения с новым пиром
+// syncMessagesWithPeer догружает сообщения пира порциями, начиная
+// с номера, на котором остановилась прошлая синхронизация с ним
 func (p *PexProtocol) syncMessagesWithPeer(peer models.Peer) error {
-	p.logger.Infof("Starting message sync with peer %s", peer.NodeID)
-
-	// Получаем список локальных сообщений
-	localMessageIDs, err := p.storage.GetMessageList()
+	cursor, err := p.storage.GetSyncCursor(peer.NodeID)
 	if err != nil {
-		return fmt.Errorf("failed to get local message list: %w", err)
+		return fmt.Errorf("failed to get sync cursor: %w", err)
 	}
+	p.logger.Infof("Starting message sync with peer %s from %d", peer.NodeID, cursor)
 
-	// Создаем карту локальных сообщений для быстрого поиска
-	localMessages := make(map[string]bool)
-	for _, msgID := range localMessageIDs {
-		localMessages[msgID] = true
-	}
-
-	// Получаем список сообщений пира
-	url := fmt.Sprintf("http://%s/messages", peer.Address)
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("failed to get message list: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status code: %d", resp.StatusCode)
-	}
+	var syncMessages int
+	reset := false
+	for {
+		batch, err := p.fetchMessages(peer, cursor)
+		if err != nil {
+			return err
+		}
 
-	var messageIDs []string
-	if err := json.NewDecoder(resp.Body).Decode(&messageIDs); err != nil {
-		return fmt.Errorf("failed to decode message list: %w", err)
-	}
+		// Номера пира меньше сохраненных: пир потерял данные, начинаем заново
+		if batch.Head < cursor && !reset {
+			p.logger.Warnf("Peer %s has %d messages, below our cursor %d, restarting sync", peer.NodeID, batch.Head, cursor)
+			cursor = 0
+			reset = true
+			continue
+		}
+		if batch.Cursor <= cursor && batch.More {
+			return fmt.Errorf("peer %s did not advance sync cursor %d", peer.NodeID, cursor)
+		}
 
-	// Загружаем отсутствующие сообщения
-	var syncMessages int
-	for _, msgID := range messageIDs {
-		if !localMessages[msgID] {
-			if err := p.downloadMessage(peer, msgID); err != nil {
-				p.logger.Warnf("Failed to download message %s: %v", msgID, err)
+		for i := range batch.Messages {
+			message := &batch.Messages[i]
+			if p.storage.HasMessage(message.MessageID) {
 				continue
 			}
-			syncMessages++
+			if gossip.AcceptMessage(message, peer.NodeID, p.storage, p.hookManager, p.reputation, p.logger) {
+				syncMessages++
+			}
+		}
+
+		if batch.Cursor > cursor {
+			cursor = batch.Cursor
+			if err := p.storage.SaveSyncCursor(peer.NodeID, cursor); err != nil {
+				return fmt.Errorf("failed to save sync cursor: %w", err)
+			}
+		}
+		if !batch.More {
+			break
 		}
 	}
 	p.logger.Infof("Synced %d messages with peer %s", syncMessages, peer.NodeID)
@@ -540,37 +767,16 @@ func (p *PexProtocol) syncMessagesWithPeer(peer models.Peer) error {
 	return nil
 }
 
-// downloadMessage загружает конкретное сообщение от пира
-func (p *PexProtocol) downloadMessage(peer models.Peer, messageID string) error {
-	url := fmt.Sprintf("http://%s/messages/%s", peer.Address, messageID)
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("failed to get message: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status code: %d", resp.StatusCode)
-	}
-
-	var message models.GossipMessage
-	if err := json.NewDecoder(resp.Body).Decode(&message); err != nil {
-		return fmt.Errorf("failed to decode message: %w", err)
-	}
+// fetchMessages запрашивает у пира порцию сообщений после номера after
+func (p *PexProtocol) fetchMessages(peer models.Peer, after uint64) (*models.MessageBatch, error) {
+	path := fmt.Sprintf("/sync/messages?after=%d&limit=%d", after, p.config.GossipConfig.SyncBatchSize)
 
-	// Проверяем валидность сообщения через хуки
-	if !p.hookManager.ValidateMessage(&message, interfaces.MessageTypeLoaded) {
-		p.logger.Warnf("Message validation failed during download: %s", messageID)
-		return fmt.Errorf("message validation failed: %s", messageID)
-	}
-
-	// Сохраняем сообщение
-	if err := p.storage.SaveMessage(&message); err != nil {
-		return fmt.Errorf("failed to save message: %w", err)
+	var batch models.MessageBatch
+	start := time.Now()
+	err := p.transport.Send(peer, http.MethodGet, path, nil, &batch)
+	p.reputation.RecordRequest(peer.NodeID, time.Since(start), err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages: %w", err)
 	}
-
-	// Обрабатываем сообщение через хуки
-	p.hookManager.ProcessMessage(&message, interfaces.MessageTypeLoaded)
-
-	return nil
+	return &batch, nil
 }
@@ -1,17 +1,26 @@
 package tests
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
 	"concoin/conrun/pkg/config"
+	"concoin/conrun/pkg/identity"
 	"concoin/conrun/pkg/interfaces"
 	"concoin/conrun/pkg/models"
 	"concoin/conrun/pkg/pex"
+	"concoin/conrun/pkg/reputation"
+	"concoin/conrun/pkg/storage"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/mock"
@@ -73,6 +82,35 @@ func (m *MockStorage) HasMessage(messageID string) bool {
 	return args.Bool(0)
 }
 
+func (m *MockStorage) GetMessagesSince(seq uint64, limit int) ([]*models.GossipMessage, uint64, uint64, error) {
+	args := m.Called(seq, limit)
+	return args.Get(0).([]*models.GossipMessage), args.Get(1).(uint64), args.Get(2).(uint64), args.Error(3)
+}
+
+func (m *MockStorage) GetSyncCursor(nodeID string) (uint64, error) {
+	args := m.Called(nodeID)
+	return args.Get(0).(uint64), args.Error(1)
+}
+
+func (m *MockStorage) SaveSyncCursor(nodeID string, cursor uint64) error {
+	args := m.Called(nodeID, cursor)
+	return args.Error(0)
+}
+
+func (m *MockStorage) SaveBan(ban *models.Ban) error {
+	args := m.Called(ban)
+	return args.Error(0)
+}
+
+func (m *MockStorage) Close() error {
+	return nil
+}
+
+func (m *MockStorage) GetBans() ([]*models.Ban, error) {
+	args := m.Called()
+	return args.Get(0).([]*models.Ban), args.Error(1)
+}
+
 func TestPexProtocol_AddPeer(t *testing.T) {
 	// Create test dependencies
 	logger := logrus.New()
@@ -110,7 +148,7 @@ func TestPexProtocol_AddPeer(t *testing.T) {
 
 	// Setup storage expectations
 	mockStorage.On("SavePeer", mock.AnythingOfType("*models.Peer")).Return(nil)
-	mockStorage.On("GetMessageList").Return([]string{}, nil)
+	mockStorage.On("GetSyncCursor", mock.Anything).Return(uint64(0), nil)
 
 	// Create pex protocol
 	pexProtocol := pex.NewPexProtocol(cfg, mockStorage, logger, mockHookManager)
@@ -127,16 +165,16 @@ func TestPexProtocol_AddPeer(t *testing.T) {
 		t.Errorf("AddPeer failed to add valid peer")
 	}
 
-	// Test adding self address
+	// Test adding self, recognized by node ID whatever the address is
 	selfPeer := models.Peer{
-		NodeID:   "self-peer",
-		Address:  "127.0.0.1:3000",
+		NodeID:   cfg.NodeID,
+		Address:  serverAddr,
 		LastSeen: time.Now(),
 	}
 
 	result = pexProtocol.AddPeer(selfPeer)
 	if result {
-		t.Errorf("AddPeer should reject self address")
+		t.Errorf("AddPeer should reject self")
 	}
 
 	// Test adding invalid address
@@ -209,7 +247,7 @@ func TestPexProtocol_HandlePexRequest(t *testing.T) {
 
 	// Setup storage expectations
 	mockStorage.On("SavePeer", mock.AnythingOfType("*models.Peer")).Return(nil)
-	mockStorage.On("GetMessageList").Return([]string{}, nil)
+	mockStorage.On("GetSyncCursor", mock.Anything).Return(uint64(0), nil)
 
 	// Create pex protocol
 	pexProtocol := pex.NewPexProtocol(cfg, mockStorage, logger, mockHookManager)
@@ -221,10 +259,11 @@ func TestPexProtocol_HandlePexRequest(t *testing.T) {
 		LastSeen: time.Now(),
 	})
 
-	// Create a test PEX request
+	// Create a test PEX request from a sender that does not know its own IP
+	_, serverPort, _ := net.SplitHostPort(serverAddr)
 	requestPeer := models.Peer{
 		NodeID:   "request-peer",
-		Address:  serverAddr,
+		Address:  ":" + serverPort,
 		LastSeen: time.Now(),
 	}
 
@@ -233,10 +272,11 @@ func TestPexProtocol_HandlePexRequest(t *testing.T) {
 		Type:      models.PexRequest,
 		Timestamp: time.Now().UTC(),
 		Peers:     []models.Peer{requestPeer},
+		SenderID:  requestPeer.NodeID,
 	}
 
 	// Handle the request
-	response := pexProtocol.HandlePexRequest(request)
+	response := pexProtocol.HandlePexRequest(request, "127.0.0.1:49152")
 
 	// Verify response
 	if response.Type != models.PexResponse {
@@ -247,12 +287,19 @@ func TestPexProtocol_HandlePexRequest(t *testing.T) {
 		t.Errorf("Expected at least 1 peer in response, got %d", len(response.Peers))
 	}
 
-	// Verify request peer was added
+	if response.SenderID != cfg.NodeID || response.ObservedAddr != "127.0.0.1" {
+		t.Errorf("Expected sender %s observed at 127.0.0.1, got %s at %q", cfg.NodeID, response.SenderID, response.ObservedAddr)
+	}
+
+	// Verify request peer was added with the address it was seen at
 	peers := pexProtocol.GetPeers()
 	found := false
 	for _, p := range peers {
 		if p.NodeID == requestPeer.NodeID {
 			found = true
+			if p.Address != serverAddr {
+				t.Errorf("Expected request peer address %s, got %s", serverAddr, p.Address)
+			}
 			break
 		}
 	}
@@ -297,15 +344,20 @@ func TestPexProtocol_Start(t *testing.T) {
 	})
 
 	// Start the protocol
-	pexProtocol.Start()
+	if err := pexProtocol.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start PEX protocol: %v", err)
+	}
+	defer pexProtocol.Stop()
 
 	// Wait a short time for seed nodes to be processed
 	time.Sleep(100 * time.Millisecond)
 
-	// Verify seed nodes were added
-	peers := pexProtocol.GetPeers()
-	if len(peers) != 1 {
-		t.Errorf("Expected 1 seed node, got %d peers", len(peers))
+	// Verify seed nodes were added as new addresses until the first exchange
+	if peers := pexProtocol.GetNewAddresses(); len(peers) != 1 {
+		t.Errorf("Expected 1 seed node, got %d new addresses", len(peers))
+	}
+	if peers := pexProtocol.GetPeers(); len(peers) != 0 {
+		t.Errorf("Expected no verified peers before the first exchange, got %d", len(peers))
 	}
 
 	if !peerUpdateCalled {
@@ -314,3 +366,269 @@ func TestPexProtocol_Start(t *testing.T) {
 
 	mockStorage.AssertExpectations(t)
 }
+
+func TestPexProtocol_Reputation(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	}))
+	defer server.Close()
+	serverAddr := server.Listener.Addr().String()
+
+	cfg := config.DefaultConfig(3000, 0)
+	cfg.DataDir = t.TempDir()
+	cfg.PexConfig.MaxPeers = 2
+
+	pexProtocol := pex.NewPexProtocol(cfg, storage.NewStorage(cfg.DataDir), logger, new(MockHookManager))
+	manager := reputation.NewManager(cfg.ReputationConfig, nil, logger)
+	pexProtocol.SetReputation(manager)
+
+	// The full table drops the worst scored peer, not the oldest one
+	pexProtocol.AddPeer(models.Peer{NodeID: "old-peer", Address: serverAddr, LastSeen: time.Now().Add(-time.Hour)})
+	pexProtocol.AddPeer(models.Peer{NodeID: "poor-peer", Address: serverAddr, LastSeen: time.Now()})
+	manager.RecordInvalid("poor-peer", "bad message signature")
+
+	if !pexProtocol.AddPeer(models.Peer{NodeID: "new-peer", Address: serverAddr, LastSeen: time.Now()}) {
+		t.Fatalf("AddPeer failed to add valid peer")
+	}
+	peers := map[string]bool{}
+	for _, peer := range pexProtocol.GetPeers() {
+		peers[peer.NodeID] = true
+	}
+	if len(peers) != 2 || !peers["old-peer"] || !peers["new-peer"] {
+		t.Errorf("Expected poor-peer to be evicted, got %v", peers)
+	}
+
+	// A banned peer is removed and can not be added again
+	for !manager.IsBanned("old-peer") {
+		manager.RecordInvalid("old-peer", "bad message signature")
+	}
+	for _, peer := range pexProtocol.GetPeers() {
+		if peer.NodeID == "old-peer" {
+			t.Errorf("Expected banned peer to be removed")
+		}
+	}
+	if pexProtocol.AddPeer(models.Peer{NodeID: "old-peer", Address: serverAddr, LastSeen: time.Now()}) {
+		t.Errorf("AddPeer should reject banned peer")
+	}
+}
+
+func TestPexProtocol_Flood(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	cfg := config.DefaultConfig(3000, 0)
+	cfg.DataDir = t.TempDir()
+	pexProtocol := pex.NewPexProtocol(cfg, storage.NewStorage(cfg.DataDir), logger, new(MockHookManager))
+
+	// One source announces many addresses, they are kept apart from verified peers
+	request := models.PexMessage{Type: models.PexRequest, Timestamp: time.Now().UTC()}
+	for i := 0; i < 500; i++ {
+		request.Peers = append(request.Peers, models.Peer{
+			NodeID:   fmt.Sprintf("flood-%d", i),
+			Address:  fmt.Sprintf("10.%d.%d.1:3000", i/250, i%250),
+			LastSeen: time.Now(),
+		})
+	}
+	pexProtocol.HandlePexRequest(request, "198.51.100.7:40000")
+
+	if peers := pexProtocol.GetPeers(); len(peers) != 0 {
+		t.Errorf("Expected announced addresses not to become peers, got %d", len(peers))
+	}
+	// The new table holds about 4*MaxPeers addresses, a source gets NewPeerShare percent of it
+	learned := len(pexProtocol.GetNewAddresses())
+	if learned == 0 || learned*100 > cfg.PexConfig.MaxPeers*4*cfg.PexConfig.NewPeerShare*2 {
+		t.Errorf("Expected one source to fill only a small share of new addresses, got %d", learned)
+	}
+}
+
+// pexNode runs a PEX protocol behind a test server with the /ping and /pex routes
+func pexNode(t *testing.T, nodeID string) (*pex.PexProtocol, *config.Config, string) {
+	logger := logrus.New()
+	logger.SetOutput(logrus.StandardLogger().Out)
+	logger.SetLevel(logrus.ErrorLevel)
+
+	var protocol *pex.PexProtocol
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ping":
+			w.Write([]byte("pong"))
+		case "/pex":
+			var request models.PexMessage
+			json.NewDecoder(r.Body).Decode(&request)
+			json.NewEncoder(w).Encode(protocol.HandlePexRequest(request, r.RemoteAddr))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	cfg := config.DefaultConfig(3000, 0)
+	cfg.NodeID = nodeID
+	cfg.Port, _ = strconv.Atoi(port)
+	cfg.DataDir = t.TempDir()
+	cfg.PexConfig.ExchangeInterval = 50 * time.Millisecond
+
+	mockHookManager := new(MockHookManager)
+	protocol = pex.NewPexProtocol(cfg, storage.NewStorage(cfg.DataDir), logger, mockHookManager)
+	return protocol, cfg, server.Listener.Addr().String()
+}
+
+func TestPexProtocol_Addresses(t *testing.T) {
+	// Neither node has an advertise address, node A knows B as a seed
+	// and is also given its own address as a seed
+	nodeB, _, addrB := pexNode(t, "node-b")
+	nodeA, cfgA, addrA := pexNode(t, "node-a")
+	cfgA.SeedNodes = []string{addrB, addrA}
+
+	if got := nodeA.AdvertisedAddress(); got != fmt.Sprintf(":%d", cfgA.Port) {
+		t.Errorf("Expected node A to advertise only its port before the first exchange, got %s", got)
+	}
+
+	nodeA.Start(context.Background())
+	t.Cleanup(nodeA.Stop)
+
+	hasPeers := func(protocol *pex.PexProtocol, expected map[string]string) bool {
+		peers := protocol.GetPeers()
+		if len(peers) != len(expected) {
+			return false
+		}
+		for _, peer := range peers {
+			if expected[peer.NodeID] != peer.Address {
+				return false
+			}
+		}
+		return true
+	}
+
+	// The seed placeholders are replaced by B and dropped for the node itself
+	deadline := time.Now().Add(3 * time.Second)
+	for !hasPeers(nodeA, map[string]string{"node-b": addrB}) || !hasPeers(nodeB, map[string]string{"node-a": addrA}) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Peer tables did not converge: A=%v B=%v", nodeA.GetPeers(), nodeB.GetPeers())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// B told A the IP it sees A at
+	if got := nodeA.AdvertisedAddress(); got != addrA {
+		t.Errorf("Expected node A to advertise %s, got %s", addrA, got)
+	}
+}
+
+func TestPexProtocol_SyncMessages(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(logrus.StandardLogger().Out)
+	logger.SetLevel(logrus.ErrorLevel)
+
+	remoteIdentity, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+	newMessage := func(i int) models.GossipMessage {
+		message := models.GossipMessage{
+			Timestamp:   time.Now().UTC(),
+			MessageType: "user_message",
+			Payload:     map[string]interface{}{"content": fmt.Sprintf("msg-%d", i)},
+		}
+		if err := remoteIdentity.Seal(&message); err != nil {
+			t.Fatalf("Failed to seal message: %v", err)
+		}
+		return message
+	}
+
+	// The peer has 5 messages and serves them 2 per batch, one of them is forged
+	var remote []models.GossipMessage
+	for i := 0; i < 5; i++ {
+		remote = append(remote, newMessage(i))
+	}
+	remote[3].Payload = map[string]interface{}{"content": "forged"}
+
+	var requestsMutex sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ping":
+			w.Write([]byte("pong"))
+		case "/sync/messages":
+			requestsMutex.Lock()
+			requests = append(requests, r.URL.Query().Get("after"))
+			requestsMutex.Unlock()
+
+			after, _ := strconv.Atoi(r.URL.Query().Get("after"))
+			end := after + 2
+			if end > len(remote) {
+				end = len(remote)
+			}
+			json.NewEncoder(w).Encode(models.MessageBatch{
+				Messages: remote[after:end],
+				Cursor:   uint64(end),
+				Head:     uint64(len(remote)),
+				More:     end < len(remote),
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tempDir := t.TempDir()
+	store := storage.NewStorage(tempDir)
+
+	cfg := config.DefaultConfig(3000, 0)
+	cfg.DataDir = tempDir
+	cfg.GossipConfig.SyncBatchSize = 2
+
+	mockHookManager := new(MockHookManager)
+	mockHookManager.On("ValidateMessage", mock.Anything, interfaces.MessageTypeLoaded).Return(true)
+	mockHookManager.On("ProcessMessage", mock.Anything, interfaces.MessageTypeLoaded).Return(true)
+
+	peer := models.Peer{NodeID: "remote-node", Address: server.Listener.Addr().String()}
+
+	waitCursor := func(expected uint64) {
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if cursor, _ := store.GetSyncCursor(peer.NodeID); cursor == expected {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("Sync cursor did not reach %d", expected)
+	}
+
+	if !pex.NewPexProtocol(cfg, store, logger, mockHookManager).AddPeer(peer) {
+		t.Fatalf("AddPeer failed to add valid peer")
+	}
+	waitCursor(5)
+
+	for i, message := range remote {
+		if store.HasMessage(message.MessageID) != (i != 3) {
+			t.Errorf("Unexpected sync state of message %d", i)
+		}
+	}
+
+	// The peer gets two more messages, a restarted node resumes from its cursor
+	for i := 5; i < 7; i++ {
+		remote = append(remote, newMessage(i))
+	}
+	requestsMutex.Lock()
+	requests = nil
+	requestsMutex.Unlock()
+
+	if !pex.NewPexProtocol(cfg, store, logger, mockHookManager).AddPeer(peer) {
+		t.Fatalf("AddPeer failed to add valid peer")
+	}
+	waitCursor(7)
+
+	requestsMutex.Lock()
+	defer requestsMutex.Unlock()
+	if len(requests) != 1 || requests[0] != "5" {
+		t.Errorf("Expected a single request after 5, got %v", requests)
+	}
+	if !store.HasMessage(remote[6].MessageID) {
+		t.Errorf("Message 6 was not synced")
+	}
+}
@@ -0,0 +1,289 @@
+package reputation
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"concoin/conrun/pkg/config"
+	"concoin/conrun/pkg/interfaces"
+	"concoin/conrun/pkg/models"
+	"concoin/conrun/pkg/transport"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrBanned возвращается на запросы забаненных пиров
+var ErrBanned = errors.New("peer is banned")
+
+const (
+	maxBehaviour        = 100                    // верхняя граница оценки поведения
+	availabilityWeight  = 20                     // вклад доступности в оценку
+	initialAvailability = 0.5                    // доступность пира без истории запросов
+	latencyUnit         = 100 * time.Millisecond // задержка, отнимающая одно очко
+	maxLatencyPenalty   = 10
+	ewmaAlpha           = 0.2 // вес нового замера в скользящих средних
+
+	// Вес пира при выборе: 1 + оценка/weightScale в пределах [minWeight, maxWeight],
+	// чтобы хорошие пиры выбирались чаще, но новые тоже получали сообщения
+	weightScale = 50
+	minWeight   = 0.1
+	maxWeight   = 3
+)
+
+// Manager ведет оценки пиров и баны. Оценка складывается из поведения
+// (награды за валидные сообщения и штрафы за нарушения), доступности
+// и задержки ответов. Пир, у которого оценка поведения опустилась до
+// BanThreshold, банится на BanDuration.
+type Manager struct {
+	config    config.ReputationConfig
+	storage   interfaces.StorageInterface
+	logger    *logrus.Logger
+	mutex     sync.RWMutex
+	scores    map[string]*models.PeerScore
+	bans      map[string]models.Ban
+	listeners []func(nodeID string)
+}
+
+// NewManager создает менеджер оценок и загружает действующие баны
+// из хранилища. Без хранилища баны не переживают перезапуск.
+func NewManager(config config.ReputationConfig, storage interfaces.StorageInterface, logger *logrus.Logger) *Manager {
+	m := &Manager{
+		config:  config,
+		storage: storage,
+		logger:  logger,
+		scores:  make(map[string]*models.PeerScore),
+		bans:    make(map[string]models.Ban),
+	}
+	if storage == nil {
+		return m
+	}
+
+	bans, err := storage.GetBans()
+	if err != nil {
+		logger.Warnf("Failed to load peer bans: %v", err)
+		return m
+	}
+	now := time.Now()
+	for _, ban := range bans {
+		if ban.Until.After(now) {
+			m.bans[ban.NodeID] = *ban
+		}
+	}
+	if len(m.bans) > 0 {
+		logger.Infof("Loaded %d peer bans", len(m.bans))
+	}
+	return m
+}
+
+// AddBanListener добавляет обработчик, вызываемый при бане пира
+func (m *Manager) AddBanListener(listener func(nodeID string)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.listeners = append(m.listeners, listener)
+}
+
+// RecordValid учитывает новое валидное сообщение от пира
+func (m *Manager) RecordValid(nodeID string) {
+	m.adjust(nodeID, m.config.ValidReward, "", func(score *models.PeerScore) { score.Valid++ })
+}
+
+// RecordRejected учитывает сообщение, отклоненное хуками. Штраф небольшой:
+// честный пир мог переслать сообщение, ставшее невалидным по пути.
+func (m *Manager) RecordRejected(nodeID string, reason string) {
+	m.adjust(nodeID, -m.config.RejectedPenalty, reason, func(score *models.PeerScore) { score.Rejected++ })
+}
+
+// RecordInvalid учитывает сообщение с неверной подписью или хешем
+func (m *Manager) RecordInvalid(nodeID string, reason string) {
+	m.adjust(nodeID, -m.config.InvalidPenalty, reason, func(score *models.PeerScore) { score.Invalid++ })
+}
+
+// RecordUnreachable учитывает анонс пиром недоступного адреса
+func (m *Manager) RecordUnreachable(nodeID string, address string) {
+	m.adjust(nodeID, -m.config.UnreachablePenalty, "unreachable address "+address, func(score *models.PeerScore) { score.Unreachable++ })
+}
+
+// RecordLimited учитывает превышение пиром лимитов входящих запросов
+func (m *Manager) RecordLimited(nodeID string, reason string) {
+	m.adjust(nodeID, -m.config.LimitPenalty, reason, func(score *models.PeerScore) { score.Limited++ })
+}
+
+// RecordRequest учитывает результат запроса к пиру. Ответ с кодом ошибки
+// означает, что пир доступен; отказ из-за паузы переподключения не учитывается.
+func (m *Manager) RecordRequest(nodeID string, latency time.Duration, err error) {
+	if nodeID == "" || errors.Is(err, transport.ErrBackoff) || errors.Is(err, transport.ErrClosed) {
+		return
+	}
+	var statusErr *transport.StatusError
+	success := err == nil || errors.As(err, &statusErr)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	score := m.score(nodeID)
+	if !success {
+		score.Availability *= 1 - ewmaAlpha
+		score.Failures++
+		return
+	}
+	score.Availability += ewmaAlpha * (1 - score.Availability)
+	if score.Latency == 0 {
+		score.Latency = latency
+	} else {
+		score.Latency += time.Duration(ewmaAlpha * float64(latency-score.Latency))
+	}
+}
+
+// adjust меняет оценку поведения пира и банит его при падении ниже порога
+func (m *Manager) adjust(nodeID string, delta float64, reason string, count func(score *models.PeerScore)) {
+	if nodeID == "" {
+		return
+	}
+
+	m.mutex.Lock()
+	score := m.score(nodeID)
+	count(score)
+	score.Behaviour = math.Min(score.Behaviour+delta, maxBehaviour)
+	if score.Behaviour > m.config.BanThreshold {
+		m.mutex.Unlock()
+		return
+	}
+
+	ban := models.Ban{
+		NodeID: nodeID,
+		Reason: reason,
+		Until:  time.Now().Add(m.config.BanDuration).UTC(),
+	}
+	m.bans[nodeID] = ban
+	delete(m.scores, nodeID)
+	listeners := append([]func(string){}, m.listeners...)
+	m.mutex.Unlock()
+
+	m.logger.Warnf("Banned peer %s until %s: %s", nodeID, ban.Until.Format(time.RFC3339), reason)
+	if m.storage != nil {
+		if err := m.storage.SaveBan(&ban); err != nil {
+			m.logger.Warnf("Failed to save ban of peer %s: %v", nodeID, err)
+		}
+	}
+	for _, listener := range listeners {
+		listener(nodeID)
+	}
+}
+
+// score возвращает запись оценки пира, создавая ее при необходимости
+func (m *Manager) score(nodeID string) *models.PeerScore {
+	score, exists := m.scores[nodeID]
+	if !exists {
+		score = &models.PeerScore{NodeID: nodeID, Availability: initialAvailability}
+		m.scores[nodeID] = score
+	}
+	return score
+}
+
+// Score возвращает итоговую оценку пира
+func (m *Manager) Score(nodeID string) float64 {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if score, exists := m.scores[nodeID]; exists {
+		return total(score)
+	}
+	return total(&models.PeerScore{Availability: initialAvailability})
+}
+
+// total складывает оценку поведения, доступности и задержки
+func total(score *models.PeerScore) float64 {
+	latencyPenalty := math.Min(float64(score.Latency)/float64(latencyUnit), maxLatencyPenalty)
+	return score.Behaviour + availabilityWeight*score.Availability - latencyPenalty
+}
+
+// IsBanned сообщает, забанен ли пир
+func (m *Manager) IsBanned(nodeID string) bool {
+	m.mutex.RLock()
+	ban, exists := m.bans[nodeID]
+	m.mutex.RUnlock()
+
+	if !exists {
+		return false
+	}
+	if time.Now().Before(ban.Until) {
+		return true
+	}
+
+	m.mutex.Lock()
+	delete(m.bans, nodeID)
+	m.mutex.Unlock()
+	m.logger.Infof("Ban of peer %s expired", nodeID)
+	return false
+}
+
+// SelectPeers выбирает до count незабаненных пиров без повторов.
+// Вероятность выбора растет с оценкой пира.
+func (m *Manager) SelectPeers(peers []models.Peer, count int) []models.Peer {
+	type candidate struct {
+		peer models.Peer
+		key  float64
+	}
+
+	candidates := make([]candidate, 0, len(peers))
+	for _, peer := range peers {
+		if m.IsBanned(peer.NodeID) {
+			continue
+		}
+		weight := math.Max(minWeight, math.Min(maxWeight, 1+m.Score(peer.NodeID)/weightScale))
+		// Взвешенная выборка без повторов: берем count пиров с наибольшим u^(1/w)
+		candidates = append(candidates, candidate{peer, math.Pow(rand.Float64(), 1/weight)})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].key > candidates[j].key
+	})
+	if len(candidates) > count {
+		candidates = candidates[:count]
+	}
+
+	selected := make([]models.Peer, len(candidates))
+	for i, c := range candidates {
+		selected[i] = c.peer
+	}
+	return selected
+}
+
+// Scores возвращает оценки всех пиров, лучшие первыми
+func (m *Manager) Scores() []models.PeerScore {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	scores := make([]models.PeerScore, 0, len(m.scores))
+	for _, score := range m.scores {
+		entry := *score
+		entry.Score = total(score)
+		scores = append(scores, entry)
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		return scores[i].Score > scores[j].Score
+	})
+	return scores
+}
+
+// Bans возвращает действующие баны
+func (m *Manager) Bans() []models.Ban {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	now := time.Now()
+	bans := make([]models.Ban, 0, len(m.bans))
+	for _, ban := range m.bans {
+		if ban.Until.After(now) {
+			bans = append(bans, ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].Until.Before(bans[j].Until)
+	})
+	return bans
+}
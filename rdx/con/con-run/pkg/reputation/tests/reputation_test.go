@@ -0,0 +1,150 @@
+package tests
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"concoin/conrun/pkg/config"
+	"concoin/conrun/pkg/models"
+	"concoin/conrun/pkg/reputation"
+	"concoin/conrun/pkg/storage"
+	"concoin/conrun/pkg/transport"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newManager(store *storage.Storage) (*reputation.Manager, config.ReputationConfig) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	cfg := config.DefaultConfig(3000, 0).ReputationConfig
+	if store == nil {
+		return reputation.NewManager(cfg, nil, logger), cfg
+	}
+	return reputation.NewManager(cfg, store, logger), cfg
+}
+
+func TestManager_Score(t *testing.T) {
+	manager, _ := newManager(nil)
+
+	neutral := manager.Score("new-peer")
+
+	manager.RecordValid("good-peer")
+	manager.RecordRequest("good-peer", 10*time.Millisecond, nil)
+	if score := manager.Score("good-peer"); score <= neutral {
+		t.Errorf("Expected valid messages and answered requests to raise the score above %.2f, got %.2f", neutral, score)
+	}
+
+	manager.RecordRejected("rejected-peer", "rejected by hooks")
+	if score := manager.Score("rejected-peer"); score >= neutral {
+		t.Errorf("Expected a rejected message to lower the score below %.2f, got %.2f", neutral, score)
+	}
+
+	// Failures lower availability, slow answers cost points
+	manager.RecordRequest("down-peer", 0, errors.New("connection refused"))
+	if score := manager.Score("down-peer"); score >= neutral {
+		t.Errorf("Expected a failed request to lower the score below %.2f, got %.2f", neutral, score)
+	}
+	manager.RecordRequest("slow-peer", 2*time.Second, nil)
+	manager.RecordRequest("fast-peer", time.Millisecond, nil)
+	if manager.Score("slow-peer") >= manager.Score("fast-peer") {
+		t.Errorf("Expected a slow peer to score below a fast one")
+	}
+
+	// A peer that answered with an error status is reachable, backoff is not a new failure
+	manager.RecordRequest("status-peer", time.Millisecond, &transport.StatusError{Code: 500})
+	manager.RecordRequest("backoff-peer", 0, transport.ErrBackoff)
+	for _, score := range manager.Scores() {
+		if score.NodeID == "status-peer" && score.Failures != 0 {
+			t.Errorf("Expected an error status not to count as a failure")
+		}
+		if score.NodeID == "backoff-peer" {
+			t.Errorf("Expected backoff not to be recorded")
+		}
+	}
+}
+
+func TestManager_Ban(t *testing.T) {
+	dataDir := t.TempDir()
+	manager, cfg := newManager(storage.NewStorage(dataDir))
+
+	var banned []string
+	manager.AddBanListener(func(nodeID string) { banned = append(banned, nodeID) })
+
+	// Valid messages do not outweigh forgeries forever
+	for i := 0; i < 10; i++ {
+		manager.RecordValid("bad-peer")
+	}
+	invalid := 0
+	for !manager.IsBanned("bad-peer") {
+		manager.RecordInvalid("bad-peer", "bad message signature")
+		invalid++
+		if invalid > 100 {
+			t.Fatalf("Peer was not banned")
+		}
+	}
+	if expected := int(math.Ceil((10*cfg.ValidReward - cfg.BanThreshold) / cfg.InvalidPenalty)); invalid != expected {
+		t.Errorf("Expected a ban after %d invalid messages, got %d", expected, invalid)
+	}
+	if len(banned) != 1 || banned[0] != "bad-peer" {
+		t.Errorf("Expected ban listener to be called for bad-peer, got %v", banned)
+	}
+
+	bans := manager.Bans()
+	if len(bans) != 1 || bans[0].Reason != "bad message signature" || time.Until(bans[0].Until) < cfg.BanDuration-time.Minute {
+		t.Fatalf("Unexpected bans %+v", bans)
+	}
+
+	// Banned peers are not selected
+	peers := []models.Peer{{NodeID: "bad-peer"}, {NodeID: "other-peer"}}
+	if selected := manager.SelectPeers(peers, 2); len(selected) != 1 || selected[0].NodeID != "other-peer" {
+		t.Errorf("Expected only other-peer to be selected, got %v", selected)
+	}
+
+	// The ban survives a restart
+	restarted, _ := newManager(storage.NewStorage(dataDir))
+	if !restarted.IsBanned("bad-peer") {
+		t.Errorf("Expected the ban to be loaded from storage")
+	}
+
+	t.Run("Expired", func(t *testing.T) {
+		store := storage.NewStorage(t.TempDir())
+		store.SaveBan(&models.Ban{NodeID: "old-peer", Reason: "test", Until: time.Now().Add(-time.Minute)})
+
+		manager, _ := newManager(store)
+		if manager.IsBanned("old-peer") || len(manager.Bans()) != 0 {
+			t.Errorf("Expected an expired ban to be ignored")
+		}
+	})
+}
+
+func TestManager_SelectPeers(t *testing.T) {
+	manager, _ := newManager(nil)
+
+	for i := 0; i < 100; i++ {
+		manager.RecordValid("good-peer")
+	}
+	for i := 0; i < 40; i++ {
+		manager.RecordRejected("poor-peer", "rejected by hooks")
+	}
+
+	peers := []models.Peer{{NodeID: "good-peer"}, {NodeID: "poor-peer"}, {NodeID: "new-peer"}}
+	if selected := manager.SelectPeers(peers, 5); len(selected) != 3 {
+		t.Fatalf("Expected all 3 peers to be selected, got %d", len(selected))
+	}
+
+	// Better scored peers are picked more often, but not exclusively
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		selected := manager.SelectPeers(peers, 1)
+		counts[selected[0].NodeID]++
+	}
+	if !(counts["good-peer"] > counts["new-peer"] && counts["new-peer"] > counts["poor-peer"]) {
+		t.Errorf("Expected selection to follow scores, got %v", counts)
+	}
+	if counts["poor-peer"] == 0 {
+		t.Errorf("Expected poorly scored peers to still be selected sometimes, got %v", counts)
+	}
+}
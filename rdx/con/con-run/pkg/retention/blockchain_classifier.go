@@ -0,0 +1,64 @@
+package retention
+
+import (
+	"time"
+
+	"concoin/conrun/pkg/blockchain"
+	"concoin/conrun/pkg/interfaces"
+	"concoin/conrun/pkg/models"
+)
+
+// Виды сообщений блокчейна
+const (
+	KindBlock       = "block"
+	KindTransaction = "transaction"
+)
+
+// BlockchainClassifier делит сообщения блокчейна на блоки и транзакции.
+// Блоки нужны всегда. Транзакция больше не нужна, если ее нет в мемпуле
+// и ее nonce уже использован в основной цепочке (она или конкурирующая
+// транзакция попала в блок) либо она старше txTTL и мемпул ее выбросил.
+type BlockchainClassifier struct {
+	ledger  blockchain.Ledger
+	mempool interfaces.MempoolInterface
+	txTTL   time.Duration
+}
+
+// NewBlockchainClassifier создает разбор сообщений блокчейна
+func NewBlockchainClassifier(ledger blockchain.Ledger, mempool interfaces.MempoolInterface, txTTL time.Duration) *BlockchainClassifier {
+	return &BlockchainClassifier{
+		ledger:  ledger,
+		mempool: mempool,
+		txTTL:   txTTL,
+	}
+}
+
+// Kind возвращает block или transaction
+func (c *BlockchainClassifier) Kind(message *models.GossipMessage) string {
+	payload, err := blockchain.PayloadOf(message)
+	if err != nil {
+		return ""
+	}
+	if payload.Block != nil {
+		return KindBlock
+	}
+	return KindTransaction
+}
+
+// Settled сообщает, что транзакция вошла в основную цепочку или истекла
+func (c *BlockchainClassifier) Settled(message *models.GossipMessage) bool {
+	payload, err := blockchain.PayloadOf(message)
+	if err != nil || payload.Transaction == nil {
+		return false
+	}
+	tx := *payload.Transaction
+
+	hash, err := blockchain.TransactionHash(tx)
+	if err != nil || c.mempool.Has(hash) {
+		return false
+	}
+	if user, err := c.ledger.FetchUser(tx.From); err == nil && user.Nonce >= tx.Nonce {
+		return true
+	}
+	return c.txTTL > 0 && time.Since(message.Timestamp) > c.txTTL
+}
@@ -0,0 +1,364 @@
+// Package retention удаляет из хранилища сообщения, которые узлу больше
+// не нужно хранить. Правила задаются по типу сообщения и, если для типа
+// есть Classifier, по виду сообщения внутри типа (например, блок или
+// транзакция). Удаленные сообщения остаются известными хранилищу, поэтому
+// пиры не присылают их снова, пока сообщения не станут старше срока,
+// после которого пиры их не рассылают (см. SetTombstoneAge).
+package retention
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"concoin/conrun/pkg/config"
+	"concoin/conrun/pkg/interfaces"
+	"concoin/conrun/pkg/kv"
+	"concoin/conrun/pkg/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// scanBatch число сообщений, читаемых из хранилища за раз
+const scanBatch = 256
+
+// DefaultPolicy ключ правила по умолчанию в статистике
+const DefaultPolicy = "default"
+
+// Classifier разбирает сообщения одного типа
+type Classifier interface {
+	// Kind возвращает вид сообщения, пустая строка - вид неизвестен
+	Kind(message *models.GossipMessage) string
+	// Settled сообщает, что сообщение больше не нужно узлу
+	Settled(message *models.GossipMessage) bool
+}
+
+// Stats итоги последней очистки
+type Stats struct {
+	Enabled      bool              `json:"enabled"`
+	Interval     string            `json:"interval"`
+	Policies     map[string]string `json:"policies"` // действующие правила, включая default
+	LastRun      time.Time         `json:"last_run"`
+	Duration     string            `json:"duration"`
+	Scanned      int               `json:"scanned"`
+	Deleted      map[string]int    `json:"deleted"` // удалено по ключам правил
+	Expired      int               `json:"expired"` // забыто ID удаленных сообщений
+	Compacted    bool              `json:"compacted"`
+	TotalDeleted int               `json:"total_deleted"` // удалено с запуска узла
+	Error        string            `json:"error,omitempty"`
+	Storage      *kv.Stats         `json:"storage,omitempty"` // для хранилища kv
+}
+
+// Manager периодически удаляет сообщения по правилам хранения
+type Manager struct {
+	storage     interfaces.StorageInterface
+	logger      *logrus.Logger
+	config      config.RetentionConfig
+	rules       map[string]config.RetentionRule
+	fallback    config.RetentionRule
+	classifiers map[string]Classifier
+	tombstones  time.Duration // сколько помнить ID удаленных сообщений, 0 - всегда
+	stats       Stats
+	mutex       sync.Mutex
+	runMutex    sync.Mutex // очистки не идут одновременно
+	wake        chan struct{}
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+}
+
+// NewManager создает менеджер хранения сообщений storage
+func NewManager(cfg config.RetentionConfig, storage interfaces.StorageInterface, logger *logrus.Logger) *Manager {
+	m := &Manager{
+		storage:     storage,
+		logger:      logger,
+		classifiers: make(map[string]Classifier),
+		wake:        make(chan struct{}, 1),
+	}
+	m.SetConfig(cfg)
+	return m
+}
+
+// SetClassifier задает разбор сообщений типа messageType
+func (m *Manager) SetClassifier(messageType string, classifier Classifier) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.classifiers[messageType] = classifier
+}
+
+// SetTombstoneAge задает, сколько хранилище помнит ID удаленных сообщений.
+// Сообщения старше gossip.message_max_age пиры не рассылают, поэтому
+// дольше помнить их не нужно. 0 - помнить всегда.
+func (m *Manager) SetTombstoneAge(age time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.tombstones = age
+}
+
+// SetConfig заменяет правила хранения и период очистки. Неверные правила
+// заменяются на keep: конфигурация проверяется при загрузке, поэтому
+// это возможно только при создании менеджера из кода.
+func (m *Manager) SetConfig(cfg config.RetentionConfig) {
+	rules := make(map[string]config.RetentionRule, len(cfg.Policies))
+	for key, value := range cfg.Policies {
+		rule, err := config.ParseRetentionRule(value)
+		if err != nil {
+			m.logger.Warnf("Retention policy %s ignored: %v", key, err)
+			rule = config.RetentionRule{Keep: true}
+		}
+		rules[key] = rule
+	}
+	fallback, err := config.ParseRetentionRule(cfg.Default)
+	if err != nil {
+		m.logger.Warnf("Default retention policy ignored: %v", err)
+		fallback = config.RetentionRule{Keep: true}
+	}
+
+	m.mutex.Lock()
+	m.config = cfg
+	m.rules = rules
+	m.fallback = fallback
+	m.mutex.Unlock()
+
+	// Цикл очистки заново отсчитывает период
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start запускает периодическую очистку. Хранилища, которые не умеют
+// удалять сообщения, не очищаются.
+func (m *Manager) Start(ctx context.Context) error {
+	if _, ok := m.storage.(interfaces.MessagePruner); !ok {
+		m.logger.Warn("Storage cannot delete messages, retention is disabled")
+		return nil
+	}
+	m.logger.Info("Starting message retention")
+
+	ctx, m.cancel = context.WithCancel(ctx)
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		for {
+			// Таймер создается заново, чтобы новый период из SetConfig
+			// применялся сразу
+			var timer *time.Timer
+			var fire <-chan time.Time
+			if interval := m.interval(); interval > 0 {
+				timer = time.NewTimer(interval)
+				fire = timer.C
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-m.wake:
+			case <-fire:
+				if _, err := m.Run(ctx); err != nil && ctx.Err() == nil {
+					m.logger.Warnf("Retention failed: %v", err)
+				}
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop останавливает очистку и дожидается завершения текущего прохода
+func (m *Manager) Stop() {
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.wg.Wait()
+}
+
+// interval возвращает текущий период очистки
+func (m *Manager) interval() time.Duration {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.config.Interval
+}
+
+// Run проходит по всем сообщениям хранилища и удаляет те, которые
+// не нужно хранить по правилам, забывает ID давно удаленных сообщений,
+// затем сжимает хранилище
+func (m *Manager) Run(ctx context.Context) (Stats, error) {
+	pruner, ok := m.storage.(interfaces.MessagePruner)
+	if !ok {
+		return m.Stats(), fmt.Errorf("storage cannot delete messages")
+	}
+
+	m.runMutex.Lock()
+	defer m.runMutex.Unlock()
+
+	started := time.Now()
+	scanned, deleted, err := m.prune(ctx, pruner)
+	var expired int
+	if err == nil {
+		expired, err = m.expire(pruner, started)
+	}
+	var compacted bool
+	if err == nil && (len(deleted) > 0 || expired > 0) {
+		if compactor, ok := m.storage.(interfaces.Compactor); ok {
+			if err = compactor.Compact(); err == nil {
+				compacted = true
+			}
+		}
+	}
+
+	m.mutex.Lock()
+	m.stats.LastRun = started
+	m.stats.Duration = time.Since(started).Round(time.Millisecond).String()
+	m.stats.Scanned = scanned
+	m.stats.Deleted = deleted
+	m.stats.Expired = expired
+	m.stats.Compacted = compacted
+	m.stats.Error = ""
+	if err != nil {
+		m.stats.Error = err.Error()
+	}
+	for _, count := range deleted {
+		m.stats.TotalDeleted += count
+	}
+	m.mutex.Unlock()
+
+	if total := sum(deleted); total > 0 {
+		m.logger.Infof("Retention deleted %d of %d messages in %s", total, scanned, time.Since(started).Round(time.Millisecond))
+	}
+	return m.Stats(), err
+}
+
+// prune удаляет ненужные сообщения пачками по мере чтения хранилища
+func (m *Manager) prune(ctx context.Context, pruner interfaces.MessagePruner) (int, map[string]int, error) {
+	now := time.Now()
+	scanned := 0
+	deleted := make(map[string]int)
+	var pending, pendingKeys []string
+
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		if err := pruner.DeleteMessages(pending); err != nil {
+			return err
+		}
+		for _, key := range pendingKeys {
+			deleted[key]++
+		}
+		pending, pendingKeys = nil, nil
+		return nil
+	}
+
+	var seq uint64
+	for {
+		if err := ctx.Err(); err != nil {
+			return scanned, deleted, err
+		}
+		messages, next, head, err := m.storage.GetMessagesSince(seq, scanBatch)
+		if err != nil {
+			return scanned, deleted, fmt.Errorf("failed to read messages: %w", err)
+		}
+		for _, message := range messages {
+			scanned++
+			if key, expired := m.check(message, now); expired {
+				pending = append(pending, message.MessageID)
+				pendingKeys = append(pendingKeys, key)
+			}
+		}
+		if len(pending) >= scanBatch {
+			if err := flush(); err != nil {
+				return scanned, deleted, err
+			}
+		}
+		seq = next
+		if len(messages) == 0 || seq >= head {
+			break
+		}
+	}
+
+	return scanned, deleted, flush()
+}
+
+// expire забывает ID сообщений, удаленных раньше срока хранения ID
+func (m *Manager) expire(pruner interfaces.MessagePruner, now time.Time) (int, error) {
+	m.mutex.Lock()
+	age := m.tombstones
+	m.mutex.Unlock()
+
+	if age <= 0 {
+		return 0, nil
+	}
+	expired, err := pruner.ExpirePruned(now.Add(-age))
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire deleted messages: %w", err)
+	}
+	return expired, nil
+}
+
+// check находит правило для сообщения и решает, удалять ли его.
+// Правило ищется по ключу тип/вид, затем по типу, иначе берется default.
+func (m *Manager) check(message *models.GossipMessage, now time.Time) (string, bool) {
+	m.mutex.Lock()
+	classifier := m.classifiers[message.MessageType]
+	key, rule := DefaultPolicy, m.fallback
+	var kind string
+	if classifier != nil {
+		kind = classifier.Kind(message)
+	}
+	if r, ok := m.rules[message.MessageType+"/"+kind]; ok && kind != "" {
+		key, rule = message.MessageType+"/"+kind, r
+	} else if r, ok := m.rules[message.MessageType]; ok {
+		key, rule = message.MessageType, r
+	}
+	m.mutex.Unlock()
+
+	if rule.Keep {
+		return key, false
+	}
+	if rule.MaxAge > 0 && now.Sub(message.Timestamp) > rule.MaxAge {
+		return key, true
+	}
+	if rule.Settled && classifier != nil && classifier.Settled(message) {
+		return key, true
+	}
+	return key, false
+}
+
+// Stats возвращает действующие правила и итоги последней очистки
+func (m *Manager) Stats() Stats {
+	m.mutex.Lock()
+	stats := m.stats
+	_, stats.Enabled = m.storage.(interfaces.MessagePruner)
+	stats.Enabled = stats.Enabled && m.config.Interval > 0
+	stats.Interval = m.config.Interval.String()
+	stats.Policies = make(map[string]string, len(m.rules)+1)
+	for key, rule := range m.rules {
+		stats.Policies[key] = rule.String()
+	}
+	stats.Policies[DefaultPolicy] = m.fallback.String()
+	stats.Deleted = make(map[string]int, len(m.stats.Deleted))
+	for key, count := range m.stats.Deleted {
+		stats.Deleted[key] = count
+	}
+	m.mutex.Unlock()
+
+	if store, ok := m.storage.(interface{ Stats() kv.Stats }); ok {
+		storageStats := store.Stats()
+		stats.Storage = &storageStats
+	}
+	return stats
+}
+
+func sum(counts map[string]int) int {
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	return total
+}
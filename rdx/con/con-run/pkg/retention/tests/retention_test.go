@@ -0,0 +1,253 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"concoin/conrun/pkg/blockchain"
+	"concoin/conrun/pkg/config"
+	"concoin/conrun/pkg/interfaces"
+	"concoin/conrun/pkg/mempool"
+	"concoin/conrun/pkg/models"
+	"concoin/conrun/pkg/retention"
+	"concoin/conrun/pkg/storage"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fakeClassifier берет вид и признак settled из полей содержимого
+type fakeClassifier struct{}
+
+func (fakeClassifier) Kind(message *models.GossipMessage) string {
+	kind, _ := message.Payload.(map[string]interface{})["kind"].(string)
+	return kind
+}
+
+func (fakeClassifier) Settled(message *models.GossipMessage) bool {
+	settled, _ := message.Payload.(map[string]interface{})["settled"].(bool)
+	return settled
+}
+
+// fakeMempool содержит только заданные транзакции
+type fakeMempool struct {
+	hashes map[blockchain.Hash]bool
+}
+
+func (m *fakeMempool) Check(tx blockchain.Transaction) error { return nil }
+func (m *fakeMempool) Add(tx blockchain.Transaction) (blockchain.Hash, error) {
+	return blockchain.TransactionHash(tx)
+}
+func (m *fakeMempool) Has(hash blockchain.Hash) bool           { return m.hashes[hash] }
+func (m *fakeMempool) Entries() []mempool.Entry                { return nil }
+func (m *fakeMempool) Pick(limit int) []blockchain.Transaction { return nil }
+func (m *fakeMempool) Size() int                               { return len(m.hashes) }
+
+func newLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return logger
+}
+
+func save(t *testing.T, store interfaces.StorageInterface, id, messageType string, age time.Duration, payload map[string]interface{}) {
+	message := &models.GossipMessage{
+		MessageID:   id,
+		Timestamp:   time.Now().Add(-age).UTC(),
+		MessageType: messageType,
+		Payload:     payload,
+	}
+	if err := store.SaveMessage(message); err != nil {
+		t.Fatalf("Failed to save message: %v", err)
+	}
+}
+
+func testConfig() config.RetentionConfig {
+	return config.RetentionConfig{
+		Interval: time.Hour,
+		Default:  "keep",
+		Policies: map[string]string{
+			"chain/block":  "keep",
+			"chain/tx":     "settled",
+			"user_message": "1h",
+		},
+	}
+}
+
+func TestManager_Run(t *testing.T) {
+	store := storage.NewStorage(t.TempDir())
+	save(t, store, "block", "chain", 48*time.Hour, map[string]interface{}{"kind": "block", "settled": true})
+	save(t, store, "tx-settled", "chain", 0, map[string]interface{}{"kind": "tx", "settled": true})
+	save(t, store, "tx-pending", "chain", 48*time.Hour, map[string]interface{}{"kind": "tx"})
+	save(t, store, "user-old", "user_message", 2*time.Hour, map[string]interface{}{})
+	save(t, store, "user-new", "user_message", 0, map[string]interface{}{})
+	save(t, store, "other-old", "other", 48*time.Hour, map[string]interface{}{})
+	// A kind without its own rule falls back to the rule of the type, then to the default
+	save(t, store, "chain-unknown", "chain", 48*time.Hour, map[string]interface{}{"kind": "vote", "settled": true})
+
+	manager := retention.NewManager(testConfig(), store, newLogger())
+	manager.SetClassifier("chain", fakeClassifier{})
+
+	stats, err := manager.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to run retention: %v", err)
+	}
+	if stats.Scanned != 7 || stats.TotalDeleted != 2 {
+		t.Errorf("Expected 7 scanned and 2 deleted, got %+v", stats)
+	}
+	if fmt.Sprint(stats.Deleted) != "map[chain/tx:1 user_message:1]" {
+		t.Errorf("Unexpected deletions by policy %v", stats.Deleted)
+	}
+	if stats.Policies["default"] != "keep" || stats.Policies["user_message"] != "1h0m0s" {
+		t.Errorf("Unexpected policies %v", stats.Policies)
+	}
+	if !stats.Enabled || stats.LastRun.IsZero() {
+		t.Errorf("Expected an enabled manager with a finished run, got %+v", stats)
+	}
+
+	messageIDs, _ := store.GetMessageList()
+	if fmt.Sprint(messageIDs) != "[block chain-unknown other-old tx-pending user-new]" {
+		t.Errorf("Unexpected messages after retention %v", messageIDs)
+	}
+	// Deleted messages stay known so that peers do not send them again
+	if !store.HasMessage("tx-settled") {
+		t.Errorf("Expected deleted messages to stay known")
+	}
+
+	// Nothing is left to delete, the total is kept
+	stats, _ = manager.Run(context.Background())
+	if stats.Scanned != 5 || len(stats.Deleted) != 0 || stats.TotalDeleted != 2 {
+		t.Errorf("Expected nothing to delete on the second run, got %+v", stats)
+	}
+
+	// New rules apply to the next run
+	cfg := testConfig()
+	cfg.Default = "24h"
+	manager.SetConfig(cfg)
+	stats, _ = manager.Run(context.Background())
+	if fmt.Sprint(stats.Deleted) != "map[default:2]" {
+		t.Errorf("Expected the default rule to delete other-old and chain-unknown, got %v", stats.Deleted)
+	}
+	if stats.Expired != 0 || !store.HasMessage("tx-settled") {
+		t.Errorf("Expected deleted messages to be remembered without a tombstone age, got %+v", stats)
+	}
+
+	// IDs of deleted messages are forgotten after the tombstone age
+	manager.SetTombstoneAge(time.Nanosecond)
+	stats, _ = manager.Run(context.Background())
+	if stats.Expired != 4 || len(stats.Deleted) != 0 {
+		t.Errorf("Expected 4 forgotten IDs, got %+v", stats)
+	}
+	if store.HasMessage("tx-settled") {
+		t.Errorf("Expected tx-settled to be forgotten")
+	}
+}
+
+func TestManager_Background(t *testing.T) {
+	store, err := storage.NewKVStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer store.Close()
+	save(t, store, "user-old", "user_message", 2*time.Hour, map[string]interface{}{})
+
+	cfg := testConfig()
+	cfg.Interval = 0
+	manager := retention.NewManager(cfg, store, newLogger())
+	if err := manager.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start retention: %v", err)
+	}
+	defer manager.Stop()
+
+	// With a zero interval nothing runs until the interval is set
+	time.Sleep(50 * time.Millisecond)
+	if stats := manager.Stats(); stats.Enabled || !stats.LastRun.IsZero() {
+		t.Fatalf("Expected retention to be disabled, got %+v", stats)
+	}
+
+	cfg.Interval = 10 * time.Millisecond
+	manager.SetConfig(cfg)
+	deadline := time.Now().Add(2 * time.Second)
+	for manager.Stats().LastRun.IsZero() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected retention to run in the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Later runs have nothing to delete, so the total stays the same
+	stats := manager.Stats()
+	if stats.TotalDeleted != 1 || stats.Storage == nil || stats.Storage.Segments != 1 || stats.Storage.MemtableKeys != 0 {
+		t.Errorf("Expected one deletion and a compacted kv storage, got %+v", stats)
+	}
+	if _, err := store.GetMessage("user-old"); err == nil {
+		t.Errorf("Expected user-old to be deleted")
+	}
+}
+
+func TestManager_Unsupported(t *testing.T) {
+	store, err := storage.NewStorageBackend(storage.BackendBrix, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	// Storages that cannot delete messages are left alone
+	manager := retention.NewManager(testConfig(), store, newLogger())
+	if err := manager.Start(context.Background()); err != nil {
+		t.Fatalf("Expected start to succeed, got %v", err)
+	}
+	manager.Stop()
+	if _, err := manager.Run(context.Background()); err == nil {
+		t.Errorf("Expected run to fail")
+	}
+	if manager.Stats().Enabled {
+		t.Errorf("Expected retention to be disabled")
+	}
+}
+
+func TestBlockchainClassifier(t *testing.T) {
+	state := blockchain.NewState()
+	state.Balances["Alice"] = 50
+	state.PublicKeys["Alice"] = "alice-key"
+	state.Nonces["Alice"] = 2
+
+	pending := blockchain.Transaction{From: "Alice", To: "Bob", Amount: 1, Nonce: 3}
+	pendingHash, _ := blockchain.TransactionHash(pending)
+	pool := &fakeMempool{hashes: map[blockchain.Hash]bool{pendingHash: true}}
+	classifier := retention.NewBlockchainClassifier(state, pool, time.Hour)
+
+	message := func(payload interface{}, age time.Duration) *models.GossipMessage {
+		return &models.GossipMessage{
+			MessageType: blockchain.MessageType,
+			Timestamp:   time.Now().Add(-age),
+			Payload:     payload,
+		}
+	}
+
+	block := message(blockchain.BlockPayload(&blockchain.Block{}), 48*time.Hour)
+	if classifier.Kind(block) != retention.KindBlock || classifier.Settled(block) {
+		t.Errorf("Expected a block that is never settled")
+	}
+
+	tests := []struct {
+		name    string
+		tx      blockchain.Transaction
+		age     time.Duration
+		settled bool
+	}{
+		{"included", blockchain.Transaction{From: "Alice", To: "Bob", Amount: 1, Nonce: 2}, 0, true},
+		{"pending", pending, 48 * time.Hour, false},
+		{"waiting", blockchain.Transaction{From: "Alice", To: "Bob", Amount: 2, Nonce: 3}, 0, false},
+		{"expired", blockchain.Transaction{From: "Alice", To: "Bob", Amount: 2, Nonce: 3}, 2 * time.Hour, true},
+		{"unknown sender", blockchain.Transaction{From: "Carol", To: "Bob", Amount: 1, Nonce: 1}, 0, false},
+	}
+	for _, test := range tests {
+		tx := message(test.tx, test.age)
+		if kind := classifier.Kind(tx); kind != retention.KindTransaction {
+			t.Errorf("%s: expected a transaction, got %q", test.name, kind)
+		}
+		if settled := classifier.Settled(tx); settled != test.settled {
+			t.Errorf("%s: expected settled %v, got %v", test.name, test.settled, settled)
+		}
+	}
+}
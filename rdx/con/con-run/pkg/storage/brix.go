@@ -0,0 +1,413 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"concoin/brix"
+	"concoin/conrun/pkg/interfaces"
+	"concoin/conrun/pkg/models"
+)
+
+// Бэкенды хранилища
+const (
+	BackendJSON = "json"
+	BackendBrix = "brix"
+)
+
+// brixMaxDepth число файлов в стеке, после которого он сливается в один файл
+const brixMaxDepth = 32
+
+// Источники RDX id: сообщения и пиры лежат в одном стеке
+const (
+	messageSrc uint64 = 0x1
+	peerSrc    uint64 = 0x2
+	cursorSrc  uint64 = 0x3
+	banSrc     uint64 = 0x4
+)
+
+// BrixStorage хранит сообщения и пиров в стеке RDX SST файлов (brik)
+// в директории dataDir/brix. Каждая запись дописывает новый файл,
+// ссылающийся на предыдущий по хешу.
+type BrixStorage struct {
+	stack    *brix.Stack
+	messages map[string]brix.ID // индекс сохраненных сообщений
+	order    []string           // ID сообщений в порядке сохранения, номер = индекс + 1
+	mutex    sync.RWMutex
+}
+
+// NewStorageBackend создает хранилище выбранного типа
+func NewStorageBackend(backend string, dataDir string) (interfaces.StorageInterface, error) {
+	switch backend {
+	case "", BackendJSON:
+		return NewStorage(dataDir), nil
+	case BackendBrix:
+		return NewBrixStorage(dataDir)
+	case BackendKV:
+		return NewKVStorage(dataDir)
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", backend)
+}
+
+// NewBrixStorage открывает стек brik файлов узла
+func NewBrixStorage(dataDir string) (*BrixStorage, error) {
+	dir := filepath.Join(dataDir, "brix")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create brix directory: %w", err)
+	}
+
+	stack, err := brix.OpenStack(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open brix stack: %w", err)
+	}
+
+	s := &BrixStorage{
+		stack:    stack,
+		messages: make(map[string]brix.ID),
+	}
+
+	seqs := make(map[string]int64)
+	for _, record := range stack.Scan() {
+		if record.ID.Src != messageSrc {
+			continue
+		}
+		key, _, err := decodeEntry(record.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read message %s: %w", record.ID, err)
+		}
+		s.messages[key] = record.ID
+		s.order = append(s.order, key)
+		seqs[key] = messageSeq(record.Value)
+	}
+
+	// Сообщения без номера (сохраненные до появления номеров) идут после остальных
+	sort.SliceStable(s.order, func(i, j int) bool {
+		a, b := seqs[s.order[i]], seqs[s.order[j]]
+		if a == 0 || b == 0 {
+			return a != 0 && b == 0
+		}
+		return a < b
+	})
+
+	var numbered []brix.Record
+	for i, key := range s.order {
+		if seqs[key] != int64(i+1) {
+			numbered = append(numbered, brix.Record{
+				ID:    s.messages[key],
+				Value: brix.Map(map[string]brix.Value{"seq": brix.Int(int64(i + 1))}),
+			})
+		}
+	}
+	if len(numbered) > 0 {
+		if _, err := stack.Append(numbered); err != nil {
+			return nil, fmt.Errorf("failed to number messages: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+// SavePeer сохраняет информацию о пире
+func (s *BrixStorage) SavePeer(peer *models.Peer) error {
+	data, err := json.Marshal(peer)
+	if err != nil {
+		return fmt.Errorf("failed to marshal peer: %w", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.put(recordID(peerSrc, peer.NodeID), peer.NodeID, encodeEntry(peer.NodeID, data))
+}
+
+// GetPeers получает всех известных пиров
+func (s *BrixStorage) GetPeers() ([]*models.Peer, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var peers []*models.Peer
+	for _, record := range s.stack.Scan() {
+		if record.ID.Src != peerSrc {
+			continue
+		}
+		_, data, err := decodeEntry(record.Value)
+		if err != nil {
+			continue
+		}
+
+		var peer models.Peer
+		if err := json.Unmarshal(data, &peer); err != nil {
+			continue
+		}
+		peers = append(peers, &peer)
+	}
+
+	return peers, nil
+}
+
+// SaveMessage сохраняет сообщение в хранилище
+func (s *BrixStorage) SaveMessage(message *models.GossipMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	id := recordID(messageSrc, message.MessageID)
+	value := encodeEntry(message.MessageID, data)
+	_, exists := s.messages[message.MessageID]
+	if !exists {
+		// Номер дописывается в ту же map, повторное сохранение его не меняет
+		value = brix.Merge(value, brix.Map(map[string]brix.Value{"seq": brix.Int(int64(len(s.order) + 1))}))
+	}
+	if err := s.put(id, message.MessageID, value); err != nil {
+		return err
+	}
+	if !exists {
+		s.messages[message.MessageID] = id
+		s.order = append(s.order, message.MessageID)
+	}
+	return nil
+}
+
+// GetMessage получает сообщение по ID
+func (s *BrixStorage) GetMessage(messageID string) (*models.GossipMessage, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	id, ok := s.messages[messageID]
+	if !ok {
+		return nil, fmt.Errorf("message %s not found", messageID)
+	}
+	value, ok := s.stack.Get(id)
+	if !ok {
+		return nil, fmt.Errorf("message %s not found", messageID)
+	}
+	_, data, err := decodeEntry(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+
+	var message models.GossipMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+
+	return &message, nil
+}
+
+// GetMessageList получает список всех сообщений
+func (s *BrixStorage) GetMessageList() ([]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	messageIDs := make([]string, 0, len(s.messages))
+	for messageID := range s.messages {
+		messageIDs = append(messageIDs, messageID)
+	}
+	sort.Strings(messageIDs)
+
+	return messageIDs, nil
+}
+
+// HasMessage проверяет наличие сообщения
+func (s *BrixStorage) HasMessage(messageID string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	_, ok := s.messages[messageID]
+	return ok
+}
+
+// GetMessagesSince возвращает до limit сообщений, сохраненных после сообщения с номером seq,
+// номер последнего из них (или seq, если новых нет) и номер последнего сохраненного сообщения
+func (s *BrixStorage) GetMessagesSince(seq uint64, limit int) ([]*models.GossipMessage, uint64, uint64, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	head := uint64(len(s.order))
+	var messages []*models.GossipMessage
+	for seq < head && len(messages) < limit {
+		messageID := s.order[seq]
+		seq++
+
+		value, ok := s.stack.Get(s.messages[messageID])
+		if !ok {
+			return nil, 0, 0, fmt.Errorf("message %s not found", messageID)
+		}
+		_, data, err := decodeEntry(value)
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("failed to read message: %w", err)
+		}
+		var message models.GossipMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			return nil, 0, 0, fmt.Errorf("failed to unmarshal message: %w", err)
+		}
+		messages = append(messages, &message)
+	}
+
+	return messages, seq, head, nil
+}
+
+// GetSyncCursor возвращает номер последнего сообщения, полученного от узла при синхронизации
+func (s *BrixStorage) GetSyncCursor(nodeID string) (uint64, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	value, ok := s.stack.Get(recordID(cursorSrc, nodeID))
+	if !ok {
+		return 0, nil
+	}
+	entries, err := value.Map()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read sync cursor: %w", err)
+	}
+	cursor, err := entries["cursor"].Int()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read sync cursor: %w", err)
+	}
+	return uint64(cursor), nil
+}
+
+// SaveSyncCursor сохраняет номер последнего сообщения, полученного от узла при синхронизации
+func (s *BrixStorage) SaveSyncCursor(nodeID string, cursor uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.put(recordID(cursorSrc, nodeID), nodeID, brix.Map(map[string]brix.Value{
+		"key":    brix.String(nodeID),
+		"cursor": brix.Int(int64(cursor)),
+	}))
+}
+
+// SaveBan сохраняет бан пира, заменяя предыдущий бан того же пира
+func (s *BrixStorage) SaveBan(ban *models.Ban) error {
+	data, err := json.Marshal(ban)
+	if err != nil {
+		return fmt.Errorf("failed to marshal ban: %w", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.put(recordID(banSrc, ban.NodeID), ban.NodeID, encodeEntry(ban.NodeID, data))
+}
+
+// GetBans возвращает все сохраненные баны, в том числе истекшие
+func (s *BrixStorage) GetBans() ([]*models.Ban, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var bans []*models.Ban
+	for _, record := range s.stack.Scan() {
+		if record.ID.Src != banSrc {
+			continue
+		}
+		_, data, err := decodeEntry(record.Value)
+		if err != nil {
+			continue
+		}
+
+		var ban models.Ban
+		if err := json.Unmarshal(data, &ban); err != nil {
+			continue
+		}
+		bans = append(bans, &ban)
+	}
+
+	return bans, nil
+}
+
+// Close дожидается завершения начатых записей и сливает стек в один файл,
+// чтобы следующий запуск читал меньше файлов
+func (s *BrixStorage) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.stack.Depth() > 1 {
+		if _, err := s.stack.Compact(); err != nil {
+			return fmt.Errorf("failed to compact brix stack: %w", err)
+		}
+	}
+	return nil
+}
+
+// Head возвращает хеш последнего файла стека
+func (s *BrixStorage) Head() *brix.Hash {
+	return s.stack.Head()
+}
+
+// put дописывает запись в стек и сливает стек, если он стал слишком глубоким
+func (s *BrixStorage) put(id brix.ID, key string, value brix.Value) error {
+	if stored, ok := s.stack.Get(id); ok {
+		if entries, err := stored.Map(); err == nil {
+			if storedKey, err := entries["key"].Str(); err == nil && storedKey != key {
+				return fmt.Errorf("brix id %s of %q collides with %q", id, key, storedKey)
+			}
+		}
+	}
+
+	if _, err := s.stack.Append([]brix.Record{{ID: id, Value: value}}); err != nil {
+		return fmt.Errorf("failed to append to brix stack: %w", err)
+	}
+
+	if s.stack.Depth() > brixMaxDepth {
+		if _, err := s.stack.Compact(); err != nil {
+			return fmt.Errorf("failed to compact brix stack: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// recordID выводит RDX id записи из строкового ключа
+func recordID(src uint64, key string) brix.ID {
+	sum := sha256.Sum256([]byte(key))
+	return brix.ID{Src: src, Seq: binary.BigEndian.Uint64(sum[:8])}
+}
+
+// encodeEntry упаковывает ключ и JSON записи в RDX map
+func encodeEntry(key string, data []byte) brix.Value {
+	return brix.Map(map[string]brix.Value{
+		"key":  brix.String(key),
+		"json": brix.String(string(data)),
+	})
+}
+
+// decodeEntry распаковывает запись, сохраненную encodeEntry
+func decodeEntry(value brix.Value) (string, []byte, error) {
+	entries, err := value.Map()
+	if err != nil {
+		return "", nil, err
+	}
+	key, err := entries["key"].Str()
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := entries["json"].Str()
+	if err != nil {
+		return "", nil, err
+	}
+	return key, []byte(data), nil
+}
+
+// messageSeq возвращает номер сообщения, 0 если номера нет
+func messageSeq(value brix.Value) int64 {
+	entries, err := value.Map()
+	if err != nil {
+		return 0
+	}
+	seq, err := entries["seq"].Int()
+	if err != nil {
+		return 0
+	}
+	return seq
+}
@@ -0,0 +1,462 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"concoin/conrun/pkg/kv"
+	"concoin/conrun/pkg/models"
+)
+
+// BackendKV хранилище во встроенной базе ключ-значение
+const BackendKV = "kv"
+
+// Префиксы ключей базы. Индексы по типу и времени хранят пустые значения,
+// сообщение находится по ID в конце ключа.
+const (
+	kvMessagePrefix = "msg/"    // msg/<id> -> kvMessage
+	kvSeqPrefix     = "seq/"    // seq/<номер> -> id
+	kvTypePrefix    = "type/"   // type/<тип>\x00<время>\x00<id>
+	kvTimePrefix    = "time/"   // time/<время>\x00<id>
+	kvPeerPrefix    = "peer/"   // peer/<id> -> models.Peer
+	kvCursorPrefix  = "cursor/" // cursor/<id узла> -> номер
+	kvBanPrefix     = "ban/"    // ban/<id узла> -> models.Ban
+	kvPrunedPrefix  = "pruned/" // pruned/<id> -> номер и время удаления сообщения
+	kvHeadKey       = "meta/head"
+)
+
+// kvMessage сообщение вместе с его номером
+type kvMessage struct {
+	Seq     uint64                `json:"seq"`
+	Message *models.GossipMessage `json:"message"`
+}
+
+// KVStorage хранит данные узла во встроенной базе ключ-значение
+// в директории dataDir/kv. Сообщение и его индексы по номеру, типу
+// и времени записываются одним атомарным пакетом.
+type KVStorage struct {
+	db    *kv.DB
+	mutex sync.Mutex // упорядочивает присвоение номеров сообщениям
+	head  uint64
+}
+
+// NewKVStorage открывает базу узла
+func NewKVStorage(dataDir string) (*KVStorage, error) {
+	db, err := kv.Open(filepath.Join(dataDir, "kv"), kv.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open kv database: %w", err)
+	}
+
+	s := &KVStorage{db: db}
+	value, ok, err := db.Get(kvHeadKey)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to read message head: %w", err)
+	}
+	if ok {
+		if s.head, err = strconv.ParseUint(string(value), 10, 64); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to read message head: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// SavePeer сохраняет информацию о пире
+func (s *KVStorage) SavePeer(peer *models.Peer) error {
+	data, err := json.Marshal(peer)
+	if err != nil {
+		return fmt.Errorf("failed to marshal peer: %w", err)
+	}
+	return s.db.Put(kvPeerPrefix+peer.NodeID, data)
+}
+
+// GetPeers получает всех известных пиров
+func (s *KVStorage) GetPeers() ([]*models.Peer, error) {
+	var peers []*models.Peer
+	err := s.db.Range(kvPeerPrefix, prefixEnd(kvPeerPrefix), func(key string, value []byte) bool {
+		var peer models.Peer
+		if err := json.Unmarshal(value, &peer); err == nil {
+			peers = append(peers, &peer)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read peers: %w", err)
+	}
+	return peers, nil
+}
+
+// SaveMessage сохраняет сообщение и его индексы. Повторное сохранение
+// не меняет номер сообщения и заменяет записи индексов.
+func (s *KVStorage) SaveMessage(message *models.GossipMessage) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var batch kv.Batch
+	seq := s.head + 1
+	stored, err := s.getStored(message.MessageID)
+	if err != nil {
+		return err
+	}
+	if stored != nil {
+		seq = stored.Seq
+		batch.Delete(typeKey(stored.Message))
+		batch.Delete(timeKey(stored.Message))
+	} else {
+		batch.Put(seqKey(seq), []byte(message.MessageID))
+		batch.Put(kvHeadKey, []byte(strconv.FormatUint(seq, 10)))
+		if pruned, _ := s.db.Has(kvPrunedPrefix + message.MessageID); pruned {
+			batch.Delete(kvPrunedPrefix + message.MessageID)
+		}
+	}
+
+	data, err := json.Marshal(kvMessage{Seq: seq, Message: message})
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+	batch.Put(kvMessagePrefix+message.MessageID, data)
+	batch.Put(typeKey(message), nil)
+	batch.Put(timeKey(message), nil)
+
+	if err := s.db.Write(&batch); err != nil {
+		return fmt.Errorf("failed to save message: %w", err)
+	}
+	if stored == nil {
+		s.head = seq
+	}
+	return nil
+}
+
+// GetMessage получает сообщение по ID. Для отсутствующего сообщения
+// возвращается ошибка, для которой os.IsNotExist истинно.
+func (s *KVStorage) GetMessage(messageID string) (*models.GossipMessage, error) {
+	stored, err := s.getStored(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return nil, &os.PathError{Op: "get", Path: messageID, Err: os.ErrNotExist}
+	}
+	return stored.Message, nil
+}
+
+// GetMessageList получает список всех сообщений
+func (s *KVStorage) GetMessageList() ([]string, error) {
+	messageIDs := []string{}
+	err := s.db.Range(kvMessagePrefix, prefixEnd(kvMessagePrefix), func(key string, value []byte) bool {
+		messageIDs = append(messageIDs, key[len(kvMessagePrefix):])
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+	return messageIDs, nil
+}
+
+// HasMessage проверяет наличие сообщения по индексу в памяти. Удаленные
+// сообщения тоже считаются известными.
+func (s *KVStorage) HasMessage(messageID string) bool {
+	if ok, err := s.db.Has(kvMessagePrefix + messageID); err == nil && ok {
+		return true
+	}
+	ok, err := s.db.Has(kvPrunedPrefix + messageID)
+	return err == nil && ok
+}
+
+// GetMessagesSince возвращает до limit сообщений, сохраненных после сообщения с номером seq,
+// номер последнего из них (или seq, если новых нет) и номер последнего сохраненного сообщения
+func (s *KVStorage) GetMessagesSince(seq uint64, limit int) ([]*models.GossipMessage, uint64, uint64, error) {
+	s.mutex.Lock()
+	head := s.head
+	s.mutex.Unlock()
+
+	// Номера удаленных сообщений пропускаются, поэтому, если обход дошел
+	// до последнего сообщения, позиция сдвигается на head
+	var messageIDs []string
+	reachedHead := seq < head
+	err := s.db.Range(seqKey(seq+1), seqKey(head+1), func(key string, value []byte) bool {
+		if len(messageIDs) >= limit {
+			reachedHead = false
+			return false
+		}
+		next, err := strconv.ParseUint(key[len(kvSeqPrefix):], 10, 64)
+		if err != nil {
+			reachedHead = false
+			return false
+		}
+		messageIDs = append(messageIDs, string(value))
+		seq = next
+		return true
+	})
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to read message log: %w", err)
+	}
+	if reachedHead {
+		seq = head
+	}
+
+	messages, err := s.getMessages(messageIDs)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return messages, seq, head, nil
+}
+
+// DeleteMessages удаляет сообщения вместе с индексами одним пакетом
+// и запоминает их ID
+func (s *KVStorage) DeleteMessages(messageIDs []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var batch kv.Batch
+	for _, messageID := range messageIDs {
+		stored, err := s.getStored(messageID)
+		if err != nil {
+			return err
+		}
+		if stored == nil {
+			continue
+		}
+		batch.Delete(kvMessagePrefix + messageID)
+		batch.Delete(seqKey(stored.Seq))
+		batch.Delete(typeKey(stored.Message))
+		batch.Delete(timeKey(stored.Message))
+		batch.Put(kvPrunedPrefix+messageID, prunedValue(stored.Seq, time.Now()))
+	}
+	if err := s.db.Write(&batch); err != nil {
+		return fmt.Errorf("failed to delete messages: %w", err)
+	}
+	return nil
+}
+
+// PrunedMessages возвращает ID удаленных сообщений
+func (s *KVStorage) PrunedMessages() ([]string, error) {
+	messageIDs := []string{}
+	err := s.db.Range(kvPrunedPrefix, prefixEnd(kvPrunedPrefix), func(key string, value []byte) bool {
+		messageIDs = append(messageIDs, key[len(kvPrunedPrefix):])
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pruned messages: %w", err)
+	}
+	return messageIDs, nil
+}
+
+// ExpirePruned забывает ID сообщений, удаленных раньше before. Записям
+// без времени удаления (до появления срока хранения ID) время задается
+// сейчас, и они забываются через тот же срок.
+func (s *KVStorage) ExpirePruned(before time.Time) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var batch kv.Batch
+	expired := 0
+	now := time.Now()
+	err := s.db.Range(kvPrunedPrefix, prefixEnd(kvPrunedPrefix), func(key string, value []byte) bool {
+		seqText, deletedText, found := strings.Cut(string(value), " ")
+		if !found {
+			seq, _ := strconv.ParseUint(seqText, 10, 64)
+			batch.Put(key, prunedValue(seq, now))
+			return true
+		}
+		if unix, err := strconv.ParseInt(deletedText, 10, 64); err == nil && time.Unix(unix, 0).Before(before) {
+			batch.Delete(key)
+			expired++
+		}
+		return true
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list pruned messages: %w", err)
+	}
+	if err := s.db.Write(&batch); err != nil {
+		return 0, fmt.Errorf("failed to expire pruned messages: %w", err)
+	}
+	return expired, nil
+}
+
+// Compact сливает сегменты базы, освобождая место удаленных записей
+func (s *KVStorage) Compact() error {
+	return s.db.Compact()
+}
+
+// MessagesByType возвращает до limit сообщений типа messageType с временем
+// в [from, to) по возрастанию времени. Нулевое to не ограничивает выборку
+// сверху, limit <= 0 не ограничивает число сообщений.
+func (s *KVStorage) MessagesByType(messageType string, from, to time.Time, limit int) ([]*models.GossipMessage, error) {
+	prefix := kvTypePrefix + messageType + "\x00"
+	return s.queryIndex(prefix, from, to, limit)
+}
+
+// MessagesBetween возвращает до limit сообщений с временем в [from, to)
+// по возрастанию времени. Нулевое to не ограничивает выборку сверху,
+// limit <= 0 не ограничивает число сообщений.
+func (s *KVStorage) MessagesBetween(from, to time.Time, limit int) ([]*models.GossipMessage, error) {
+	return s.queryIndex(kvTimePrefix, from, to, limit)
+}
+
+// GetSyncCursor возвращает номер последнего сообщения, полученного от узла при синхронизации
+func (s *KVStorage) GetSyncCursor(nodeID string) (uint64, error) {
+	value, ok, err := s.db.Get(kvCursorPrefix + nodeID)
+	if err != nil || !ok {
+		return 0, err
+	}
+	cursor, err := strconv.ParseUint(string(value), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read sync cursor: %w", err)
+	}
+	return cursor, nil
+}
+
+// SaveSyncCursor сохраняет номер последнего сообщения, полученного от узла при синхронизации
+func (s *KVStorage) SaveSyncCursor(nodeID string, cursor uint64) error {
+	return s.db.Put(kvCursorPrefix+nodeID, []byte(strconv.FormatUint(cursor, 10)))
+}
+
+// SyncCursors возвращает позиции синхронизации со всеми узлами
+func (s *KVStorage) SyncCursors() map[string]uint64 {
+	cursors := make(map[string]uint64)
+	s.db.Range(kvCursorPrefix, prefixEnd(kvCursorPrefix), func(key string, value []byte) bool {
+		if cursor, err := strconv.ParseUint(string(value), 10, 64); err == nil {
+			cursors[key[len(kvCursorPrefix):]] = cursor
+		}
+		return true
+	})
+	return cursors
+}
+
+// SaveBan сохраняет бан пира, заменяя предыдущий бан того же пира
+func (s *KVStorage) SaveBan(ban *models.Ban) error {
+	data, err := json.Marshal(ban)
+	if err != nil {
+		return fmt.Errorf("failed to marshal ban: %w", err)
+	}
+	return s.db.Put(kvBanPrefix+ban.NodeID, data)
+}
+
+// GetBans возвращает все сохраненные баны, в том числе истекшие
+func (s *KVStorage) GetBans() ([]*models.Ban, error) {
+	var bans []*models.Ban
+	err := s.db.Range(kvBanPrefix, prefixEnd(kvBanPrefix), func(key string, value []byte) bool {
+		var ban models.Ban
+		if err := json.Unmarshal(value, &ban); err == nil {
+			bans = append(bans, &ban)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bans: %w", err)
+	}
+	return bans, nil
+}
+
+// Stats возвращает размер базы
+func (s *KVStorage) Stats() kv.Stats {
+	return s.db.Stats()
+}
+
+// Close сбрасывает записи из памяти на диск и закрывает базу
+func (s *KVStorage) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.db.Close()
+}
+
+// getStored читает сообщение с номером, nil если сообщения нет
+func (s *KVStorage) getStored(messageID string) (*kvMessage, error) {
+	value, ok, err := s.db.Get(kvMessagePrefix + messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message %s: %w", messageID, err)
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	var stored kvMessage
+	if err := json.Unmarshal(value, &stored); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+	return &stored, nil
+}
+
+// getMessages читает сообщения по списку ID
+func (s *KVStorage) getMessages(messageIDs []string) ([]*models.GossipMessage, error) {
+	messages := make([]*models.GossipMessage, 0, len(messageIDs))
+	for _, messageID := range messageIDs {
+		stored, err := s.getStored(messageID)
+		if err != nil {
+			return nil, err
+		}
+		if stored == nil {
+			return nil, fmt.Errorf("message %s not found", messageID)
+		}
+		messages = append(messages, stored.Message)
+	}
+	return messages, nil
+}
+
+// queryIndex обходит индекс по времени с префиксом prefix. ID сообщения
+// собираются во время обхода, а читаются после него, так как Range
+// не допускает обращений к базе из обработчика.
+func (s *KVStorage) queryIndex(prefix string, from, to time.Time, limit int) ([]*models.GossipMessage, error) {
+	start := prefix + timeString(from)
+	end := prefixEnd(prefix)
+	if !to.IsZero() {
+		end = prefix + timeString(to)
+	}
+
+	var messageIDs []string
+	err := s.db.Range(start, end, func(key string, value []byte) bool {
+		if limit > 0 && len(messageIDs) >= limit {
+			return false
+		}
+		rest := key[len(prefix):]
+		if i := len(timeString(time.Time{})); len(rest) > i {
+			messageIDs = append(messageIDs, rest[i+1:])
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages: %w", err)
+	}
+	return s.getMessages(messageIDs)
+}
+
+func seqKey(seq uint64) string {
+	return fmt.Sprintf("%s%020d", kvSeqPrefix, seq)
+}
+
+// prunedValue записывает номер удаленного сообщения и время удаления
+func prunedValue(seq uint64, deleted time.Time) []byte {
+	return []byte(strconv.FormatUint(seq, 10) + " " + strconv.FormatInt(deleted.Unix(), 10))
+}
+
+func typeKey(message *models.GossipMessage) string {
+	return kvTypePrefix + message.MessageType + "\x00" + timeString(message.Timestamp) + "\x00" + message.MessageID
+}
+
+func timeKey(message *models.GossipMessage) string {
+	return kvTimePrefix + timeString(message.Timestamp) + "\x00" + message.MessageID
+}
+
+// timeString записывает время так, чтобы строки сравнивались в порядке
+// времени. Время до 1970 года приравнивается к его началу.
+func timeString(t time.Time) string {
+	var nanos int64
+	if t.After(time.Unix(0, 0)) {
+		nanos = t.UnixNano()
+	}
+	return fmt.Sprintf("%019d", nanos)
+}
+
+// prefixEnd возвращает первый ключ после всех ключей с префиксом prefix
+func prefixEnd(prefix string) string {
+	return prefix[:len(prefix)-1] + string(prefix[len(prefix)-1]+1)
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"concoin/conrun/pkg/interfaces"
+)
+
+// migrateBatch число сообщений, читаемых из исходного хранилища за раз
+const migrateBatch = 256
+
+// MigrateStats число перенесенных записей
+type MigrateStats struct {
+	Messages int // перенесено сообщений
+	Skipped  int // сообщений, уже бывших в целевом хранилище
+	Peers    int
+	Cursors  int
+	Bans     int
+}
+
+// cursorLister хранилище, которое может перечислить позиции синхронизации
+type cursorLister interface {
+	SyncCursors() map[string]uint64
+}
+
+// Migrate копирует данные узла из from в to. Сообщения переносятся
+// в порядке их номеров, поэтому позиции синхронизации пиров остаются
+// верными. Сообщения, уже бывшие в to, пропускаются, так что прерванный
+// перенос можно повторить.
+func Migrate(from, to interfaces.StorageInterface) (MigrateStats, error) {
+	var stats MigrateStats
+
+	var seq uint64
+	for {
+		messages, next, head, err := from.GetMessagesSince(seq, migrateBatch)
+		if err != nil {
+			return stats, fmt.Errorf("failed to read messages: %w", err)
+		}
+		for _, message := range messages {
+			if to.HasMessage(message.MessageID) {
+				stats.Skipped++
+				continue
+			}
+			if err := to.SaveMessage(message); err != nil {
+				return stats, fmt.Errorf("failed to save message %s: %w", message.MessageID, err)
+			}
+			stats.Messages++
+		}
+		seq = next
+		if len(messages) == 0 || seq >= head {
+			break
+		}
+	}
+
+	peers, err := from.GetPeers()
+	if err != nil {
+		return stats, fmt.Errorf("failed to read peers: %w", err)
+	}
+	for _, peer := range peers {
+		if err := to.SavePeer(peer); err != nil {
+			return stats, fmt.Errorf("failed to save peer %s: %w", peer.NodeID, err)
+		}
+		stats.Peers++
+	}
+
+	// Позиции синхронизации перечисляются, если хранилище это умеет,
+	// иначе переносятся позиции известных пиров
+	cursors := make(map[string]uint64)
+	if lister, ok := from.(cursorLister); ok {
+		cursors = lister.SyncCursors()
+	} else {
+		for _, peer := range peers {
+			if cursor, err := from.GetSyncCursor(peer.NodeID); err == nil && cursor > 0 {
+				cursors[peer.NodeID] = cursor
+			}
+		}
+	}
+	for nodeID, cursor := range cursors {
+		if err := to.SaveSyncCursor(nodeID, cursor); err != nil {
+			return stats, fmt.Errorf("failed to save sync cursor of %s: %w", nodeID, err)
+		}
+		stats.Cursors++
+	}
+
+	bans, err := from.GetBans()
+	if err != nil {
+		return stats, fmt.Errorf("failed to read bans: %w", err)
+	}
+	for _, ban := range bans {
+		if err := to.SaveBan(ban); err != nil {
+			return stats, fmt.Errorf("failed to save ban of %s: %w", ban.NodeID, err)
+		}
+		stats.Bans++
+	}
+
+	return stats, nil
+}
+
+// HasJSONMessages проверяет, есть ли в dataDir сообщения json хранилища
+func HasJSONMessages(dataDir string) bool {
+	entries, err := os.ReadDir(filepath.Join(dataDir, "messages"))
+	return err == nil && len(entries) > 0
+}
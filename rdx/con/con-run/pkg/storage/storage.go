@@ -1,23 +1,43 @@
 package storage
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"concoin/conrun/pkg/models"
 )
 
+// logHeadPrefix начинает строку сжатого messages.log с номером последнего
+// сохраненного сообщения: после сжатия его может не быть в журнале
+const logHeadPrefix = "#head "
 
+// logEntry сообщение в журнале порядка сохранения
+type logEntry struct {
+	seq       uint64
+	messageID string
+}
 
 // Storage обеспечивает хранение данных узла
 type Storage struct {
 	dataDir       string
 	peersMutex    sync.RWMutex
 	messagesMutex sync.RWMutex
+	messageLog    []logEntry           // сообщения в порядке сохранения
+	messageSeq    map[string]uint64    // ID сообщения -> номер
+	messageHead   uint64               // номер последнего сохраненного сообщения
+	pruned        map[string]time.Time // ID удаленного сообщения -> время удаления
+	cursorsMutex  sync.RWMutex
+	syncCursors   map[string]uint64 // узел -> номер последнего полученного от него сообщения
+	bansMutex     sync.RWMutex
+	bans          map[string]*models.Ban
 }
 
 // NewStorage создает новый экземпляр хранилища
@@ -34,9 +54,20 @@ func NewStorage(dataDir string) *Storage {
 		// В MVP просто игнорируем ошибку
 	}
 
-	return &Storage{
-		dataDir: dataDir,
+	s := &Storage{
+		dataDir:     dataDir,
+		messageSeq:  make(map[string]uint64),
+		pruned:      make(map[string]time.Time),
+		syncCursors: make(map[string]uint64),
+		bans:        make(map[string]*models.Ban),
 	}
+	// В MVP просто игнорируем ошибки: журнал будет восстановлен из директории
+	s.loadMessageLog()
+	s.loadPruned()
+	s.loadSyncCursors()
+	s.loadBans()
+
+	return s
 }
 
 // SavePeer сохраняет информацию о пире
@@ -114,6 +145,13 @@ func (s *Storage) SaveMessage(message *models.GossipMessage) error {
 		return fmt.Errorf("failed to save message to file: %w", err)
 	}
 
+	if _, exists := s.messageSeq[message.MessageID]; !exists {
+		if err := s.appendMessageLog(message.MessageID); err != nil {
+			return fmt.Errorf("failed to append message log: %w", err)
+		}
+	}
+	delete(s.pruned, message.MessageID)
+
 	return nil
 }
 
@@ -168,7 +206,385 @@ func (s *Storage) HasMessage(messageID string) bool {
 	s.messagesMutex.RLock()
 	defer s.messagesMutex.RUnlock()
 
+	if _, pruned := s.pruned[messageID]; pruned {
+		return true
+	}
 	messagePath := filepath.Join(s.dataDir, "messages", fmt.Sprintf("%s.json", messageID))
 	_, err := os.Stat(messagePath)
 	return err == nil
 }
+
+// GetMessagesSince возвращает до limit сообщений, сохраненных после сообщения с номером seq,
+// номер последнего из них (или seq, если новых нет) и номер последнего сохраненного сообщения
+func (s *Storage) GetMessagesSince(seq uint64, limit int) ([]*models.GossipMessage, uint64, uint64, error) {
+	s.messagesMutex.RLock()
+	defer s.messagesMutex.RUnlock()
+
+	head := s.messageHead
+	i := sort.Search(len(s.messageLog), func(i int) bool {
+		return s.messageLog[i].seq > seq
+	})
+	var messages []*models.GossipMessage
+	for ; i < len(s.messageLog) && len(messages) < limit; i++ {
+		messageID := s.messageLog[i].messageID
+		seq = s.messageLog[i].seq
+
+		messageData, err := os.ReadFile(filepath.Join(s.dataDir, "messages", fmt.Sprintf("%s.json", messageID)))
+		if _, pruned := s.pruned[messageID]; os.IsNotExist(err) && pruned {
+			continue
+		}
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("failed to read message file: %w", err)
+		}
+		var message models.GossipMessage
+		if err := json.Unmarshal(messageData, &message); err != nil {
+			return nil, 0, 0, fmt.Errorf("failed to unmarshal message: %w", err)
+		}
+		messages = append(messages, &message)
+	}
+	// Удаленные сообщения в конце журнала пропускаются, чтобы позиция дошла до head
+	for ; i < len(s.messageLog); i++ {
+		if _, pruned := s.pruned[s.messageLog[i].messageID]; !pruned {
+			break
+		}
+		seq = s.messageLog[i].seq
+	}
+	// Номера забытых сообщений пропускаются
+	if i == len(s.messageLog) && seq < head {
+		seq = head
+	}
+
+	return messages, seq, head, nil
+}
+
+// DeleteMessages удаляет файлы сообщений и запоминает их ID и время
+// удаления в pruned.log
+func (s *Storage) DeleteMessages(messageIDs []string) error {
+	s.messagesMutex.Lock()
+	defer s.messagesMutex.Unlock()
+
+	file, err := os.OpenFile(filepath.Join(s.dataDir, "pruned.log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open pruned log: %w", err)
+	}
+	defer file.Close()
+
+	now := time.Now()
+	for _, messageID := range messageIDs {
+		if _, known := s.messageSeq[messageID]; !known {
+			continue
+		}
+		// ID записывается до удаления файла, чтобы сбой между ними не сделал сообщение неизвестным
+		if _, pruned := s.pruned[messageID]; !pruned {
+			if _, err := file.WriteString(prunedLine(messageID, now)); err != nil {
+				return fmt.Errorf("failed to append pruned log: %w", err)
+			}
+			s.pruned[messageID] = now
+		}
+		messagePath := filepath.Join(s.dataDir, "messages", fmt.Sprintf("%s.json", messageID))
+		if err := os.Remove(messagePath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete message file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// PrunedMessages возвращает ID удаленных сообщений
+func (s *Storage) PrunedMessages() ([]string, error) {
+	s.messagesMutex.RLock()
+	defer s.messagesMutex.RUnlock()
+
+	messageIDs := make([]string, 0, len(s.pruned))
+	for messageID := range s.pruned {
+		messageIDs = append(messageIDs, messageID)
+	}
+	sort.Strings(messageIDs)
+	return messageIDs, nil
+}
+
+// ExpirePruned забывает ID сообщений, удаленных раньше before, и сжимает
+// messages.log и pruned.log. Номера остальных сообщений не меняются.
+func (s *Storage) ExpirePruned(before time.Time) (int, error) {
+	s.messagesMutex.Lock()
+	defer s.messagesMutex.Unlock()
+
+	expired := 0
+	for messageID, deleted := range s.pruned {
+		if deleted.Before(before) {
+			delete(s.pruned, messageID)
+			delete(s.messageSeq, messageID)
+			expired++
+		}
+	}
+	if expired == 0 {
+		return 0, nil
+	}
+
+	kept := s.messageLog[:0]
+	for _, entry := range s.messageLog {
+		if _, known := s.messageSeq[entry.messageID]; known {
+			kept = append(kept, entry)
+		}
+	}
+	s.messageLog = kept
+
+	// Сначала сжимается журнал порядка: если сбой случится до сжатия
+	// pruned.log, забытые сообщения останутся удаленными
+	var messagesLog strings.Builder
+	messagesLog.WriteString(logHeadPrefix + strconv.FormatUint(s.messageHead, 10) + "\n")
+	for _, entry := range s.messageLog {
+		messagesLog.WriteString(entry.messageID + " " + strconv.FormatUint(entry.seq, 10) + "\n")
+	}
+	if err := s.replaceFile("messages.log", messagesLog.String()); err != nil {
+		return 0, fmt.Errorf("failed to compact message log: %w", err)
+	}
+
+	var prunedLog strings.Builder
+	for messageID, deleted := range s.pruned {
+		prunedLog.WriteString(prunedLine(messageID, deleted))
+	}
+	if err := s.replaceFile("pruned.log", prunedLog.String()); err != nil {
+		return 0, fmt.Errorf("failed to compact pruned log: %w", err)
+	}
+
+	return expired, nil
+}
+
+// GetSyncCursor возвращает номер последнего сообщения, полученного от узла при синхронизации
+func (s *Storage) GetSyncCursor(nodeID string) (uint64, error) {
+	s.cursorsMutex.RLock()
+	defer s.cursorsMutex.RUnlock()
+
+	return s.syncCursors[nodeID], nil
+}
+
+// SaveSyncCursor сохраняет номер последнего сообщения, полученного от узла при синхронизации
+func (s *Storage) SaveSyncCursor(nodeID string, cursor uint64) error {
+	s.cursorsMutex.Lock()
+	defer s.cursorsMutex.Unlock()
+
+	s.syncCursors[nodeID] = cursor
+
+	data, err := json.MarshalIndent(s.syncCursors, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal sync cursors: %w", err)
+	}
+	cursorsPath := filepath.Join(s.dataDir, "sync_cursors.json")
+	if err := os.WriteFile(cursorsPath+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to save sync cursors: %w", err)
+	}
+	if err := os.Rename(cursorsPath+".tmp", cursorsPath); err != nil {
+		return fmt.Errorf("failed to save sync cursors: %w", err)
+	}
+
+	return nil
+}
+
+// SyncCursors возвращает позиции синхронизации со всеми узлами
+func (s *Storage) SyncCursors() map[string]uint64 {
+	s.cursorsMutex.RLock()
+	defer s.cursorsMutex.RUnlock()
+
+	cursors := make(map[string]uint64, len(s.syncCursors))
+	for nodeID, cursor := range s.syncCursors {
+		cursors[nodeID] = cursor
+	}
+	return cursors
+}
+
+// SaveBan сохраняет бан пира, заменяя предыдущий бан того же пира
+func (s *Storage) SaveBan(ban *models.Ban) error {
+	s.bansMutex.Lock()
+	defer s.bansMutex.Unlock()
+
+	banCopy := *ban
+	s.bans[ban.NodeID] = &banCopy
+
+	data, err := json.MarshalIndent(s.bans, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal bans: %w", err)
+	}
+	bansPath := filepath.Join(s.dataDir, "bans.json")
+	if err := os.WriteFile(bansPath+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to save bans: %w", err)
+	}
+	if err := os.Rename(bansPath+".tmp", bansPath); err != nil {
+		return fmt.Errorf("failed to save bans: %w", err)
+	}
+
+	return nil
+}
+
+// GetBans возвращает все сохраненные баны, в том числе истекшие
+func (s *Storage) GetBans() ([]*models.Ban, error) {
+	s.bansMutex.RLock()
+	defer s.bansMutex.RUnlock()
+
+	bans := make([]*models.Ban, 0, len(s.bans))
+	for _, ban := range s.bans {
+		banCopy := *ban
+		bans = append(bans, &banCopy)
+	}
+	return bans, nil
+}
+
+// Close дожидается завершения начатых записей. Записи попадают на диск
+// сразу, поэтому сбрасывать больше нечего.
+func (s *Storage) Close() error {
+	for _, mutex := range []*sync.RWMutex{&s.peersMutex, &s.messagesMutex, &s.cursorsMutex, &s.bansMutex} {
+		mutex.Lock()
+		mutex.Unlock()
+	}
+	return nil
+}
+
+// loadMessageLog загружает журнал порядка сообщений и дописывает в него сообщения,
+// сохраненные до появления журнала, в порядке времени изменения файлов
+func (s *Storage) loadMessageLog() error {
+	logPath := filepath.Join(s.dataDir, "messages.log")
+	if file, err := os.Open(logPath); err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if head, found := strings.CutPrefix(line, logHeadPrefix); found {
+				if seq, err := strconv.ParseUint(head, 10, 64); err == nil && seq > s.messageHead {
+					s.messageHead = seq
+				}
+				continue
+			}
+
+			// Строка сжатого журнала содержит номер сообщения, иначе номер следующий
+			messageID, seqText, compacted := strings.Cut(line, " ")
+			seq := s.messageHead + 1
+			if compacted {
+				parsed, err := strconv.ParseUint(seqText, 10, 64)
+				if err != nil {
+					continue
+				}
+				seq = parsed
+			}
+			if _, exists := s.messageSeq[messageID]; messageID != "" && !exists {
+				s.messageLog = append(s.messageLog, logEntry{seq, messageID})
+				s.messageSeq[messageID] = seq
+				if seq > s.messageHead {
+					s.messageHead = seq
+				}
+			}
+		}
+		file.Close()
+	}
+
+	entries, err := os.ReadDir(filepath.Join(s.dataDir, "messages"))
+	if err != nil {
+		return err
+	}
+
+	type unlogged struct {
+		messageID string
+		modTime   int64
+	}
+	var missing []unlogged
+	for _, entry := range entries {
+		messageID := strings.TrimSuffix(entry.Name(), ".json")
+		if entry.IsDir() || messageID == entry.Name() {
+			continue
+		}
+		if _, exists := s.messageSeq[messageID]; exists {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		missing = append(missing, unlogged{messageID, info.ModTime().UnixNano()})
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		return missing[i].modTime < missing[j].modTime
+	})
+
+	for _, message := range missing {
+		if err := s.appendMessageLog(message.messageID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendMessageLog присваивает сообщению следующий номер и дописывает его в журнал
+func (s *Storage) appendMessageLog(messageID string) error {
+	file, err := os.OpenFile(filepath.Join(s.dataDir, "messages.log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(messageID + "\n"); err != nil {
+		return err
+	}
+
+	s.messageHead++
+	s.messageLog = append(s.messageLog, logEntry{s.messageHead, messageID})
+	s.messageSeq[messageID] = s.messageHead
+	return nil
+}
+
+// replaceFile атомарно заменяет содержимое файла name в директории данных
+func (s *Storage) replaceFile(name, content string) error {
+	path := filepath.Join(s.dataDir, name)
+	if err := os.WriteFile(path+".tmp", []byte(content), 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// prunedLine возвращает строку pruned.log для сообщения, удаленного в deleted
+func prunedLine(messageID string, deleted time.Time) string {
+	return messageID + " " + strconv.FormatInt(deleted.Unix(), 10) + "\n"
+}
+
+// loadPruned загружает ID удаленных сообщений. Сообщение, сохраненное
+// снова после удаления, удаленным не считается. Для строк без времени
+// удаления (до появления срока хранения ID) оно отсчитывается от загрузки.
+func (s *Storage) loadPruned() error {
+	file, err := os.Open(filepath.Join(s.dataDir, "pruned.log"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	now := time.Now()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		messageID, deletedText, _ := strings.Cut(scanner.Text(), " ")
+		if messageID == "" {
+			continue
+		}
+		deleted := now
+		if unix, err := strconv.ParseInt(deletedText, 10, 64); err == nil {
+			deleted = time.Unix(unix, 0)
+		}
+		if _, err := os.Stat(filepath.Join(s.dataDir, "messages", fmt.Sprintf("%s.json", messageID))); os.IsNotExist(err) {
+			s.pruned[messageID] = deleted
+		}
+	}
+	return scanner.Err()
+}
+
+// loadSyncCursors загружает сохраненные позиции синхронизации с пирами
+func (s *Storage) loadSyncCursors() error {
+	data, err := os.ReadFile(filepath.Join(s.dataDir, "sync_cursors.json"))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &s.syncCursors)
+}
+
+// loadBans загружает сохраненные баны пиров
+func (s *Storage) loadBans() error {
+	data, err := os.ReadFile(filepath.Join(s.dataDir, "bans.json"))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &s.bans)
+}
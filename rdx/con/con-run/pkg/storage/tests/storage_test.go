@@ -1,11 +1,15 @@
 package tests
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"concoin/conrun/pkg/interfaces"
 	"concoin/conrun/pkg/models"
 	"concoin/conrun/pkg/storage"
 )
@@ -128,3 +132,520 @@ func TestStorage(t *testing.T) {
 	})
 
 }
+
+func TestBrixStorage(t *testing.T) {
+	tempDir := t.TempDir()
+
+	store, err := storage.NewBrixStorage(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create brix storage: %v", err)
+	}
+
+	peer := &models.Peer{
+		NodeID:   "test-peer-id",
+		Address:  "127.0.0.1:3000",
+		LastSeen: time.Now().UTC(),
+	}
+	if err := store.SavePeer(peer); err != nil {
+		t.Fatalf("Failed to save peer: %v", err)
+	}
+
+	// More messages than files allowed before the stack is compacted
+	for i := 0; i < 40; i++ {
+		message := &models.GossipMessage{
+			MessageID:   fmt.Sprintf("msg-%02d", i),
+			OriginID:    "test-node-id",
+			Timestamp:   time.Now().UTC(),
+			TTL:         i,
+			MessageType: "user_message",
+			Payload:     map[string]interface{}{"content": "Hello, world!"},
+		}
+		if err := store.SaveMessage(message); err != nil {
+			t.Fatalf("Failed to save message: %v", err)
+		}
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(tempDir, "brix", "*.brik"))
+	if len(matches) > 33 {
+		t.Errorf("Expected the stack to be compacted, got %d files", len(matches))
+	}
+
+	// Reopen the storage
+	store, err = storage.NewBrixStorage(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to reopen brix storage: %v", err)
+	}
+
+	peers, err := store.GetPeers()
+	if err != nil {
+		t.Fatalf("Failed to get peers: %v", err)
+	}
+	if len(peers) != 1 || peers[0].Address != peer.Address {
+		t.Fatalf("Unexpected peers %v", peers)
+	}
+
+	if !store.HasMessage("msg-07") {
+		t.Errorf("HasMessage returned false for existing message")
+	}
+	if store.HasMessage("non-existent-id") {
+		t.Errorf("HasMessage returned true for non-existent message")
+	}
+
+	message, err := store.GetMessage("msg-07")
+	if err != nil {
+		t.Fatalf("Failed to get message: %v", err)
+	}
+	if message.TTL != 7 || message.OriginID != "test-node-id" {
+		t.Errorf("Unexpected message %+v", message)
+	}
+
+	messageIDs, err := store.GetMessageList()
+	if err != nil {
+		t.Fatalf("Failed to get message list: %v", err)
+	}
+	if len(messageIDs) != 40 || messageIDs[0] != "msg-00" {
+		t.Errorf("Unexpected message list %v", messageIDs)
+	}
+}
+
+func TestMessagesSince(t *testing.T) {
+	for _, backend := range []string{storage.BackendJSON, storage.BackendBrix, storage.BackendKV} {
+		t.Run(backend, func(t *testing.T) {
+			tempDir := t.TempDir()
+
+			store, err := storage.NewStorageBackend(backend, tempDir)
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+
+			// Save in reverse ID order to check that arrival order is kept
+			for i := 4; i >= 0; i-- {
+				message := &models.GossipMessage{
+					MessageID:   fmt.Sprintf("msg-%d", i),
+					OriginID:    "test-node-id",
+					Timestamp:   time.Now().UTC(),
+					MessageType: "user_message",
+				}
+				if err := store.SaveMessage(message); err != nil {
+					t.Fatalf("Failed to save message: %v", err)
+				}
+			}
+			// Saving a message again does not move it
+			if err := store.SaveMessage(&models.GossipMessage{MessageID: "msg-4"}); err != nil {
+				t.Fatalf("Failed to save message: %v", err)
+			}
+
+			messages, cursor, head, err := store.GetMessagesSince(0, 2)
+			if err != nil {
+				t.Fatalf("Failed to get messages: %v", err)
+			}
+			if len(messages) != 2 || messages[0].MessageID != "msg-4" || messages[1].MessageID != "msg-3" {
+				t.Fatalf("Unexpected first batch %v", messages)
+			}
+			if cursor != 2 || head != 5 {
+				t.Errorf("Expected cursor 2 and head 5, got %d and %d", cursor, head)
+			}
+
+			if err := store.SaveSyncCursor("peer-1", cursor); err != nil {
+				t.Fatalf("Failed to save sync cursor: %v", err)
+			}
+
+			// Reopen the storage and resume from the saved cursor
+			store, err = storage.NewStorageBackend(backend, tempDir)
+			if err != nil {
+				t.Fatalf("Failed to reopen storage: %v", err)
+			}
+
+			cursor, err = store.GetSyncCursor("peer-1")
+			if err != nil || cursor != 2 {
+				t.Fatalf("Expected saved cursor 2, got %d, %v", cursor, err)
+			}
+			if unknown, _ := store.GetSyncCursor("peer-2"); unknown != 0 {
+				t.Errorf("Expected zero cursor for unknown peer, got %d", unknown)
+			}
+
+			messages, cursor, head, err = store.GetMessagesSince(cursor, 10)
+			if err != nil {
+				t.Fatalf("Failed to get messages: %v", err)
+			}
+			if len(messages) != 3 || messages[0].MessageID != "msg-2" || messages[2].MessageID != "msg-0" {
+				t.Fatalf("Unexpected second batch %v", messages)
+			}
+			if cursor != 5 || head != 5 {
+				t.Errorf("Expected cursor 5 and head 5, got %d and %d", cursor, head)
+			}
+
+			messages, cursor, _, err = store.GetMessagesSince(cursor, 10)
+			if err != nil || len(messages) != 0 || cursor != 5 {
+				t.Errorf("Expected no messages after the head, got %v, %d, %v", messages, cursor, err)
+			}
+		})
+	}
+}
+
+func TestBans(t *testing.T) {
+	for _, backend := range []string{storage.BackendJSON, storage.BackendBrix, storage.BackendKV} {
+		t.Run(backend, func(t *testing.T) {
+			tempDir := t.TempDir()
+
+			store, err := storage.NewStorageBackend(backend, tempDir)
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+
+			until := time.Now().Add(time.Hour).UTC().Round(time.Second)
+			if err := store.SaveBan(&models.Ban{NodeID: "peer-1", Reason: "first", Until: until}); err != nil {
+				t.Fatalf("Failed to save ban: %v", err)
+			}
+			if err := store.SaveBan(&models.Ban{NodeID: "peer-2", Reason: "second", Until: until}); err != nil {
+				t.Fatalf("Failed to save ban: %v", err)
+			}
+			// A new ban of the same peer replaces the old one
+			if err := store.SaveBan(&models.Ban{NodeID: "peer-1", Reason: "again", Until: until.Add(time.Hour)}); err != nil {
+				t.Fatalf("Failed to save ban: %v", err)
+			}
+
+			// Bans are kept after reopening the storage
+			store, err = storage.NewStorageBackend(backend, tempDir)
+			if err != nil {
+				t.Fatalf("Failed to reopen storage: %v", err)
+			}
+			bans, err := store.GetBans()
+			if err != nil {
+				t.Fatalf("Failed to get bans: %v", err)
+			}
+			if len(bans) != 2 {
+				t.Fatalf("Expected 2 bans, got %d", len(bans))
+			}
+			for _, ban := range bans {
+				if ban.NodeID == "peer-1" && (ban.Reason != "again" || !ban.Until.Equal(until.Add(time.Hour))) {
+					t.Errorf("Unexpected ban %+v", ban)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteMessages(t *testing.T) {
+	for _, backend := range []string{storage.BackendJSON, storage.BackendKV} {
+		t.Run(backend, func(t *testing.T) {
+			tempDir := t.TempDir()
+
+			store, err := storage.NewStorageBackend(backend, tempDir)
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+			for i := 0; i < 5; i++ {
+				message := &models.GossipMessage{
+					MessageID:   fmt.Sprintf("msg-%d", i),
+					Timestamp:   time.Now().UTC(),
+					MessageType: "user_message",
+				}
+				if err := store.SaveMessage(message); err != nil {
+					t.Fatalf("Failed to save message: %v", err)
+				}
+			}
+
+			pruner, ok := store.(interfaces.MessagePruner)
+			if !ok {
+				t.Fatalf("Expected %s storage to delete messages", backend)
+			}
+			// Unknown IDs are ignored
+			if err := pruner.DeleteMessages([]string{"msg-1", "msg-3", "msg-4", "unknown"}); err != nil {
+				t.Fatalf("Failed to delete messages: %v", err)
+			}
+
+			check := func(store interfaces.StorageInterface) {
+				if _, err := store.GetMessage("msg-1"); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("Expected msg-1 to be deleted, got %v", err)
+				}
+				// Deleted messages stay known so that peers do not send them again
+				if !store.HasMessage("msg-1") || store.HasMessage("unknown") {
+					t.Errorf("Expected only deleted messages to stay known")
+				}
+				pruned, err := store.(interfaces.MessagePruner).PrunedMessages()
+				if err != nil || fmt.Sprint(pruned) != "[msg-1 msg-3 msg-4]" {
+					t.Errorf("Unexpected pruned messages %v, %v", pruned, err)
+				}
+
+				// Sync skips deleted messages and still reaches the head
+				messages, cursor, head, err := store.GetMessagesSince(0, 2)
+				if err != nil {
+					t.Fatalf("Failed to get messages: %v", err)
+				}
+				if len(messages) != 2 || messages[0].MessageID != "msg-0" || messages[1].MessageID != "msg-2" {
+					t.Errorf("Unexpected messages %v", messages)
+				}
+				if cursor != 5 || head != 5 {
+					t.Errorf("Expected cursor 5 and head 5, got %d and %d", cursor, head)
+				}
+			}
+			check(store)
+
+			// Deletions are kept after reopening the storage
+			if err := store.Close(); err != nil {
+				t.Fatalf("Failed to close storage: %v", err)
+			}
+			store, err = storage.NewStorageBackend(backend, tempDir)
+			if err != nil {
+				t.Fatalf("Failed to reopen storage: %v", err)
+			}
+			defer store.Close()
+			check(store)
+
+			// A deleted message received again is stored again
+			if err := store.SaveMessage(&models.GossipMessage{MessageID: "msg-3"}); err != nil {
+				t.Fatalf("Failed to save message: %v", err)
+			}
+			if _, err := store.GetMessage("msg-3"); err != nil {
+				t.Errorf("Expected msg-3 to be stored again, got %v", err)
+			}
+			if pruned, _ := store.(interfaces.MessagePruner).PrunedMessages(); fmt.Sprint(pruned) != "[msg-1 msg-4]" {
+				t.Errorf("Unexpected pruned messages %v", pruned)
+			}
+		})
+	}
+}
+
+func TestExpirePruned(t *testing.T) {
+	for _, backend := range []string{storage.BackendJSON, storage.BackendKV} {
+		t.Run(backend, func(t *testing.T) {
+			tempDir := t.TempDir()
+
+			store, err := storage.NewStorageBackend(backend, tempDir)
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+			for i := 0; i < 5; i++ {
+				message := &models.GossipMessage{
+					MessageID:   fmt.Sprintf("msg-%d", i),
+					Timestamp:   time.Now().UTC(),
+					MessageType: "user_message",
+				}
+				if err := store.SaveMessage(message); err != nil {
+					t.Fatalf("Failed to save message: %v", err)
+				}
+			}
+			pruner := store.(interfaces.MessagePruner)
+			if err := pruner.DeleteMessages([]string{"msg-1", "msg-3", "msg-4"}); err != nil {
+				t.Fatalf("Failed to delete messages: %v", err)
+			}
+
+			// Recently deleted messages are remembered
+			if expired, err := pruner.ExpirePruned(time.Now().Add(-time.Hour)); err != nil || expired != 0 {
+				t.Fatalf("Expected nothing to expire, got %d, %v", expired, err)
+			}
+			if expired, err := pruner.ExpirePruned(time.Now().Add(time.Hour)); err != nil || expired != 3 {
+				t.Fatalf("Expected 3 deleted messages to expire, got %d, %v", expired, err)
+			}
+
+			check := func(store interfaces.StorageInterface) {
+				if store.HasMessage("msg-1") || !store.HasMessage("msg-2") {
+					t.Errorf("Expected only stored messages to stay known")
+				}
+				pruned, err := store.(interfaces.MessagePruner).PrunedMessages()
+				if err != nil || len(pruned) != 0 {
+					t.Errorf("Expected no pruned messages, got %v, %v", pruned, err)
+				}
+
+				// Numbers of the remaining messages and the head do not change,
+				// so peers keep their sync positions
+				messages, cursor, head, err := store.GetMessagesSince(0, 10)
+				if err != nil {
+					t.Fatalf("Failed to get messages: %v", err)
+				}
+				if len(messages) != 2 || messages[0].MessageID != "msg-0" || messages[1].MessageID != "msg-2" {
+					t.Errorf("Unexpected messages %v", messages)
+				}
+				if cursor != 5 || head != 5 {
+					t.Errorf("Expected cursor 5 and head 5, got %d and %d", cursor, head)
+				}
+				if messages, cursor, _, _ := store.GetMessagesSince(1, 10); len(messages) != 1 || cursor != 5 {
+					t.Errorf("Expected msg-2 after msg-0 and cursor 5, got %v and %d", messages, cursor)
+				}
+			}
+			check(store)
+
+			if err := store.Close(); err != nil {
+				t.Fatalf("Failed to close storage: %v", err)
+			}
+			store, err = storage.NewStorageBackend(backend, tempDir)
+			if err != nil {
+				t.Fatalf("Failed to reopen storage: %v", err)
+			}
+			defer store.Close()
+			check(store)
+
+			if backend == storage.BackendJSON {
+				// Both logs are compacted
+				prunedLog, _ := os.ReadFile(filepath.Join(tempDir, "pruned.log"))
+				messagesLog, _ := os.ReadFile(filepath.Join(tempDir, "messages.log"))
+				if len(prunedLog) != 0 || strings.Count(string(messagesLog), "\n") != 3 {
+					t.Errorf("Expected compacted logs, got %q and %q", prunedLog, messagesLog)
+				}
+			}
+
+			// A forgotten message received again gets a new number
+			if err := store.SaveMessage(&models.GossipMessage{MessageID: "msg-4"}); err != nil {
+				t.Fatalf("Failed to save message: %v", err)
+			}
+			if messages, cursor, head, _ := store.GetMessagesSince(5, 10); len(messages) != 1 || cursor != 6 || head != 6 {
+				t.Errorf("Expected msg-4 with number 6, got %v, cursor %d, head %d", messages, cursor, head)
+			}
+		})
+	}
+}
+
+func TestKVStorage(t *testing.T) {
+	tempDir := t.TempDir()
+
+	store, err := storage.NewKVStorage(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create kv storage: %v", err)
+	}
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 6; i++ {
+		messageType := "user_message"
+		if i%2 == 1 {
+			messageType = "block"
+		}
+		message := &models.GossipMessage{
+			MessageID:   fmt.Sprintf("msg-%d", i),
+			OriginID:    "test-node-id",
+			Timestamp:   base.Add(time.Duration(5-i) * time.Minute),
+			TTL:         i,
+			MessageType: messageType,
+		}
+		if err := store.SaveMessage(message); err != nil {
+			t.Fatalf("Failed to save message: %v", err)
+		}
+	}
+	// Saving again with another type moves the message between indexes
+	if err := store.SaveMessage(&models.GossipMessage{MessageID: "msg-0", MessageType: "block", Timestamp: base.Add(time.Hour)}); err != nil {
+		t.Fatalf("Failed to save message: %v", err)
+	}
+
+	ids := func(messages []*models.GossipMessage) string {
+		var result []string
+		for _, message := range messages {
+			result = append(result, message.MessageID)
+		}
+		return fmt.Sprint(result)
+	}
+
+	check := func(store *storage.KVStorage) {
+		// Index queries return messages in timestamp order
+		blocks, err := store.MessagesByType("block", time.Time{}, time.Time{}, 0)
+		if err != nil {
+			t.Fatalf("Failed to query by type: %v", err)
+		}
+		if got := ids(blocks); got != "[msg-5 msg-3 msg-1 msg-0]" {
+			t.Errorf("Unexpected blocks %s", got)
+		}
+		users, _ := store.MessagesByType("user_message", time.Time{}, time.Time{}, 0)
+		if got := ids(users); got != "[msg-4 msg-2]" {
+			t.Errorf("Unexpected user messages %s", got)
+		}
+
+		// The interval includes from and excludes to
+		between, err := store.MessagesBetween(base.Add(time.Minute), base.Add(4*time.Minute), 0)
+		if err != nil {
+			t.Fatalf("Failed to query by time: %v", err)
+		}
+		if got := ids(between); got != "[msg-4 msg-3 msg-2]" {
+			t.Errorf("Unexpected messages between %s", got)
+		}
+		limited, _ := store.MessagesByType("block", base.Add(2*time.Minute), time.Time{}, 1)
+		if got := ids(limited); got != "[msg-3]" {
+			t.Errorf("Unexpected limited query %s", got)
+		}
+
+		messageIDs, _ := store.GetMessageList()
+		if len(messageIDs) != 6 || messageIDs[0] != "msg-0" {
+			t.Errorf("Unexpected message list %v", messageIDs)
+		}
+		if _, err := store.GetMessage("non-existent-id"); !os.IsNotExist(err) {
+			t.Errorf("Expected a not-exist error, got %v", err)
+		}
+	}
+	check(store)
+
+	// Indexes are kept after reopening the storage
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close storage: %v", err)
+	}
+	store, err = storage.NewKVStorage(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to reopen kv storage: %v", err)
+	}
+	defer store.Close()
+	check(store)
+
+	// Numbering continues after the last saved message
+	store.SaveMessage(&models.GossipMessage{MessageID: "msg-6"})
+	if _, cursor, head, _ := store.GetMessagesSince(5, 10); cursor != 7 || head != 7 {
+		t.Errorf("Expected cursor 7 and head 7, got %d and %d", cursor, head)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	tempDir := t.TempDir()
+
+	from := storage.NewStorage(tempDir)
+	for i := 0; i < 300; i++ {
+		message := &models.GossipMessage{
+			MessageID:   fmt.Sprintf("msg-%03d", 299-i),
+			Timestamp:   time.Now().UTC(),
+			MessageType: "user_message",
+		}
+		if err := from.SaveMessage(message); err != nil {
+			t.Fatalf("Failed to save message: %v", err)
+		}
+	}
+	from.SavePeer(&models.Peer{NodeID: "peer-1", Address: "127.0.0.1:3001"})
+	from.SaveSyncCursor("peer-1", 10)
+	from.SaveSyncCursor("gone-peer", 20)
+	from.SaveBan(&models.Ban{NodeID: "peer-2", Reason: "spam"})
+
+	if !storage.HasJSONMessages(tempDir) {
+		t.Errorf("Expected json messages to be found")
+	}
+
+	to, err := storage.NewKVStorage(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create kv storage: %v", err)
+	}
+	stats, err := storage.Migrate(from, to)
+	if err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	if stats.Messages != 300 || stats.Peers != 1 || stats.Cursors != 2 || stats.Bans != 1 {
+		t.Errorf("Unexpected migration stats %+v", stats)
+	}
+
+	// Message numbers are kept, so saved sync cursors stay valid
+	messages, cursor, head, err := to.GetMessagesSince(0, 300)
+	if err != nil {
+		t.Fatalf("Failed to get messages: %v", err)
+	}
+	if len(messages) != 300 || messages[0].MessageID != "msg-299" || messages[299].MessageID != "msg-000" || cursor != 300 || head != 300 {
+		t.Errorf("Unexpected migrated messages: %d, cursor %d, head %d", len(messages), cursor, head)
+	}
+	if cursor, _ := to.GetSyncCursor("gone-peer"); cursor != 20 {
+		t.Errorf("Expected the cursor of an unknown peer to be migrated, got %d", cursor)
+	}
+	if bans, _ := to.GetBans(); len(bans) != 1 || bans[0].Reason != "spam" {
+		t.Errorf("Unexpected migrated bans %v", bans)
+	}
+
+	// Running the migration again skips messages that are already there
+	stats, err = storage.Migrate(from, to)
+	if err != nil {
+		t.Fatalf("Failed to migrate again: %v", err)
+	}
+	if stats.Messages != 0 || stats.Skipped != 300 {
+		t.Errorf("Expected all messages to be skipped, got %+v", stats)
+	}
+	to.Close()
+}
@@ -0,0 +1,100 @@
+package transport
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxFrameSize ограничивает размер тела кадра, если лимит не задан
+const maxFrameSize = 16 << 20
+
+// maxHeaderSize ограничивает размер заголовка кадра
+const maxHeaderSize = 64 << 10
+
+// errBodyTooLarge тело кадра больше лимита. Оно пропущено без выделения
+// памяти, и следующие кадры соединения читаются как обычно.
+var errBodyTooLarge = errors.New("frame body is too large")
+
+// header заголовок кадра. Запрос и ответ связаны общим ID,
+// поэтому по одному соединению может идти несколько запросов сразу.
+type header struct {
+	ID     uint64 `json:"id"`
+	Method string `json:"method,omitempty"` // только в запросе
+	Path   string `json:"path,omitempty"`   // только в запросе, вместе с query
+	Status int    `json:"status,omitempty"` // только в ответе
+}
+
+// writeFrame пишет кадр: длина заголовка, JSON заголовок, длина тела, тело
+func writeFrame(w io.Writer, h header, body []byte) error {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+
+	frame := make([]byte, 0, 8+len(data)+len(body))
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(data)))
+	frame = append(frame, data...)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(body)))
+	frame = append(frame, body...)
+
+	_, err = w.Write(frame)
+	return err
+}
+
+// readFrame читает кадр, записанный writeFrame. Тело больше maxBody байт
+// (0 - без лимита) пропускается, и возвращается заголовок с errBodyTooLarge.
+func readFrame(r io.Reader, maxBody int64) (header, []byte, error) {
+	var h header
+
+	size, err := readSize(r, maxHeaderSize)
+	if err != nil {
+		return h, nil, err
+	}
+	data, err := readChunk(r, size)
+	if err != nil {
+		return h, nil, err
+	}
+	if err := json.Unmarshal(data, &h); err != nil {
+		return h, nil, fmt.Errorf("bad frame header: %w", err)
+	}
+
+	size, err = readSize(r, maxFrameSize)
+	if err != nil {
+		return h, nil, err
+	}
+	if maxBody > 0 && int64(size) > maxBody {
+		if _, err := io.CopyN(io.Discard, r, int64(size)); err != nil {
+			return h, nil, err
+		}
+		return h, nil, errBodyTooLarge
+	}
+	body, err := readChunk(r, size)
+	if err != nil {
+		return h, nil, err
+	}
+	return h, body, nil
+}
+
+// readSize читает длину части кадра и проверяет ее до выделения памяти
+func readSize(r io.Reader, limit uint32) (uint32, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return 0, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > limit {
+		return 0, fmt.Errorf("frame of %d bytes is too large", n)
+	}
+	return n, nil
+}
+
+func readChunk(r io.Reader, size uint32) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
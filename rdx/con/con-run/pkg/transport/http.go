@@ -0,0 +1,77 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"concoin/conrun/pkg/config"
+	"concoin/conrun/pkg/models"
+)
+
+// HTTPTransport отправляет каждый запрос отдельным HTTP запросом.
+// В отличие от http.Post по умолчанию, запрос ограничен SendTimeout.
+type HTTPTransport struct {
+	client *http.Client
+}
+
+// NewHTTPTransport создает HTTP транспорт
+func NewHTTPTransport(cfg config.TransportConfig) *HTTPTransport {
+	cfg = withDefaults(cfg)
+
+	// Держим несколько соединений на пира, чтобы не открывать их на каждое сообщение
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 4
+
+	return &HTTPTransport{
+		client: &http.Client{
+			Timeout:   cfg.SendTimeout,
+			Transport: transport,
+		},
+	}
+}
+
+// Send отправляет запрос пиру и декодирует JSON ответ в response, если он не nil
+func (t *HTTPTransport) Send(peer models.Peer, method, path string, request, response interface{}) error {
+	var body io.Reader
+	if request != nil {
+		data, err := json.Marshal(request)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, fmt.Sprintf("http://%s%s", peer.Address, path), body)
+	if err != nil {
+		return err
+	}
+	if request != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{Code: resp.StatusCode}
+	}
+	if response == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// Close закрывает простаивающие соединения
+func (t *HTTPTransport) Close() error {
+	t.client.CloseIdleConnections()
+	return nil
+}
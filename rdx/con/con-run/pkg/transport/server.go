@@ -0,0 +1,188 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"concoin/conrun/pkg/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxInflight число запросов одного соединения, обрабатываемых одновременно.
+// Следующие кадры не читаются, пока не освободится место, и отправитель
+// упирается в свою очередь.
+const maxInflight = 64
+
+// Server принимает соединения StreamTransport и передает запросы из кадров
+// обычному HTTP обработчику узла
+type Server struct {
+	handler      http.Handler
+	writeTimeout time.Duration
+	bodyLimit    func() int64 // наибольший размер тела запроса, 0 - без ограничения
+	logger       *logrus.Logger
+	conns        map[net.Conn]struct{}
+	mutex        sync.Mutex
+}
+
+// NewServer создает сервер, передающий запросы handler
+func NewServer(handler http.Handler, cfg config.TransportConfig, logger *logrus.Logger) *Server {
+	return &Server{
+		handler:      handler,
+		writeTimeout: withDefaults(cfg).SendTimeout,
+		logger:       logger,
+		conns:        make(map[net.Conn]struct{}),
+	}
+}
+
+// SetBodyLimit задает наибольший размер тела запроса. Лимит читается перед
+// каждым кадром, поэтому его изменения действуют и на открытые соединения.
+func (s *Server) SetBodyLimit(limit func() int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.bodyLimit = limit
+}
+
+// maxBodySize возвращает текущий лимит тела запроса, 0 - без ограничения
+func (s *Server) maxBodySize() int64 {
+	s.mutex.Lock()
+	limit := s.bodyLimit
+	s.mutex.Unlock()
+
+	if limit == nil {
+		return 0
+	}
+	return limit()
+}
+
+// ServeHTTP переключает HTTP соединение на кадры и обслуживает его до закрытия
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), protocolName) {
+		http.Error(w, "Upgrade required", http.StatusUpgradeRequired)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Connection upgrade is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		s.logger.Warnf("Failed to upgrade transport connection: %v", err)
+		return
+	}
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: " + protocolName + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return
+	}
+
+	s.serve(conn, rw.Reader, r.RemoteAddr)
+}
+
+// Close закрывает все принятые соединения
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return nil
+}
+
+func (s *Server) serve(conn net.Conn, reader *bufio.Reader, remoteAddr string) {
+	s.mutex.Lock()
+	s.conns[conn] = struct{}{}
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, conn)
+		s.mutex.Unlock()
+		conn.Close()
+	}()
+
+	s.logger.Debugf("Transport connection from %s", remoteAddr)
+
+	var writeMutex sync.Mutex
+	inflight := make(chan struct{}, maxInflight)
+	for {
+		h, body, err := readFrame(reader, s.maxBodySize())
+		// Большое тело пропущено, на запрос отвечаем 413 как HTTP обработчик
+		tooLarge := errors.Is(err, errBodyTooLarge)
+		if err != nil && !tooLarge {
+			s.logger.Debugf("Transport connection from %s closed: %v", remoteAddr, err)
+			return
+		}
+
+		inflight <- struct{}{}
+		go func() {
+			defer func() { <-inflight }()
+
+			status, response := http.StatusRequestEntityTooLarge, []byte("Request body too large\n")
+			if !tooLarge {
+				status, response = s.handle(h, body, remoteAddr)
+			}
+
+			writeMutex.Lock()
+			defer writeMutex.Unlock()
+			conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+			if err := writeFrame(conn, header{ID: h.ID, Status: status}, response); err != nil {
+				s.logger.Debugf("Failed to write transport response to %s: %v", remoteAddr, err)
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// handle выполняет запрос из кадра HTTP обработчиком
+func (s *Server) handle(h header, body []byte, remoteAddr string) (int, []byte) {
+	req, err := http.NewRequest(h.Method, h.Path, bytes.NewReader(body))
+	if err != nil {
+		return http.StatusBadRequest, []byte(err.Error())
+	}
+	req.RemoteAddr = remoteAddr
+	if len(body) > 0 {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	w := &frameResponse{header: make(http.Header)}
+	s.handler.ServeHTTP(w, req)
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.status, w.body.Bytes()
+}
+
+// frameResponse собирает ответ HTTP обработчика для отправки кадром
+type frameResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *frameResponse) Header() http.Header {
+	return w.header
+}
+
+func (w *frameResponse) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(data)
+}
+
+func (w *frameResponse) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
@@ -0,0 +1,363 @@
+package transport
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"concoin/conrun/pkg/config"
+	"concoin/conrun/pkg/models"
+)
+
+// Path HTTP ручка, на которой соединение переключается на кадры
+const Path = "/transport"
+
+// protocolName значение заголовка Upgrade при переключении
+const protocolName = "concoin-frames"
+
+// minBackoff пауза перед повторным подключением после первой неудачи,
+// дальше она удваивается до TransportConfig.MaxBackoff
+const minBackoff = 100 * time.Millisecond
+
+// keepAlivePeriod период TCP keepalive: соединение с пропавшим пиром
+// закрывается, даже если по нему ничего не отправляется
+const keepAlivePeriod = 15 * time.Second
+
+// maxTimeouts запросов подряд без ответа, после которых соединение считается
+// зависшим и закрывается, чтобы следующая отправка переподключилась
+const maxTimeouts = 3
+
+// StreamTransport держит одно долгоживущее TCP соединение на пира и
+// мультиплексирует по нему запросы. Соединение открывается на HTTP порту
+// пира через Upgrade, поэтому адреса пиров остаются прежними.
+type StreamTransport struct {
+	config config.TransportConfig
+	peers  map[string]*streamPeer // адрес -> соединение
+	closed bool
+	mutex  sync.Mutex
+}
+
+// streamPeer соединение с пиром и состояние переподключения
+type streamPeer struct {
+	conn     *streamConn
+	failures int       // неудачных подключений подряд
+	retryAt  time.Time // раньше этого времени не подключаемся
+	mutex    sync.Mutex
+}
+
+// streamConn открытое соединение
+type streamConn struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	queue    chan *call       // ограниченная очередь отправки
+	pending  map[uint64]*call // запросы, ждущие ответа
+	nextID   uint64
+	timeouts int // запросов подряд, не дождавшихся ответа
+	mutex    sync.Mutex
+	done     chan struct{}
+	err      error
+	once     sync.Once
+}
+
+// call запрос, ждущий ответа
+type call struct {
+	header header
+	body   []byte
+	reply  chan reply
+}
+
+type reply struct {
+	status int
+	body   []byte
+}
+
+// NewStreamTransport создает транспорт на долгоживущих соединениях
+func NewStreamTransport(cfg config.TransportConfig) *StreamTransport {
+	return &StreamTransport{
+		config: withDefaults(cfg),
+		peers:  make(map[string]*streamPeer),
+	}
+}
+
+// Send отправляет запрос пиру и декодирует JSON ответ в response, если он не nil.
+// Постановка в очередь и ожидание ответа вместе ограничены SendTimeout.
+func (t *StreamTransport) Send(peer models.Peer, method, path string, request, response interface{}) error {
+	var body []byte
+	if request != nil {
+		data, err := json.Marshal(request)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		body = data
+	}
+
+	deadline := time.NewTimer(t.config.SendTimeout)
+	defer deadline.Stop()
+
+	conn, err := t.connect(peer.Address)
+	if err != nil {
+		return err
+	}
+
+	c := conn.register(method, path, body)
+
+	// Очередь ограничена: если пир не успевает читать, отправитель ждет, но не дольше дедлайна
+	select {
+	case conn.queue <- c:
+	case <-conn.done:
+		return conn.err
+	case <-deadline.C:
+		conn.unregister(c.header.ID)
+		conn.timedOut()
+		return fmt.Errorf("%w: %s", ErrQueueFull, peer.Address)
+	}
+
+	var r reply
+	select {
+	case r = <-c.reply:
+	case <-conn.done:
+		select {
+		case r = <-c.reply:
+		default:
+			return conn.err
+		}
+	case <-deadline.C:
+		conn.unregister(c.header.ID)
+		conn.timedOut()
+		return fmt.Errorf("%w: %s", ErrTimeout, peer.Address)
+	}
+
+	if r.status != http.StatusOK {
+		return &StatusError{Code: r.status}
+	}
+	if response == nil {
+		return nil
+	}
+	if err := json.Unmarshal(r.body, response); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// Close закрывает все соединения
+func (t *StreamTransport) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.closed = true
+	for _, peer := range t.peers {
+		peer.mutex.Lock()
+		if peer.conn != nil {
+			peer.conn.close(ErrClosed)
+		}
+		peer.mutex.Unlock()
+	}
+	return nil
+}
+
+// connect возвращает открытое соединение с пиром, при необходимости переподключаясь.
+// После неудачного подключения следующая попытка возможна только через паузу.
+func (t *StreamTransport) connect(address string) (*streamConn, error) {
+	t.mutex.Lock()
+	if t.closed {
+		t.mutex.Unlock()
+		return nil, ErrClosed
+	}
+	peer, ok := t.peers[address]
+	if !ok {
+		peer = &streamPeer{}
+		t.peers[address] = peer
+	}
+	t.mutex.Unlock()
+
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	if peer.conn != nil && !peer.conn.isClosed() {
+		return peer.conn, nil
+	}
+	if time.Now().Before(peer.retryAt) {
+		return nil, fmt.Errorf("%w: %s", ErrBackoff, address)
+	}
+
+	conn, err := dial(address, t.config)
+	if err != nil {
+		peer.failures++
+		peer.retryAt = time.Now().Add(backoff(peer.failures, t.config.MaxBackoff))
+		return nil, err
+	}
+	peer.failures = 0
+	peer.conn = conn
+	return conn, nil
+}
+
+// backoff пауза после failures неудачных подключений подряд
+func backoff(failures int, max time.Duration) time.Duration {
+	pause := minBackoff
+	for i := 1; i < failures && pause < max; i++ {
+		pause *= 2
+	}
+	if pause > max {
+		pause = max
+	}
+	return pause
+}
+
+// dial открывает соединение и переключает его на кадры
+func dial(address string, cfg config.TransportConfig) (*streamConn, error) {
+	dialer := net.Dialer{Timeout: cfg.DialTimeout, KeepAlive: keepAlivePeriod}
+	conn, err := dialer.Dial("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	conn.SetDeadline(time.Now().Add(cfg.DialTimeout))
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s%s", address, Path), nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", protocolName)
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to upgrade connection to %s: %w", address, err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to upgrade connection to %s: %w", address, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, fmt.Errorf("failed to upgrade connection to %s: %w", address, &StatusError{Code: resp.StatusCode})
+	}
+	conn.SetDeadline(time.Time{})
+
+	c := &streamConn{
+		conn:    conn,
+		reader:  reader,
+		queue:   make(chan *call, cfg.QueueSize),
+		pending: make(map[uint64]*call),
+		done:    make(chan struct{}),
+	}
+	go c.writeLoop(cfg.SendTimeout)
+	go c.readLoop()
+	return c, nil
+}
+
+// register присваивает запросу ID и ждет ответа на него
+func (c *streamConn) register(method, path string, body []byte) *call {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.nextID++
+	request := &call{
+		header: header{ID: c.nextID, Method: method, Path: path},
+		body:   body,
+		reply:  make(chan reply, 1),
+	}
+	c.pending[request.header.ID] = request
+	return request
+}
+
+// unregister забывает запрос, ответ на него будет отброшен
+func (c *streamConn) unregister(id uint64) *call {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	request := c.pending[id]
+	delete(c.pending, id)
+	return request
+}
+
+// answered забывает запрос, на который пришел ответ. Ответ показывает,
+// что соединение живо, поэтому счетчик запросов без ответа сбрасывается.
+func (c *streamConn) answered(id uint64) *call {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.timeouts = 0
+	request := c.pending[id]
+	delete(c.pending, id)
+	return request
+}
+
+// timedOut учитывает запрос, не дождавшийся ответа. После maxTimeouts
+// таких запросов подряд соединение закрывается.
+func (c *streamConn) timedOut() {
+	c.mutex.Lock()
+	c.timeouts++
+	stalled := c.timeouts >= maxTimeouts
+	c.mutex.Unlock()
+
+	if stalled {
+		c.close(fmt.Errorf("%w: %d requests in a row timed out", ErrClosed, maxTimeouts))
+	}
+}
+
+func (c *streamConn) isPending(id uint64) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	_, ok := c.pending[id]
+	return ok
+}
+
+// writeLoop пишет запросы из очереди, запись каждого кадра ограничена timeout
+func (c *streamConn) writeLoop(timeout time.Duration) {
+	for {
+		select {
+		case request := <-c.queue:
+			// Запрос, отправитель которого уже не ждет, не отправляем
+			if !c.isPending(request.header.ID) {
+				continue
+			}
+			c.conn.SetWriteDeadline(time.Now().Add(timeout))
+			if err := writeFrame(c.conn, request.header, request.body); err != nil {
+				c.close(fmt.Errorf("%w: %v", ErrClosed, err))
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// readLoop раздает ответы ждущим их запросам
+func (c *streamConn) readLoop() {
+	for {
+		h, body, err := readFrame(c.reader, 0)
+		if err != nil {
+			c.close(fmt.Errorf("%w: %v", ErrClosed, err))
+			return
+		}
+		if request := c.answered(h.ID); request != nil {
+			request.reply <- reply{status: h.Status, body: body}
+		}
+	}
+}
+
+func (c *streamConn) close(err error) {
+	c.once.Do(func() {
+		c.err = err
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+func (c *streamConn) isClosed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,266 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"concoin/conrun/pkg/config"
+	"concoin/conrun/pkg/interfaces"
+	"concoin/conrun/pkg/models"
+	"concoin/conrun/pkg/transport"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+type echo struct {
+	Text  string `json:"text"`
+	After string `json:"after,omitempty"`
+}
+
+// newNode starts a server with the routes of a node: the handlers
+// and the transport endpoint that feeds frames to the same router
+func newNode(t *testing.T, cfg config.TransportConfig, release chan struct{}) (*httptest.Server, *transport.Server) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		var request echo
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(request)
+	}).Methods("POST")
+	router.HandleFunc("/query", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(echo{After: r.URL.Query().Get("after")})
+	}).Methods("GET")
+	router.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}).Methods("POST")
+
+	server := transport.NewServer(router, cfg, logger)
+	router.Handle(transport.Path, server).Methods("GET")
+
+	httpServer := httptest.NewServer(router)
+	t.Cleanup(httpServer.Close)
+	t.Cleanup(func() { server.Close() })
+	return httpServer, server
+}
+
+func testConfig(transportType string) config.TransportConfig {
+	cfg := config.DefaultConfig(3000, 0).TransportConfig
+	cfg.Type = transportType
+	cfg.SendTimeout = 300 * time.Millisecond
+	cfg.DialTimeout = 300 * time.Millisecond
+	cfg.MaxBackoff = 200 * time.Millisecond
+	return cfg
+}
+
+func TestTransport(t *testing.T) {
+	for _, transportType := range []string{transport.TypeHTTP, transport.TypeTCP} {
+		t.Run(transportType, func(t *testing.T) {
+			cfg := testConfig(transportType)
+			release := make(chan struct{})
+			defer close(release)
+			httpServer, _ := newNode(t, cfg, release)
+
+			tr, err := transport.New(cfg)
+			if err != nil {
+				t.Fatalf("Failed to create transport: %v", err)
+			}
+			defer tr.Close()
+
+			peer := models.Peer{NodeID: "peer", Address: httpServer.Listener.Addr().String()}
+
+			var response echo
+			if err := tr.Send(peer, http.MethodPost, "/echo", echo{Text: "hello"}, &response); err != nil {
+				t.Fatalf("Failed to send: %v", err)
+			}
+			if response.Text != "hello" {
+				t.Errorf("Unexpected response %+v", response)
+			}
+
+			if err := tr.Send(peer, http.MethodGet, "/query?after=5", nil, &response); err != nil {
+				t.Fatalf("Failed to send: %v", err)
+			}
+			if response.After != "5" {
+				t.Errorf("Expected query to be passed, got %+v", response)
+			}
+
+			var statusErr *transport.StatusError
+			if err := tr.Send(peer, http.MethodPost, "/missing", echo{}, nil); !errors.As(err, &statusErr) || statusErr.Code != http.StatusNotFound {
+				t.Errorf("Expected status 404, got %v", err)
+			}
+
+			// A slow peer does not block the sender beyond the deadline
+			start := time.Now()
+			if err := tr.Send(peer, http.MethodPost, "/slow", echo{}, nil); err == nil {
+				t.Errorf("Expected slow request to fail")
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("Slow request took %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestStreamTransport_Concurrent(t *testing.T) {
+	cfg := testConfig(transport.TypeTCP)
+	httpServer, _ := newNode(t, cfg, nil)
+
+	tr := transport.NewStreamTransport(cfg)
+	defer tr.Close()
+	peer := models.Peer{NodeID: "peer", Address: httpServer.Listener.Addr().String()}
+
+	// Responses are matched to requests on a shared connection
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(text string) {
+			defer wg.Done()
+			var response echo
+			if err := tr.Send(peer, http.MethodPost, "/echo", echo{Text: text}, &response); err != nil {
+				errs <- err
+				return
+			}
+			if response.Text != text {
+				errs <- errors.New("mismatched response " + response.Text + " for " + text)
+			}
+		}(fmt.Sprintf("msg-%d", i))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestStreamTransport_Reconnect(t *testing.T) {
+	cfg := testConfig(transport.TypeTCP)
+	httpServer, server := newNode(t, cfg, nil)
+
+	var tr interfaces.TransportInterface = transport.NewStreamTransport(cfg)
+	defer tr.Close()
+	peer := models.Peer{NodeID: "peer", Address: httpServer.Listener.Addr().String()}
+
+	if err := tr.Send(peer, http.MethodPost, "/echo", echo{Text: "first"}, nil); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+
+	// The peer drops the connection, the next send opens a new one
+	server.Close()
+	time.Sleep(50 * time.Millisecond)
+	if err := tr.Send(peer, http.MethodPost, "/echo", echo{Text: "second"}, nil); err != nil {
+		t.Fatalf("Failed to send after the connection was dropped: %v", err)
+	}
+
+	t.Run("Backoff", func(t *testing.T) {
+		// Reserve a port with nothing listening on it
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		down := models.Peer{NodeID: "down", Address: listener.Addr().String()}
+		listener.Close()
+
+		if err := tr.Send(down, http.MethodPost, "/echo", echo{}, nil); err == nil || errors.Is(err, transport.ErrBackoff) {
+			t.Fatalf("Expected a connection error, got %v", err)
+		}
+		if err := tr.Send(down, http.MethodPost, "/echo", echo{}, nil); !errors.Is(err, transport.ErrBackoff) {
+			t.Fatalf("Expected ErrBackoff right after a failure, got %v", err)
+		}
+
+		time.Sleep(150 * time.Millisecond)
+		if err := tr.Send(down, http.MethodPost, "/echo", echo{}, nil); err == nil || errors.Is(err, transport.ErrBackoff) {
+			t.Errorf("Expected a new connection attempt after the backoff, got %v", err)
+		}
+	})
+}
+
+func TestStreamTransport_BodyLimit(t *testing.T) {
+	cfg := testConfig(transport.TypeTCP)
+	httpServer, server := newNode(t, cfg, nil)
+	server.SetBodyLimit(func() int64 { return 64 })
+
+	tr := transport.NewStreamTransport(cfg)
+	defer tr.Close()
+	peer := models.Peer{NodeID: "peer", Address: httpServer.Listener.Addr().String()}
+
+	// A frame over the limit is rejected without reading it into memory
+	var statusErr *transport.StatusError
+	large := echo{Text: strings.Repeat("x", 1000)}
+	if err := tr.Send(peer, http.MethodPost, "/echo", large, nil); !errors.As(err, &statusErr) || statusErr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected status 413, got %v", err)
+	}
+
+	// The skipped body does not break the following frames of the connection
+	var response echo
+	if err := tr.Send(peer, http.MethodPost, "/echo", echo{Text: "small"}, &response); err != nil {
+		t.Fatalf("Failed to send after a rejected frame: %v", err)
+	}
+	if response.Text != "small" {
+		t.Errorf("Unexpected response %+v", response)
+	}
+}
+
+func TestStreamTransport_StalledPeer(t *testing.T) {
+	cfg := testConfig(transport.TypeTCP)
+	cfg.SendTimeout = 100 * time.Millisecond
+	release := make(chan struct{})
+	defer close(release)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	router := mux.NewRouter()
+	router.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}).Methods("POST")
+	router.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {}).Methods("POST")
+	server := transport.NewServer(router, cfg, logger)
+	defer server.Close()
+	router.Handle(transport.Path, server).Methods("GET")
+
+	// Count the connections the sender opens
+	var mutex sync.Mutex
+	connections := 0
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == transport.Path {
+			mutex.Lock()
+			connections++
+			mutex.Unlock()
+		}
+		router.ServeHTTP(w, r)
+	}))
+	defer httpServer.Close()
+
+	tr := transport.NewStreamTransport(cfg)
+	defer tr.Close()
+	peer := models.Peer{NodeID: "peer", Address: httpServer.Listener.Addr().String()}
+
+	// Requests in a row without a reply mark the connection as stalled
+	for i := 0; i < 3; i++ {
+		if err := tr.Send(peer, http.MethodPost, "/slow", echo{}, nil); !errors.Is(err, transport.ErrTimeout) {
+			t.Fatalf("Expected ErrTimeout, got %v", err)
+		}
+	}
+	if err := tr.Send(peer, http.MethodPost, "/echo", echo{}, nil); err != nil {
+		t.Fatalf("Failed to send after timeouts: %v", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if connections != 2 {
+		t.Errorf("Expected the stalled connection to be replaced, got %d connections", connections)
+	}
+}
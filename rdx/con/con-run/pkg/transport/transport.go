@@ -0,0 +1,60 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+
+	"concoin/conrun/pkg/config"
+	"concoin/conrun/pkg/interfaces"
+)
+
+// Типы транспорта (TransportConfig.Type)
+const (
+	TypeHTTP = "http" // отдельный HTTP запрос на каждое сообщение
+	TypeTCP  = "tcp"  // долгоживущее соединение с кадрами на каждого пира
+)
+
+var (
+	ErrTimeout   = errors.New("transport: send deadline exceeded")
+	ErrQueueFull = errors.New("transport: send queue is full")
+	ErrBackoff   = errors.New("transport: peer is in reconnect backoff")
+	ErrClosed    = errors.New("transport: connection closed")
+)
+
+// StatusError ответ пира с кодом, отличным от 200
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("bad status code: %d", e.Code)
+}
+
+// New создает транспорт выбранного типа
+func New(cfg config.TransportConfig) (interfaces.TransportInterface, error) {
+	switch cfg.Type {
+	case "", TypeHTTP:
+		return NewHTTPTransport(cfg), nil
+	case TypeTCP:
+		return NewStreamTransport(cfg), nil
+	}
+	return nil, fmt.Errorf("unknown transport %q", cfg.Type)
+}
+
+// withDefaults заполняет незаданные настройки, например из старого файла конфигурации
+func withDefaults(cfg config.TransportConfig) config.TransportConfig {
+	defaults := config.DefaultConfig(0, 0).TransportConfig
+	if cfg.SendTimeout <= 0 {
+		cfg.SendTimeout = defaults.SendTimeout
+	}
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = defaults.DialTimeout
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaults.QueueSize
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = defaults.MaxBackoff
+	}
+	return cfg
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/asn1"
 	"encoding/json"
 	"log"
@@ -33,6 +34,9 @@ type Transaction struct {
 	From   string
 	To     string
 	Amount int
+	Fee    int    // commission for the miner, charged on top of Amount
+	Nonce  uint64 // sequence number of the sender's transaction, starting at 1
+	PubKey string // hex public key of a new account of To, empty for plain transfers
 	Key    *ecdsa.PrivateKey
 }
 
@@ -40,6 +44,9 @@ type TxData struct {
 	From   string `json:"from"`
 	To     string `json:"to"`
 	Amount int    `json:"amount"`
+	Fee    int    `json:"fee,omitempty"`
+	Nonce  uint64 `json:"nonce"`
+	PubKey string `json:"pubKey,omitempty"`
 }
 
 type SignedTransaction struct {
@@ -62,6 +69,9 @@ func Sign(tx Transaction, t MaliciousBehaviourType) []byte {
 		From:   tx.From,
 		To:     tx.To,
 		Amount: tx.Amount,
+		Fee:    tx.Fee,
+		Nonce:  tx.Nonce,
+		PubKey: tx.PubKey,
 	}
 
 	dataBytes, err := json.Marshal(data)
@@ -76,7 +86,10 @@ func Sign(tx Transaction, t MaliciousBehaviourType) []byte {
 		}()
 	}
 
-	r, s, err := ecdsa.Sign(rand.Reader, tx.Key, dataBytes[:])
+	// Sign the digest like con-valid verifies it: signing raw bytes would
+	// truncate them to the curve size and leave the nonce unsigned
+	digest := sha256.Sum256(dataBytes)
+	r, s, err := ecdsa.Sign(rand.Reader, tx.Key, digest[:])
 	if err != nil {
 		log.Fatalf("Error signing transaction: %v", err)
 	}
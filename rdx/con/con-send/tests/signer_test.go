@@ -4,6 +4,7 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/asn1"
 	"encoding/json"
 	"math/big"
@@ -24,6 +25,7 @@ func TestValidSign(t *testing.T) {
 				From:   "Alice",
 				To:     "Bob",
 				Amount: 100,
+				Nonce:  1,
 				Key: func() *ecdsa.PrivateKey {
 					privKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 					return privKey
@@ -36,6 +38,22 @@ func TestValidSign(t *testing.T) {
 				From:   "Bob",
 				To:     "Alice",
 				Amount: 100,
+				Fee:    3,
+				Nonce:  7,
+				Key: func() *ecdsa.PrivateKey {
+					privKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+					return privKey
+				}(),
+			},
+		},
+		{
+			name: "valid_account_creation",
+			input: signer.Transaction{
+				From:   "Bob",
+				To:     "Carol",
+				Amount: 10,
+				Nonce:  8,
+				PubKey: "04b922a5d9b01265c9d4f03993bb3007785c588ff1a28b3f3e8b2dfc4eb7f81fbe40baedee8720d3a512719a6c5b7c1eb90a5fc68461d413845d9c84c56204c532",
 				Key: func() *ecdsa.PrivateKey {
 					privKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 					return privKey
@@ -66,8 +84,9 @@ func TestValidSign(t *testing.T) {
 			dataBytes, err := json.Marshal(signedTx.Data)
 			require.NoError(t, err, "Failed to marshal transaction data")
 
+			digest := sha256.Sum256(dataBytes)
 			pubKey := tc.input.Key.Public().(*ecdsa.PublicKey)
-			isValid := ecdsa.Verify(pubKey, dataBytes, r, s)
+			isValid := ecdsa.Verify(pubKey, digest[:], r, s)
 
 			require.True(t, isValid, "Signature verification failed")
 
@@ -75,12 +94,37 @@ func TestValidSign(t *testing.T) {
 				From:   tc.input.From,
 				To:     tc.input.To,
 				Amount: tc.input.Amount,
+				Fee:    tc.input.Fee,
+				Nonce:  tc.input.Nonce,
+				PubKey: tc.input.PubKey,
 			}
 			require.Equal(t, expectedData, signedTx.Data, "Transaction data does not match expected values")
 		})
 	}
 }
 
+func TestNonceIsSigned(t *testing.T) {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err, "Failed to generate key")
+
+	tx := signer.Transaction{From: "Alice", To: "Bob", Amount: 100, Nonce: 1, Key: privKey}
+	var signedTx signer.SignedTransaction
+	require.NoError(t, json.Unmarshal(signer.Sign(tx, signer.None), &signedTx))
+
+	sigStruct := struct {
+		R, S *big.Int
+	}{}
+	_, err = asn1.Unmarshal(signedTx.Signature, &sigStruct)
+	require.NoError(t, err, "Failed to unmarshal signature")
+
+	// A replay with another nonce must not reuse the signature
+	signedTx.Data.Nonce = 2
+	dataBytes, err := json.Marshal(signedTx.Data)
+	require.NoError(t, err, "Failed to marshal transaction data")
+	digest := sha256.Sum256(dataBytes)
+	require.False(t, ecdsa.Verify(&privKey.PublicKey, digest[:], sigStruct.R, sigStruct.S), "Signature must cover the nonce")
+}
+
 func TestMalicious(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -188,8 +232,9 @@ func TestMalicious(t *testing.T) {
 			dataBytes, err := json.Marshal(signedTx.Data)
 			require.NoError(t, err, "Failed to marshal transaction data")
 
+			digest := sha256.Sum256(dataBytes)
 			pubKey := tc.input.Key.Public().(*ecdsa.PublicKey)
-			isValid := ecdsa.Verify(pubKey, dataBytes, r, s)
+			isValid := ecdsa.Verify(pubKey, digest[:], r, s)
 
 			// include DifferentData as it affects signature
 			if tc.maliciousType == signer.InvalidSignKey || tc.maliciousType == signer.DifferentData {
@@ -203,6 +248,7 @@ func TestMalicious(t *testing.T) {
 				From:   tc.input.From,
 				To:     tc.input.To,
 				Amount: tc.input.Amount,
+				Nonce:  tc.input.Nonce,
 			}
 
 			if tc.maliciousType == signer.DifferentData {
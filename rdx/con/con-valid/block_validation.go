@@ -6,20 +6,29 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"strings"
+	"math/big"
 	"time"
 )
 
 const (
+	// DifficultyTarget target of the first block and the easiest allowed target
 	DifficultyTarget = "0000"
+	// MinerUsername user id that refers to the miner of the block
+	MinerUsername = "cc"
 )
 
-func isDifficultyTargetValid(difficultyTarget string) bool {
-	return difficultyTarget == DifficultyTarget
+func isDifficultyTargetValid(difficultyTarget string, expected *big.Int) bool {
+	target, err := parseTarget(difficultyTarget)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return false
+	}
+	return target.Cmp(expected) == 0
 }
 
-func isBlockHashDifficult(hash model.Hash) bool {
-	return strings.HasPrefix(hash, DifficultyTarget)
+func isBlockHashDifficult(hash model.Hash, target *big.Int) bool {
+	value, ok := new(big.Int).SetString(hash, 16)
+	return ok && value.Cmp(target) <= 0
 }
 
 func isRewardValid(reward model.Amount) bool {
@@ -42,6 +51,13 @@ func AreMapsEqual(a map[model.Username]model.Amount, b map[model.Username]model.
 	return true
 }
 
+func isSameHash(a *model.Hash, b *model.Hash) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
 func calculateBlockHash(block model.Block) (*model.Hash, error) {
 	type blockForHashing struct {
 		BalancesDelta    map[string]model.Amount `json:"balancesDelta"`
@@ -83,7 +99,13 @@ func calculateBlockHash(block model.Block) (*model.Hash, error) {
 func isBlockValid(block model.Block, blockchain Blockchain) bool {
 	fmt.Printf("Validating block with hash: %s\n", block.Hash)
 
-	if !isDifficultyTargetValid(block.DifficultyTarget) {
+	expectedTarget, err := expectedDifficultyTarget(blockchain)
+	if err != nil {
+		fmt.Printf("Error computing expected difficulty target: %v\n", err)
+		return false
+	}
+
+	if !isDifficultyTargetValid(block.DifficultyTarget, expectedTarget) {
 		fmt.Println("Block difficulty target is invalid")
 		return false
 	}
@@ -102,13 +124,13 @@ func isBlockValid(block model.Block, blockchain Blockchain) bool {
 	}
 	fmt.Println("Block hash matches")
 
-	if !isBlockHashDifficult(*blockHash) {
+	if !isBlockHashDifficult(*blockHash, expectedTarget) {
 		fmt.Println("Block hash is not difficult enough")
 		return false
 	}
 	fmt.Println("Block hash is difficult enough")
 
-	if block.PrevBlockHash != blockchain.LastBlockHash {
+	if !isSameHash(block.PrevBlockHash, blockchain.LastBlockHash) {
 		fmt.Println("Previous block hash doesn't equal last block hash from current state")
 		return false
 	}
@@ -152,16 +174,35 @@ func isBlockValid(block model.Block, blockchain Blockchain) bool {
 		return false
 	}
 
+	// Transactions of one sender are checked against the state left by the previous ones
+	pending := make(map[model.Username]pendingState)
 	deltas := make(map[model.Username]model.Amount)
 	for i, tx := range block.Txs {
-		if !isTransactionValid(tx, blockchain) {
+		if !isPendingTransactionValid(tx, blockchain, pending[tx.From]) {
 			fmt.Printf("Tx %d is invalid\n", i)
 			return false
 
 		}
+		if _, touched := pending[tx.To]; tx.PubKey != "" && touched {
+			fmt.Printf("Tx %d creates account %s that is already used in this block\n", i, tx.To)
+			return false
+		}
 		fmt.Printf("Tx %d is valid, applying it to deltas\n", i)
-		deltas[tx.From] -= tx.Amount
-		deltas[tx.To] += tx.Amount
+		// The fee and transfers to cc are the miner's commission
+		to := tx.To
+		if to == MinerUsername {
+			to = block.Miner
+		}
+		deltas[tx.From] -= tx.Amount + tx.Fee
+		deltas[to] += tx.Amount
+		deltas[block.Miner] += tx.Fee
+		sender := pending[tx.From]
+		sender.sent++
+		sender.delta -= tx.Amount + tx.Fee
+		pending[tx.From] = sender
+		recipient := pending[tx.To]
+		recipient.delta += tx.Amount
+		pending[tx.To] = recipient
 	}
 	fmt.Println("Successfully validated block transactions")
 
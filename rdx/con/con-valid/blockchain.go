@@ -2,6 +2,7 @@ package main
 
 import (
 	"con-valid/model"
+	"concoin/brix"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,11 +14,13 @@ type Blockchain struct {
 	LastBlockHash *model.Hash
 	PublicKeys    map[model.Username]model.PubKey
 	UserBalances  map[model.Username]model.Amount
+	Nonces        map[model.Username]model.Nonce
 }
 
 type blockchainActualState struct {
 	UserBalances  map[model.Username]model.Amount `json:"cc-1"`
 	PublicKeys    map[model.Username]model.PubKey `json:"cc-3"`
+	Nonces        map[model.Username]model.Nonce  `json:"cc-4"`
 	LastBlockHash *model.Hash                     `json:"last_block_hash"`
 }
 
@@ -35,6 +38,11 @@ func LoadJSON[T any](filename string) (T, error) {
 }
 
 func initBlockchain(pathToDb string) (*Blockchain, error) {
+	statePath := fmt.Sprintf("%s/state", pathToDb)
+	if _, err := os.Stat(statePath); err == nil {
+		return loadBrixState(pathToDb, statePath)
+	}
+
 	path := fmt.Sprintf("%s/actual_state.json", pathToDb)
 
 	state, err := LoadJSON[blockchainActualState](path)
@@ -47,9 +55,36 @@ func initBlockchain(pathToDb string) (*Blockchain, error) {
 		LastBlockHash: state.LastBlockHash,
 		PublicKeys:    state.PublicKeys,
 		UserBalances:  state.UserBalances,
+		Nonces:        state.Nonces,
 	}, nil
 }
 
+// loadBrixState reads the state from the stack of brik files in <path to DB>/state
+func loadBrixState(pathToDb string, statePath string) (*Blockchain, error) {
+	stack, err := brix.OpenStack(statePath)
+	if err != nil {
+		return nil, fmt.Errorf("error on blockchain init: %w", err)
+	}
+	state, err := brix.ReadState(stack)
+	if err != nil {
+		return nil, fmt.Errorf("error on blockchain init: %w", err)
+	}
+
+	blockchain := &Blockchain{
+		pathToDb:     pathToDb,
+		PublicKeys:   state.PublicKeys,
+		UserBalances: make(map[model.Username]model.Amount, len(state.Balances)),
+		Nonces:       state.Nonces,
+	}
+	for user, balance := range state.Balances {
+		blockchain.UserBalances[user] = model.Amount(balance)
+	}
+	if state.LastBlockHash != "" {
+		blockchain.LastBlockHash = &state.LastBlockHash
+	}
+	return blockchain, nil
+}
+
 func (b *Blockchain) FetchTransactionFromMemPool(hash model.Hash) (*model.Transaction, error) {
 	path := fmt.Sprintf("%s/mempool/%s.json", b.pathToDb, hash)
 
@@ -82,6 +117,13 @@ func (b *Blockchain) FetchProposedBlock() (*model.Block, error) {
 	return &block, nil
 }
 
+// HasAccount reports whether the user has a balance or a public key
+func (b *Blockchain) HasAccount(username model.Username) bool {
+	_, hasBalance := b.UserBalances[username]
+	_, hasPubKey := b.PublicKeys[username]
+	return hasBalance || hasPubKey
+}
+
 func (b *Blockchain) FetchUser(username model.Username) (*model.User, error) {
 	balance, ok := b.UserBalances[username]
 	if !ok {
@@ -99,5 +141,6 @@ func (b *Blockchain) FetchUser(username model.Username) (*model.User, error) {
 		Userame: username,
 		Balance: balance,
 		PubKey:  pubKey,
+		Nonce:   b.Nonces[username],
 	}, nil
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"con-valid/model"
+	"concoin/consensus"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const targetDigits = 64
+
+// parseTarget parses a 256-bit hex difficulty target.
+// Missing low digits are treated as f, so the prefix "0000" means 0000ffff...ff.
+func parseTarget(target string) (*big.Int, error) {
+	if target == "" || len(target) > targetDigits {
+		return nil, fmt.Errorf("difficulty target must have 1 to %d hex digits", targetDigits)
+	}
+	for _, c := range target {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return nil, fmt.Errorf("difficulty target %q is not a lowercase hex number", target)
+		}
+	}
+
+	value, _ := new(big.Int).SetString(target+strings.Repeat("f", targetDigits-len(target)), 16)
+	return value, nil
+}
+
+// expectedDifficultyTarget computes the target of a block on top of LastBlockHash.
+// The target is recalculated every consensus.RetargetInterval blocks from block timestamps
+// against consensus.BlockTime, changes at most 4 times per recalculation and never exceeds DifficultyTarget.
+func expectedDifficultyTarget(blockchain Blockchain) (*big.Int, error) {
+	maxTarget, err := parseTarget(DifficultyTarget)
+	if err != nil {
+		return nil, err
+	}
+
+	// Accepted blocks from the last one back to the first one
+	var blocks []*model.Block
+	for hash := blockchain.LastBlockHash; hash != nil; {
+		block, err := blockchain.FetchAcceptedBlock(*hash)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+		hash = block.PrevBlockHash
+	}
+
+	if len(blocks) == 0 {
+		return maxTarget, nil
+	}
+
+	prev := blocks[0]
+	target, err := parseTarget(prev.DifficultyTarget)
+	if err != nil {
+		return nil, err
+	}
+	if len(blocks)%consensus.RetargetInterval != 0 {
+		return target, nil
+	}
+
+	first := blocks[consensus.RetargetInterval-1]
+	expected := int64(consensus.RetargetInterval-1) * int64(consensus.BlockTime/time.Second)
+	actual := prev.Time - first.Time
+	if actual < expected/4 {
+		actual = expected / 4
+	}
+	if actual > expected*4 {
+		actual = expected * 4
+	}
+
+	target.Mul(target, big.NewInt(actual))
+	target.Div(target, big.NewInt(expected))
+	if target.Cmp(maxTarget) > 0 {
+		target.Set(maxTarget)
+	}
+	if target.Sign() == 0 {
+		target.SetInt64(1)
+	}
+	return target, nil
+}
@@ -3,6 +3,7 @@ module con-valid
 go 1.24.1
 
 require (
+	concoin v0.0.0
 	github.com/stretchr/testify v1.10.0
 	gitlab.com/slon/shad-go v0.0.0-20231003165454-50b27acb6315
 )
@@ -13,3 +14,5 @@ require (
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace concoin => ../
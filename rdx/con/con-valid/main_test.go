@@ -68,6 +68,76 @@ func TestConValidValidateTransaction(t *testing.T) {
 			maliciousMode:    false,
 			expectedExitCode: 1,
 		},
+		{
+			name:             "nonce_is_reused",
+			pathToDb:         "./tests/transaction_validation/nonce_is_reused",
+			txHash:           "tx",
+			maliciousMode:    false,
+			expectedExitCode: 1,
+		},
+		{
+			name:             "nonce_is_out_of_order",
+			pathToDb:         "./tests/transaction_validation/nonce_is_out_of_order",
+			txHash:           "tx",
+			maliciousMode:    false,
+			expectedExitCode: 1,
+		},
+		{
+			name:             "account_creation",
+			pathToDb:         "./tests/transaction_validation/account_creation",
+			txHash:           "tx",
+			maliciousMode:    false,
+			expectedExitCode: 0,
+		},
+		{
+			name:             "account_already_exists",
+			pathToDb:         "./tests/transaction_validation/account_already_exists",
+			txHash:           "tx",
+			maliciousMode:    false,
+			expectedExitCode: 1,
+		},
+		{
+			name:             "negative_fee",
+			pathToDb:         "./tests/transaction_validation/negative_fee",
+			txHash:           "tx",
+			maliciousMode:    false,
+			expectedExitCode: 1,
+		},
+		{
+			name:             "fee_is_more_than_balance",
+			pathToDb:         "./tests/transaction_validation/fee_is_more_than_balance",
+			txHash:           "tx",
+			maliciousMode:    false,
+			expectedExitCode: 1,
+		},
+		{
+			name:             "amount_with_fee_overflows",
+			pathToDb:         "./tests/transaction_validation/amount_with_fee_overflows",
+			txHash:           "tx",
+			maliciousMode:    false,
+			expectedExitCode: 1,
+		},
+		{
+			name:             "brix_state",
+			pathToDb:         "./tests/transaction_validation/brix_state",
+			txHash:           "tx",
+			maliciousMode:    false,
+			expectedExitCode: 0,
+		},
+		{
+			name:             "brix_state_nonce_is_reused",
+			pathToDb:         "./tests/transaction_validation/brix_state_nonce_is_reused",
+			txHash:           "tx",
+			maliciousMode:    false,
+			expectedExitCode: 1,
+		},
+		{
+			name:             "brix_state_is_tampered",
+			pathToDb:         "./tests/transaction_validation/brix_state_is_tampered",
+			txHash:           "tx",
+			maliciousMode:    false,
+			expectedExitCode: 1,
+		},
 		{
 			name:             "malicious_mode",
 			pathToDb:         "./tests/transaction_validation/negative_amount",
@@ -120,6 +190,66 @@ func TestConValidValidateProposedBlock(t *testing.T) {
 			maliciousMode:    false,
 			expectedExitCode: 1,
 		},
+		{
+			name:             "difficulty_target_is_too_easy",
+			pathToDb:         "./tests/block_validation/difficulty_target_is_too_easy",
+			maliciousMode:    false,
+			expectedExitCode: 1,
+		},
+		{
+			name:             "retarget_happy_path",
+			pathToDb:         "./tests/block_validation/retarget_happy_path",
+			maliciousMode:    false,
+			expectedExitCode: 0,
+		},
+		{
+			name:             "difficulty_target_is_not_retargeted",
+			pathToDb:         "./tests/block_validation/difficulty_target_is_not_retargeted",
+			maliciousMode:    false,
+			expectedExitCode: 1,
+		},
+		{
+			name:             "replayed_transaction",
+			pathToDb:         "./tests/block_validation/replayed_transaction",
+			maliciousMode:    false,
+			expectedExitCode: 1,
+		},
+		{
+			name:             "account_creation",
+			pathToDb:         "./tests/block_validation/account_creation",
+			maliciousMode:    false,
+			expectedExitCode: 0,
+		},
+		{
+			name:             "account_created_after_transfer",
+			pathToDb:         "./tests/block_validation/account_created_after_transfer",
+			maliciousMode:    false,
+			expectedExitCode: 1,
+		},
+		{
+			name:             "commission_to_miner",
+			pathToDb:         "./tests/block_validation/commission_to_miner",
+			maliciousMode:    false,
+			expectedExitCode: 0,
+		},
+		{
+			name:             "commission_credited_to_cc",
+			pathToDb:         "./tests/block_validation/commission_credited_to_cc",
+			maliciousMode:    false,
+			expectedExitCode: 1,
+		},
+		{
+			name:             "balance_spent_twice_in_block",
+			pathToDb:         "./tests/block_validation/balance_spent_twice_in_block",
+			maliciousMode:    false,
+			expectedExitCode: 1,
+		},
+		{
+			name:             "brix_state",
+			pathToDb:         "./tests/block_validation/brix_state",
+			maliciousMode:    false,
+			expectedExitCode: 0,
+		},
 		{
 			name:             "malicious_mode",
 			pathToDb:         "./tests/block_validation/tx_signature_is_bad",
@@ -3,9 +3,15 @@ package model
 type Amount = int
 type TxSignature = []byte
 
+// Nonce sequence number of a sender's transaction, the first one is 1
+type Nonce = uint64
+
 type Transaction struct {
 	Amount    Amount      `json:"amount"`
+	Fee       Amount      `json:"fee,omitempty"` // commission for the miner, charged on top of Amount
 	From      Username    `json:"from"`
+	Nonce     Nonce       `json:"nonce"`
+	PubKey    PubKey      `json:"pubKey,omitempty"` // set when the transaction creates the account of To
 	Signature TxSignature `json:"signature"`
 	To        Username    `json:"to"`
 }
@@ -8,4 +8,5 @@ type User struct {
 	Userame Username
 	PubKey  PubKey
 	Balance Amount
+	Nonce   Nonce
 }
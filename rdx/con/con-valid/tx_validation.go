@@ -16,11 +16,17 @@ func isSignatureValid(tx model.Transaction, pubKey model.PubKey) bool {
 		From   model.Username `json:"from"`
 		To     model.Username `json:"to"`
 		Amount model.Amount   `json:"amount"`
+		Fee    model.Amount   `json:"fee,omitempty"`
+		Nonce  model.Nonce    `json:"nonce"`
+		PubKey model.PubKey   `json:"pubKey,omitempty"`
 	}
 	data := txData{
 		From:   tx.From,
 		To:     tx.To,
 		Amount: tx.Amount,
+		Fee:    tx.Fee,
+		Nonce:  tx.Nonce,
+		PubKey: tx.PubKey,
 	}
 	dataBytes, err := json.Marshal(data)
 	if err != nil {
@@ -44,19 +50,73 @@ func isSignatureValid(tx model.Transaction, pubKey model.PubKey) bool {
 	return ecdsa.VerifyASN1(unmarshaledPublicKey, txHash[:], tx.Signature)
 }
 
-func isAmountValid(amount model.Amount, senderBalance model.Amount) bool {
+func isAmountValid(amount model.Amount, fee model.Amount, senderBalance model.Amount) bool {
 	if amount < 0 {
 		fmt.Println("Tx amount is less than zero")
 		return false
 	}
-	if amount > senderBalance {
-		fmt.Println("Tx amount is more than sender's balance")
+	if fee < 0 {
+		fmt.Println("Tx fee is less than zero")
+		return false
+	}
+	// Compared by subtraction: amount+fee can overflow and become negative
+	if fee > senderBalance || amount > senderBalance-fee {
+		fmt.Println("Tx amount with fee is more than sender's balance")
+		return false
+	}
+	return true
+}
+
+// isNonceValid checks that the transaction continues the sender's sequence,
+// so a signed transaction cannot be replayed
+func isNonceValid(nonce model.Nonce, senderNonce model.Nonce) bool {
+	if nonce <= senderNonce {
+		fmt.Printf("Tx nonce %d is already used, last nonce is %d\n", nonce, senderNonce)
+		return false
+	}
+	if nonce != senderNonce+1 {
+		fmt.Printf("Tx nonce %d is out of order, expected %d\n", nonce, senderNonce+1)
+		return false
+	}
+	return true
+}
+
+// isAccountCreationValid checks that the transaction registers a valid public key
+// for an id that has neither a balance nor a public key yet
+func isAccountCreationValid(tx model.Transaction, blockchain Blockchain) bool {
+	pubKeyBytes, err := hex.DecodeString(tx.PubKey)
+	if err != nil {
+		fmt.Println("Couldn't decode new account pubkey string")
+		return false
+	}
+	if _, err := curves.UnmarshalPublicKey(elliptic.P256(), pubKeyBytes); err != nil {
+		fmt.Println("Couldn't unmarshall new account pubkey string")
+		return false
+	}
+	if tx.To == MinerUsername {
+		fmt.Printf("Account %s is reserved for the miner\n", tx.To)
+		return false
+	}
+	if blockchain.HasAccount(tx.To) {
+		fmt.Printf("Account %s already exists\n", tx.To)
 		return false
 	}
 	return true
 }
 
+// pendingState is what the earlier transactions of a block changed for their sender
+type pendingState struct {
+	sent  int          // sender's transactions before this one
+	delta model.Amount // balance change by the transfers before this one
+}
+
 func isTransactionValid(tx model.Transaction, blockchain Blockchain) bool {
+	return isPendingTransactionValid(tx, blockchain, pendingState{})
+}
+
+// isPendingTransactionValid checks a transaction that follows the sender's
+// earlier transactions of the same block
+func isPendingTransactionValid(tx model.Transaction, blockchain Blockchain, pending pendingState) bool {
 	fmt.Printf("Validating transaction")
 	fmt.Println("Extracting Tx sender")
 	sender, err := blockchain.FetchUser(tx.From)
@@ -72,12 +132,26 @@ func isTransactionValid(tx model.Transaction, blockchain Blockchain) bool {
 	}
 	fmt.Println("Tx signature is valid")
 
-	if !isAmountValid(tx.Amount, sender.Balance) {
+	if !isNonceValid(tx.Nonce, sender.Nonce+model.Nonce(pending.sent)) {
+		fmt.Println("Tx nonce is invalid")
+		return false
+	}
+	fmt.Println("Tx nonce is valid")
+
+	if !isAmountValid(tx.Amount, tx.Fee, sender.Balance+pending.delta) {
 		fmt.Println("Tx amount is invalid")
 		return false
 	}
 	fmt.Println("Tx amount is valid")
 
+	if tx.PubKey != "" {
+		if !isAccountCreationValid(tx, blockchain) {
+			fmt.Println("Tx account creation is invalid")
+			return false
+		}
+		fmt.Println("Tx account creation is valid")
+	}
+
 	fmt.Println("Tx is valid")
 
 	return true
@@ -0,0 +1,13 @@
+// Package consensus holds the ConCoin parameters every node must agree on.
+// They are constants rather than settings: a node with other values computes
+// other difficulty targets and forks itself off the network.
+package consensus
+
+import "time"
+
+const (
+	// RetargetInterval is the number of blocks between difficulty recalculations
+	RetargetInterval = 10
+	// BlockTime is the desired time between blocks
+	BlockTime = 10 * time.Second
+)